@@ -0,0 +1,482 @@
+// Package format prints expressions in their canonical form. The output
+// parses back to the same AST: operators are surrounded by single spaces,
+// parentheses are only written where precedence requires them, strings use
+// double quotes and pipelines that do not fit on a line get one stage per
+// line.
+package format
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mredencom/expr/ast"
+	"github.com/mredencom/expr/lexer"
+	"github.com/mredencom/expr/parser"
+	"github.com/mredencom/expr/types"
+)
+
+// Config controls the layout of formatted expressions
+type Config struct {
+	Width     int                          // Line width beyond which pipelines are split, 0 never splits them
+	Indent    string                       // Indentation of the stages of a split pipeline
+	Operators map[string]parser.Precedence // Custom operators used by the expressions
+}
+
+// DefaultConfig is the layout used by Node and Source
+var DefaultConfig = Config{Width: 80, Indent: "    "}
+
+// Node formats an AST node with the default configuration
+func Node(node ast.Node) string {
+	return DefaultConfig.Node(node)
+}
+
+// Source formats the source of an expression with the default configuration
+func Source(src string) (string, error) {
+	return DefaultConfig.Source(src)
+}
+
+// Node formats an AST node. Statements of a program are separated by a
+// semicolon and a new line.
+func (c Config) Node(node ast.Node) string {
+	p := &printer{config: c}
+	p.node(node)
+	return p.buf.String()
+}
+
+// Source parses an expression and formats it. It returns the first parse
+// error when the source is not a valid expression.
+func (c Config) Source(src string) (string, error) {
+	prs := parser.NewWithOperators(lexer.New(src), c.Operators)
+	program := prs.ParseProgram()
+	if errs := prs.SourceErrors(); len(errs) > 0 {
+		return "", errs[0]
+	}
+	return c.Node(program), nil
+}
+
+// primary is the precedence of the operands that never need parentheses:
+// literals, names, calls and member accesses
+const primary = parser.OPTIONAL_CHAINING + 1
+
+// printer writes the canonical form of nodes
+type printer struct {
+	config Config
+	buf    strings.Builder
+	indent string // Indentation of the current line
+}
+
+// write appends text to the output
+func (p *printer) write(text string) {
+	p.buf.WriteString(text)
+}
+
+// column returns the width of the current line
+func (p *printer) column() int {
+	out := p.buf.String()
+	return utf8.RuneCountInString(out[strings.LastIndexByte(out, '\n')+1:])
+}
+
+// node prints a statement, a program or an expression
+func (p *printer) node(node ast.Node) {
+	switch n := node.(type) {
+	case *ast.Program:
+		for i, stmt := range n.Statements {
+			if i > 0 {
+				p.write(";\n")
+			}
+			p.node(stmt)
+		}
+	case *ast.ExpressionStatement:
+		p.expr(n.Expression, parser.LOWEST, true)
+	case *ast.LetStatement:
+		p.write("let " + n.Name + " = ")
+		p.expr(n.Value, parser.LOWEST, true)
+	case *ast.ImportStatement:
+		p.importStatement(n)
+	case *ast.DestructuringAssignment:
+		p.pattern(n.Left)
+		p.write(" = ")
+		p.expr(n.Right, parser.LOWEST, true)
+	case ast.Expression:
+		p.expr(n, parser.LOWEST, true)
+	}
+}
+
+// importStatement prints an import of a module or of names from a module
+func (p *printer) importStatement(n *ast.ImportStatement) {
+	if len(n.Names) > 0 {
+		module := n.ModuleName
+		if !isIdentifier(module) {
+			module = quote(module)
+		}
+		p.write("from " + module + " import " + strings.Join(n.Names, ", "))
+		return
+	}
+
+	p.write("import " + quote(n.ModuleName))
+	if n.Alias != "" && n.Alias != n.ModuleName {
+		p.write(" as " + n.Alias)
+	}
+}
+
+// expr prints an expression in a context that parses operands of precedence
+// min and above. tail is false when more of the enclosing expression follows,
+// in which case expressions ending in a ternary or a lambda are parenthesized
+// since those would take in what follows.
+func (p *printer) expr(e ast.Expression, min parser.Precedence, tail bool) {
+	if p.precedence(e) < min || (!tail && p.open(e)) {
+		p.write("(")
+		p.expr(e, parser.LOWEST, true)
+		p.write(")")
+		return
+	}
+
+	switch n := e.(type) {
+	case *ast.Identifier:
+		p.write(n.Value)
+	case *ast.VariableExpression:
+		p.write(n.Name)
+	case *ast.Literal:
+		p.literal(n.Value)
+	case *ast.PlaceholderExpression:
+		p.write("#")
+	case *ast.WildcardExpression:
+		p.write("*")
+	case *ast.InfixExpression:
+		if n.Operator == "|" {
+			p.pipeline(n, tail)
+			break
+		}
+		precedence := p.operatorPrecedence(n.Operator)
+		left, right := precedence, precedence+1
+		if n.Operator == "**" {
+			left, right = precedence+1, precedence // Right associative
+		}
+		p.expr(n.Left, left, false)
+		p.write(" " + n.Operator + " ")
+		p.expr(n.Right, right, tail)
+	case *ast.PrefixExpression:
+		p.write(n.Operator)
+		p.expr(n.Right, parser.PREFIX, tail)
+	case *ast.ConditionalExpression:
+		p.expr(n.Test, parser.TERNARY+1, false)
+		p.write(" ? ")
+		p.expr(n.Consequent, parser.LOWEST, true)
+		p.write(" : ")
+		p.expr(n.Alternative, parser.LOWEST, tail)
+	case *ast.LambdaExpression:
+		if len(n.Parameters) == 1 {
+			p.write(n.Parameters[0])
+		} else {
+			p.write("(" + strings.Join(n.Parameters, ", ") + ")")
+		}
+		p.write(" => ")
+		p.expr(n.Body, parser.LAMBDA+1, tail)
+	case *ast.PipeExpression:
+		p.pipeline(n, tail)
+	case *ast.NullCoalescingExpression:
+		p.expr(n.Left, parser.NULL_COALESCING, false)
+		p.write(" ?? ")
+		p.expr(n.Right, parser.NULL_COALESCING+1, tail)
+	case *ast.MemberExpression:
+		p.expr(n.Object, parser.CALL, false)
+		switch property := n.Property.(type) {
+		case *ast.Identifier:
+			p.write("." + property.Value)
+		case *ast.WildcardExpression:
+			p.write(".*")
+		default:
+			p.write(".[")
+			p.expr(property, parser.LOWEST, true)
+			p.write("]")
+		}
+	case *ast.OptionalChainingExpression:
+		p.expr(n.Object, parser.CALL, false)
+		if property, ok := n.Property.(*ast.Identifier); ok {
+			p.write("?." + property.Value)
+			break
+		}
+		p.write("?.[")
+		p.expr(n.Property, parser.LOWEST, true)
+		p.write("]")
+	case *ast.IndexExpression:
+		p.expr(n.Left, parser.CALL, false)
+		p.write("[")
+		p.expr(n.Index, parser.LOWEST, true)
+		p.write("]")
+	case *ast.CallExpression:
+		p.expr(n.Function, parser.CALL, false)
+		p.list("(", n.Arguments, ")")
+	case *ast.BuiltinExpression:
+		p.write(n.Name)
+		p.list("(", n.Arguments, ")")
+	case *ast.ModuleCallExpression:
+		p.write(n.Module + "." + n.Function)
+		p.list("(", n.Arguments, ")")
+	case *ast.ArrayLiteral:
+		p.list("[", n.Elements, "]")
+	case *ast.MapLiteral:
+		p.write("{")
+		for i, pair := range n.Pairs {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.expr(pair.Key, parser.LOWEST, true)
+			p.write(": ")
+			p.expr(pair.Value, parser.LOWEST, true)
+		}
+		p.write("}")
+	default:
+		p.write(e.String())
+	}
+}
+
+// list prints expressions separated by commas between delimiters
+func (p *printer) list(open string, exprs []ast.Expression, close string) {
+	p.write(open)
+	for i, e := range exprs {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.expr(e, parser.LOWEST, true)
+	}
+	p.write(close)
+}
+
+// pipeline prints a chain of pipes on one line when it fits the configured
+// width, and otherwise with each stage on its own indented line
+func (p *printer) pipeline(n ast.Expression, tail bool) {
+	source, stage, _ := pipe(n)
+	stages := []ast.Expression{stage}
+	for {
+		left, right, ok := pipe(source)
+		if !ok || p.open(source) {
+			break
+		}
+		stages = append(stages, right)
+		source = left
+	}
+
+	split := false
+	if p.config.Width > 0 {
+		flat := &printer{config: p.config}
+		flat.config.Width = 0
+		flat.pipeline(n, tail)
+		split = p.column()+utf8.RuneCountInString(flat.buf.String()) > p.config.Width
+	}
+
+	indent := p.indent
+	p.expr(source, parser.PIPE, false)
+	if split {
+		p.indent += p.config.Indent
+	}
+	for i := len(stages) - 1; i >= 0; i-- {
+		if split {
+			p.write("\n" + p.indent + "| ")
+		} else {
+			p.write(" | ")
+		}
+		p.expr(stages[i], parser.PIPE+1, tail && i == 0)
+	}
+	p.indent = indent
+}
+
+// pipe returns the source and the stage of a pipe. A stage the parser does
+// not take for a pipeline function after another pipe, such as string() in
+// a | sum() | string(), is parsed as a "|" infix expression; both are
+// printed alike.
+func pipe(e ast.Expression) (ast.Expression, ast.Expression, bool) {
+	switch n := e.(type) {
+	case *ast.PipeExpression:
+		return n.Left, n.Right, true
+	case *ast.InfixExpression:
+		if n.Operator == "|" {
+			return n.Left, n.Right, true
+		}
+	}
+	return nil, nil, false
+}
+
+// precedence returns the precedence an expression is parsed with
+func (p *printer) precedence(e ast.Expression) parser.Precedence {
+	if _, _, ok := pipe(e); ok {
+		return parser.PIPE
+	}
+	switch n := e.(type) {
+	case *ast.InfixExpression:
+		return p.operatorPrecedence(n.Operator)
+	case *ast.PrefixExpression:
+		return parser.PREFIX
+	case *ast.Literal:
+		// Negative numbers are written with a prefix minus
+		switch v := n.Value.(type) {
+		case *types.IntValue:
+			if v.Value() < 0 {
+				return parser.PREFIX
+			}
+		case *types.FloatValue:
+			if v.Value() < 0 {
+				return parser.PREFIX
+			}
+		}
+	case *ast.ConditionalExpression:
+		return parser.TERNARY
+	case *ast.LambdaExpression:
+		return parser.LAMBDA
+	case *ast.NullCoalescingExpression:
+		return parser.NULL_COALESCING
+	}
+	return primary
+}
+
+// open reports whether an expression ends in a ternary or lambda written
+// without parentheses, which would extend over any operator following it
+func (p *printer) open(e ast.Expression) bool {
+	var last ast.Expression
+	var min parser.Precedence
+	switch n := e.(type) {
+	case *ast.ConditionalExpression, *ast.LambdaExpression:
+		return true
+	case *ast.InfixExpression:
+		last, min = n.Right, p.precedence(n)+1
+		if n.Operator == "**" {
+			min--
+		}
+	case *ast.PrefixExpression:
+		last, min = n.Right, parser.PREFIX
+	case *ast.PipeExpression:
+		last, min = n.Right, parser.PIPE+1
+	case *ast.NullCoalescingExpression:
+		last, min = n.Right, parser.NULL_COALESCING+1
+	default:
+		return false
+	}
+	return p.precedence(last) >= min && p.open(last)
+}
+
+// operatorPrecedence returns the precedence of a built-in or custom operator
+func (p *printer) operatorPrecedence(operator string) parser.Precedence {
+	if precedence, ok := p.config.Operators[operator]; ok && !parser.IsBuiltinOperator(operator) {
+		if precedence <= parser.LOWEST {
+			return parser.EQUALS
+		}
+		return precedence
+	}
+	if precedence := parser.GetPrecedence(lexer.New(operator).NextToken().Type); precedence > parser.LOWEST {
+		return precedence
+	}
+	return parser.EQUALS
+}
+
+// literal prints a constant value as the literal that produces it
+func (p *printer) literal(value types.Value) {
+	switch v := value.(type) {
+	case nil, *types.NilValue:
+		p.write("null")
+	case *types.StringValue:
+		p.write(quote(v.Value()))
+	case *types.FloatValue:
+		text := strconv.FormatFloat(v.Value(), 'g', -1, 64)
+		if !strings.ContainsAny(text, ".eIN") {
+			text += ".0" // Keep whole floats floats
+		}
+		p.write(text)
+	case *types.SliceValue:
+		p.write("[")
+		for i, element := range v.Values() {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.literal(element)
+		}
+		p.write("]")
+	case *types.MapValue:
+		keys := v.Keys()
+		sort.Strings(keys)
+		p.write("{")
+		for i, key := range keys {
+			if i > 0 {
+				p.write(", ")
+			}
+			element, _ := v.Get(key)
+			p.write(quote(key) + ": ")
+			p.literal(element)
+		}
+		p.write("}")
+	default:
+		p.write(value.String())
+	}
+}
+
+// pattern prints the left side of a destructuring assignment
+func (p *printer) pattern(pattern ast.DestructuringPattern) {
+	switch n := pattern.(type) {
+	case *ast.ArrayDestructuringPattern:
+		p.write("[")
+		for i, element := range n.Elements {
+			if i > 0 {
+				p.write(", ")
+			}
+			switch e := element.(type) {
+			case *ast.IdentifierElement:
+				p.write(e.Name)
+				p.defaultValue(e.Default)
+			case *ast.RestElement:
+				p.write("..." + e.Name)
+			}
+		}
+		p.write("]")
+	case *ast.ObjectDestructuringPattern:
+		p.write("{")
+		for i, property := range n.Properties {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.write(property.Key)
+			if property.Value != property.Key {
+				p.write(": " + property.Value)
+			}
+			p.defaultValue(property.Default)
+		}
+		p.write("}")
+	}
+}
+
+// defaultValue prints the default of a destructured name, if any
+func (p *printer) defaultValue(value ast.Expression) {
+	if value != nil {
+		p.write(" = ")
+		p.expr(value, parser.LOWEST, true)
+	}
+}
+
+// quote returns a string literal for s
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// isIdentifier reports whether s is lexed as a single identifier
+func isIdentifier(s string) bool {
+	l := lexer.New(s)
+	return l.NextToken().Type == lexer.IDENT && l.NextToken().Type == lexer.EOF
+}
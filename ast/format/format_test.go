@@ -0,0 +1,177 @@
+package format
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mredencom/expr/ast"
+	"github.com/mredencom/expr/lexer"
+	"github.com/mredencom/expr/parser"
+	"github.com/mredencom/expr/types"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a+b * c", "a + b * c"},
+		{"(a+b) * c", "(a + b) * c"},
+		{"(a-b)-c", "a - b - c"},
+		{"a-(b-c)", "a - (b - c)"},
+		{"(2**3)**2", "(2 ** 3) ** 2"},
+		{"2**(3**2)", "2 ** 3 ** 2"},
+		{"-(x**2)", "-(x ** 2)"},
+		{"!(a&&b)||c", "!(a && b) || c"},
+		{"(a ? b : c) + 1", "(a ? b : c) + 1"},
+		{"(a ?? b) ? c : d", "(a ?? b) ? c : d"},
+		{"(a ? b : c) | map(#)", "(a ? b : c) | map(#)"},
+		{"items|filter(#>1)|map(#*2)", "items | filter(# > 1) | map(# * 2)"},
+		{"a|sum()|string()", "a | sum() | string()"},
+		{"map(items,(a,b)=>a+b)", "map(items, (a, b) => a + b)"},
+		{"user?.name??'anon'", `user?.name ?? "anon"`},
+		{`user['say "hi"']`, `user["say \"hi\""]`},
+		{"{'a':1,b:[1,2.5,true,null]}", `{"a": 1, b: [1, 2.5, true, null]}`},
+		{"(-a).b", "(-a).b"},
+		{"a in [1,2] && name matches '^a'", `a in [1, 2] && name matches "^a"`},
+		{"let x = 1;import 'math' as m; m.sqrt(x)", "let x = 1;\nimport \"math\" as m;\nm.sqrt(x)"},
+		{"from math import sqrt,pow; sqrt(2)", "from math import sqrt, pow;\nsqrt(2)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			output, err := Source(tt.input)
+			if err != nil {
+				t.Fatalf("Format error: %v", err)
+			}
+			if output != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, output)
+			}
+		})
+	}
+
+	if _, err := Source("a +"); err == nil || !strings.Contains(err.Error(), "line 1, column") {
+		t.Errorf("Expected a parse error with its position, got %v", err)
+	}
+}
+
+func TestSourceRoundTrip(t *testing.T) {
+	inputs := []string{
+		"a ?? b ? c : d",
+		"x | filter(# > 1) | map(# * 2) | sum()",
+		"items | map(x => x ? 1 : 2) | count()",
+		"(x => x + 1) + 1",
+		"a ? b ? c : d : e ? f : g",
+		"user.addresses[0].city contains 'Os' || user.* == []",
+		"-x ** 2 + 5 | 3",
+		"a | sum() | string() | upper()",
+		"(items | filter(# > 1) | count() | string()) + \"!\"",
+		"data.items | filter(item => item.tags | any(# == 'a')) | map({name: #.name, total: #.price * #.qty})",
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			output, err := Source(input)
+			if err != nil {
+				t.Fatalf("Format error: %v", err)
+			}
+			if debugString(t, output) != debugString(t, input) {
+				t.Errorf("%q parses differently from %q: %s", output, input, debugString(t, output))
+			}
+			again, err := Source(output)
+			if err != nil || again != output {
+				t.Errorf("Formatting is not stable: %q became %q (%v)", output, again, err)
+			}
+		})
+	}
+}
+
+func TestConfigWidth(t *testing.T) {
+	input := `orders | filter(o => o.total > 100 && o.status == "paid") | map(o => o.customer) | unique()`
+
+	output, err := Config{Width: 40, Indent: "\t"}.Source(input)
+	if err != nil {
+		t.Fatalf("Format error: %v", err)
+	}
+	expected := "orders\n" +
+		"\t| filter(o => o.total > 100 && o.status == \"paid\")\n" +
+		"\t| map(o => o.customer)\n" +
+		"\t| unique()"
+	if output != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, output)
+	}
+	if debugString(t, output) != debugString(t, input) {
+		t.Errorf("Split pipeline parses differently: %s", debugString(t, output))
+	}
+
+	output, err = Config{}.Source(input)
+	if err != nil || strings.Contains(output, "\n") {
+		t.Errorf("Expected a single line without width, got %q (%v)", output, err)
+	}
+
+	// Stages after a call without arguments are split like the others
+	input = `orders | filter(o => o.status == "paid") | count() | string()`
+	output, err = Config{Width: 40, Indent: "\t"}.Source(input)
+	if err != nil {
+		t.Fatalf("Format error: %v", err)
+	}
+	expected = "orders\n" +
+		"\t| filter(o => o.status == \"paid\")\n" +
+		"\t| count()\n" +
+		"\t| string()"
+	if output != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, output)
+	}
+	if debugString(t, output) != debugString(t, input) {
+		t.Errorf("Split pipeline parses differently: %s", debugString(t, output))
+	}
+}
+
+func TestConfigOperators(t *testing.T) {
+	config := Config{Operators: map[string]parser.Precedence{"between": parser.EQUALS, "<>": parser.SUM}}
+
+	output, err := config.Source("(a <> b) between [1,2] && (c between d) <> e")
+	if err != nil {
+		t.Fatalf("Format error: %v", err)
+	}
+	if expected := "a <> b between [1, 2] && (c between d) <> e"; output != expected {
+		t.Errorf("Expected %q, got %q", expected, output)
+	}
+}
+
+func TestNodeLiterals(t *testing.T) {
+	node := &ast.InfixExpression{
+		Left: &ast.InfixExpression{
+			Left:     &ast.Literal{Value: types.NewFloat(2)},
+			Operator: "*",
+			Right:    &ast.Literal{Value: types.NewInt(-3)},
+		},
+		Operator: "in",
+		Right: &ast.Literal{Value: types.NewMap(map[string]types.Value{
+			"b": types.NewSlice([]types.Value{types.NewString("x\ny")}, types.StringType),
+			"a": types.NewNil(),
+		}, types.StringType, types.TypeInfo{Kind: types.KindInterface, Name: "interface{}"})},
+	}
+
+	expected := `2.0 * -3 in {"a": null, "b": ["x\ny"]}`
+	if output := Node(node); output != expected {
+		t.Errorf("Expected %s, got %s", expected, output)
+	}
+}
+
+// debugString returns the debug form of the AST of an expression
+func debugString(t *testing.T, input string) string {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("Parse errors in %q: %v", input, p.Errors())
+	}
+
+	var parts []string
+	for _, stmt := range program.Statements {
+		parts = append(parts, stmt.String())
+	}
+	return strings.Join(parts, "; ")
+}
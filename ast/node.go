@@ -1,6 +1,8 @@
 package ast
 
 import (
+	"strings"
+
 	"github.com/mredencom/expr/lexer"
 	"github.com/mredencom/expr/types"
 )
@@ -511,10 +513,12 @@ func (nce *NullCoalescingExpression) String() string {
 
 func (nce *NullCoalescingExpression) expressionNode() {}
 
-// ImportStatement represents import statements (e.g., import "math" as m)
+// ImportStatement represents import statements (e.g., import "math" as m
+// or from math import sqrt, pow)
 type ImportStatement struct {
-	ModuleName string // The module name to import (e.g., "math")
-	Alias      string // The alias for the module (e.g., "m")
+	ModuleName string   // The module name to import (e.g., "math")
+	Alias      string   // The alias for the module (e.g., "m")
+	Names      []string // Functions imported by name (e.g., sqrt, pow)
 	Pos        lexer.Position
 }
 
@@ -527,6 +531,9 @@ func (is *ImportStatement) Position() lexer.Position {
 }
 
 func (is *ImportStatement) String() string {
+	if len(is.Names) > 0 {
+		return "from " + is.ModuleName + " import " + strings.Join(is.Names, ", ")
+	}
 	if is.Alias != "" {
 		return "import '" + is.ModuleName + "' as " + is.Alias
 	}
@@ -535,6 +542,28 @@ func (is *ImportStatement) String() string {
 
 func (is *ImportStatement) statementNode() {}
 
+// LetStatement binds the value of an expression to a name for the rest of
+// the expression (e.g., let total = price * qty)
+type LetStatement struct {
+	Name  string     // The bound name
+	Value Expression // The value expression
+	Pos   lexer.Position
+}
+
+func (ls *LetStatement) Type() types.TypeInfo {
+	return types.TypeInfo{Kind: types.KindNil, Name: "nil", Size: 0}
+}
+
+func (ls *LetStatement) Position() lexer.Position {
+	return ls.Pos
+}
+
+func (ls *LetStatement) String() string {
+	return "let " + ls.Name + " = " + ls.Value.String()
+}
+
+func (ls *LetStatement) statementNode() {}
+
 // ModuleCallExpression represents module function calls (e.g., m.sqrt(16))
 type ModuleCallExpression struct {
 	Module    string       // Module alias (e.g., "m")
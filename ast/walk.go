@@ -0,0 +1,208 @@
+package ast
+
+// Visitor visits the nodes of an AST. Visit receives a pointer to the node so
+// that it can replace it in place; a replacement that does not implement the
+// interface of the field holding the node (Expression, Statement, ...) is ignored.
+type Visitor interface {
+	Visit(node *Node)
+}
+
+// VisitorFunc adapts an ordinary function to the Visitor interface
+type VisitorFunc func(node *Node)
+
+// Visit calls f(node)
+func (f VisitorFunc) Visit(node *Node) {
+	f(node)
+}
+
+// Walk traverses the AST depth-first, visiting the children of a node before
+// the node itself
+func Walk(node *Node, v Visitor) {
+	if node == nil || *node == nil {
+		return
+	}
+
+	switch n := (*node).(type) {
+	case *Program:
+		for i := range n.Statements {
+			walkChild(&n.Statements[i], v)
+		}
+	case *ExpressionStatement:
+		walkChild(&n.Expression, v)
+	case *LetStatement:
+		walkChild(&n.Value, v)
+	case *InfixExpression:
+		walkChild(&n.Left, v)
+		walkChild(&n.Right, v)
+	case *PrefixExpression:
+		walkChild(&n.Right, v)
+	case *CallExpression:
+		walkChild(&n.Function, v)
+		walkChildren(n.Arguments, v)
+	case *IndexExpression:
+		walkChild(&n.Left, v)
+		walkChild(&n.Index, v)
+	case *MemberExpression:
+		walkChild(&n.Object, v)
+		walkChild(&n.Property, v)
+	case *ConditionalExpression:
+		walkChild(&n.Test, v)
+		walkChild(&n.Consequent, v)
+		walkChild(&n.Alternative, v)
+	case *ArrayLiteral:
+		walkChildren(n.Elements, v)
+	case *MapLiteral:
+		for i := range n.Pairs {
+			walkChild(&n.Pairs[i].Key, v)
+			walkChild(&n.Pairs[i].Value, v)
+		}
+	case *BuiltinExpression:
+		walkChildren(n.Arguments, v)
+	case *LambdaExpression:
+		walkChild(&n.Body, v)
+	case *PipeExpression:
+		walkChild(&n.Left, v)
+		walkChild(&n.Right, v)
+	case *OptionalChainingExpression:
+		walkChild(&n.Object, v)
+		walkChild(&n.Property, v)
+	case *NullCoalescingExpression:
+		walkChild(&n.Left, v)
+		walkChild(&n.Right, v)
+	case *ModuleCallExpression:
+		walkChildren(n.Arguments, v)
+	case *DestructuringAssignment:
+		walkChild(&n.Left, v)
+		walkChild(&n.Right, v)
+	case *ArrayDestructuringPattern:
+		for i := range n.Elements {
+			walkChild(&n.Elements[i], v)
+		}
+	case *ObjectDestructuringPattern:
+		for i := range n.Properties {
+			property := &n.Properties[i]
+			var child Node = property
+			Walk(&child, v)
+			if replaced, ok := child.(*ObjectDestructuringProperty); ok && replaced != property {
+				*property = *replaced
+			}
+		}
+	case *IdentifierElement:
+		walkChild(&n.Default, v)
+	case *ObjectDestructuringProperty:
+		walkChild(&n.Default, v)
+	}
+
+	v.Visit(node)
+}
+
+// walkChild walks a child held in a field of type T and stores the
+// replacement back when it still implements T
+func walkChild[T Node](field *T, v Visitor) {
+	if any(*field) == nil {
+		return
+	}
+
+	var child Node = *field
+	Walk(&child, v)
+	if replaced, ok := child.(T); ok {
+		*field = replaced
+	}
+}
+
+// walkChildren walks a list of expressions in place
+func walkChildren(expressions []Expression, v Visitor) {
+	for i := range expressions {
+		walkChild(&expressions[i], v)
+	}
+}
+
+// Inspect traverses the AST depth-first in source order, calling f for a
+// node before its children. When f returns true the children are inspected
+// and f is called with nil after them.
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+	for _, child := range children(node) {
+		Inspect(child, f)
+	}
+	f(nil)
+}
+
+// children returns the child nodes of a node in the order Walk visits them.
+// Absent optional children are returned as nil.
+func children(node Node) []Node {
+	switch n := node.(type) {
+	case *Program:
+		nodes := make([]Node, len(n.Statements))
+		for i, stmt := range n.Statements {
+			nodes[i] = stmt
+		}
+		return nodes
+	case *ExpressionStatement:
+		return []Node{n.Expression}
+	case *LetStatement:
+		return []Node{n.Value}
+	case *InfixExpression:
+		return []Node{n.Left, n.Right}
+	case *PrefixExpression:
+		return []Node{n.Right}
+	case *CallExpression:
+		return append([]Node{n.Function}, expressionNodes(n.Arguments)...)
+	case *IndexExpression:
+		return []Node{n.Left, n.Index}
+	case *MemberExpression:
+		return []Node{n.Object, n.Property}
+	case *ConditionalExpression:
+		return []Node{n.Test, n.Consequent, n.Alternative}
+	case *ArrayLiteral:
+		return expressionNodes(n.Elements)
+	case *MapLiteral:
+		nodes := make([]Node, 0, 2*len(n.Pairs))
+		for _, pair := range n.Pairs {
+			nodes = append(nodes, pair.Key, pair.Value)
+		}
+		return nodes
+	case *BuiltinExpression:
+		return expressionNodes(n.Arguments)
+	case *LambdaExpression:
+		return []Node{n.Body}
+	case *PipeExpression:
+		return []Node{n.Left, n.Right}
+	case *OptionalChainingExpression:
+		return []Node{n.Object, n.Property}
+	case *NullCoalescingExpression:
+		return []Node{n.Left, n.Right}
+	case *ModuleCallExpression:
+		return expressionNodes(n.Arguments)
+	case *DestructuringAssignment:
+		return []Node{n.Left, n.Right}
+	case *ArrayDestructuringPattern:
+		nodes := make([]Node, len(n.Elements))
+		for i, element := range n.Elements {
+			nodes[i] = element
+		}
+		return nodes
+	case *ObjectDestructuringPattern:
+		nodes := make([]Node, len(n.Properties))
+		for i := range n.Properties {
+			nodes[i] = &n.Properties[i]
+		}
+		return nodes
+	case *IdentifierElement:
+		return []Node{n.Default}
+	case *ObjectDestructuringProperty:
+		return []Node{n.Default}
+	}
+	return nil
+}
+
+// expressionNodes converts a list of expressions to nodes
+func expressionNodes(expressions []Expression) []Node {
+	nodes := make([]Node, len(expressions))
+	for i, expr := range expressions {
+		nodes[i] = expr
+	}
+	return nodes
+}
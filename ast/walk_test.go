@@ -0,0 +1,177 @@
+package ast
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mredencom/expr/types"
+)
+
+// TestWalkVisitsAllNodes tests that Walk reaches nested pipeline, lambda,
+// optional chaining and destructuring nodes
+func TestWalkVisitsAllNodes(t *testing.T) {
+	program := &Program{
+		Statements: []Statement{
+			&ExpressionStatement{
+				Expression: &PipeExpression{
+					Left: &OptionalChainingExpression{
+						Object:   &Identifier{Value: "user"},
+						Property: &Identifier{Value: "orders"},
+					},
+					Right: &CallExpression{
+						Function: &Identifier{Value: "filter"},
+						Arguments: []Expression{
+							&LambdaExpression{
+								Parameters: []string{"o"},
+								Body: &InfixExpression{
+									Left:     &Identifier{Value: "o"},
+									Operator: ">",
+									Right:    &Literal{Value: types.NewInt(10)},
+								},
+							},
+						},
+					},
+				},
+			},
+			&DestructuringAssignment{
+				Left: &ArrayDestructuringPattern{
+					Elements: []DestructuringElement{
+						&IdentifierElement{Name: "a", Default: &Identifier{Value: "fallback"}},
+					},
+				},
+				Right: &Identifier{Value: "items"},
+			},
+		},
+	}
+
+	var identifiers []string
+	var count int
+	var root Node = program
+	Walk(&root, VisitorFunc(func(node *Node) {
+		count++
+		if ident, ok := (*node).(*Identifier); ok {
+			identifiers = append(identifiers, ident.Value)
+		}
+	}))
+
+	expected := []string{"user", "orders", "filter", "o", "fallback", "items"}
+	if len(identifiers) != len(expected) {
+		t.Fatalf("Expected identifiers %v, got %v", expected, identifiers)
+	}
+	for i, name := range expected {
+		if identifiers[i] != name {
+			t.Errorf("Expected identifier %d to be %s, got %s", i, name, identifiers[i])
+		}
+	}
+	if count != 17 {
+		t.Errorf("Expected 17 visited nodes, got %d", count)
+	}
+}
+
+// TestWalkReplacesNodes tests in-place replacement of nodes
+func TestWalkReplacesNodes(t *testing.T) {
+	expr := &InfixExpression{
+		Left:     &Identifier{Value: "oldName"},
+		Operator: "+",
+		Right:    &Literal{Value: types.NewInt(1)},
+	}
+
+	var root Node = expr
+	Walk(&root, VisitorFunc(func(node *Node) {
+		if ident, ok := (*node).(*Identifier); ok && ident.Value == "oldName" {
+			*node = &Identifier{Value: "newName"}
+		}
+	}))
+
+	if ident, ok := expr.Left.(*Identifier); !ok || ident.Value != "newName" {
+		t.Errorf("Expected left operand to be replaced, got %v", expr.Left)
+	}
+	if root != expr {
+		t.Error("Expected root node to be unchanged")
+	}
+}
+
+// TestWalkIgnoresIncompatibleReplacement tests that a replacement which does
+// not fit the field type is dropped
+func TestWalkIgnoresIncompatibleReplacement(t *testing.T) {
+	stmt := &ExpressionStatement{Expression: &Identifier{Value: "x"}}
+	program := &Program{Statements: []Statement{stmt}}
+
+	var root Node = program
+	Walk(&root, VisitorFunc(func(node *Node) {
+		if _, ok := (*node).(*ExpressionStatement); ok {
+			*node = &Identifier{Value: "y"}
+		}
+	}))
+
+	if program.Statements[0] != stmt {
+		t.Errorf("Expected statement to be kept, got %v", program.Statements[0])
+	}
+}
+
+// TestWalkNil tests that Walk tolerates nil nodes
+func TestWalkNil(t *testing.T) {
+	Walk(nil, VisitorFunc(func(node *Node) {
+		t.Error("Visitor should not be called")
+	}))
+
+	var root Node
+	Walk(&root, VisitorFunc(func(node *Node) {
+		t.Error("Visitor should not be called")
+	}))
+}
+
+// TestInspect tests that Inspect visits nodes before their children, closes
+// each inspected node with nil and skips the children of rejected nodes
+func TestInspect(t *testing.T) {
+	program := &Program{
+		Statements: []Statement{
+			&ExpressionStatement{
+				Expression: &InfixExpression{
+					Left: &CallExpression{
+						Function:  &MemberExpression{Object: &Identifier{Value: "name"}, Property: &Identifier{Value: "upper"}},
+						Arguments: []Expression{},
+					},
+					Operator: "==",
+					Right:    &ArrayLiteral{Elements: []Expression{&Literal{Value: types.NewString("A")}}},
+				},
+			},
+			&DestructuringAssignment{
+				Left: &ObjectDestructuringPattern{
+					Properties: []ObjectDestructuringProperty{{Key: "a", Value: "a", Default: &Identifier{Value: "fallback"}}},
+				},
+				Right: &Identifier{Value: "items"},
+			},
+		},
+	}
+
+	var identifiers []string
+	depth, maxDepth := 0, 0
+	Inspect(program, func(node Node) bool {
+		if node == nil {
+			depth--
+			return false
+		}
+		depth++
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+		if ident, ok := node.(*Identifier); ok {
+			identifiers = append(identifiers, ident.Value)
+		}
+		_, isArray := node.(*ArrayLiteral)
+		return !isArray
+	})
+
+	expected := []string{"name", "upper", "fallback", "items"}
+	if strings.Join(identifiers, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected identifiers %v, got %v", expected, identifiers)
+	}
+	// The rejected array literal is entered but never closed
+	if depth != 1 {
+		t.Errorf("Expected depth 1 after inspection, got %d", depth)
+	}
+	if maxDepth != 6 {
+		t.Errorf("Expected maximum depth 6, got %d", maxDepth)
+	}
+}
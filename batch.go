@@ -0,0 +1,122 @@
+package expr
+
+import (
+	"context"
+	"time"
+
+	"github.com/mredencom/expr/vm"
+)
+
+// RunBatch runs the program once for each environment on a single prepared
+// VM and returns the results in order. When some runs fail, errs has the
+// error of each failed run at its position and nil for the others; it is nil
+// when every run succeeds.
+//
+// Variables whose value is the same as in the previous environment are not
+// converted again. Maps, slices and pointers are the same when they are the
+// same object, so values shared by the environments must not be changed in
+// place during the batch.
+func (p *Program) RunBatch(envs []map[string]interface{}) (results []interface{}, errs []error) {
+	results = make([]interface{}, len(envs))
+	i := 0
+	next := func() (map[string]interface{}, bool) {
+		if i == len(envs) {
+			return nil, false
+		}
+		i++
+		return envs[i-1], true
+	}
+
+	p.RunEach(next, func(index int, result interface{}, err error) bool {
+		results[index] = result
+		if err != nil {
+			if errs == nil {
+				errs = make([]error, len(envs))
+			}
+			errs[index] = err
+		}
+		return true
+	})
+	return results, errs
+}
+
+// RunEach runs the program for each environment returned by next until it
+// reports false, and calls fn with the index of the environment and the
+// result or error of its run. Returning false from fn stops the iteration.
+// Environments are prepared as in RunBatch.
+func (p *Program) RunEach(next func() (map[string]interface{}, bool), fn func(index int, result interface{}, err error) bool) {
+	machine := vm.GlobalVMPool.Get()
+	defer vm.GlobalVMPool.Put(machine)
+
+	// Set up the VM once for all the runs
+	p.prepare(machine)
+
+	var previous map[string]interface{}
+	for index := 0; ; index++ {
+		environment, ok := next()
+		if !ok {
+			return
+		}
+
+		result, err := p.runPrepared(machine, environment, previous)
+		previous = environment
+		if err != nil {
+			// Convert every variable again after a failure
+			previous = nil
+		}
+		if !fn(index, result, err) {
+			return
+		}
+	}
+}
+
+// runPrepared runs the program on a VM set up by RunEach whose globals hold
+// the variables of the previous environment
+func (p *Program) runPrepared(machine *vm.VM, environment, previous map[string]interface{}) (interface{}, error) {
+	if err := machine.UpdateEnvironment(environment, previous, p.variableOrder); err != nil {
+		return nil, &RuntimeError{Message: "environment setup error", Cause: err}
+	}
+	return p.execute(machine)
+}
+
+// prepare sets up a VM with the constants, operators, modules and custom
+// functions of the program
+func (p *Program) prepare(machine *vm.VM) {
+	machine.SetConstants(p.bytecode.Constants)
+	machine.SetTagName(p.config.tagName)
+	machine.SetOperators(p.operators)
+	machine.SetModules(p.modules)
+	machine.SetSourceMap(p.bytecode.Positions)
+	for name, function := range p.functions {
+		machine.SetCustomBuiltin(name, function)
+	}
+}
+
+// execute runs the program on a VM whose environment is set and returns
+// the result as a Go value
+func (p *Program) execute(machine *vm.VM) (interface{}, error) {
+	start := time.Now()
+
+	ctx := context.Background()
+	if p.config.maxExecutionTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.config.maxExecutionTime)
+		defer cancel()
+	}
+
+	result, err := machine.RunInstructionsWithContext(ctx, p.bytecode.Instructions)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, contextError(p, ctxErr)
+		}
+		return nil, newRuntimeError(p.source, err)
+	}
+
+	globalStats.TotalExecutions++
+	globalStats.AverageExecTime = updateAverage(globalStats.AverageExecTime, time.Since(start), globalStats.TotalExecutions)
+
+	if result == nil {
+		return nil, nil
+	}
+	return convertTypesValueToGoValue(result), nil
+}
@@ -0,0 +1,119 @@
+package expr
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunBatch(t *testing.T) {
+	tenant := map[string]interface{}{"rate": 2, "plan": "pro"}
+	env := map[string]interface{}{"amount": 0, "tenant": tenant, "tags": []string{}}
+	program, err := Compile(`tenant.plan == "pro" ? amount * tenant.rate : amount`, Env(env))
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+
+	envs := []map[string]interface{}{
+		{"amount": 10, "tenant": tenant},
+		{"amount": 10, "tenant": tenant},
+		{"amount": 7, "tenant": map[string]interface{}{"rate": 3, "plan": "free"}},
+		{"amount": 7, "tenant": tenant},
+	}
+	results, errs := program.RunBatch(envs)
+	if errs != nil {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+	expected := []interface{}{int64(20), int64(20), int64(7), int64(14)}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("Expected %v, got %v", expected, results)
+	}
+
+	// Each result matches a separate run
+	for i, environment := range envs {
+		result, err := Run(program, environment)
+		if err != nil || result != results[i] {
+			t.Errorf("Environment %d: Run gives %v (%v), RunBatch gave %v", i, result, err, results[i])
+		}
+	}
+}
+
+func TestRunBatchErrors(t *testing.T) {
+	program, err := Compile(`10 / n`, Env(map[string]interface{}{"n": 1}))
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+
+	results, errs := program.RunBatch([]map[string]interface{}{{"n": 2}, {"n": 0}, {"n": 5}, {"n": struct{}{}}, {"n": 5}})
+	if len(errs) != 5 || errs[0] != nil || errs[2] != nil || errs[4] != nil {
+		t.Fatalf("Expected errors for items 1 and 3 only, got %v", errs)
+	}
+	if !strings.Contains(errs[1].Error(), "division by zero") {
+		t.Errorf("Expected a division error, got %v", errs[1])
+	}
+	if errs[3] == nil {
+		t.Errorf("Expected an error for an unconvertible variable")
+	}
+	if results[0] != int64(5) || results[1] != nil || results[2] != int64(2) || results[4] != int64(2) {
+		t.Errorf("Unexpected results %v", results)
+	}
+}
+
+func TestRunEach(t *testing.T) {
+	program, err := Compile(`n * 2`, Env(map[string]interface{}{"n": 1}))
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+
+	n := 0
+	next := func() (map[string]interface{}, bool) {
+		n++
+		return map[string]interface{}{"n": n}, true
+	}
+	var results []interface{}
+	program.RunEach(next, func(index int, result interface{}, err error) bool {
+		if err != nil || index != len(results) {
+			t.Fatalf("Unexpected item %d: %v", index, err)
+		}
+		results = append(results, result)
+		return len(results) < 3
+	})
+	if !reflect.DeepEqual(results, []interface{}{int64(2), int64(4), int64(6)}) {
+		t.Errorf("Expected [2 4 6], got %v", results)
+	}
+}
+
+func batchBenchmarkEnvs(b *testing.B) (*Program, []map[string]interface{}) {
+	tenant := map[string]interface{}{"rate": 2, "plan": "pro", "countries": []interface{}{"NO", "SE"}}
+	envs := make([]map[string]interface{}, 1000)
+	for i := range envs {
+		envs[i] = map[string]interface{}{"amount": i, "country": "NO", "tenant": tenant}
+	}
+	program, err := Compile(`tenant.plan == "pro" && country != "" && len(tenant.countries) > 1 ? amount * tenant.rate : amount`, Env(envs[0]))
+	if err != nil {
+		b.Fatalf("Compile error: %v", err)
+	}
+	return program, envs
+}
+
+func BenchmarkRunLoop(b *testing.B) {
+	program, envs := batchBenchmarkEnvs(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, environment := range envs {
+			if _, err := Run(program, environment); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkRunBatch(b *testing.B) {
+	program, envs := batchBenchmarkEnvs(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, errs := program.RunBatch(envs); errs != nil {
+			b.Fatal(errs)
+		}
+	}
+}
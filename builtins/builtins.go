@@ -1,6 +1,7 @@
 package builtins
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"regexp"
@@ -14,6 +15,9 @@ import (
 // BuiltinFunction represents a builtin function
 type BuiltinFunction func(args []types.Value) (types.Value, error)
 
+// ContextBuiltinFunction represents a builtin function that honours cancellation
+type ContextBuiltinFunction func(ctx context.Context, args []types.Value) (types.Value, error)
+
 // StandardBuiltinNames defines the standard order of builtin functions for indexing
 var StandardBuiltinNames = []string{
 	// Core builtins
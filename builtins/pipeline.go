@@ -1,6 +1,7 @@
 ﻿package builtins
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"strings"
@@ -47,9 +48,32 @@ var PipelineBuiltins = map[string]BuiltinFunction{
 	"pipe":  pipeFunc,
 }
 
+// ContextBuiltins contains context-aware variants of the long-running collection
+// builtins, used by the VM when an evaluation can be cancelled
+var ContextBuiltins = map[string]ContextBuiltinFunction{
+	"filter": filterContextFunc,
+	"map":    mapContextFunc,
+	"reduce": reduceContextFunc,
+}
+
+// cancelCheckInterval is the number of elements processed between context checks
+const cancelCheckInterval = 256
+
+// checkContext returns the context error, if any, every cancelCheckInterval elements
+func checkContext(ctx context.Context, i int) error {
+	if i%cancelCheckInterval != 0 {
+		return nil
+	}
+	return ctx.Err()
+}
+
 // Collection processing functions
 
 func filterFunc(args []types.Value) (types.Value, error) {
+	return filterContextFunc(context.Background(), args)
+}
+
+func filterContextFunc(ctx context.Context, args []types.Value) (types.Value, error) {
 	if len(args) != 2 {
 		return nil, fmt.Errorf("filter requires exactly 2 arguments")
 	}
@@ -60,6 +84,9 @@ func filterFunc(args []types.Value) (types.Value, error) {
 	if array, ok := collection.(*types.SliceValue); ok {
 		var result []types.Value
 		for i := 0; i < array.Len(); i++ {
+			if err := checkContext(ctx, i); err != nil {
+				return nil, err
+			}
 			item := array.Get(i)
 			// Apply predicate (for now, simplified)
 			if shouldInclude(item, predicate) {
@@ -73,6 +100,10 @@ func filterFunc(args []types.Value) (types.Value, error) {
 }
 
 func mapFunc(args []types.Value) (types.Value, error) {
+	return mapContextFunc(context.Background(), args)
+}
+
+func mapContextFunc(ctx context.Context, args []types.Value) (types.Value, error) {
 	if len(args) != 2 {
 		return nil, fmt.Errorf("map requires exactly 2 arguments")
 	}
@@ -83,6 +114,9 @@ func mapFunc(args []types.Value) (types.Value, error) {
 	if array, ok := collection.(*types.SliceValue); ok {
 		var result []types.Value
 		for i := 0; i < array.Len(); i++ {
+			if err := checkContext(ctx, i); err != nil {
+				return nil, err
+			}
 			item := array.Get(i)
 			// Apply transformer (for now, simplified)
 			transformed := applyTransform(item, transformer)
@@ -95,6 +129,10 @@ func mapFunc(args []types.Value) (types.Value, error) {
 }
 
 func reduceFunc(args []types.Value) (types.Value, error) {
+	return reduceContextFunc(context.Background(), args)
+}
+
+func reduceContextFunc(ctx context.Context, args []types.Value) (types.Value, error) {
 	if len(args) < 2 || len(args) > 3 {
 		return nil, fmt.Errorf("reduce requires 2 or 3 arguments")
 	}
@@ -122,6 +160,9 @@ func reduceFunc(args []types.Value) (types.Value, error) {
 		}
 
 		for i := start; i < array.Len(); i++ {
+			if err := checkContext(ctx, i-start); err != nil {
+				return nil, err
+			}
 			acc = applyReducer(acc, array.Get(i), reducer)
 		}
 
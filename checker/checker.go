@@ -10,15 +10,21 @@ import (
 
 // Checker performs static type checking on AST nodes
 type Checker struct {
-	scope  *Scope
-	errors []string
+	scope     *Scope
+	errors    []*lexer.SourceError
+	lenient   bool
+	functions map[string]bool
+	operators map[string]bool
+	modules   map[string]map[string]*FunctionInfo
+	imports   map[string]string // Module aliases to module names
+	result    types.TypeInfo    // Type of the last expression statement
 }
 
 // New creates a new type checker
 func New() *Checker {
 	return &Checker{
 		scope:  NewRootScope(),
-		errors: []string{},
+		errors: []*lexer.SourceError{},
 	}
 }
 
@@ -26,7 +32,7 @@ func New() *Checker {
 func NewWithScope(scope *Scope) *Checker {
 	return &Checker{
 		scope:  scope,
-		errors: []string{},
+		errors: []*lexer.SourceError{},
 	}
 }
 
@@ -37,7 +43,7 @@ func (c *Checker) Check(program *ast.Program) error {
 	}
 
 	if len(c.errors) > 0 {
-		return fmt.Errorf("type checking failed: %v", c.errors)
+		return fmt.Errorf("type checking failed: %v", c.Errors())
 	}
 
 	return nil
@@ -48,14 +54,29 @@ func (c *Checker) CheckExpression(expr ast.Expression) (types.TypeInfo, error) {
 	typeInfo := c.checkExpression(expr)
 
 	if len(c.errors) > 0 {
-		return types.TypeInfo{}, fmt.Errorf("type checking failed: %v", c.errors)
+		return types.TypeInfo{}, fmt.Errorf("type checking failed: %v", c.Errors())
 	}
 
 	return typeInfo, nil
 }
 
+// ResultType returns the type of the last expression statement checked by
+// Check, which is the type of the value a program produces
+func (c *Checker) ResultType() types.TypeInfo {
+	return c.result
+}
+
 // Errors returns the type checking errors
 func (c *Checker) Errors() []string {
+	messages := make([]string, len(c.errors))
+	for i, err := range c.errors {
+		messages[i] = err.Error()
+	}
+	return messages
+}
+
+// SourceErrors returns the type checking errors with their positions
+func (c *Checker) SourceErrors() []*lexer.SourceError {
 	return c.errors
 }
 
@@ -72,11 +93,83 @@ func (c *Checker) WithEnvironment(env map[string]types.TypeInfo) *Checker {
 	return c
 }
 
+// WithFunctions adds functions to the scope. Their arguments are also
+// checked in lenient mode.
+func (c *Checker) WithFunctions(functions map[string]*FunctionInfo) *Checker {
+	if c.functions == nil {
+		c.functions = make(map[string]bool)
+	}
+	for name, funcInfo := range functions {
+		c.scope.DefineFunction(name, funcInfo)
+		c.functions[name] = true
+	}
+	return c
+}
+
+// WithOperators declares custom and overloaded operators. Their operands
+// are not checked since they are implemented by Go functions.
+func (c *Checker) WithOperators(symbols []string) *Checker {
+	if c.operators == nil {
+		c.operators = make(map[string]bool)
+	}
+	for _, symbol := range symbols {
+		c.operators[symbol] = true
+	}
+	return c
+}
+
+// WithModules declares the modules that can be called and the signatures of
+// their functions. Functions with a nil signature accept any arguments.
+func (c *Checker) WithModules(modules map[string]map[string]*FunctionInfo) *Checker {
+	if c.modules == nil {
+		c.modules = make(map[string]map[string]*FunctionInfo)
+	}
+	for name, functions := range modules {
+		c.modules[name] = functions
+	}
+	return c
+}
+
+// Lenient makes the checker follow the dynamic semantics of the VM: values
+// of unknown type are accepted, maps are open and only operations that
+// always fail at runtime are reported
+func (c *Checker) Lenient() *Checker {
+	c.lenient = true
+	return c
+}
+
 // checkStatement checks a statement
 func (c *Checker) checkStatement(stmt ast.Statement) {
 	switch s := stmt.(type) {
 	case *ast.ExpressionStatement:
-		c.checkExpression(s.Expression)
+		c.result = c.checkExpression(s.Expression)
+	case *ast.LetStatement:
+		c.scope.DefineVariable(s.Name, c.checkExpression(s.Value))
+	case *ast.ImportStatement:
+		// Unknown modules are reported by the compiler; imported functions
+		// shadow builtins of the same name
+		if len(s.Names) == 0 {
+			alias := s.Alias
+			if alias == "" {
+				alias = s.ModuleName
+			}
+			if c.imports == nil {
+				c.imports = make(map[string]string)
+			}
+			c.imports[alias] = s.ModuleName
+		}
+		for _, name := range s.Names {
+			if funcInfo := c.modules[s.ModuleName][name]; funcInfo != nil {
+				c.scope.DefineFunction(name, funcInfo)
+				if c.functions == nil {
+					c.functions = make(map[string]bool)
+				}
+				c.functions[name] = true
+				continue
+			}
+			c.scope.DefineFunction(name, &FunctionInfo{Name: name})
+			delete(c.functions, name)
+		}
 	default:
 		c.addError(fmt.Sprintf("unknown statement type: %T", stmt))
 	}
@@ -113,6 +206,18 @@ func (c *Checker) checkExpression(expr ast.Expression) types.TypeInfo {
 		return c.checkBuiltinExpression(e)
 	case *ast.VariableExpression:
 		return c.checkVariableExpression(e)
+	case *ast.LambdaExpression:
+		return c.checkLambdaExpression(e)
+	case *ast.PipeExpression:
+		return c.checkPipeExpression(e)
+	case *ast.OptionalChainingExpression:
+		return c.checkOptionalChainingExpression(e)
+	case *ast.NullCoalescingExpression:
+		return c.checkNullCoalescingExpression(e)
+	case *ast.ModuleCallExpression:
+		return c.checkModuleCall(e.Module, e.Function, e.Arguments, e.Pos)
+	case *ast.PlaceholderExpression, *ast.WildcardExpression:
+		return interfaceType
 	default:
 		c.addError(fmt.Sprintf("unknown expression type: %T", expr))
 		return types.TypeInfo{Kind: types.KindNil, Name: "unknown"}
@@ -134,6 +239,9 @@ func (c *Checker) checkIdentifier(ident *ast.Identifier) types.TypeInfo {
 		ident.TypeInfo = typeInfo
 		return typeInfo
 	}
+	if c.lenient {
+		return interfaceType
+	}
 
 	c.addError(fmt.Sprintf("undefined variable: %s", ident.Value))
 	return types.TypeInfo{Kind: types.KindNil, Name: "undefined"}
@@ -145,6 +253,9 @@ func (c *Checker) checkVariableExpression(varExpr *ast.VariableExpression) types
 		varExpr.TypeInfo = typeInfo
 		return typeInfo
 	}
+	if c.lenient {
+		return interfaceType
+	}
 
 	c.addError(fmt.Sprintf("undefined variable: %s", varExpr.Name))
 	return types.TypeInfo{Kind: types.KindNil, Name: "undefined"}
@@ -155,7 +266,12 @@ func (c *Checker) checkInfixExpression(infix *ast.InfixExpression) types.TypeInf
 	leftType := c.checkExpression(infix.Left)
 	rightType := c.checkExpression(infix.Right)
 
-	resultType := c.inferInfixType(infix.Operator, leftType, rightType, infix.Pos)
+	var resultType types.TypeInfo
+	if c.lenient {
+		resultType = c.inferDynamicInfixType(infix.Operator, leftType, rightType, infix.Pos)
+	} else {
+		resultType = c.inferInfixType(infix.Operator, leftType, rightType, infix.Pos)
+	}
 	infix.TypeInfo = resultType
 	return resultType
 }
@@ -164,13 +280,32 @@ func (c *Checker) checkInfixExpression(infix *ast.InfixExpression) types.TypeInf
 func (c *Checker) checkPrefixExpression(prefix *ast.PrefixExpression) types.TypeInfo {
 	rightType := c.checkExpression(prefix.Right)
 
-	resultType := c.inferPrefixType(prefix.Operator, rightType, prefix.Pos)
+	var resultType types.TypeInfo
+	if c.lenient {
+		resultType = c.inferDynamicPrefixType(prefix.Operator, rightType, prefix.Pos)
+	} else {
+		resultType = c.inferPrefixType(prefix.Operator, rightType, prefix.Pos)
+	}
 	prefix.TypeInfo = resultType
 	return resultType
 }
 
 // checkCallExpression checks a function call expression
 func (c *Checker) checkCallExpression(call *ast.CallExpression) types.TypeInfo {
+	if member, ok := call.Function.(*ast.MemberExpression); ok {
+		object, isIdent := member.Object.(*ast.Identifier)
+		property, isName := member.Property.(*ast.Identifier)
+		if isIdent && isName {
+			if _, isModule := c.resolveModule(object.Value); isModule {
+				return c.checkModuleCall(object.Value, property.Value, call.Arguments, call.Pos)
+			}
+		}
+	}
+
+	if c.lenient {
+		return c.checkDynamicCall(call)
+	}
+
 	// Check if it's a function identifier
 	if ident, ok := call.Function.(*ast.Identifier); ok {
 		if funcInfo, exists := c.scope.LookupFunction(ident.Value); exists {
@@ -180,6 +315,18 @@ func (c *Checker) checkCallExpression(call *ast.CallExpression) types.TypeInfo {
 		return types.TypeInfo{Kind: types.KindNil, Name: "undefined"}
 	}
 
+	// Methods of Go values are checked against their signatures
+	if member, ok := call.Function.(*ast.MemberExpression); ok {
+		if property, ok := member.Property.(*ast.Identifier); ok {
+			object := c.checkExpression(member.Object)
+			if method, ok := findMethod(object, property.Value); ok {
+				return c.checkFunctionCall(method, call.Arguments, call.Pos)
+			}
+			c.addError(fmt.Sprintf("method %s not found in type %s", property.Value, object.Name))
+			return types.TypeInfo{Kind: types.KindNil, Name: "error"}
+		}
+	}
+
 	// For other function expressions, we need to check the function type
 	funcType := c.checkExpression(call.Function)
 	if funcType.Kind != types.KindFunc {
@@ -195,6 +342,9 @@ func (c *Checker) checkCallExpression(call *ast.CallExpression) types.TypeInfo {
 func (c *Checker) checkIndexExpression(index *ast.IndexExpression) types.TypeInfo {
 	leftType := c.checkExpression(index.Left)
 	indexType := c.checkExpression(index.Index)
+	if c.lenient {
+		return c.inferDynamicIndexType(leftType)
+	}
 
 	switch leftType.Kind {
 	case types.KindSlice, types.KindArray:
@@ -245,6 +395,12 @@ func (c *Checker) checkMemberExpression(member *ast.MemberExpression) types.Type
 		return result
 	}
 
+	if c.lenient {
+		result := c.inferDynamicMemberType(member, objectType, member.Property)
+		member.TypeInfo = result
+		return result
+	}
+
 	// Handle identifier property
 	if ident, ok := member.Property.(*ast.Identifier); ok {
 		if objectType.Kind == types.KindStruct {
@@ -256,6 +412,10 @@ func (c *Checker) checkMemberExpression(member *ast.MemberExpression) types.Type
 					return field.Type
 				}
 			}
+			if _, ok := findMethod(objectType, ident.Value); ok {
+				member.TypeInfo = methodType
+				return methodType
+			}
 			c.addError(fmt.Sprintf("field %s not found in struct %s",
 				ident.Value, objectType.Name))
 		} else {
@@ -276,8 +436,8 @@ func (c *Checker) checkConditionalExpression(cond *ast.ConditionalExpression) ty
 	consequentType := c.checkExpression(cond.Consequent)
 	alternativeType := c.checkExpression(cond.Alternative)
 
-	// Test must be boolean
-	if testType.Kind != types.KindBool {
+	// Test must be boolean, any value is truthy or falsy in lenient mode
+	if testType.Kind != types.KindBool && !c.lenient {
 		c.addError(fmt.Sprintf("conditional test must be boolean, got %s", testType.Name))
 	}
 
@@ -317,6 +477,10 @@ func (c *Checker) checkArrayLiteral(array *ast.ArrayLiteral) types.TypeInfo {
 	for i, elem := range array.Elements[1:] {
 		elemType := c.checkExpression(elem)
 		if !firstType.Compatible(elemType) {
+			if c.lenient {
+				firstType = interfaceType
+				continue
+			}
 			c.addError(fmt.Sprintf("array element %d type mismatch: expected %s, got %s",
 				i+1, firstType.Name, elemType.Name))
 		}
@@ -358,7 +522,7 @@ func (c *Checker) checkMapLiteral(mapLit *ast.MapLiteral) types.TypeInfo {
 		pairKeyType := c.checkExpression(pair.Key)
 		pairValType := c.checkExpression(pair.Value)
 
-		if !keyType.Compatible(pairKeyType) {
+		if !keyType.Compatible(pairKeyType) && !c.lenient {
 			c.addError(fmt.Sprintf("map key type mismatch: expected %s, got %s",
 				keyType.Name, pairKeyType.Name))
 		}
@@ -384,6 +548,12 @@ func (c *Checker) checkMapLiteral(mapLit *ast.MapLiteral) types.TypeInfo {
 
 // checkBuiltinExpression checks a builtin expression
 func (c *Checker) checkBuiltinExpression(builtin *ast.BuiltinExpression) types.TypeInfo {
+	if c.lenient {
+		result := c.checkDynamicBuiltin(builtin)
+		builtin.TypeInfo = result
+		return result
+	}
+
 	if funcInfo, ok := c.scope.LookupFunction(builtin.Name); ok {
 		result := c.checkFunctionCall(funcInfo, builtin.Arguments, builtin.Pos)
 		builtin.TypeInfo = result
@@ -394,16 +564,47 @@ func (c *Checker) checkBuiltinExpression(builtin *ast.BuiltinExpression) types.T
 	return types.TypeInfo{Kind: types.KindNil, Name: "undefined"}
 }
 
+// checkModuleCall checks the arguments of a module function call against
+// the signature of the function
+func (c *Checker) checkModuleCall(module, function string, args []ast.Expression, pos lexer.Position) types.TypeInfo {
+	name, _ := c.resolveModule(module)
+	funcInfo := c.modules[name][function]
+	if funcInfo == nil {
+		for _, arg := range args {
+			c.checkExpression(arg)
+		}
+		return interfaceType
+	}
+
+	if c.lenient {
+		return c.checkDynamicArguments(funcInfo, args, pos)
+	}
+	return c.checkFunctionCall(funcInfo, args, pos)
+}
+
+// resolveModule returns the module a name refers to: an import alias, or a
+// declared module that is not shadowed by a variable
+func (c *Checker) resolveModule(name string) (string, bool) {
+	if module, ok := c.imports[name]; ok {
+		return module, true
+	}
+	if _, ok := c.scope.LookupVariable(name); ok {
+		return "", false
+	}
+	_, ok := c.modules[name]
+	return name, ok
+}
+
 // Helper methods
 
 // addError adds an error to the error list
 func (c *Checker) addError(msg string) {
-	c.errors = append(c.errors, msg)
+	c.errors = append(c.errors, lexer.NewSourceError(lexer.NoPos, lexer.NoPos, msg))
 }
 
 // addErrorAt adds an error with position information
 func (c *Checker) addErrorAt(pos lexer.Position, msg string) {
-	c.errors = append(c.errors, fmt.Sprintf("%s: %s", pos.String(), msg))
+	c.errors = append(c.errors, lexer.NewSourceError(pos, lexer.NoPos, msg))
 }
 
 // checkFunctionCall checks a function call
@@ -1,6 +1,7 @@
 package checker
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/mredencom/expr/ast"
@@ -416,6 +417,185 @@ func TestComplexExpression(t *testing.T) {
 	}
 }
 
+func TestCheckLetStatements(t *testing.T) {
+	checker := New()
+	checker.WithEnvironment(map[string]types.TypeInfo{"price": types.FloatType})
+
+	program := parseProgram(t, `let total = price * 2.0; let label = "total"; total > 10.0`)
+	if err := checker.Check(program); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if typeInfo, ok := checker.Scope().LookupVariable("total"); !ok || typeInfo.Kind != types.KindFloat64 {
+		t.Errorf("Expected total to be float, got %v", typeInfo)
+	}
+
+	checker = New()
+	if err := checker.Check(parseProgram(t, `let label = "x"; label - 1`)); err == nil {
+		t.Error("Expected type error for string let binding")
+	}
+}
+
+func TestLenientChecking(t *testing.T) {
+	userType := types.TypeInfo{
+		Kind: types.KindStruct,
+		Name: "User",
+		Fields: []types.FieldInfo{
+			{Name: "name", Type: types.StringType},
+			{Name: "age", Type: types.IntType},
+		},
+	}
+	metaType := types.TypeInfo{
+		Kind:   types.KindMap,
+		Name:   "map[string]interface{}",
+		Fields: []types.FieldInfo{{Name: "score", Type: types.FloatType}},
+	}
+	env := map[string]types.TypeInfo{
+		"user": userType,
+		"meta": metaType,
+		"any":  interfaceType,
+	}
+	functions := map[string]*FunctionInfo{
+		"discount": {
+			Name:    "discount",
+			Params:  []types.TypeInfo{types.FloatType},
+			Returns: []types.TypeInfo{types.FloatType},
+		},
+	}
+
+	tests := []struct {
+		input       string
+		expectedErr string
+	}{
+		{`user.age > 18 && user.name != ""`, ""},
+		{`user.agee > 18`, "user.agee: unknown field"},
+		{`user?.nickname`, "user?.nickname: unknown field"},
+		{`user.name == 18`, "cannot compare string with int"},
+		{`meta.score > "high"`, "cannot compare float with string"},
+		{`meta.level + any`, ""},
+		{`any.deep.field > 1`, ""},
+		{`user.name + 1`, "invalid operation: string + int"},
+		{`-user.name`, "invalid operation: -string"},
+		{`user.age ? "yes" : "no"`, ""},
+		{`user.name.upper()`, ""},
+		{`filter([1, 2], # > 1)`, ""},
+		{`map([1, 2], x => x * 2)`, ""},
+		{`[user.name, user.age]`, ""},
+		{`user | len`, ""},
+		{`discount(user.age) > 1`, ""},
+		{`discount(user.name)`, "function discount argument 1 type mismatch: expected float, got string"},
+		{`discount()`, "function discount expects 1 arguments, got 0"},
+		{`user.name <=> 1`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := parser.NewWithOperators(l, map[string]parser.Precedence{"<=>": parser.EQUALS})
+			program := p.ParseProgram()
+			if len(p.Errors()) > 0 {
+				t.Fatalf("Parser errors: %v", p.Errors())
+			}
+
+			checker := New().Lenient().WithEnvironment(env).WithFunctions(functions).WithOperators([]string{"<=>"})
+			err := checker.Check(program)
+
+			if tt.expectedErr == "" {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Expected error containing %q", tt.expectedErr)
+			}
+			if !strings.Contains(err.Error(), tt.expectedErr) || !strings.Contains(err.Error(), "line 1, column") {
+				t.Errorf("Expected positioned error containing %q, got %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestCheckModuleCalls(t *testing.T) {
+	modules := map[string]map[string]*FunctionInfo{
+		"geo": {
+			"distance": {Name: "geo.distance", Params: []types.TypeInfo{types.FloatType, types.FloatType}, Returns: []types.TypeInfo{types.FloatType}},
+			"any":      nil,
+		},
+	}
+	env := map[string]types.TypeInfo{"lat": types.FloatType, "city": types.StringType}
+
+	tests := []struct {
+		input       string
+		expectedErr string
+	}{
+		{`geo.distance(lat, 1) > 2.0`, ""},
+		{`geo.distance(lat, city)`, "function geo.distance argument 2 type mismatch: expected float, got string"},
+		{`geo.distance(lat)`, "function geo.distance expects 2 arguments, got 1"},
+		{`geo.distance(lat, 1) + city`, "invalid operation: float + string"},
+		{`geo.any(city, 1, true)`, ""},
+		{`import "geo" as g; g.distance(city, lat)`, "function geo.distance argument 1 type mismatch"},
+		{`from geo import distance; distance(lat, lat) - 1`, ""},
+		{`from geo import distance; distance(city, lat)`, "function geo.distance argument 1 type mismatch"},
+		{`let geo = city; geo.distance(city)`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			err := New().Lenient().WithEnvironment(env).WithModules(modules).Check(parseProgram(t, tt.input))
+			if tt.expectedErr == "" {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestCheckMethodCalls(t *testing.T) {
+	order := types.TypeInfo{
+		Kind:   types.KindStruct,
+		Name:   "Order",
+		Fields: []types.FieldInfo{{Name: "ID", Type: types.IntType}},
+		Methods: []types.MethodInfo{
+			{Name: "Total", Returns: []types.TypeInfo{types.FloatType}},
+			{Name: "HasRole", Params: []types.TypeInfo{types.StringType}, Returns: []types.TypeInfo{types.BoolType}},
+		},
+	}
+	env := map[string]types.TypeInfo{"order": order}
+
+	tests := []struct {
+		input       string
+		expectedErr string
+	}{
+		{`order.Total() > 100`, ""},
+		{`order.HasRole("admin") && order.ID > 0`, ""},
+		{`order.Total`, ""},
+		{`order.HasRole(1)`, "function Order.HasRole argument 1 type mismatch: expected string, got int"},
+		{`order.Total(1)`, "function Order.Total expects 0 arguments, got 1"},
+		{`order.Total() + "x"`, "invalid operation: float + string"},
+		{`order.Cancel()`, "order.Cancel: unknown method"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			err := New().Lenient().WithEnvironment(env).Check(parseProgram(t, tt.input))
+			if tt.expectedErr == "" {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
 // Helper functions
 
 func parseProgram(t *testing.T, input string) *ast.Program {
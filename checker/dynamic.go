@@ -0,0 +1,315 @@
+package checker
+
+import (
+	"fmt"
+
+	"github.com/mredencom/expr/ast"
+	"github.com/mredencom/expr/lexer"
+	"github.com/mredencom/expr/types"
+)
+
+// interfaceType is the type of values only known at runtime
+var interfaceType = types.TypeInfo{Kind: types.KindInterface, Name: "interface{}"}
+
+// methodType is the type of a method of a Go value bound to its receiver
+var methodType = types.TypeInfo{Kind: types.KindFunc, Name: "func"}
+
+// checkLambdaExpression checks a lambda body with its parameters in scope
+func (c *Checker) checkLambdaExpression(lambda *ast.LambdaExpression) types.TypeInfo {
+	scope := NewScope(c.scope)
+	for _, param := range lambda.Parameters {
+		scope.DefineVariable(param, interfaceType)
+	}
+
+	outer := c.scope
+	c.scope = scope
+	c.checkExpression(lambda.Body)
+	c.scope = outer
+
+	result := types.TypeInfo{Kind: types.KindFunc, Name: "func"}
+	lambda.TypeInfo = result
+	return result
+}
+
+// checkPipeExpression checks both sides of a pipe. The piped value is passed
+// implicitly, so only the explicit arguments of the stage are checked.
+func (c *Checker) checkPipeExpression(pipe *ast.PipeExpression) types.TypeInfo {
+	c.checkExpression(pipe.Left)
+
+	switch stage := pipe.Right.(type) {
+	case *ast.BuiltinExpression:
+		for _, arg := range stage.Arguments {
+			c.checkExpression(arg)
+		}
+	case *ast.CallExpression:
+		for _, arg := range stage.Arguments {
+			c.checkExpression(arg)
+		}
+	case *ast.Identifier:
+	default:
+		c.checkExpression(pipe.Right)
+	}
+
+	pipe.TypeInfo = interfaceType
+	return interfaceType
+}
+
+// checkOptionalChainingExpression checks a null-safe member access
+func (c *Checker) checkOptionalChainingExpression(chain *ast.OptionalChainingExpression) types.TypeInfo {
+	objectType := c.checkExpression(chain.Object)
+	if _, ok := chain.Property.(*ast.Identifier); !ok {
+		c.checkExpression(chain.Property)
+		return interfaceType
+	}
+
+	result := c.inferDynamicMemberType(chain, objectType, chain.Property)
+	chain.TypeInfo = result
+	return result
+}
+
+// checkNullCoalescingExpression checks a null coalescing expression
+func (c *Checker) checkNullCoalescingExpression(coalescing *ast.NullCoalescingExpression) types.TypeInfo {
+	leftType := c.checkExpression(coalescing.Left)
+	rightType := c.checkExpression(coalescing.Right)
+
+	result := interfaceType
+	if leftType.Compatible(rightType) && rightType.Compatible(leftType) {
+		result = leftType
+	}
+	coalescing.TypeInfo = result
+	return result
+}
+
+// checkDynamicCall checks a call in lenient mode. Methods are resolved at
+// runtime, so only the receiver and the arguments are checked.
+func (c *Checker) checkDynamicCall(call *ast.CallExpression) types.TypeInfo {
+	switch fn := call.Function.(type) {
+	case *ast.MemberExpression:
+		object := c.checkExpression(fn.Object)
+		if property, ok := fn.Property.(*ast.Identifier); ok {
+			// Methods of Go values are checked against their signatures
+			if method, ok := findMethod(object, property.Value); ok {
+				return c.checkDynamicArguments(method, call.Arguments, call.Pos)
+			}
+			if object.Kind == types.KindStruct && !hasField(object, property.Value) {
+				c.addErrorAt(property.Pos, fmt.Sprintf("%s: unknown method", fn.String()))
+			}
+		}
+	case *ast.Identifier:
+	default:
+		c.checkExpression(fn)
+	}
+
+	for _, arg := range call.Arguments {
+		c.checkExpression(arg)
+	}
+	return interfaceType
+}
+
+// checkDynamicBuiltin checks a function call in lenient mode. Functions
+// declared with WithFunctions have their arguments checked, other functions
+// only contribute their result type.
+func (c *Checker) checkDynamicBuiltin(builtin *ast.BuiltinExpression) types.TypeInfo {
+	funcInfo, ok := c.scope.LookupFunction(builtin.Name)
+	if !ok || !c.functions[builtin.Name] {
+		for _, arg := range builtin.Arguments {
+			c.checkExpression(arg)
+		}
+		if ok && len(funcInfo.Returns) > 0 {
+			return funcInfo.Returns[0]
+		}
+		return interfaceType
+	}
+
+	return c.checkDynamicArguments(funcInfo, builtin.Arguments, builtin.Pos)
+}
+
+// checkDynamicArguments checks the arguments of a call in lenient mode and
+// returns the result type of the function
+func (c *Checker) checkDynamicArguments(funcInfo *FunctionInfo, args []ast.Expression, pos lexer.Position) types.TypeInfo {
+	expectedArgs := len(funcInfo.Params)
+	if funcInfo.Variadic && len(args) < expectedArgs-1 {
+		c.addErrorAt(pos, fmt.Sprintf("function %s expects at least %d arguments, got %d",
+			funcInfo.Name, expectedArgs-1, len(args)))
+	} else if !funcInfo.Variadic && len(args) != expectedArgs {
+		c.addErrorAt(pos, fmt.Sprintf("function %s expects %d arguments, got %d",
+			funcInfo.Name, expectedArgs, len(args)))
+	}
+
+	for i, arg := range args {
+		argType := c.checkExpression(arg)
+
+		var expectedType types.TypeInfo
+		if i < expectedArgs && !(funcInfo.Variadic && i == expectedArgs-1) {
+			expectedType = funcInfo.Params[i]
+		} else if funcInfo.Variadic {
+			expectedType = funcInfo.Params[expectedArgs-1]
+		} else {
+			continue
+		}
+
+		expectedClass, argClass := valueClass(expectedType), valueClass(argType)
+		if expectedClass != "" && argClass != "" && expectedClass != argClass {
+			c.addErrorAt(pos, fmt.Sprintf("function %s argument %d type mismatch: expected %s, got %s",
+				funcInfo.Name, i+1, expectedType.Name, argType.Name))
+		}
+	}
+
+	if len(funcInfo.Returns) > 0 {
+		return funcInfo.Returns[0]
+	}
+	return interfaceType
+}
+
+// inferDynamicMemberType resolves a member access in lenient mode. Struct
+// fields are known statically, maps are open and other values may have
+// members resolved at runtime.
+func (c *Checker) inferDynamicMemberType(member ast.Expression, object types.TypeInfo, property ast.Expression) types.TypeInfo {
+	ident, ok := property.(*ast.Identifier)
+	if !ok {
+		return interfaceType
+	}
+
+	switch object.Kind {
+	case types.KindStruct:
+		for _, field := range object.Fields {
+			if field.Name == ident.Value {
+				ident.TypeInfo = field.Type
+				return field.Type
+			}
+		}
+		if _, ok := findMethod(object, ident.Value); ok {
+			return methodType
+		}
+		c.addErrorAt(ident.Pos, fmt.Sprintf("%s: unknown field", member.String()))
+	case types.KindMap:
+		for _, field := range object.Fields {
+			if field.Name == ident.Value {
+				ident.TypeInfo = field.Type
+				return field.Type
+			}
+		}
+		if object.ValType != nil {
+			return *object.ValType
+		}
+	}
+	return interfaceType
+}
+
+// inferDynamicIndexType infers the element type of an index expression
+func (c *Checker) inferDynamicIndexType(left types.TypeInfo) types.TypeInfo {
+	switch left.Kind {
+	case types.KindSlice, types.KindArray:
+		if left.ElemType != nil {
+			return *left.ElemType
+		}
+	case types.KindMap:
+		if left.ValType != nil {
+			return *left.ValType
+		}
+	case types.KindString:
+		return types.StringType
+	}
+	return interfaceType
+}
+
+// inferDynamicInfixType infers the result type of an infix operation in
+// lenient mode, reporting only operand types the VM always rejects
+func (c *Checker) inferDynamicInfixType(op string, left, right types.TypeInfo, pos lexer.Position) types.TypeInfo {
+	if c.operators[op] {
+		return interfaceType
+	}
+
+	leftClass, rightClass := valueClass(left), valueClass(right)
+	known := leftClass != "" && rightClass != ""
+
+	switch op {
+	case "==", "!=":
+		if known && leftClass != rightClass {
+			c.addErrorAt(pos, fmt.Sprintf("cannot compare %s with %s", left.Name, right.Name))
+		}
+		return types.BoolType
+
+	case "<", "<=", ">", ">=":
+		if known && (leftClass != rightClass || leftClass == "bool") {
+			c.addErrorAt(pos, fmt.Sprintf("cannot compare %s with %s", left.Name, right.Name))
+		}
+		return types.BoolType
+
+	case "&&", "||", "in", "matches", "contains", "startsWith", "endsWith":
+		return types.BoolType
+
+	case "+":
+		if leftClass == "string" && rightClass == "string" {
+			return types.StringType
+		}
+		fallthrough
+	case "-", "*", "/", "%", "**":
+		if leftClass == "number" && rightClass == "number" {
+			if left.IsFloat() || right.IsFloat() {
+				return types.FloatType
+			}
+			return types.IntType
+		}
+		if known {
+			c.addErrorAt(pos, fmt.Sprintf("invalid operation: %s %s %s", left.Name, op, right.Name))
+		}
+	}
+	return interfaceType
+}
+
+// inferDynamicPrefixType infers the result type of a prefix operation in
+// lenient mode
+func (c *Checker) inferDynamicPrefixType(op string, right types.TypeInfo, pos lexer.Position) types.TypeInfo {
+	switch op {
+	case "!":
+		return types.BoolType
+	case "-":
+		switch valueClass(right) {
+		case "number":
+			return right
+		case "string", "bool":
+			c.addErrorAt(pos, fmt.Sprintf("invalid operation: -%s", right.Name))
+		}
+	}
+	return interfaceType
+}
+
+// findMethod returns the signature of the Go method called name of a type
+func findMethod(object types.TypeInfo, name string) (*FunctionInfo, bool) {
+	for _, method := range object.Methods {
+		if method.Name == name {
+			return &FunctionInfo{
+				Name:     object.Name + "." + name,
+				Params:   method.Params,
+				Returns:  method.Returns,
+				Variadic: method.Variadic,
+			}, true
+		}
+	}
+	return nil, false
+}
+
+// hasField reports whether a struct type has a field called name
+func hasField(object types.TypeInfo, name string) bool {
+	for _, field := range object.Fields {
+		if field.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// valueClass groups the types the VM handles alike. Types whose values are
+// only known at runtime have no class.
+func valueClass(t types.TypeInfo) string {
+	switch {
+	case t.IsNumeric():
+		return "number"
+	case t.Kind == types.KindString:
+		return "string"
+	case t.Kind == types.KindBool:
+		return "bool"
+	}
+	return ""
+}
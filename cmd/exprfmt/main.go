@@ -0,0 +1,98 @@
+// Command exprfmt formats expressions. Each file holds one expression;
+// directories are searched for .expr files. Without arguments the expression
+// is read from standard input and the formatted form is written to standard
+// output.
+//
+//	exprfmt [-l] [-w] [-width n] [path ...]
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/mredencom/expr/ast/format"
+)
+
+var (
+	list  = flag.Bool("l", false, "list files whose formatting differs from exprfmt's")
+	write = flag.Bool("w", false, "write the result to the file instead of standard output")
+	width = flag.Int("width", format.DefaultConfig.Width, "line width beyond which pipelines are split, 0 never splits")
+)
+
+func main() {
+	flag.Parse()
+	config := format.DefaultConfig
+	config.Width = *width
+
+	if flag.NArg() == 0 {
+		if err := process(config, "<stdin>", os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		return
+	}
+
+	status := 0
+	for _, root := range flag.Args() {
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() || (path != root && filepath.Ext(path) != ".expr") {
+				return nil
+			}
+			if err := processFile(config, path); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				status = 2
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+		}
+	}
+	os.Exit(status)
+}
+
+// processFile formats the expression of a file
+func processFile(config format.Config, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return process(config, path, file, os.Stdout)
+}
+
+// process formats the expression read from in, reporting it as path
+func process(config format.Config, path string, in io.Reader, out io.Writer) error {
+	src, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	formatted, err := config.Source(string(src))
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	result := []byte(formatted + "\n")
+
+	if bytes.Equal(src, result) && (*list || *write) {
+		return nil
+	}
+	if *list {
+		fmt.Fprintln(out, path)
+	}
+	if *write && in != os.Stdin {
+		return os.WriteFile(path, result, 0644)
+	}
+	if !*list {
+		_, err = out.Write(result)
+	}
+	return err
+}
@@ -2,6 +2,8 @@ package expr
 
 import (
 	"fmt"
+
+	"github.com/mredencom/expr/ast"
 )
 
 // Compatibility API for expr-lang/expr library
@@ -74,24 +76,40 @@ type Operator struct {
 	Func       interface{}
 }
 
-// Operators option for adding multiple custom operators
+// Operators option for adding multiple custom operators. Precedence values
+// are the levels of parser.Precedence, e.g. parser.SUM for "+".
 func Operators(ops map[string]Operator) Option {
 	return func(c *Config) {
+		if c.operators == nil {
+			c.operators = make(map[string]int)
+		}
 		for symbol, op := range ops {
 			c.operators[symbol] = op.Precedence
+			if op.Func == nil {
+				continue
+			}
+			if c.operatorFuncs == nil {
+				c.operatorFuncs = make(map[string][]interface{})
+			}
+			c.operatorFuncs[symbol] = append(c.operatorFuncs[symbol], op.Func)
 		}
 	}
 }
 
-// Patch represents a patch to apply to the AST
+// Patch rewrites the AST after parsing and before compilation. The visitor
+// is called for every node and may replace it in place.
 type Patch struct {
-	// Implementation would go here for AST patching
+	Visitor ast.Visitor
 }
 
-// Patch option for applying AST patches
+// Patches applies AST patches in order before compiling the expression
 func Patches(patches ...Patch) Option {
 	return func(c *Config) {
-		// AST patching would be implemented here
+		for _, patch := range patches {
+			if patch.Visitor != nil {
+				c.patches = append(c.patches, patch.Visitor)
+			}
+		}
 	}
 }
 
@@ -100,10 +118,11 @@ type Tag struct {
 	Name string
 }
 
-// Tags option for configuring struct tag handling
+// Tags sets the struct tag used to rename fields of struct environments and
+// values; a tag value of "-" hides the field
 func Tags(tag Tag) Option {
 	return func(c *Config) {
-		// Struct tag handling would be implemented here
+		c.tagName = tag.Name
 	}
 }
 
@@ -114,10 +133,11 @@ func Optimize(enabled bool) Option {
 	}
 }
 
-// ConstExpr marks an expression as constant for optimization
+// ConstExpr marks a custom function as pure: calls to it whose arguments are
+// all constants are evaluated once at compile time
 func ConstExpr(name string) Option {
 	return func(c *Config) {
-		// Constant expression optimization would be implemented here
+		c.constExprs = append(c.constExprs, name)
 	}
 }
 
@@ -258,57 +278,6 @@ func ConvertType[T any](value interface{}) (T, error) {
 	return zero, fmt.Errorf("cannot convert %T to %T", value, zero)
 }
 
-// Error types for compatibility
-
-// CompileError represents a compilation error
-type CompileError struct {
-	Message  string
-	Position int
-	Line     int
-	Column   int
-}
-
-func (e *CompileError) Error() string {
-	return fmt.Sprintf("compile error at line %d, column %d: %s", e.Line, e.Column, e.Message)
-}
-
-// RuntimeError represents a runtime error
-type RuntimeError struct {
-	Message string
-	Cause   error
-}
-
-func (e *RuntimeError) Error() string {
-	if e.Cause != nil {
-		return fmt.Sprintf("runtime error: %s (caused by: %v)", e.Message, e.Cause)
-	}
-	return fmt.Sprintf("runtime error: %s", e.Message)
-}
-
-// Unwrap returns the underlying cause
-func (e *RuntimeError) Unwrap() error {
-	return e.Cause
-}
-
-// Helper functions for creating errors
-
-// NewCompileError creates a new compile error
-func NewCompileError(message string, line, column int) *CompileError {
-	return &CompileError{
-		Message: message,
-		Line:    line,
-		Column:  column,
-	}
-}
-
-// NewRuntimeError creates a new runtime error
-func NewRuntimeError(message string, cause error) *RuntimeError {
-	return &RuntimeError{
-		Message: message,
-		Cause:   cause,
-	}
-}
-
 // Utility functions for compatibility (using type assertions instead of reflection)
 
 // GetType returns the type name of a value using type assertions
@@ -3,6 +3,9 @@ package expr
 import (
 	"fmt"
 	"testing"
+	"time"
+
+	"github.com/mredencom/expr/ast"
 )
 
 func TestAsOptions(t *testing.T) {
@@ -83,6 +86,17 @@ func TestOperatorsOption(t *testing.T) {
 	if config.operators["??"] != 1 {
 		t.Errorf("Expected precedence 1 for '??', got %d", config.operators["??"])
 	}
+
+	if len(config.operatorFuncs) != 0 {
+		t.Errorf("Expected no operator implementations, got %d", len(config.operatorFuncs))
+	}
+
+	add := func(a, b int) int { return a + b }
+	Operators(map[string]Operator{"+": {Symbol: "+", Func: add}})(config)
+	WithOperatorFunc("+", 0, add)(config)
+	if len(config.operatorFuncs["+"]) != 2 {
+		t.Errorf("Expected 2 implementations of '+', got %d", len(config.operatorFuncs["+"]))
+	}
 }
 
 func TestOptimizeOption(t *testing.T) {
@@ -409,18 +423,144 @@ func TestPatchesOption(t *testing.T) {
 	option := Patches(patch1, patch2)
 	option(config)
 
-	// Since Patches doesn't currently do anything, just verify it doesn't panic
+	// Patches without a visitor are ignored
+	if len(config.patches) != 0 {
+		t.Errorf("Expected no patches, got %d", len(config.patches))
+	}
+
+	option = Patches(Patch{Visitor: ast.VisitorFunc(func(node *ast.Node) {})})
+	option(config)
+	if len(config.patches) != 1 {
+		t.Errorf("Expected 1 patch, got %d", len(config.patches))
+	}
+}
+
+func TestPatchesRewriteAST(t *testing.T) {
+	renameField := Patch{Visitor: ast.VisitorFunc(func(node *ast.Node) {
+		if ident, ok := (*node).(*ast.Identifier); ok && ident.Value == "oldName" {
+			*node = &ast.Identifier{Value: "newName", Pos: ident.Pos}
+		}
+	})}
+	swapCall := Patch{Visitor: ast.VisitorFunc(func(node *ast.Node) {
+		if call, ok := (*node).(*ast.BuiltinExpression); ok && call.Name == "upper" {
+			call.Name = "lower"
+		}
+	})}
+
+	env := map[string]interface{}{"newName": "Hello"}
+	program, err := Compile(`upper(oldName)`, Env(env), Patches(renameField, swapCall))
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	result, err := Run(program, env)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if result != "hello" {
+		t.Errorf("Expected hello, got %v", result)
+	}
 }
 
 func TestTagsOption(t *testing.T) {
-	// Test that Tags option can be called without error
 	config := &Config{}
 	tag := Tag{Name: "json"}
 
 	option := Tags(tag)
 	option(config)
 
-	// Since Tags doesn't currently do anything, just verify it doesn't panic
+	if config.tagName != "json" {
+		t.Errorf("Expected tag name json, got %q", config.tagName)
+	}
+}
+
+type tagsAddress struct {
+	City string `expr:"city"`
+}
+
+type tagsLine struct {
+	SKU   string
+	Price float64 `expr:"price"`
+}
+
+type tagsAccount struct {
+	Plan string
+}
+
+type tagsCustomer struct {
+	tagsAccount
+	Name     string       `expr:"name"`
+	Password string       `expr:"-"`
+	Address  *tagsAddress `expr:"address"`
+	Lines    []tagsLine   `expr:"lines"`
+}
+
+func TestStructEnvironment(t *testing.T) {
+	customer := tagsCustomer{
+		tagsAccount: tagsAccount{Plan: "pro"},
+		Name:        "Alice",
+		Password:    "secret",
+		Address:     &tagsAddress{City: "Paris"},
+		Lines:       []tagsLine{{SKU: "a", Price: 10}, {SKU: "b", Price: 32.5}},
+	}
+
+	tests := []struct {
+		expression string
+		expected   interface{}
+	}{
+		{`name`, "Alice"},
+		{`Plan == "pro"`, true},
+		{`address.city`, "Paris"},
+		{`lines[1].price`, 32.5},
+		{`lines[0].SKU + lines[1].SKU`, "ab"},
+		{`len(lines)`, int64(2)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			program, err := Compile(tt.expression, Env(customer), Tags(Tag{Name: "expr"}))
+			if err != nil {
+				t.Fatalf("Compilation error: %v", err)
+			}
+
+			// Values and pointers to structs are both accepted at run time
+			for _, environment := range []interface{}{customer, &customer} {
+				result, err := Run(program, environment)
+				if err != nil {
+					t.Fatalf("Runtime error: %v", err)
+				}
+				if result != tt.expected {
+					t.Errorf("Expected %v, got %v", tt.expected, result)
+				}
+			}
+		})
+	}
+
+	t.Run("HiddenField", func(t *testing.T) {
+		if _, err := Compile(`Password`, Env(customer), Tags(Tag{Name: "expr"})); err == nil {
+			t.Error("Expected hidden field to be undefined")
+		}
+	})
+
+	t.Run("WithoutTags", func(t *testing.T) {
+		result, err := Eval(`Name + " " + Address.City`, customer)
+		if err != nil {
+			t.Fatalf("Eval error: %v", err)
+		}
+		if result != "Alice Paris" {
+			t.Errorf("Expected 'Alice Paris', got %v", result)
+		}
+	})
+
+	t.Run("NestedValue", func(t *testing.T) {
+		result, err := Eval(`customer.Lines[0].Price`, map[string]interface{}{"customer": &customer})
+		if err != nil {
+			t.Fatalf("Eval error: %v", err)
+		}
+		if result != float64(10) {
+			t.Errorf("Expected 10, got %v", result)
+		}
+	})
 }
 
 func TestConstExprOption(t *testing.T) {
@@ -430,5 +570,48 @@ func TestConstExprOption(t *testing.T) {
 	option := ConstExpr("myConstant")
 	option(config)
 
-	// Since ConstExpr doesn't currently do anything, just verify it doesn't panic
+	if len(config.constExprs) != 1 || config.constExprs[0] != "myConstant" {
+		t.Errorf("Expected myConstant to be recorded, got %v", config.constExprs)
+	}
+}
+
+func TestConstExprEvaluation(t *testing.T) {
+	calls := 0
+	duration := func(s string) (time.Duration, error) {
+		calls++
+		return time.ParseDuration(s)
+	}
+	options := []Option{
+		Env(map[string]interface{}{"elapsed": int64(time.Hour)}),
+		WithBuiltin("duration", duration),
+		ConstExpr("duration"),
+	}
+
+	program, err := Compile(`elapsed < duration("24h") && lower("ADMIN") == "admin"`, options...)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected duration to be called once at compile time, got %d", calls)
+	}
+
+	for i := 0; i < 3; i++ {
+		result, err := Run(program, map[string]interface{}{"elapsed": int64(time.Hour)})
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if result != true {
+			t.Errorf("Expected true, got %v", result)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected no calls at run time, got %d", calls-1)
+	}
+
+	if _, err := Compile(`duration("soon")`, options...); err == nil {
+		t.Error("Expected compile error for an invalid duration")
+	}
+	if _, err := Compile(`1`, ConstExpr("missing")); err == nil {
+		t.Error("Expected compile error for an unregistered function")
+	}
 }
@@ -1,6 +1,7 @@
 package compiler
 
 import (
+	"errors"
 	"fmt"
 	"math"
 
@@ -8,6 +9,8 @@ import (
 	"github.com/mredencom/expr/builtins"
 	"github.com/mredencom/expr/checker"
 	"github.com/mredencom/expr/env"
+	"github.com/mredencom/expr/lexer"
+	"github.com/mredencom/expr/modules"
 	"github.com/mredencom/expr/types"
 	"github.com/mredencom/expr/vm"
 )
@@ -30,6 +33,7 @@ type EmittedInstruction struct {
 // CompilationScope represents a compilation scope
 type CompilationScope struct {
 	instructions        []byte
+	positions           vm.SourceMap
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
 }
@@ -42,6 +46,9 @@ type Compiler struct {
 	scopes     []CompilationScope
 	scopeIndex int
 
+	// Source position of the node being compiled, recorded for each instruction
+	position lexer.Position
+
 	// Jump tracking for control flow
 	jumpStack []int
 
@@ -53,6 +60,31 @@ type Compiler struct {
 
 	// Bytecode optimizer
 	optimizer *BytecodeOptimizer
+
+	// Operators implemented by Go functions at runtime
+	operators map[string]bool
+
+	// Custom functions implemented by Go functions at runtime
+	functions map[string]bool
+
+	// Names bound by let statements and the globals they shadow
+	lets     map[string]bool
+	shadowed []Symbol
+
+	// Functions evaluated at compile time when their arguments are constants
+	constFuncs  map[string]ConstFunc
+	foldedCalls map[*ast.BuiltinExpression]foldedCall
+
+	// Modules known to the program and the names imported from them
+	moduleRegistry *modules.Registry
+	imports        map[string]string       // Module alias to module name
+	importedFuncs  map[string]importedFunc // Function name to module function
+}
+
+// importedFunc is a module function imported by name
+type importedFunc struct {
+	module   string
+	function string
 }
 
 // New creates a new compiler
@@ -79,6 +111,9 @@ func New() *Compiler {
 		errors:            []string{},
 		optimizer:         NewBytecodeOptimizer(OptimizationBasic),
 		inPipelineContext: false,
+		moduleRegistry:    modules.DefaultRegistry,
+		imports:           make(map[string]string),
+		importedFuncs:     make(map[string]importedFunc),
 	}
 }
 
@@ -90,8 +125,31 @@ func NewWithState(s *SymbolTable, constants []types.Value) *Compiler {
 	return compiler
 }
 
-// Compile compiles an AST node to bytecode
+// Compile compiles an AST node to bytecode. Errors are located at the
+// innermost node that failed to compile.
 func (c *Compiler) Compile(node ast.Node) error {
+	if node != nil && node.Position().Valid() {
+		outer := c.position
+		c.position = node.Position()
+		defer func() {
+			c.position = outer
+		}()
+	}
+
+	err := c.compile(node)
+	if err == nil {
+		return nil
+	}
+
+	var sourceErr *lexer.SourceError
+	if errors.As(err, &sourceErr) || node == nil || !node.Position().Valid() {
+		return err
+	}
+	return &lexer.SourceError{Pos: node.Position(), Message: err.Error(), Cause: err}
+}
+
+// compile compiles a node according to its type
+func (c *Compiler) compile(node ast.Node) error {
 
 	switch node := node.(type) {
 	case *ast.Program:
@@ -100,6 +158,9 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.ExpressionStatement:
 		return c.compileExpressionStatement(node)
 
+	case *ast.LetStatement:
+		return c.compileLetStatement(node)
+
 	case *ast.Literal:
 		return c.compileLiteral(node)
 
@@ -162,9 +223,22 @@ func (c *Compiler) Compile(node ast.Node) error {
 	}
 }
 
-// compileProgram compiles a program node
+// compileProgram compiles a program node. Let bindings and imports come
+// first; the final expression statement leaves the result on the stack.
 func (c *Compiler) compileProgram(node *ast.Program) error {
-	for _, stmt := range node.Statements {
+	for i, stmt := range node.Statements {
+		last := i == len(node.Statements)-1
+		switch stmt.(type) {
+		case *ast.ExpressionStatement:
+			if !last {
+				return &lexer.SourceError{Pos: stmt.Position(), Message: "only the last statement can be an expression"}
+			}
+		case *ast.LetStatement, *ast.ImportStatement:
+			if last {
+				return &lexer.SourceError{Pos: stmt.Position(), Message: "expression must end with a result expression"}
+			}
+		}
+
 		err := c.Compile(stmt)
 		if err != nil {
 			return err
@@ -182,6 +256,32 @@ func (c *Compiler) compileExpressionStatement(node *ast.ExpressionStatement) err
 	return nil
 }
 
+// compileLetStatement stores the value of a let binding in a new global. A
+// let may shadow a variable of the environment or a builtin, but not another
+// let or an import.
+func (c *Compiler) compileLetStatement(node *ast.LetStatement) error {
+	if c.lets[node.Name] || c.imports[node.Name] != "" {
+		return fmt.Errorf("cannot bind %s: name is already defined", node.Name)
+	}
+
+	err := c.Compile(node.Value)
+	if err != nil {
+		return err
+	}
+
+	// The shadowed variable keeps its global, read by the code before the let
+	if shadowed, ok := c.symbolTable.Resolve(node.Name); ok && shadowed.Scope == GlobalScope {
+		c.shadowed = append(c.shadowed, shadowed)
+	}
+	if c.lets == nil {
+		c.lets = make(map[string]bool)
+	}
+	c.lets[node.Name] = true
+
+	symbol := c.symbolTable.Define(node.Name)
+	return c.emitError(vm.OpSetVar, symbol.Index)
+}
+
 // compileLiteral compiles a literal expression
 func (c *Compiler) compileLiteral(node *ast.Literal) error {
 	if node.Value == nil {
@@ -210,12 +310,20 @@ func (c *Compiler) compileInfixExpression(node *ast.InfixExpression) error {
 		return c.compilePlaceholderInfixExpression(node)
 	}
 
+	if c.operators[node.Operator] {
+		return c.compileOperatorExpression(node)
+	}
+
 	// Try constant folding first - if successful, emit only the result
 	if foldedValue := c.tryConstantFolding(node); foldedValue != nil {
 		// Apply constant folding for all supported operations
 		return c.emitError(vm.OpConstant, c.addConstant(foldedValue))
 	}
 
+	if node.Operator == "&&" || node.Operator == "||" {
+		return c.compileLogicalExpression(node)
+	}
+
 	if node.Operator == "<" {
 		err := c.Compile(node.Right)
 		if err != nil {
@@ -261,10 +369,6 @@ func (c *Compiler) compileInfixExpression(node *ast.InfixExpression) error {
 		return c.emitError(vm.OpGreaterEqual)
 	case "<=":
 		return c.emitError(vm.OpLessEqual)
-	case "&&":
-		return c.emitError(vm.OpAnd)
-	case "||":
-		return c.emitError(vm.OpOr)
 	case "&":
 		return c.emitError(vm.OpBitAnd)
 	case "|":
@@ -280,20 +384,75 @@ func (c *Compiler) compileInfixExpression(node *ast.InfixExpression) error {
 	}
 }
 
-// tryConstantFolding attempts to fold constant expressions at compile time
-func (c *Compiler) tryConstantFolding(node *ast.InfixExpression) types.Value {
-	// Only fold if both operands are literals
-	leftLit, leftIsLit := node.Left.(*ast.Literal)
-	rightLit, rightIsLit := node.Right.(*ast.Literal)
+// compileOperatorExpression compiles an operator with Go implementations. The
+// VM picks the implementation from the operand types at runtime and falls
+// back to the built-in operator, so no constant folding is done here.
+func (c *Compiler) compileOperatorExpression(node *ast.InfixExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	return c.emitError(vm.OpOperator, c.addConstant(types.NewString(node.Operator)))
+}
 
-	if !leftIsLit || !rightIsLit {
-		return nil
+// compileLogicalExpression compiles && and || with short-circuit jumps: the
+// right operand only runs when the left one does not decide the result, and
+// the result is always a bool.
+//
+//	&&: left; JumpFalse F; right; JumpFalse F; true; Jump E; F: false; E:
+//	||: left; JumpTrue T; right; JumpTrue T; false; Jump E; T: true; E:
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	// A constant left operand may decide the result on its own
+	if left, ok := node.Left.(*ast.Literal); ok {
+		if folded := c.foldLogical(literalValue(left), nil, node.Operator); folded != nil {
+			return c.emitError(vm.OpConstant, c.addConstant(folded))
+		}
+	}
+
+	jumpOp, shortCircuit := vm.OpJumpFalse, false
+	if node.Operator == "||" {
+		jumpOp, shortCircuit = vm.OpJumpTrue, true
 	}
 
-	left := leftLit.Value
-	right := rightLit.Value
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+	jumpLeft := c.emit(jumpOp, 0)
+
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	jumpRight := c.emit(jumpOp, 0)
+
+	c.emit(vm.OpConstant, c.addConstant(types.NewBool(!shortCircuit)))
+	jumpEnd := c.emit(vm.OpJump, 0)
+
+	shortCircuitPos := len(c.currentInstructions())
+	c.changeOperand(jumpLeft, shortCircuitPos)
+	c.changeOperand(jumpRight, shortCircuitPos)
+	c.emit(vm.OpConstant, c.addConstant(types.NewBool(shortCircuit)))
 
-	if left == nil || right == nil {
+	c.changeOperand(jumpEnd, len(c.currentInstructions()))
+	return nil
+}
+
+// literalValue returns the value of a literal, with nil literals as types.Nil
+func literalValue(node *ast.Literal) types.Value {
+	if node.Value == nil {
+		return types.NewNil()
+	}
+	return node.Value
+}
+
+// tryConstantFolding attempts to fold constant expressions at compile time
+func (c *Compiler) tryConstantFolding(node *ast.InfixExpression) types.Value {
+	// Only fold if both operands are known at compile time
+	left, leftIsConst := c.constantValue(node.Left)
+	right, rightIsConst := c.constantValue(node.Right)
+
+	if !leftIsConst || !rightIsConst {
 		return nil
 	}
 
@@ -447,26 +606,37 @@ func (c *Compiler) foldComparison(left, right types.Value, op string) types.Valu
 	return nil
 }
 
-// foldLogical performs compile-time logical operations
+// foldLogical performs compile-time logical operations. right is nil when
+// the right operand is not a constant: the result is then only folded when
+// the left operand decides it, since the right operand would never run.
 func (c *Compiler) foldLogical(left, right types.Value, op string) types.Value {
-	// Convert values to boolean for logical operations
 	leftBool := c.valueToBool(left)
-	rightBool := c.valueToBool(right)
-
-	if leftBool == nil || rightBool == nil {
+	if leftBool == nil {
 		return nil
 	}
-
 	lVal := leftBool.(*types.BoolValue).Value()
-	rVal := rightBool.(*types.BoolValue).Value()
 
 	switch op {
 	case "&&":
-		return types.NewBool(lVal && rVal)
+		if !lVal {
+			return types.NewBool(false)
+		}
 	case "||":
-		return types.NewBool(lVal || rVal)
+		if lVal {
+			return types.NewBool(true)
+		}
+	default:
+		return nil
 	}
-	return nil
+
+	if right == nil {
+		return nil
+	}
+	rightBool := c.valueToBool(right)
+	if rightBool == nil {
+		return nil
+	}
+	return types.NewBool(rightBool.(*types.BoolValue).Value())
 }
 
 // foldBitwise performs compile-time bitwise operations
@@ -779,19 +949,19 @@ func (c *Compiler) compilePrefixExpression(node *ast.PrefixExpression) error {
 
 // tryPrefixConstantFolding attempts to fold prefix constant expressions
 func (c *Compiler) tryPrefixConstantFolding(node *ast.PrefixExpression) types.Value {
-	// Check if operand is a literal
-	lit, ok := node.Right.(*ast.Literal)
-	if !ok || lit.Value == nil {
+	// Check if operand is known at compile time
+	value, ok := c.constantValue(node.Right)
+	if !ok {
 		return nil
 	}
 
 	switch node.Operator {
 	case "-":
-		return c.foldNegation(lit.Value)
+		return c.foldNegation(value)
 	case "!":
-		return c.foldLogicalNot(lit.Value)
+		return c.foldLogicalNot(value)
 	case "~":
-		return c.foldBitwiseNot(lit.Value)
+		return c.foldBitwiseNot(value)
 	}
 
 	return nil
@@ -831,6 +1001,17 @@ func (c *Compiler) foldBitwiseNot(val types.Value) types.Value {
 
 // compileCallExpression compiles a function call expression
 func (c *Compiler) compileCallExpression(node *ast.CallExpression) error {
+	// Calls of module functions, e.g. s.upper(name) after import "strings" as s
+	if member, ok := node.Function.(*ast.MemberExpression); ok {
+		object, isIdent := member.Object.(*ast.Identifier)
+		property, isName := member.Property.(*ast.Identifier)
+		if isIdent && isName {
+			if module, ok := c.resolveModule(object.Value); ok {
+				return c.compileModuleCall(module, property.Value, node.Arguments)
+			}
+		}
+	}
+
 	err := c.Compile(node.Function)
 	if err != nil {
 		return err
@@ -848,6 +1029,11 @@ func (c *Compiler) compileCallExpression(node *ast.CallExpression) error {
 
 // compileBuiltinExpression compiles a builtin expression
 func (c *Compiler) compileBuiltinExpression(node *ast.BuiltinExpression) error {
+	// Functions imported from modules take precedence over builtins
+	if imported, ok := c.importedFuncs[node.Name]; ok {
+		return c.compileModuleCall(imported.module, imported.function, node.Arguments)
+	}
+
 	// Check if any argument contains a placeholder - if so, treat this as a pipeline function
 	hasPlaceholder := c.containsPlaceholder(node.Arguments)
 
@@ -856,6 +1042,15 @@ func (c *Compiler) compileBuiltinExpression(node *ast.BuiltinExpression) error {
 		return c.compilePipelineFunction(node.Name, node.Arguments)
 	}
 
+	// Evaluate pure calls with constant arguments at compile time
+	value, folded, err := c.tryCallFolding(node)
+	if err != nil {
+		return err
+	}
+	if folded {
+		return c.emitError(vm.OpConstant, c.addConstant(value))
+	}
+
 	// Regular builtin function compilation (no placeholders)
 	for _, arg := range node.Arguments {
 		err := c.Compile(arg)
@@ -864,6 +1059,11 @@ func (c *Compiler) compileBuiltinExpression(node *ast.BuiltinExpression) error {
 		}
 	}
 
+	// Custom functions take precedence over builtins of the same name
+	if c.functions[node.Name] {
+		return c.emitError(vm.OpCallFunction, c.addConstant(types.NewString(node.Name)), len(node.Arguments))
+	}
+
 	// Find the index in StandardBuiltinNames
 	builtinIndex := -1
 	for i, name := range builtins.StandardBuiltinNames {
@@ -1051,11 +1251,67 @@ func (c *Compiler) compileMapLiteral(node *ast.MapLiteral) error {
 
 // compileLambdaExpression compiles a lambda expression
 func (c *Compiler) compileLambdaExpression(node *ast.LambdaExpression) error {
-	// Create a function value with the lambda parameters and body
-	funcValue := types.NewFunc(node.Parameters, node.Body, nil, "")
+	// The body is compiled on its own, outside of any enclosing pipeline context
+	oldContext := c.inPipelineContext
+	c.inPipelineContext = false
+	defer func() {
+		c.inPipelineContext = oldContext
+	}()
 
-	// Add the function as a constant
-	return c.emitError(vm.OpConstant, c.addConstant(funcValue))
+	c.enterScope()
+	for _, param := range node.Parameters {
+		c.symbolTable.Define(param)
+	}
+
+	if err := c.Compile(node.Body); err != nil {
+		c.leaveScope()
+		return err
+	}
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.numDefinitions
+	instructions, positions := c.leaveScope()
+	if c.optimizer != nil {
+		instructions, positions = c.optimizer.OptimizeWithSourceMap(instructions, positions)
+	}
+
+	fn := &vm.CompiledFunction{Instructions: instructions, NumLocals: numLocals, Positions: positions}
+	for _, symbol := range freeSymbols {
+		fn.FreeNames = append(fn.FreeNames, symbol.Name)
+	}
+	funcValue := types.NewFunc(node.Parameters, fn, nil, "")
+
+	if len(freeSymbols) == 0 {
+		return c.emitError(vm.OpConstant, c.addConstant(funcValue))
+	}
+
+	// Load the captured variables in the enclosing scope and bind them
+	for _, symbol := range freeSymbols {
+		if err := c.loadSymbol(symbol); err != nil {
+			return err
+		}
+	}
+	return c.emitError(vm.OpClosure, c.addConstant(funcValue), len(freeSymbols))
+}
+
+// enterScope starts a function scope with its own instructions and symbols
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, CompilationScope{})
+	c.scopeIndex++
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+// leaveScope ends the current function scope and returns its instructions
+// with their source positions
+func (c *Compiler) leaveScope() ([]byte, vm.SourceMap) {
+	instructions := c.currentInstructions()
+	positions := c.scopes[c.scopeIndex].positions
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+
+	return instructions, positions
 }
 
 // compilePlaceholderExpression compiles a placeholder expression
@@ -1194,6 +1450,10 @@ func (c *Compiler) emit(op vm.Opcode, operands ...int) int {
 	pos := c.addInstruction(ins)
 
 	c.setLastInstruction(op, pos)
+	if c.position.Valid() {
+		scope := &c.scopes[c.scopeIndex]
+		scope.positions = append(scope.positions, vm.SourcePosition{Offset: pos, Pos: c.position})
+	}
 
 	return pos
 }
@@ -1235,9 +1495,18 @@ func (c *Compiler) removeLastPop() {
 	new := old[:last.Position]
 
 	c.scopes[c.scopeIndex].instructions = new
+	c.scopes[c.scopeIndex].positions = trimSourceMap(c.scopes[c.scopeIndex].positions, last.Position)
 	c.scopes[c.scopeIndex].lastInstruction = previous
 }
 
+// trimSourceMap drops the positions of instructions at or after offset
+func trimSourceMap(positions vm.SourceMap, offset int) vm.SourceMap {
+	for len(positions) > 0 && positions[len(positions)-1].Offset >= offset {
+		positions = positions[:len(positions)-1]
+	}
+	return positions
+}
+
 // replaceInstruction replaces an instruction at the given position
 func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
 	ins := c.currentInstructions()
@@ -1272,11 +1541,11 @@ func (c *Compiler) loadSymbol(s Symbol) error {
 	case GlobalScope:
 		return c.emitError(vm.OpGetVar, s.Index)
 	case LocalScope:
-		return c.emitError(vm.OpGetVar, s.Index)
+		return c.emitError(vm.OpGetLocal, s.Index)
 	case BuiltinScope:
 		return c.emitError(vm.OpBuiltin, s.Index, 0)
 	case FreeScope:
-		return c.emitError(vm.OpGetVar, s.Index)
+		return c.emitError(vm.OpGetFree, s.Index)
 	default:
 		return fmt.Errorf("unknown symbol scope: %s", s.Scope)
 	}
@@ -1285,15 +1554,17 @@ func (c *Compiler) loadSymbol(s Symbol) error {
 // Bytecode returns the compiled bytecode with optimizations applied
 func (c *Compiler) Bytecode() *vm.Bytecode {
 	instructions := c.currentInstructions()
+	positions := c.scopes[c.scopeIndex].positions
 
 	// Apply bytecode optimizations
 	if c.optimizer != nil {
-		instructions = c.optimizer.OptimizeInstructions(instructions)
+		instructions, positions = c.optimizer.OptimizeWithSourceMap(instructions, positions)
 	}
 
 	return &vm.Bytecode{
 		Instructions: instructions,
 		Constants:    c.constants,
+		Positions:    positions,
 	}
 }
 
@@ -1304,10 +1575,18 @@ func (c *Compiler) Errors() []string {
 
 // GetVariableOrder returns the order of variables as they were defined
 func (c *Compiler) GetVariableOrder() []string {
+	// Globals shadowed by let statements are no longer in the store
+	globals := append([]Symbol{}, c.shadowed...)
+	for _, symbol := range c.symbolTable.store {
+		if symbol.Scope == GlobalScope {
+			globals = append(globals, symbol)
+		}
+	}
+
 	// Find the maximum index first
 	maxIndex := -1
-	for _, symbol := range c.symbolTable.store {
-		if symbol.Scope == GlobalScope && symbol.Index > maxIndex {
+	for _, symbol := range globals {
+		if symbol.Index > maxIndex {
 			maxIndex = symbol.Index
 		}
 	}
@@ -1320,10 +1599,8 @@ func (c *Compiler) GetVariableOrder() []string {
 	order := make([]string, maxIndex+1)
 
 	// Fill in the variable names at their correct indices
-	for name, symbol := range c.symbolTable.store {
-		if symbol.Scope == GlobalScope {
-			order[symbol.Index] = name
-		}
+	for _, symbol := range globals {
+		order[symbol.Index] = symbol.Name
 	}
 
 	return order
@@ -1334,8 +1611,14 @@ func (c *Compiler) GetSymbolTable() *SymbolTable {
 	return c.symbolTable
 }
 
-// DefineBuiltin defines a custom builtin function
+// DefineBuiltin defines a custom builtin function. Calls of it are compiled
+// to OpCallFunction and resolved by name at runtime.
 func (c *Compiler) DefineBuiltin(name string) {
+	if c.functions == nil {
+		c.functions = make(map[string]bool)
+	}
+	c.functions[name] = true
+
 	// Find the next available builtin index
 	index := len(c.symbolTable.store)
 	for i := 25; i < 100; i++ { // Start from 25 (after core builtins)
@@ -1354,6 +1637,29 @@ func (c *Compiler) DefineBuiltin(name string) {
 	c.symbolTable.DefineBuiltin(index, name)
 }
 
+// DefineOperator marks an infix operator as implemented by Go functions.
+// Of the built-in operators only arithmetic and comparisons can be
+// overloaded, as the VM falls back to them for operands no implementation
+// accepts; short-circuit and other built-in operators cannot.
+func (c *Compiler) DefineOperator(symbol string) error {
+	switch symbol {
+	case "&&", "||", "??", "**", "&", "|", "^", "<<", ">>",
+		"in", "matches", "contains", "startsWith", "endsWith":
+		return fmt.Errorf("operator %s cannot be overloaded", symbol)
+	}
+	if c.operators == nil {
+		c.operators = make(map[string]bool)
+	}
+	c.operators[symbol] = true
+	return nil
+}
+
+// SetModuleRegistry sets the registry module calls and imports are resolved
+// against. The default is modules.DefaultRegistry.
+func (c *Compiler) SetModuleRegistry(registry *modules.Registry) {
+	c.moduleRegistry = registry
+}
+
 // CompileWithChecker compiles an AST node with type checking
 func CompileWithChecker(node ast.Node, env interface{}) (*vm.Bytecode, error) {
 	// For now, we'll skip type checking if it's not a Program
@@ -1444,6 +1750,8 @@ func (c *Compiler) hasPlaceholder(expr ast.Expression) bool {
 		return c.hasPlaceholder(node.Object) || c.hasPlaceholder(node.Property)
 	case *ast.ConditionalExpression:
 		return c.hasPlaceholder(node.Test) || c.hasPlaceholder(node.Consequent) || c.hasPlaceholder(node.Alternative)
+	case *ast.NullCoalescingExpression:
+		return c.hasPlaceholder(node.Left) || c.hasPlaceholder(node.Right)
 	default:
 		return false
 	}
@@ -1809,47 +2117,144 @@ func (c *Compiler) compileOptionalChainingExpression(node *ast.OptionalChainingE
 	return c.emitError(vm.OpOptionalChaining)
 }
 
-// compileNullCoalescingExpression compiles null coalescing expressions (a ?? b)
+// compileNullCoalescingExpression compiles a ?? b so that b only runs when a is nil:
+//
+//	left; Dup; JumpNil R; Jump E; R: Pop; right; E:
 func (c *Compiler) compileNullCoalescingExpression(node *ast.NullCoalescingExpression) error {
+	if c.inPipelineContext && c.hasPlaceholder(node) {
+		return c.compilePlaceholderNullCoalescing(node)
+	}
+
+	// A constant left operand decides which side is evaluated
+	if left, ok := node.Left.(*ast.Literal); ok {
+		if left.Value == nil || left.Value.Type().Kind == types.KindNil {
+			return c.Compile(node.Right)
+		}
+		return c.Compile(node.Left)
+	}
+
 	// Compile the left operand
 	err := c.Compile(node.Left)
 	if err != nil {
 		return err
 	}
 
-	// Compile the right operand (default value)
+	c.emit(vm.OpDup)
+	jumpNil := c.emit(vm.OpJumpNil, 0)
+	jumpEnd := c.emit(vm.OpJump, 0)
+
+	// Left is nil: drop it and compile the right operand (default value)
+	c.changeOperand(jumpNil, len(c.currentInstructions()))
+	c.emit(vm.OpPop)
 	err = c.Compile(node.Right)
 	if err != nil {
 		return err
 	}
 
-	// Emit the null coalescing instruction
-	return c.emitError(vm.OpNullCoalescing)
+	c.changeOperand(jumpEnd, len(c.currentInstructions()))
+	return nil
 }
 
-// compileImportStatement compiles an import statement
+// compilePlaceholderNullCoalescing serializes a ?? b containing placeholders
+// as ["??", left, right] for evaluation against each pipeline element
+func (c *Compiler) compilePlaceholderNullCoalescing(node *ast.NullCoalescingExpression) error {
+	err := c.emitError(vm.OpConstant, c.addConstant(types.NewString("??")))
+	if err != nil {
+		return err
+	}
+
+	err = c.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+
+	err = c.Compile(node.Right)
+	if err != nil {
+		return err
+	}
+
+	return c.emitError(vm.OpSlice, 3)
+}
+
+// compileImportStatement makes a module available under its alias, or the
+// listed functions of a module available by name. Imports emit no code.
 func (c *Compiler) compileImportStatement(node *ast.ImportStatement) error {
-	// Import statements are handled at compile time, so we emit nothing
-	// The module registry will be populated during compilation
+	if _, err := c.moduleRegistry.GetModule(node.ModuleName); err != nil {
+		return err
+	}
+
+	if len(node.Names) == 0 {
+		alias := node.Alias
+		if alias == "" {
+			alias = node.ModuleName
+		}
+		if _, exists := c.symbolTable.Resolve(alias); exists {
+			return fmt.Errorf("cannot import %s as %s: name is already defined", node.ModuleName, alias)
+		}
+		c.imports[alias] = node.ModuleName
+		return nil
+	}
+
+	for _, name := range node.Names {
+		if _, err := c.moduleRegistry.GetFunction(node.ModuleName, name); err != nil {
+			return err
+		}
+		c.importedFuncs[name] = importedFunc{module: node.ModuleName, function: name}
+	}
 	return nil
 }
 
+// resolveModule returns the module a name refers to: an import alias, or a
+// registered module that is not shadowed by a variable
+func (c *Compiler) resolveModule(name string) (string, bool) {
+	if module, ok := c.imports[name]; ok {
+		return module, true
+	}
+	if _, exists := c.symbolTable.Resolve(name); exists {
+		return "", false
+	}
+	return name, c.moduleRegistry.HasModule(name)
+}
+
 // compileModuleCallExpression compiles a module function call
 func (c *Compiler) compileModuleCallExpression(node *ast.ModuleCallExpression) error {
+	module, ok := c.resolveModule(node.Module)
+	if !ok {
+		return fmt.Errorf("module '%s' not found", node.Module)
+	}
+	return c.compileModuleCall(module, node.Function, node.Arguments)
+}
+
+// compileModuleCall compiles a call of a module function, checking that the
+// function exists and accepts the number of arguments
+func (c *Compiler) compileModuleCall(module, function string, arguments []ast.Expression) error {
+	fn, err := c.moduleRegistry.GetFunction(module, function)
+	if err != nil {
+		return err
+	}
+	if fn.ParamTypes != nil {
+		params := len(fn.ParamTypes)
+		if fn.Variadic && len(arguments) < params-1 {
+			return fmt.Errorf("function %s.%s expects at least %d arguments, got %d", module, function, params-1, len(arguments))
+		}
+		if !fn.Variadic && len(arguments) != params {
+			return fmt.Errorf("function %s.%s expects %d arguments, got %d", module, function, params, len(arguments))
+		}
+	}
+
 	// Compile arguments
-	for _, arg := range node.Arguments {
+	for _, arg := range arguments {
 		err := c.Compile(arg)
 		if err != nil {
 			return err
 		}
 	}
 
-	// Emit module call instruction
-	// We need to store the module name and function name as constants
-	moduleNameIndex := c.addConstant(types.NewString(node.Module))
-	functionNameIndex := c.addConstant(types.NewString(node.Function))
+	// The module and function names are stored as constants
+	moduleNameIndex := c.addConstant(types.NewString(module))
+	functionNameIndex := c.addConstant(types.NewString(function))
 
-	return c.emitError(vm.OpModuleCall, moduleNameIndex, functionNameIndex, len(node.Arguments))
+	return c.emitError(vm.OpModuleCall, moduleNameIndex, functionNameIndex, len(arguments))
 }
 
 // compileDestructuringAssignment compiles a destructuring assignment statement
@@ -1,6 +1,8 @@
 package compiler
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/mredencom/expr/ast"
@@ -233,16 +235,23 @@ func TestCompileMapLiteral(t *testing.T) {
 }
 
 func TestCompileBuiltinExpression(t *testing.T) {
-	tests := []string{
-		`len("hello")`,
-		`string(42)`,
-		`int("42")`,
-		`bool(1)`,
+	// Pure builtins with constant arguments are evaluated at compile time
+	folded := []struct {
+		input    string
+		expected types.Value
+	}{
+		{`len("hello")`, types.NewInt(5)},
+		{`string(42)`, types.NewString("42")},
+		{`int("42")`, types.NewInt(42)},
+		{`bool(1)`, types.NewBool(true)},
+		{`upper(lower("ADMIN") + "s")`, types.NewString("ADMINS")},
+		{`len(split("a,b,c", ","))`, types.NewInt(3)},
+		{`-len("ab") * 2`, types.NewInt(-4)},
 	}
 
-	for _, input := range tests {
-		t.Run(input, func(t *testing.T) {
-			program := parseProgram(t, input)
+	for _, tt := range folded {
+		t.Run(tt.input, func(t *testing.T) {
+			program := parseProgram(t, tt.input)
 			compiler := New()
 
 			err := compiler.Compile(program)
@@ -252,8 +261,34 @@ func TestCompileBuiltinExpression(t *testing.T) {
 
 			bytecode := compiler.Bytecode()
 			ops := extractOpcodes(bytecode.Instructions)
+			if len(ops) != 1 || ops[0] != vm.OpConstant {
+				t.Fatalf("Expected a single OpConstant, got: %v", ops)
+			}
+
+			result := bytecode.Constants[len(bytecode.Constants)-1]
+			if !result.Equal(tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+
+	// Impure builtins and failing calls are left to the VM
+	called := []string{
+		`now()`,
+		`int("abc")`,
+	}
+
+	for _, input := range called {
+		t.Run(input, func(t *testing.T) {
+			program := parseProgram(t, input)
+			compiler := New()
+
+			err := compiler.Compile(program)
+			if err != nil {
+				t.Fatalf("Compilation error: %v", err)
+			}
 
-			// Should contain a call to a builtin function
+			ops := extractOpcodes(compiler.Bytecode().Instructions)
 			found := false
 			for _, op := range ops {
 				if op == vm.OpBuiltin || op == vm.OpCall {
@@ -268,6 +303,42 @@ func TestCompileBuiltinExpression(t *testing.T) {
 	}
 }
 
+func TestDefineConstFunc(t *testing.T) {
+	calls := 0
+	compiler := New()
+	compiler.DefineConstFunc("double", func(args []types.Value) (types.Value, error) {
+		calls++
+		n, ok := args[0].(*types.IntValue)
+		if !ok {
+			return nil, fmt.Errorf("expected int")
+		}
+		return types.NewInt(n.Value() * 2), nil
+	})
+
+	if err := compiler.Compile(parseProgram(t, `double(double(3)) + 1`)); err != nil {
+		t.Fatalf("Compilation error: %v", err)
+	}
+
+	bytecode := compiler.Bytecode()
+	if ops := extractOpcodes(bytecode.Instructions); len(ops) != 1 || ops[0] != vm.OpConstant {
+		t.Fatalf("Expected a single OpConstant, got: %v", ops)
+	}
+	if result := bytecode.Constants[len(bytecode.Constants)-1]; !result.Equal(types.NewInt(13)) {
+		t.Errorf("Expected 13, got %v", result)
+	}
+	if calls == 0 {
+		t.Error("Expected double to be called at compile time")
+	}
+
+	compiler = New()
+	compiler.DefineConstFunc("double", func(args []types.Value) (types.Value, error) {
+		return nil, fmt.Errorf("expected int")
+	})
+	if err := compiler.Compile(parseProgram(t, `double("x")`)); err == nil || !strings.Contains(err.Error(), "double: expected int") {
+		t.Errorf("Expected compile error from double, got %v", err)
+	}
+}
+
 func TestCompileConditionalExpression(t *testing.T) {
 	program := parseProgram(t, "5 > 3 ? 10 : 20")
 	compiler := New()
@@ -296,6 +367,305 @@ func TestCompileConditionalExpression(t *testing.T) {
 	}
 }
 
+func TestCompileLogicalExpressions(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedOps []vm.Opcode
+	}{
+		{"a && b", []vm.Opcode{vm.OpGetVar, vm.OpJumpFalse, vm.OpGetVar, vm.OpJumpFalse, vm.OpConstant, vm.OpJump, vm.OpConstant}},
+		{"a || b", []vm.Opcode{vm.OpGetVar, vm.OpJumpTrue, vm.OpGetVar, vm.OpJumpTrue, vm.OpConstant, vm.OpJump, vm.OpConstant}},
+		{"a ?? b", []vm.Opcode{vm.OpGetVar, vm.OpDup, vm.OpJumpNil, vm.OpJump, vm.OpPop, vm.OpGetVar}},
+		// A constant left operand that decides the result is folded
+		{"false && a", []vm.Opcode{vm.OpConstant}},
+		{"true || a", []vm.Opcode{vm.OpConstant}},
+		{"null ?? a", []vm.Opcode{vm.OpGetVar}},
+		{"1 ?? a", []vm.Opcode{vm.OpConstant}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			program := parseProgram(t, tt.input)
+			compiler := New()
+			compiler.symbolTable.Define("a")
+			compiler.symbolTable.Define("b")
+
+			err := compiler.Compile(program)
+			if err != nil {
+				t.Fatalf("Compilation error: %v", err)
+			}
+
+			ops := extractOpcodes(compiler.Bytecode().Instructions)
+			if len(ops) != len(tt.expectedOps) {
+				t.Fatalf("Expected opcodes %v, got %v", tt.expectedOps, ops)
+			}
+			for i, op := range tt.expectedOps {
+				if ops[i] != op {
+					t.Errorf("Expected opcode %v at %d, got %v", op, i, ops[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCompileLetStatements(t *testing.T) {
+	program := parseProgram(t, "let b = a * 2; let c = b + 1; c")
+	compiler := New()
+	compiler.symbolTable.Define("a")
+
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("Compilation error: %v", err)
+	}
+
+	expected := []vm.Opcode{
+		vm.OpGetVar, vm.OpConstant, vm.OpMul, vm.OpSetVar,
+		vm.OpGetVar, vm.OpConstant, vm.OpAdd, vm.OpSetVar,
+		vm.OpGetVar,
+	}
+	ops := extractOpcodes(compiler.Bytecode().Instructions)
+	if len(ops) != len(expected) {
+		t.Fatalf("Expected opcodes %v, got %v", expected, ops)
+	}
+	for i, op := range expected {
+		if ops[i] != op {
+			t.Errorf("Expected opcode %v at %d, got %v", op, i, ops[i])
+		}
+	}
+
+	order := compiler.GetVariableOrder()
+	if len(order) != 3 || order[1] != "b" || order[2] != "c" {
+		t.Errorf("Expected let bindings after a in variable order, got %v", order)
+	}
+
+	errorTests := map[string]string{
+		"let b = 1; let b = 2; b": "cannot bind b: name is already defined",
+		"let x = 1":               "expression must end with a result expression",
+		"1; 2":                    "only the last statement can be an expression",
+	}
+	for input, want := range errorTests {
+		compiler := New()
+		compiler.symbolTable.Define("a")
+		err := compiler.Compile(parseProgram(t, input))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected error containing %q, got %v", input, want, err)
+		}
+	}
+
+	// A let shadows a variable or builtin, which keeps its global for the
+	// code before the let
+	compiler = New()
+	compiler.symbolTable.Define("a")
+	if err := compiler.Compile(parseProgram(t, "let a = a + 1; let len = a; len")); err != nil {
+		t.Fatalf("Compilation error: %v", err)
+	}
+	if order := compiler.GetVariableOrder(); len(order) != 3 || order[0] != "a" || order[1] != "a" || order[2] != "len" {
+		t.Errorf("Expected the shadowed a to keep its global, got %v", order)
+	}
+}
+
+func TestCompileImports(t *testing.T) {
+	tests := []struct {
+		input    string
+		module   string
+		function string
+	}{
+		{`import "strings" as s; s.upper(name)`, "strings", "upper"},
+		{`from math import sqrt; sqrt(x)`, "math", "sqrt"},
+		{`math.abs(x)`, "math", "abs"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			compiler := New()
+			compiler.symbolTable.Define("name")
+			compiler.symbolTable.Define("x")
+
+			err := compiler.Compile(parseProgram(t, tt.input))
+			if err != nil {
+				t.Fatalf("Compilation error: %v", err)
+			}
+
+			bytecode := compiler.Bytecode()
+			ops := extractOpcodes(bytecode.Instructions)
+			if len(ops) != 2 || ops[0] != vm.OpGetVar || ops[1] != vm.OpModuleCall {
+				t.Fatalf("Expected [OpGetVar OpModuleCall], got %v", ops)
+			}
+			def, _ := vm.Lookup(vm.OpModuleCall)
+			operands, _ := vm.ReadOperands(def, bytecode.Instructions[4:])
+			module := bytecode.Constants[operands[0]].(*types.StringValue).Value()
+			function := bytecode.Constants[operands[1]].(*types.StringValue).Value()
+			if module != tt.module || function != tt.function || operands[2] != 1 {
+				t.Errorf("Expected %s.%s with 1 argument, got %s.%s with %d", tt.module, tt.function, module, function, operands[2])
+			}
+		})
+	}
+
+	errorTests := map[string]string{
+		`import "nope"; 1`:             "module 'nope' not found",
+		`from math import nope; 1`:     "function 'nope' not found in module 'math'",
+		`import "math" as x; 1`:        "cannot import math as x: name is already defined",
+		`import "math" as m; m.nope()`: "function 'nope' not found in module 'math'",
+		`math.sqrt(1, 2)`:              "function math.sqrt expects 1 arguments, got 2",
+		`x.sqrt(1)`:                    "",
+	}
+	for input, want := range errorTests {
+		compiler := New()
+		compiler.symbolTable.Define("x")
+		err := compiler.Compile(parseProgram(t, input))
+		if want == "" {
+			if err != nil {
+				t.Errorf("%s: variables shadow modules, got %v", input, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected error containing %q, got %v", input, want, err)
+		}
+	}
+}
+
+func TestFoldLogical(t *testing.T) {
+	compiler := New()
+
+	if result := compiler.foldLogical(types.NewBool(false), nil, "&&"); result == nil || result.(*types.BoolValue).Value() {
+		t.Errorf("Expected false && x to fold to false, got %v", result)
+	}
+	if result := compiler.foldLogical(types.NewInt(1), nil, "||"); result == nil || !result.(*types.BoolValue).Value() {
+		t.Errorf("Expected 1 || x to fold to true, got %v", result)
+	}
+	if result := compiler.foldLogical(types.NewBool(true), nil, "&&"); result != nil {
+		t.Errorf("Expected true && x not to fold, got %v", result)
+	}
+	if result := compiler.foldLogical(types.NewBool(true), types.NewString(""), "&&"); result == nil || result.(*types.BoolValue).Value() {
+		t.Errorf("Expected true && \"\" to fold to false, got %v", result)
+	}
+}
+
+func TestCompileLambdaExpression(t *testing.T) {
+	program := parseProgram(t, "x => (y => x + y)")
+	compiler := New()
+
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("Compilation error: %v", err)
+	}
+
+	bytecode := compiler.Bytecode()
+	outer, ok := bytecode.Constants[len(bytecode.Constants)-1].(*types.FuncValue)
+	if !ok {
+		t.Fatalf("Expected function constant, got %T", bytecode.Constants[len(bytecode.Constants)-1])
+	}
+	fn, ok := outer.Body().(*vm.CompiledFunction)
+	if !ok {
+		t.Fatalf("Expected compiled body, got %T", outer.Body())
+	}
+
+	// The inner lambda captures x from the outer one
+	ops := extractOpcodes(fn.Instructions)
+	if len(ops) != 2 || ops[0] != vm.OpGetLocal || ops[1] != vm.OpClosure {
+		t.Fatalf("Expected [OpGetLocal OpClosure], got %v", ops)
+	}
+
+	inner := bytecode.Constants[0].(*types.FuncValue).Body().(*vm.CompiledFunction)
+	if len(inner.FreeNames) != 1 || inner.FreeNames[0] != "x" {
+		t.Errorf("Expected inner lambda to capture x, got %v", inner.FreeNames)
+	}
+	ops = extractOpcodes(inner.Instructions)
+	if len(ops) != 3 || ops[0] != vm.OpGetFree || ops[1] != vm.OpGetLocal {
+		t.Errorf("Expected [OpGetFree OpGetLocal OpAdd], got %v", ops)
+	}
+}
+
+func TestOptimizeInstructionsKeepsJumpTargets(t *testing.T) {
+	var instructions []byte
+	instructions = append(instructions, vm.Make(vm.OpNoop)...)
+	instructions = append(instructions, vm.Make(vm.OpConstant, 0)...)
+	instructions = append(instructions, vm.Make(vm.OpJumpFalse, 11)...)
+	instructions = append(instructions, vm.Make(vm.OpConstant, 1)...)
+	instructions = append(instructions, vm.Make(vm.OpPop)...)
+	instructions = append(instructions, vm.Make(vm.OpConstant, 2)...)
+
+	optimized := NewBytecodeOptimizer(OptimizationBasic).OptimizeInstructions(instructions)
+
+	var expected []byte
+	expected = append(expected, vm.Make(vm.OpConstant, 0)...)
+	expected = append(expected, vm.Make(vm.OpJumpFalse, 6)...)
+	expected = append(expected, vm.Make(vm.OpConstant, 2)...)
+	if string(optimized) != string(expected) {
+		t.Errorf("Expected %v, got %v", expected, optimized)
+	}
+
+	// A pop reached by a jump is not merged with the push before it
+	instructions = nil
+	instructions = append(instructions, vm.Make(vm.OpJump, 4)...)
+	instructions = append(instructions, vm.Make(vm.OpDup)...)
+	instructions = append(instructions, vm.Make(vm.OpPop)...)
+	optimized = NewBytecodeOptimizer(OptimizationBasic).OptimizeInstructions(instructions)
+	if string(optimized) != string(instructions) {
+		t.Errorf("Expected instructions to be unchanged, got %v", optimized)
+	}
+}
+
+func TestOptimizeWithSourceMap(t *testing.T) {
+	var instructions []byte
+	instructions = append(instructions, vm.Make(vm.OpNoop)...)
+	instructions = append(instructions, vm.Make(vm.OpConstant, 0)...)
+	instructions = append(instructions, vm.Make(vm.OpConstant, 1)...)
+	instructions = append(instructions, vm.Make(vm.OpDiv)...)
+	positions := vm.SourceMap{
+		{Offset: 0, Pos: lexer.Position{Line: 1, Column: 1}},
+		{Offset: 1, Pos: lexer.Position{Line: 1, Column: 1}},
+		{Offset: 4, Pos: lexer.Position{Line: 1, Column: 5}},
+		{Offset: 7, Pos: lexer.Position{Line: 1, Column: 3}},
+	}
+
+	optimized, positions := NewBytecodeOptimizer(OptimizationBasic).OptimizeWithSourceMap(instructions, positions)
+	if len(optimized) != 7 {
+		t.Fatalf("Expected the noop to be removed, got %v", optimized)
+	}
+	expected := vm.SourceMap{
+		{Offset: 0, Pos: lexer.Position{Line: 1, Column: 1}},
+		{Offset: 3, Pos: lexer.Position{Line: 1, Column: 5}},
+		{Offset: 6, Pos: lexer.Position{Line: 1, Column: 3}},
+	}
+	if fmt.Sprint(positions) != fmt.Sprint(expected) {
+		t.Errorf("Expected positions %v, got %v", expected, positions)
+	}
+}
+
+func TestBytecodePositions(t *testing.T) {
+	l := lexer.New("a +\n  b * 2")
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	c := New()
+	c.symbolTable.Define("a")
+	c.symbolTable.Define("b")
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	bytecode := c.Bytecode()
+	pos, ok := bytecode.Positions.Lookup(len(bytecode.Instructions) - 1)
+	if !ok || pos.Line != 1 || pos.Column != 3 {
+		t.Errorf("Expected the addition at 1:3, got %v", pos)
+	}
+
+	found := false
+	for _, entry := range bytecode.Positions {
+		if vm.Opcode(bytecode.Instructions[entry.Offset]) != vm.OpMul {
+			continue
+		}
+		found = true
+		if entry.Pos.Line != 2 || entry.Pos.Column != 5 {
+			t.Errorf("Expected the multiplication at 2:5, got %v", entry.Pos)
+		}
+	}
+	if !found {
+		t.Error("Expected a position for the multiplication")
+	}
+}
+
 func TestBytecode(t *testing.T) {
 	compiler := New()
 	compiler.constants = []types.Value{types.NewInt(42)}
@@ -361,6 +731,38 @@ func TestGetVariableOrder(t *testing.T) {
 	}
 }
 
+func TestPartialEval(t *testing.T) {
+	known := map[string]types.Value{
+		"premium": types.NewBool(true),
+		"rate":    types.NewFloat(1.5),
+		"limits":  types.NewMap(map[string]types.Value{"max": types.NewInt(10)}, types.StringType, types.IntType),
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"premium && size < limits.max", "(size < 10)"},
+		{"!premium || size > 100", "(size > 100)"},
+		{"premium && amount", "(true && amount)"},
+		{"premium ? amount * rate : amount", "(amount * 1.5)"},
+		{`limits["max"] * 2 + size`, "(20 + size)"},
+		{"let m = limits.max + 1; size < m", "(size < 11)"},
+		{"amount ?? rate", "(amount ?? 1.5)"},
+		{"items | map(rate => rate * 2)", "items | map(rate => (rate * 2))"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			program := New().PartialEval(parseProgram(t, tt.input), known)
+			last := program.Statements[len(program.Statements)-1]
+			if last.String() != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, last.String())
+			}
+		})
+	}
+}
+
 // Helper functions
 
 func parseProgram(t *testing.T, input string) *ast.Program {
@@ -389,14 +791,12 @@ func extractOpcodes(instructions []byte) []vm.Opcode {
 		op := vm.Opcode(instructions[i])
 		ops = append(ops, op)
 
-		// Skip operands based on opcode
-		switch op {
-		case vm.OpConstant, vm.OpGetVar, vm.OpSetVar, vm.OpSlice, vm.OpMap, vm.OpJump, vm.OpJumpFalse:
-			i += 3 // 1 byte opcode + 2 byte operand
-		case vm.OpCall, vm.OpBuiltin:
-			i += 2 // 1 byte opcode + 1 byte operand
-		default:
-			i += 1 // Just the opcode
+		// Skip operands based on the opcode definition
+		i++
+		if def, err := vm.Lookup(op); err == nil {
+			for _, width := range def.OperandWidth {
+				i += width
+			}
 		}
 	}
 	return ops
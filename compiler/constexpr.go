@@ -0,0 +1,123 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/mredencom/expr/ast"
+	"github.com/mredencom/expr/builtins"
+	"github.com/mredencom/expr/types"
+)
+
+// ConstFunc evaluates a function call at compile time
+type ConstFunc func(args []types.Value) (types.Value, error)
+
+// pureBuiltins are the builtins whose result only depends on their arguments.
+// Calls to them with constant arguments are evaluated at compile time.
+var pureBuiltins = map[string]bool{
+	"len": true, "string": true, "int": true, "float": true, "bool": true,
+	"abs": true, "max": true, "min": true, "type": true,
+	"contains": true, "startsWith": true, "endsWith": true, "matches": true,
+	"upper": true, "lower": true, "trim": true,
+	"replace": true, "substring": true, "indexOf": true,
+	"ceil": true, "floor": true, "round": true, "sqrt": true, "pow": true,
+	"split": true, "join": true, "flatten": true, "reverse": true, "unique": true,
+	"count": true, "sum": true, "first": true, "last": true, "keys": true,
+}
+
+// foldedCall is the cached outcome of evaluating a call at compile time
+type foldedCall struct {
+	value  types.Value
+	folded bool
+	err    error
+}
+
+// DefineConstFunc registers a function that is evaluated at compile time
+// whenever all of its arguments are constants
+func (c *Compiler) DefineConstFunc(name string, fn ConstFunc) {
+	if c.constFuncs == nil {
+		c.constFuncs = make(map[string]ConstFunc)
+	}
+	c.constFuncs[name] = fn
+}
+
+// tryCallFolding evaluates a call with constant arguments at compile time.
+// Errors of pure builtins are left for the VM to report, errors of functions
+// registered with DefineConstFunc are compile errors. Each call is evaluated
+// at most once.
+func (c *Compiler) tryCallFolding(node *ast.BuiltinExpression) (types.Value, bool, error) {
+	if cached, ok := c.foldedCalls[node]; ok {
+		return cached.value, cached.folded, cached.err
+	}
+
+	value, folded, err := c.evaluateCall(node.Name, node.Arguments)
+	if c.foldedCalls == nil {
+		c.foldedCalls = make(map[*ast.BuiltinExpression]foldedCall)
+	}
+	c.foldedCalls[node] = foldedCall{value: value, folded: folded, err: err}
+	return value, folded, err
+}
+
+// evaluateCall calls a pure function when all of its arguments are constants
+func (c *Compiler) evaluateCall(name string, arguments []ast.Expression) (types.Value, bool, error) {
+	fn, isConstFunc := c.constFuncs[name]
+	if !isConstFunc {
+		// A custom function replaces the builtin of the same name at runtime
+		builtin, ok := builtins.AllBuiltins[name]
+		if !ok || !pureBuiltins[name] || c.functions[name] {
+			return nil, false, nil
+		}
+		fn = ConstFunc(builtin)
+	}
+
+	args := make([]types.Value, len(arguments))
+	for i, argument := range arguments {
+		value, ok := c.constantValue(argument)
+		if !ok {
+			return nil, false, nil
+		}
+		args[i] = value
+	}
+
+	result, err := fn(args)
+	if err != nil {
+		if isConstFunc {
+			return nil, false, fmt.Errorf("%s: %w", name, err)
+		}
+		return nil, false, nil
+	}
+	if result == nil {
+		return nil, false, nil
+	}
+	return result, true, nil
+}
+
+// constantValue returns the value of an expression that is known at compile time
+func (c *Compiler) constantValue(node ast.Expression) (types.Value, bool) {
+	switch n := node.(type) {
+	case *ast.Literal:
+		return n.Value, n.Value != nil
+	case *ast.PrefixExpression:
+		value := c.tryPrefixConstantFolding(n)
+		return value, value != nil
+	case *ast.InfixExpression:
+		if c.operators[n.Operator] {
+			return nil, false
+		}
+		value := c.tryConstantFolding(n)
+		return value, value != nil
+	case *ast.ArrayLiteral:
+		values := make([]types.Value, len(n.Elements))
+		for i, element := range n.Elements {
+			value, ok := c.constantValue(element)
+			if !ok {
+				return nil, false
+			}
+			values[i] = value
+		}
+		return types.NewSlice(values, types.TypeInfo{Kind: types.KindInterface, Name: "interface{}"}), true
+	case *ast.BuiltinExpression:
+		value, ok, err := c.tryCallFolding(n)
+		return value, ok && err == nil
+	}
+	return nil, false
+}
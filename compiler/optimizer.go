@@ -27,15 +27,22 @@ func NewBytecodeOptimizer(level OptimizationLevel) *BytecodeOptimizer {
 
 // OptimizeInstructions applies various optimizations to bytecode instructions
 func (bo *BytecodeOptimizer) OptimizeInstructions(instructions []byte) []byte {
+	optimized, _ := bo.OptimizeWithSourceMap(instructions, nil)
+	return optimized
+}
+
+// OptimizeWithSourceMap optimizes bytecode instructions and moves their
+// source positions along. Positions of removed instructions are dropped.
+func (bo *BytecodeOptimizer) OptimizeWithSourceMap(instructions []byte, positions vm.SourceMap) ([]byte, vm.SourceMap) {
 	if bo.optimizationLevel == int(OptimizationNone) {
-		return instructions
+		return instructions, positions
 	}
 
 	optimized := instructions
 
 	// Apply basic optimizations
-	optimized = bo.mergePushPop(optimized)
-	optimized = bo.eliminateNoop(optimized)
+	optimized, positions = bo.mergePushPop(optimized, positions)
+	optimized, positions = bo.eliminateNoop(optimized, positions)
 
 	if bo.optimizationLevel >= int(OptimizationAggressive) {
 		// Apply aggressive optimizations
@@ -43,77 +50,152 @@ func (bo *BytecodeOptimizer) OptimizeInstructions(instructions []byte) []byte {
 		optimized = bo.optimizeConstantSequences(optimized)
 	}
 
-	return optimized
+	return optimized, positions
 }
 
 // mergePushPop removes consecutive push/pop operations that cancel out
-func (bo *BytecodeOptimizer) mergePushPop(instructions []byte) []byte {
-	if len(instructions) < 2 {
-		return instructions
+func (bo *BytecodeOptimizer) mergePushPop(instructions []byte, positions vm.SourceMap) ([]byte, vm.SourceMap) {
+	decoded, ok := decodeInstructions(instructions)
+	if !ok || len(decoded) < 2 {
+		return instructions, positions
 	}
 
-	result := make([]byte, 0, len(instructions))
-	i := 0
+	targets := jumpTargets(instructions, decoded)
+	removed := make([]bool, len(decoded))
+	changed := false
 
-	for i < len(instructions) {
-		current := vm.Opcode(instructions[i])
+	for i := 0; i+1 < len(decoded); i++ {
+		current, next := decoded[i], decoded[i+1]
+		if next.op != vm.OpPop || targets[next.offset] {
+			continue
+		}
 
-		// Look for OpConstant followed by OpPop pattern
-		if current == vm.OpConstant && i+3 < len(instructions) {
-			if vm.Opcode(instructions[i+3]) == vm.OpPop {
-				// Skip both instructions
-				i += 4
-				continue
-			}
+		// OpConstant or OpDup followed by OpPop is a no-op, unless another
+		// path jumps straight to the OpPop
+		if current.op == vm.OpConstant || current.op == vm.OpDup {
+			removed[i], removed[i+1] = true, true
+			changed = true
+			i++
 		}
+	}
 
-		// Look for duplicate stack operations
-		if current == vm.OpDup && i+1 < len(instructions) {
-			if vm.Opcode(instructions[i+1]) == vm.OpPop {
-				// OpDup followed by OpPop is essentially a no-op
-				i += 2
-				continue
-			}
+	if !changed {
+		return instructions, positions
+	}
+	return removeInstructions(instructions, decoded, removed, positions)
+}
+
+// eliminateNoop removes no-operation instructions
+func (bo *BytecodeOptimizer) eliminateNoop(instructions []byte, positions vm.SourceMap) ([]byte, vm.SourceMap) {
+	decoded, ok := decodeInstructions(instructions)
+	if !ok {
+		return instructions, positions
+	}
+
+	removed := make([]bool, len(decoded))
+	changed := false
+	for i, ins := range decoded {
+		if ins.op == vm.OpNoop {
+			removed[i] = true
+			changed = true
 		}
+	}
 
-		// Copy current instruction
-		if current == vm.OpConstant && i+2 < len(instructions) {
-			// Copy opcode and 2-byte operand
-			result = append(result, instructions[i:i+3]...)
-			i += 3
-		} else {
-			result = append(result, instructions[i])
-			i++
+	if !changed {
+		return instructions, positions
+	}
+	return removeInstructions(instructions, decoded, removed, positions)
+}
+
+// instruction is a decoded instruction with its offset and width in bytes
+type instruction struct {
+	op     vm.Opcode
+	offset int
+	width  int
+}
+
+// decodeInstructions splits bytecode into instructions using the opcode
+// definitions. It returns false for unknown opcodes or truncated operands.
+func decodeInstructions(instructions []byte) ([]instruction, bool) {
+	var decoded []instruction
+	for i := 0; i < len(instructions); {
+		op := vm.Opcode(instructions[i])
+		def, err := vm.Lookup(op)
+		if err != nil {
+			return nil, false
+		}
+
+		width := 1
+		for _, w := range def.OperandWidth {
+			width += w
+		}
+		if i+width > len(instructions) {
+			return nil, false
 		}
+
+		decoded = append(decoded, instruction{op: op, offset: i, width: width})
+		i += width
 	}
+	return decoded, true
+}
 
-	return result
+// isJump reports whether the opcode takes an absolute jump target
+func isJump(op vm.Opcode) bool {
+	switch op {
+	case vm.OpJump, vm.OpJumpTrue, vm.OpJumpFalse, vm.OpJumpNil:
+		return true
+	}
+	return false
 }
 
-// eliminateNoop removes no-operation instructions
-func (bo *BytecodeOptimizer) eliminateNoop(instructions []byte) []byte {
+// jumpTargets returns the set of offsets targeted by jump instructions
+func jumpTargets(instructions []byte, decoded []instruction) map[int]bool {
+	targets := make(map[int]bool)
+	for _, ins := range decoded {
+		if isJump(ins.op) {
+			targets[int(instructions[ins.offset+1])<<8|int(instructions[ins.offset+2])] = true
+		}
+	}
+	return targets
+}
+
+// removeInstructions drops the removed instructions and rewrites jump
+// targets and source positions to the new offsets. A jump to a removed
+// instruction lands on the next instruction that is kept.
+func removeInstructions(instructions []byte, decoded []instruction, removed []bool, positions vm.SourceMap) ([]byte, vm.SourceMap) {
+	newOffsets := make(map[int]int, len(decoded)+1)
+	kept := make(map[int]bool, len(decoded))
 	result := make([]byte, 0, len(instructions))
-	i := 0
 
-	for i < len(instructions) {
-		current := vm.Opcode(instructions[i])
+	for i, ins := range decoded {
+		newOffsets[ins.offset] = len(result)
+		if !removed[i] {
+			kept[ins.offset] = true
+			result = append(result, instructions[ins.offset:ins.offset+ins.width]...)
+		}
+	}
+	newOffsets[len(instructions)] = len(result)
 
-		if current == vm.OpNoop {
-			i++
-			continue
+	var newPositions vm.SourceMap
+	for _, entry := range positions {
+		if kept[entry.Offset] {
+			newPositions = append(newPositions, vm.SourcePosition{Offset: newOffsets[entry.Offset], Pos: entry.Pos})
 		}
+	}
 
-		// Copy instruction
-		if current == vm.OpConstant && i+2 < len(instructions) {
-			result = append(result, instructions[i:i+3]...)
-			i += 3
-		} else {
-			result = append(result, instructions[i])
-			i++
+	for i, ins := range decoded {
+		if removed[i] || !isJump(ins.op) {
+			continue
+		}
+		pos := newOffsets[ins.offset]
+		target := int(instructions[ins.offset+1])<<8 | int(instructions[ins.offset+2])
+		if newTarget, ok := newOffsets[target]; ok {
+			result[pos+1] = byte(newTarget >> 8)
+			result[pos+2] = byte(newTarget)
 		}
 	}
 
-	return result
+	return result, newPositions
 }
 
 // fuseArithmeticInstructions combines arithmetic operations where possible
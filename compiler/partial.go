@@ -0,0 +1,286 @@
+package compiler
+
+import (
+	"github.com/mredencom/expr/ast"
+	"github.com/mredencom/expr/types"
+)
+
+// PartialEval specializes a program for the variables whose values are
+// known. Known variables become literals, members and elements read from
+// them are looked up, constant subexpressions are folded, ternaries with a
+// constant test keep only the branch taken and logical operators with a
+// constant left operand are reduced: true && x and false || x become x
+// when x is a bool. Lets bound to constants are inlined. The program is
+// changed in place and returned; it is then compiled as usual.
+func (c *Compiler) PartialEval(program *ast.Program, known map[string]types.Value) *ast.Program {
+	p := &partialEvaluator{
+		compiler: c,
+		known:    known,
+		scopes:   []map[string]types.Value{{}},
+		imported: make(map[string]bool),
+	}
+
+	statements := program.Statements[:0]
+	for _, stmt := range program.Statements {
+		switch s := stmt.(type) {
+		case *ast.ImportStatement:
+			for _, name := range s.Names {
+				p.imported[name] = true
+			}
+		case *ast.ExpressionStatement:
+			s.Expression = p.simplify(s.Expression)
+		case *ast.LetStatement:
+			s.Value = p.simplify(s.Value)
+			if literal, ok := s.Value.(*ast.Literal); ok {
+				p.scopes[0][s.Name] = literalValue(literal)
+				continue
+			}
+			p.scopes[0][s.Name] = nil
+		case *ast.DestructuringAssignment:
+			s.Right = p.simplify(s.Right)
+			for _, name := range destructuredNames(s.Left) {
+				p.scopes[0][name] = nil
+			}
+		}
+		statements = append(statements, stmt)
+	}
+	program.Statements = statements
+	return program
+}
+
+// partialEvaluator rewrites expressions using the values known before they run
+type partialEvaluator struct {
+	compiler *Compiler
+	known    map[string]types.Value
+	scopes   []map[string]types.Value // Names bound in the program, nil values are unknown
+	imported map[string]bool          // Functions imported by name from modules
+}
+
+// value returns the value of a name when it is known
+func (p *partialEvaluator) value(name string) (types.Value, bool) {
+	for i := len(p.scopes) - 1; i >= 0; i-- {
+		if value, bound := p.scopes[i][name]; bound {
+			return value, value != nil
+		}
+	}
+	value, ok := p.known[name]
+	return value, ok && value != nil
+}
+
+// simplify returns the residual of an expression, changing its children in place
+func (p *partialEvaluator) simplify(expr ast.Expression) ast.Expression {
+	switch e := expr.(type) {
+	case *ast.Identifier:
+		if value, ok := p.value(e.Value); ok {
+			return &ast.Literal{Value: value, Pos: e.Pos}
+		}
+	case *ast.InfixExpression:
+		return p.simplifyInfix(e)
+	case *ast.PrefixExpression:
+		e.Right = p.simplify(e.Right)
+		if value := p.compiler.tryPrefixConstantFolding(e); value != nil {
+			return &ast.Literal{Value: value, Pos: e.Pos}
+		}
+	case *ast.ConditionalExpression:
+		e.Test = p.simplify(e.Test)
+		if test, ok := p.constantBool(e.Test); ok {
+			if test {
+				return p.simplify(e.Consequent)
+			}
+			return p.simplify(e.Alternative)
+		}
+		e.Consequent = p.simplify(e.Consequent)
+		e.Alternative = p.simplify(e.Alternative)
+	case *ast.NullCoalescingExpression:
+		e.Left = p.simplify(e.Left)
+		if left, ok := e.Left.(*ast.Literal); ok {
+			if _, isNil := literalValue(left).(*types.NilValue); isNil {
+				return p.simplify(e.Right)
+			}
+			return left
+		}
+		e.Right = p.simplify(e.Right)
+	case *ast.MemberExpression:
+		e.Object = p.simplify(e.Object)
+		if value, ok := p.member(e.Object, e.Property); ok {
+			return &ast.Literal{Value: value, Pos: e.Pos}
+		}
+	case *ast.OptionalChainingExpression:
+		e.Object = p.simplify(e.Object)
+		if object, ok := e.Object.(*ast.Literal); ok {
+			if _, isNil := literalValue(object).(*types.NilValue); isNil {
+				return &ast.Literal{Value: types.NewNil(), Pos: e.Pos}
+			}
+		}
+		if value, ok := p.member(e.Object, e.Property); ok {
+			return &ast.Literal{Value: value, Pos: e.Pos}
+		}
+	case *ast.IndexExpression:
+		e.Left = p.simplify(e.Left)
+		e.Index = p.simplify(e.Index)
+		if value, ok := p.element(e.Left, e.Index); ok {
+			return &ast.Literal{Value: value, Pos: e.Pos}
+		}
+	case *ast.BuiltinExpression:
+		p.simplifyAll(e.Arguments)
+		if !p.imported[e.Name] {
+			if value, folded, err := p.compiler.tryCallFolding(e); folded && err == nil {
+				return &ast.Literal{Value: value, Pos: e.Pos}
+			}
+		}
+	case *ast.CallExpression:
+		e.Function = p.simplify(e.Function)
+		p.simplifyAll(e.Arguments)
+	case *ast.ModuleCallExpression:
+		p.simplifyAll(e.Arguments)
+	case *ast.PipeExpression:
+		e.Left = p.simplify(e.Left)
+		// The call on the right receives the data as its first argument, so
+		// only its own arguments are simplified
+		switch right := e.Right.(type) {
+		case *ast.BuiltinExpression:
+			p.simplifyAll(right.Arguments)
+		case *ast.Identifier:
+		default:
+			e.Right = p.simplify(e.Right)
+		}
+	case *ast.LambdaExpression:
+		scope := make(map[string]types.Value, len(e.Parameters))
+		for _, param := range e.Parameters {
+			scope[param] = nil
+		}
+		p.scopes = append(p.scopes, scope)
+		e.Body = p.simplify(e.Body)
+		p.scopes = p.scopes[:len(p.scopes)-1]
+	case *ast.ArrayLiteral:
+		p.simplifyAll(e.Elements)
+	case *ast.MapLiteral:
+		for i := range e.Pairs {
+			// Names used as keys are strings, not variables
+			if _, isName := e.Pairs[i].Key.(*ast.Identifier); !isName {
+				e.Pairs[i].Key = p.simplify(e.Pairs[i].Key)
+			}
+			e.Pairs[i].Value = p.simplify(e.Pairs[i].Value)
+		}
+	}
+	return expr
+}
+
+// simplifyAll simplifies a list of expressions in place
+func (p *partialEvaluator) simplifyAll(exprs []ast.Expression) {
+	for i, expr := range exprs {
+		exprs[i] = p.simplify(expr)
+	}
+}
+
+// simplifyInfix folds an infix expression with constant operands and
+// reduces logical operators whose left operand is constant
+func (p *partialEvaluator) simplifyInfix(e *ast.InfixExpression) ast.Expression {
+	e.Left = p.simplify(e.Left)
+	logical := e.Operator == "&&" || e.Operator == "||"
+	left, constant := p.constantBool(e.Left)
+	if logical && constant && left == (e.Operator == "||") {
+		return &ast.Literal{Value: types.NewBool(left), Pos: e.Pos}
+	}
+	e.Right = p.simplify(e.Right)
+
+	// With a constant left operand the result is the right operand converted
+	// to a bool, so the operand replaces the expression only when it is a
+	// bool already
+	if logical && constant && p.isBool(e.Right) {
+		return e.Right
+	}
+
+	if p.compiler.operators[e.Operator] {
+		return e
+	}
+	if value := p.compiler.tryConstantFolding(e); value != nil {
+		return &ast.Literal{Value: value, Pos: e.Pos}
+	}
+	return e
+}
+
+// isBool reports whether an expression always evaluates to a bool
+func (p *partialEvaluator) isBool(expr ast.Expression) bool {
+	switch e := expr.(type) {
+	case *ast.Literal:
+		_, ok := e.Value.(*types.BoolValue)
+		return ok
+	case *ast.PrefixExpression:
+		return e.Operator == "!"
+	case *ast.InfixExpression:
+		switch e.Operator {
+		case "==", "!=", "<", "<=", ">", ">=", "&&", "||":
+			return !p.compiler.operators[e.Operator]
+		}
+	}
+	return false
+}
+
+// constantBool returns the truth of a literal
+func (p *partialEvaluator) constantBool(expr ast.Expression) (bool, bool) {
+	literal, ok := expr.(*ast.Literal)
+	if !ok {
+		return false, false
+	}
+	value, ok := p.compiler.valueToBool(literalValue(literal)).(*types.BoolValue)
+	if !ok {
+		return false, false
+	}
+	return value.Value(), true
+}
+
+// member reads a named member of a constant map
+func (p *partialEvaluator) member(object, property ast.Expression) (types.Value, bool) {
+	literal, isLiteral := object.(*ast.Literal)
+	name, isName := property.(*ast.Identifier)
+	if !isLiteral || !isName {
+		return nil, false
+	}
+	if m, ok := literal.Value.(*types.MapValue); ok {
+		return m.Get(name.Value)
+	}
+	return nil, false
+}
+
+// element reads an element of a constant slice or map at a constant index
+func (p *partialEvaluator) element(object, index ast.Expression) (types.Value, bool) {
+	literal, isLiteral := object.(*ast.Literal)
+	key, isKey := index.(*ast.Literal)
+	if !isLiteral || !isKey {
+		return nil, false
+	}
+
+	switch container := literal.Value.(type) {
+	case *types.MapValue:
+		if str, ok := key.Value.(*types.StringValue); ok {
+			return container.Get(str.Value())
+		}
+	case *types.SliceValue:
+		if i, ok := key.Value.(*types.IntValue); ok && i.Value() >= 0 && i.Value() < int64(container.Len()) {
+			return container.Get(int(i.Value())), true
+		}
+	}
+	return nil, false
+}
+
+// destructuredNames returns the names bound by a destructuring pattern
+func destructuredNames(pattern ast.DestructuringPattern) []string {
+	var names []string
+	switch p := pattern.(type) {
+	case *ast.ArrayDestructuringPattern:
+		for _, element := range p.Elements {
+			switch e := element.(type) {
+			case *ast.IdentifierElement:
+				names = append(names, e.Name)
+			case *ast.RestElement:
+				names = append(names, e.Name)
+			}
+		}
+	case *ast.ObjectDestructuringPattern:
+		for _, property := range p.Properties {
+			names = append(names, property.Value)
+		}
+	}
+	return names
+}
@@ -0,0 +1,251 @@
+package compiler
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/mredencom/expr/ast"
+	"github.com/mredencom/expr/types"
+)
+
+// References are the names a program uses from outside the expression
+type References struct {
+	Variables []string // Environment variables read by the program
+	Paths     []string // Member paths read from the variables, e.g. user.address.city
+	Functions []string // Builtin and custom functions, module functions as module.function
+	Modules   []string // Modules whose functions are called
+}
+
+// References collects the variables, member paths, functions and modules a
+// program uses. It must be called after Compile so that the imports of the
+// program are known. Names bound by let and lambda parameters are not
+// references; a variable used as a whole is listed in Paths by its name.
+func (c *Compiler) References(program *ast.Program) *References {
+	r := &referenceCollector{
+		compiler:  c,
+		scopes:    []map[string]bool{{}},
+		variables: make(map[string]bool),
+		paths:     make(map[string]bool),
+		functions: make(map[string]bool),
+		modules:   make(map[string]bool),
+	}
+	for _, stmt := range program.Statements {
+		switch s := stmt.(type) {
+		case *ast.ExpressionStatement:
+			r.visit(s.Expression)
+		case *ast.LetStatement:
+			r.visit(s.Value)
+			r.scopes[0][s.Name] = true
+		case *ast.DestructuringAssignment:
+			r.visit(s.Right)
+		}
+	}
+
+	return &References{
+		Variables: sortedNames(r.variables),
+		Paths:     sortedNames(r.paths),
+		Functions: sortedNames(r.functions),
+		Modules:   sortedNames(r.modules),
+	}
+}
+
+// referenceCollector walks a program keeping track of the names bound in it
+type referenceCollector struct {
+	compiler  *Compiler
+	scopes    []map[string]bool // Names bound by let and lambda parameters
+	variables map[string]bool
+	paths     map[string]bool
+	functions map[string]bool
+	modules   map[string]bool
+}
+
+// bound reports whether a name is bound inside the program
+func (r *referenceCollector) bound(name string) bool {
+	for _, scope := range r.scopes {
+		if scope[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// visit collects the references of an expression
+func (r *referenceCollector) visit(expr ast.Expression) {
+	switch e := expr.(type) {
+	case *ast.Identifier:
+		if !r.bound(e.Value) {
+			r.variables[e.Value] = true
+			r.paths[e.Value] = true
+		}
+	case *ast.MemberExpression, *ast.IndexExpression, *ast.OptionalChainingExpression:
+		r.visitAccess(e)
+	case *ast.CallExpression:
+		r.visitCall(e)
+	case *ast.BuiltinExpression:
+		r.function(e.Name)
+		r.visitAll(e.Arguments)
+	case *ast.ModuleCallExpression:
+		if module, ok := r.compiler.resolveModule(e.Module); ok {
+			r.moduleFunction(module, e.Function)
+		}
+		r.visitAll(e.Arguments)
+	case *ast.PipeExpression:
+		r.visit(e.Left)
+		// A name on the right of a pipe is the function applied to the data
+		if ident, ok := e.Right.(*ast.Identifier); ok && !r.bound(ident.Value) {
+			r.function(ident.Value)
+			return
+		}
+		r.visit(e.Right)
+	case *ast.LambdaExpression:
+		scope := make(map[string]bool, len(e.Parameters))
+		for _, param := range e.Parameters {
+			scope[param] = true
+		}
+		r.scopes = append(r.scopes, scope)
+		r.visit(e.Body)
+		r.scopes = r.scopes[:len(r.scopes)-1]
+	case *ast.InfixExpression:
+		r.visit(e.Left)
+		r.visit(e.Right)
+	case *ast.PrefixExpression:
+		r.visit(e.Right)
+	case *ast.ConditionalExpression:
+		r.visit(e.Test)
+		r.visit(e.Consequent)
+		r.visit(e.Alternative)
+	case *ast.NullCoalescingExpression:
+		r.visit(e.Left)
+		r.visit(e.Right)
+	case *ast.ArrayLiteral:
+		r.visitAll(e.Elements)
+	case *ast.MapLiteral:
+		for _, pair := range e.Pairs {
+			// Names used as keys are strings, not variables
+			if _, isName := pair.Key.(*ast.Identifier); !isName {
+				r.visit(pair.Key)
+			}
+			r.visit(pair.Value)
+		}
+	}
+}
+
+// visitAll collects the references of a list of expressions
+func (r *referenceCollector) visitAll(exprs []ast.Expression) {
+	for _, expr := range exprs {
+		r.visit(expr)
+	}
+}
+
+// visitAccess collects a member or index access. Accesses with constant
+// names on a variable form a path; other accesses end the path at the value
+// they are applied to.
+func (r *referenceCollector) visitAccess(expr ast.Expression) {
+	if path := r.path(expr); path != nil {
+		r.variables[path[0]] = true
+		r.paths[strings.Join(path, ".")] = true
+		return
+	}
+
+	switch e := expr.(type) {
+	case *ast.MemberExpression:
+		r.visit(e.Object)
+	case *ast.OptionalChainingExpression:
+		r.visit(e.Object)
+	case *ast.IndexExpression:
+		r.visit(e.Left)
+		r.visit(e.Index)
+	}
+}
+
+// path returns the names of an access chain starting at a variable, or nil
+// when the chain contains computed members
+func (r *referenceCollector) path(expr ast.Expression) []string {
+	var object, property ast.Expression
+	switch e := expr.(type) {
+	case *ast.Identifier:
+		if r.bound(e.Value) {
+			return nil
+		}
+		return []string{e.Value}
+	case *ast.MemberExpression:
+		object, property = e.Object, e.Property
+	case *ast.OptionalChainingExpression:
+		object, property = e.Object, e.Property
+	case *ast.IndexExpression:
+		// Only string literals index by name, user["name"]
+		literal, ok := e.Index.(*ast.Literal)
+		if !ok {
+			return nil
+		}
+		object, property = e.Left, literal
+	default:
+		return nil
+	}
+
+	var name string
+	switch p := property.(type) {
+	case *ast.Identifier:
+		name = p.Value
+	case *ast.Literal:
+		str, ok := p.Value.(*types.StringValue)
+		if !ok {
+			return nil
+		}
+		name = str.Value()
+	default:
+		return nil
+	}
+
+	path := r.path(object)
+	if path == nil {
+		return nil
+	}
+	return append(path, name)
+}
+
+// visitCall collects a call. Members called as methods are not part of the
+// paths; calls on module names are module function calls.
+func (r *referenceCollector) visitCall(call *ast.CallExpression) {
+	r.visitAll(call.Arguments)
+
+	member, ok := call.Function.(*ast.MemberExpression)
+	if !ok {
+		r.visit(call.Function)
+		return
+	}
+	if object, isIdent := member.Object.(*ast.Identifier); isIdent && !r.bound(object.Value) {
+		if property, isName := member.Property.(*ast.Identifier); isName {
+			if module, ok := r.compiler.resolveModule(object.Value); ok {
+				r.moduleFunction(module, property.Value)
+				return
+			}
+		}
+	}
+	r.visit(member.Object)
+}
+
+// function records a call of a builtin, custom or imported function
+func (r *referenceCollector) function(name string) {
+	if imported, ok := r.compiler.importedFuncs[name]; ok {
+		r.moduleFunction(imported.module, imported.function)
+		return
+	}
+	r.functions[name] = true
+}
+
+// moduleFunction records a call of a module function
+func (r *referenceCollector) moduleFunction(module, function string) {
+	r.modules[module] = true
+	r.functions[module+"."+function] = true
+}
+
+// sortedNames returns the names of a set in order
+func sortedNames(set map[string]bool) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -2,6 +2,7 @@ package debug
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/mredencom/expr/lexer"
@@ -21,6 +22,12 @@ type Debugger struct {
 	currentStack     []types.Value
 	instructionCount int64
 
+	// Source of the program being debugged and its instruction positions
+	source    string
+	positions vm.SourceMap
+	pcCounts  map[int]int64
+	pcOps     map[int]vm.Opcode
+
 	// Event callbacks
 	onBreakpoint func(*DebugContext)
 	onStep       func(*DebugContext)
@@ -93,6 +100,14 @@ func (d *Debugger) SetStepMode(enabled bool) {
 	d.stepMode = enabled
 }
 
+// SetSourceMap sets the source of the program being debugged and the
+// positions of its instructions, so that breakpoints, debug contexts and hot
+// spots refer to source lines and columns
+func (d *Debugger) SetSourceMap(source string, positions vm.SourceMap) {
+	d.source = source
+	d.positions = positions
+}
+
 // SetBreakpoint sets a breakpoint at the specified program counter
 func (d *Debugger) SetBreakpoint(pc int) *Breakpoint {
 	bp := &Breakpoint{
@@ -100,10 +115,27 @@ func (d *Debugger) SetBreakpoint(pc int) *Breakpoint {
 		Enabled:  true,
 		HitCount: 0,
 	}
+	if pos, ok := d.positions.Lookup(pc); ok {
+		bp.Position = pos
+		bp.Source = sourceLine(d.source, pos)
+	}
 	d.breakpoints[pc] = bp
 	return bp
 }
 
+// SetBreakpointAt sets a breakpoint at the first instruction compiled from
+// the given source line and column. A column of 0 matches the whole line.
+func (d *Debugger) SetBreakpointAt(line, column int) (*Breakpoint, error) {
+	offsets := d.positions.Offsets(line, column)
+	if len(offsets) == 0 {
+		if column == 0 {
+			return nil, fmt.Errorf("no instruction at line %d", line)
+		}
+		return nil, fmt.Errorf("no instruction at line %d, column %d", line, column)
+	}
+	return d.SetBreakpoint(offsets[0]), nil
+}
+
 // RemoveBreakpoint removes a breakpoint at the specified program counter
 func (d *Debugger) RemoveBreakpoint(pc int) bool {
 	_, exists := d.breakpoints[pc]
@@ -174,6 +206,15 @@ func (d *Debugger) OnInstruction(pc int, instruction []byte, stack []types.Value
 		opcode := vm.Opcode(instruction[0])
 		d.stats.InstructionCounts[opcode]++
 		d.stats.TotalInstructions++
+
+		if d.positions != nil {
+			if d.pcCounts == nil {
+				d.pcCounts = make(map[int]int64)
+				d.pcOps = make(map[int]vm.Opcode)
+			}
+			d.pcCounts[pc]++
+			d.pcOps[pc] = opcode
+		}
 	}
 
 	// Create debug context
@@ -182,7 +223,9 @@ func (d *Debugger) OnInstruction(pc int, instruction []byte, stack []types.Value
 		Instruction: instruction,
 		Stack:       stack,
 		Variables:   make(map[string]types.Value), // TODO: populate from VM
+		Source:      d.source,
 	}
+	ctx.Position, _ = d.positions.Lookup(pc)
 
 	// Check if we should break (but don't call ShouldBreak as it modifies state)
 	shouldBreak := false
@@ -218,14 +261,30 @@ func (d *Debugger) ResetStats() {
 		StartTime:         time.Now(),
 	}
 	d.instructionCount = 0
+	d.pcCounts = nil
+	d.pcOps = nil
 }
 
-// updateHotSpots calculates the most frequently executed instructions
+// updateHotSpots calculates the most frequently executed instructions. With a
+// source map each hot spot is an instruction located in the source, otherwise
+// hot spots are counted per opcode.
 func (d *Debugger) updateHotSpots() {
 	d.stats.HotSpots = nil
 
+	for pc, count := range d.pcCounts {
+		pos, _ := d.positions.Lookup(pc)
+		d.stats.HotSpots = append(d.stats.HotSpots, HotSpot{
+			PC:         pc,
+			OpCode:     d.pcOps[pc],
+			Count:      count,
+			Percentage: float64(count) / float64(d.stats.TotalInstructions) * 100,
+			Source:     sourceLine(d.source, pos),
+			Position:   pos,
+		})
+	}
+
 	for opcode, count := range d.stats.InstructionCounts {
-		if count > 0 {
+		if count > 0 && d.pcCounts == nil {
 			percentage := float64(count) / float64(d.stats.TotalInstructions) * 100
 			hotspot := HotSpot{
 				OpCode:     opcode,
@@ -267,6 +326,11 @@ func (d *Debugger) FormatStats() string {
 			if i >= 5 { // Show top 5
 				break
 			}
+			if hotspot.Position.Valid() {
+				result += fmt.Sprintf("  %d. %s at %s: %d times (%.1f%%)\n",
+					i+1, hotspot.OpCode.String(), hotspot.Position, hotspot.Count, hotspot.Percentage)
+				continue
+			}
 			result += fmt.Sprintf("  %d. %s: %d times (%.1f%%)\n",
 				i+1, hotspot.OpCode.String(), hotspot.Count, hotspot.Percentage)
 		}
@@ -295,6 +359,9 @@ func (d *Debugger) FormatInstructionCounts() string {
 func (d *Debugger) Trace() string {
 	result := fmt.Sprintf("Debug Trace:\n")
 	result += fmt.Sprintf("  PC: %d\n", d.currentPC)
+	if pos, ok := d.positions.Lookup(d.currentPC); ok {
+		result += fmt.Sprintf("  Position: %s\n", pos)
+	}
 	result += fmt.Sprintf("  Instructions Executed: %d\n", d.instructionCount)
 	result += fmt.Sprintf("  Stack Size: %d\n", len(d.currentStack))
 
@@ -306,3 +373,16 @@ func (d *Debugger) Trace() string {
 
 	return result
 }
+
+// sourceLine returns the source line containing pos
+func sourceLine(source string, pos lexer.Position) string {
+	if !pos.Valid() || pos.Offset > len(source) {
+		return ""
+	}
+	start := strings.LastIndexByte(source[:pos.Offset], '\n') + 1
+	end := strings.IndexByte(source[pos.Offset:], '\n')
+	if end < 0 {
+		return source[start:]
+	}
+	return source[start : pos.Offset+end]
+}
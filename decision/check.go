@@ -0,0 +1,334 @@
+package decision
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// IssueKind is the kind of a problem found in the rules of a table
+type IssueKind int
+
+const (
+	Overlap IssueKind = iota // Rules of a Unique table match the same inputs
+	Gap                      // Inputs are matched by no rule
+)
+
+// String returns the name of the kind
+func (k IssueKind) String() string {
+	switch k {
+	case Overlap:
+		return "overlap"
+	case Gap:
+		return "gap"
+	}
+	return fmt.Sprintf("IssueKind(%d)", int(k))
+}
+
+// Issue is a problem found in the rules of a table
+type Issue struct {
+	Kind    IssueKind
+	Rules   []int  // Numbers of the overlapping rules, empty for gaps
+	Message string // Describes the inputs concerned
+}
+
+// String returns the message of the issue
+func (i Issue) String() string {
+	return i.Message
+}
+
+const (
+	maxGaps   = 10     // Gaps reported at most
+	maxChecks = 100000 // Input values tried at most when looking for gaps
+)
+
+// point is an input value standing for all the values the tests of its
+// column treat alike. Label describes those values after the input name;
+// numeric points describe the ends of the ranges they start and end.
+type point struct {
+	value     interface{}
+	label     string
+	low, high string // Empty for the unbounded ends
+	numeric   bool   // Merged with the numeric points next to it in gap reports
+}
+
+// constantPoint is a point for a value a test compares with
+func constantPoint(value interface{}) point {
+	v := formatValue(value)
+	return point{value: value, label: "= " + v, low: ">= " + v, high: "<= " + v}
+}
+
+// checker looks for overlapping and missing rules. Only tests whose
+// operands are constants are analyzed: a test using variables may match any
+// value, so rules with such tests never overlap and may fill any gap.
+type checker struct {
+	table  *Table
+	points [][]point // Values tried for each input
+	gaps   []Issue
+	seen   map[string]bool
+	checks int
+}
+
+// check returns the issues of a table. Domains are the values listed for
+// the inputs, nil for inputs without values.
+func check(t *Table, domains [][]interface{}) []Issue {
+	c := &checker{table: t, points: make([][]point, len(t.inputs)), seen: make(map[string]bool)}
+	for i := range t.inputs {
+		c.points[i] = c.columnPoints(i, domains[i])
+	}
+
+	var issues []Issue
+	if t.policy == Unique {
+		issues = c.overlaps()
+	}
+	if len(t.rules) == 0 {
+		return append(issues, Issue{Kind: Gap, Message: "the table has no rules"})
+	}
+	c.search(0, t.rules, nil)
+	return append(issues, c.gaps...)
+}
+
+// columnPoints returns the values to try for an input: its listed values,
+// or the constants its tests compare with and values between and around
+// them, numbers in increasing order
+func (c *checker) columnPoints(column int, domain []interface{}) []point {
+	if domain != nil {
+		points := make([]point, len(domain))
+		for i, value := range domain {
+			points[i] = constantPoint(value)
+		}
+		return points
+	}
+
+	var numbers []float64
+	var strs []string
+	integers, bools := true, false
+	for _, r := range c.table.rules {
+		for _, value := range r.tests[column].values {
+			switch v := value.(type) {
+			case int64:
+				numbers = append(numbers, float64(v))
+			case float64:
+				numbers = append(numbers, v)
+				integers = false
+			case string:
+				strs = append(strs, v)
+			case bool:
+				bools = true
+			}
+		}
+	}
+
+	var points []point
+	add := func(p point) {
+		for _, q := range points {
+			if equal(p.value, q.value) {
+				return
+			}
+		}
+		points = append(points, p)
+	}
+
+	sort.Float64s(numbers)
+	for i, n := range numbers {
+		if integers {
+			below, at, above := constantPoint(int64(n)-1), constantPoint(int64(n)), constantPoint(int64(n)+1)
+			if i == 0 {
+				below.label, below.low = "< "+formatValue(int64(n)), ""
+			}
+			if i == len(numbers)-1 {
+				above.label, above.high = "> "+formatValue(int64(n)), ""
+			}
+			add(below)
+			add(at)
+			add(above)
+		} else {
+			if i == 0 {
+				v := formatValue(n)
+				add(point{value: n - 1, label: "< " + v, high: "< " + v})
+			}
+			add(constantPoint(n))
+			if i == len(numbers)-1 {
+				v := formatValue(n)
+				add(point{value: n + 1, label: "> " + v, low: "> " + v})
+			} else if next := numbers[i+1]; next != n {
+				low, high := "> "+formatValue(n), "< "+formatValue(next)
+				add(point{value: (n + next) / 2, label: low + " and " + high, low: low, high: high})
+			}
+		}
+	}
+	for i := range points {
+		points[i].numeric = true
+	}
+
+	// Strings around the constants stand for the strings no test mentions
+	for _, s := range strs {
+		add(constantPoint(s))
+	}
+	if len(strs) > 0 {
+		add(constantPoint(""))
+		for _, s := range strs {
+			add(point{value: s + "\x00", label: "is another value"})
+		}
+	}
+
+	if bools {
+		add(constantPoint(true))
+		add(constantPoint(false))
+	}
+	if len(points) == 0 {
+		points = append(points, point{label: "is any value"})
+	}
+	return points
+}
+
+// matches reports whether a rule may match a value of an input
+func (c *checker) matches(r *rule, column int, p point) bool {
+	t := r.tests[column]
+	if t.op != "" && !t.constant {
+		return true
+	}
+	return t.holds(p.value)
+}
+
+// analyzed reports whether every test of a rule has constant operands
+func analyzed(r *rule) bool {
+	for _, t := range r.tests {
+		if t.op != "" && !t.constant {
+			return false
+		}
+	}
+	return true
+}
+
+// overlaps returns the pairs of rules matching the same inputs
+func (c *checker) overlaps() []Issue {
+	var issues []Issue
+	for i, a := range c.table.rules {
+		if !analyzed(a) {
+			continue
+		}
+		for _, b := range c.table.rules[i+1:] {
+			if !analyzed(b) {
+				continue
+			}
+			if inputs, ok := c.common(a, b); ok {
+				issues = append(issues, Issue{
+					Kind:    Overlap,
+					Rules:   []int{a.number, b.number},
+					Message: fmt.Sprintf("rules %d and %d both match %s", a.number, b.number, inputs),
+				})
+			}
+		}
+	}
+	return issues
+}
+
+// common returns inputs matched by both rules
+func (c *checker) common(a, b *rule) (string, bool) {
+	var inputs []string
+	for column, points := range c.points {
+		if a.tests[column].op == "" && b.tests[column].op == "" {
+			continue
+		}
+		found := false
+		for _, p := range points {
+			if a.tests[column].holds(p.value) && b.tests[column].holds(p.value) {
+				inputs = append(inputs, c.table.inputs[column].Name+" "+p.label)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", false
+		}
+	}
+	if len(inputs) == 0 {
+		return "any input", true
+	}
+	return strings.Join(inputs, ", "), true
+}
+
+// search tries the values of the inputs from column on, recording a gap
+// when no candidate rule matches the values tried so far. Numbers next to
+// each other matched by the same rules are tried together as a range.
+func (c *checker) search(column int, candidates []*rule, assigned []string) {
+	if column == len(c.points) {
+		return
+	}
+	points := c.points[column]
+	matching := make([][]*rule, len(points))
+	for i, p := range points {
+		for _, r := range candidates {
+			if c.matches(r, column, p) {
+				matching[i] = append(matching[i], r)
+			}
+		}
+	}
+
+	for i := 0; i < len(points); {
+		if len(c.gaps) >= maxGaps || c.checks >= maxChecks {
+			return
+		}
+		c.checks++
+
+		end := i
+		for points[i].numeric && end+1 < len(points) && points[end+1].numeric && sameRules(matching[i], matching[end+1]) {
+			end++
+		}
+		inputs := append(assigned[:len(assigned):len(assigned)], c.table.inputs[column].Name+" "+rangeLabel(points[i:end+1]))
+		if len(matching[i]) == 0 {
+			c.addGap(inputs)
+		} else {
+			c.search(column+1, matching[i], inputs)
+		}
+		i = end + 1
+	}
+}
+
+// rangeLabel describes the values of consecutive points
+func rangeLabel(points []point) string {
+	if len(points) == 1 {
+		return points[0].label
+	}
+	var bounds []string
+	for _, bound := range []string{points[0].low, points[len(points)-1].high} {
+		if bound != "" {
+			bounds = append(bounds, bound)
+		}
+	}
+	if len(bounds) == 0 {
+		return "is any number"
+	}
+	return strings.Join(bounds, " and ")
+}
+
+// sameRules reports whether two lists of candidate rules are the same
+func sameRules(a, b []*rule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// addGap records inputs matched by no rule
+func (c *checker) addGap(inputs []string) {
+	message := "no rule matches " + strings.Join(inputs, ", ")
+	if !c.seen[message] && len(c.gaps) < maxGaps {
+		c.seen[message] = true
+		c.gaps = append(c.gaps, Issue{Kind: Gap, Message: message})
+	}
+}
+
+// formatValue formats a value the way it is written in expressions
+func formatValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,98 @@
+package decision
+
+import (
+	"reflect"
+	"testing"
+)
+
+func issueMessages(t *testing.T, definition Definition) []string {
+	t.Helper()
+	table, err := New(definition, orderEnv)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	var messages []string
+	for _, issue := range table.Issues() {
+		messages = append(messages, issue.Kind.String()+": "+issue.String())
+	}
+	return messages
+}
+
+func TestIssues(t *testing.T) {
+	ages := Definition{
+		HitPolicy: Unique,
+		Inputs: []Column{
+			{Name: "age", Expression: "order.amount"},
+			{Name: "country", Expression: "order.country", Values: `"NO", "SE", "DK"`},
+		},
+		Outputs: []Column{{Name: "group"}},
+		Rules: []Rule{
+			{Inputs: []string{"< 18", "-"}, Outputs: []string{`"minor"`}},
+			{Inputs: []string{">= 18", `in ["NO", "SE"]`}, Outputs: []string{`"adult"`}},
+			{Inputs: []string{"> 65", "-"}, Outputs: []string{`"senior"`}},
+		},
+	}
+	expected := []string{
+		`overlap: rules 2 and 3 both match age > 65, country = "NO"`,
+		`gap: no rule matches age >= 18 and <= 65, country = "DK"`,
+	}
+	if messages := issueMessages(t, ages); !reflect.DeepEqual(messages, expected) {
+		t.Errorf("Expected %q, got %q", expected, messages)
+	}
+
+	// Overlaps are expected with the other hit policies
+	ages.HitPolicy = First
+	if messages := issueMessages(t, ages); !reflect.DeepEqual(messages, expected[1:]) {
+		t.Errorf("Expected %q, got %q", expected[1:], messages)
+	}
+
+	prices := Definition{
+		HitPolicy: Unique,
+		Inputs:    []Column{{Name: "weight", Expression: "order.amount"}, {Name: "vip", Expression: "order.vip"}},
+		Outputs:   []Column{{Name: "price"}},
+		Rules: []Rule{
+			{Inputs: []string{"< 1.5", "-"}, Outputs: []string{"10"}},
+			{Inputs: []string{"> 2.5", "false"}, Outputs: []string{"20"}},
+			{Inputs: []string{"> 2.5", "true"}, Outputs: []string{"15"}},
+		},
+	}
+	expected = []string{`gap: no rule matches weight >= 1.5 and <= 2.5`}
+	if messages := issueMessages(t, prices); !reflect.DeepEqual(messages, expected) {
+		t.Errorf("Expected %q, got %q", expected, messages)
+	}
+
+	countries := Definition{
+		HitPolicy: Unique,
+		Inputs:    []Column{{Name: "country", Expression: "order.country"}},
+		Outputs:   []Column{{Name: "zone"}},
+		Rules: []Rule{
+			{Inputs: []string{`in ["NO", "SE"]`}, Outputs: []string{`"nordic"`}},
+			{Inputs: []string{`"SE"`}, Outputs: []string{`"sweden"`}},
+		},
+	}
+	expected = []string{
+		`overlap: rules 1 and 2 both match country = "SE"`,
+		`gap: no rule matches country = ""`,
+		`gap: no rule matches country is another value`,
+	}
+	if messages := issueMessages(t, countries); !reflect.DeepEqual(messages, expected) {
+		t.Errorf("Expected %q, got %q", expected, messages)
+	}
+
+	// Tests comparing with variables are not analyzed
+	limits := Definition{
+		Inputs:  []Column{{Name: "amount", Expression: "order.amount"}},
+		Outputs: []Column{{Name: "large"}},
+		Rules: []Rule{
+			{Inputs: []string{"> limit"}, Outputs: []string{"true"}},
+			{Inputs: []string{"<= 1000"}, Outputs: []string{"false"}},
+		},
+	}
+	if messages := issueMessages(t, limits); messages != nil {
+		t.Errorf("Expected no issues, got %q", messages)
+	}
+
+	if messages := issueMessages(t, Definition{Outputs: []Column{{Name: "x"}}}); !reflect.DeepEqual(messages, []string{"gap: the table has no rules"}) {
+		t.Errorf("Expected a table without rules to be reported, got %q", messages)
+	}
+}
@@ -0,0 +1,436 @@
+// Package decision evaluates decision tables. Each rule of a table is a row
+// holding a unary test per input column, such as "> 100" or `in ["A", "B"]`,
+// and an expression per output column. The hit policy of the table decides
+// which of the matching rules give the result.
+package decision
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mredencom/expr"
+)
+
+// HitPolicy decides which matching rules give the result of a table
+type HitPolicy string
+
+const (
+	Unique       HitPolicy = "UNIQUE"        // At most one rule may match
+	First        HitPolicy = "FIRST"         // The first matching rule in table order
+	Priority     HitPolicy = "PRIORITY"      // The matching rule whose outputs come first in the output values
+	Collect      HitPolicy = "COLLECT"       // All matching rules, in table order
+	CollectSum   HitPolicy = "COLLECT SUM"   // The sum of the output of the matching rules
+	CollectMin   HitPolicy = "COLLECT MIN"   // The smallest output of the matching rules
+	CollectMax   HitPolicy = "COLLECT MAX"   // The largest output of the matching rules
+	CollectCount HitPolicy = "COLLECT COUNT" // The number of matching rules
+)
+
+// abbreviations are the single cell notations of the hit policies
+var abbreviations = map[string]HitPolicy{
+	"U":  Unique,
+	"F":  First,
+	"P":  Priority,
+	"C":  Collect,
+	"C+": CollectSum,
+	"C<": CollectMin,
+	"C>": CollectMax,
+	"C#": CollectCount,
+}
+
+// ParseHitPolicy parses a hit policy by name, such as "collect sum", or by
+// its abbreviation, such as "C+". An empty policy is Unique.
+func ParseHitPolicy(s string) (HitPolicy, error) {
+	s = strings.ToUpper(strings.Join(strings.Fields(s), " "))
+	if s == "" {
+		return Unique, nil
+	}
+	if policy, ok := abbreviations[s]; ok {
+		return policy, nil
+	}
+	switch policy := HitPolicy(s); policy {
+	case Unique, First, Priority, Collect, CollectSum, CollectMin, CollectMax, CollectCount:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown hit policy %q", s)
+}
+
+// aggregates reports whether the policy reduces the outputs to one value
+func (h HitPolicy) aggregates() bool {
+	switch h {
+	case CollectSum, CollectMin, CollectMax, CollectCount:
+		return true
+	}
+	return false
+}
+
+// Column describes an input or an output of a table. Values optionally
+// lists the possible values of the column as comma separated expressions:
+// input values bound the combinations checked for missing rules and output
+// values rank the outputs for the Priority hit policy, first is highest.
+type Column struct {
+	Name       string `json:"name,omitempty"`       // Defaults to the expression for inputs
+	Expression string `json:"expression,omitempty"` // Value tested by the input column
+	Values     string `json:"values,omitempty"`
+}
+
+// Rule is a row of a table: a unary test per input and an expression per
+// output. A test of "-" or an empty test matches any value; an empty output
+// is nil.
+type Rule struct {
+	Description string   `json:"description,omitempty"`
+	Inputs      []string `json:"inputs"`
+	Outputs     []string `json:"outputs"`
+}
+
+// Definition is the source of a decision table
+type Definition struct {
+	Name      string    `json:"name,omitempty"`
+	HitPolicy HitPolicy `json:"hitPolicy,omitempty"`
+	Inputs    []Column  `json:"inputs"`
+	Outputs   []Column  `json:"outputs"`
+	Rules     []Rule    `json:"rules"`
+}
+
+// Result is the result of evaluating a table
+type Result struct {
+	Rules   []int                    // Numbers of the rules giving the result, from 1
+	Outputs []map[string]interface{} // Outputs of those rules by output name
+
+	// Value is the result of the table. For the Unique, First and Priority
+	// policies it is the output of the rule, or a map of its outputs when
+	// the table has several, and nil without a match. For Collect it is the
+	// list of those values and for the aggregations the aggregated number.
+	Value interface{}
+}
+
+// Table is a compiled decision table. It is safe for concurrent use.
+type Table struct {
+	name       string
+	policy     HitPolicy
+	inputs     []Column
+	outputs    []Column
+	priorities [][]interface{} // Output values by output, nil without values
+	rules      []*rule
+	issues     []Issue
+}
+
+// rule is a compiled row of a table
+type rule struct {
+	number    int
+	tests     []test
+	condition *expr.Program   // nil when every test matches any value
+	outputs   []*expr.Program // nil for empty outputs
+}
+
+// New compiles a decision table. The options apply to every expression of
+// the table, typically expr.Env to declare the variables of the
+// environments it is evaluated against. Overlapping and missing rules do
+// not fail compilation; they are reported by Issues.
+func New(definition Definition, options ...expr.Option) (*Table, error) {
+	policy, err := ParseHitPolicy(string(definition.HitPolicy))
+	if err != nil {
+		return nil, err
+	}
+	if len(definition.Outputs) == 0 {
+		return nil, fmt.Errorf("decision table has no outputs")
+	}
+	if policy.aggregates() && len(definition.Outputs) != 1 {
+		return nil, fmt.Errorf("the %s hit policy needs a single output, the table has %d", policy, len(definition.Outputs))
+	}
+
+	t := &Table{
+		name:       definition.Name,
+		policy:     policy,
+		inputs:     make([]Column, len(definition.Inputs)),
+		outputs:    definition.Outputs,
+		priorities: make([][]interface{}, len(definition.Outputs)),
+	}
+	domains := make([][]interface{}, len(definition.Inputs))
+	for i, input := range definition.Inputs {
+		if strings.TrimSpace(input.Expression) == "" {
+			return nil, fmt.Errorf("input %d has no expression", i+1)
+		}
+		if input.Name == "" {
+			input.Name = input.Expression
+		}
+		t.inputs[i] = input
+		if domains[i], err = columnValues(input, options); err != nil {
+			return nil, err
+		}
+	}
+
+	names := make(map[string]bool, len(definition.Outputs))
+	hasPriorities := false
+	for i, output := range definition.Outputs {
+		if output.Name == "" {
+			return nil, fmt.Errorf("output %d has no name", i+1)
+		}
+		if names[output.Name] {
+			return nil, fmt.Errorf("duplicate output %s", output.Name)
+		}
+		names[output.Name] = true
+		if t.priorities[i], err = columnValues(output, options); err != nil {
+			return nil, err
+		}
+		hasPriorities = hasPriorities || t.priorities[i] != nil
+	}
+	if policy == Priority && !hasPriorities {
+		return nil, fmt.Errorf("the %s hit policy needs output values to rank the outputs", policy)
+	}
+
+	for i, definition := range definition.Rules {
+		r, err := t.compileRule(i+1, definition, options)
+		if err != nil {
+			return nil, err
+		}
+		t.rules = append(t.rules, r)
+	}
+
+	t.issues = check(t, domains)
+	return t, nil
+}
+
+// compileRule compiles the tests and outputs of a row
+func (t *Table) compileRule(number int, definition Rule, options []expr.Option) (*rule, error) {
+	if len(definition.Inputs) != len(t.inputs) {
+		return nil, fmt.Errorf("rule %d has %d input entries, the table has %d inputs", number, len(definition.Inputs), len(t.inputs))
+	}
+	if len(definition.Outputs) != len(t.outputs) {
+		return nil, fmt.Errorf("rule %d has %d output entries, the table has %d outputs", number, len(definition.Outputs), len(t.outputs))
+	}
+
+	r := &rule{number: number, tests: make([]test, len(t.inputs)), outputs: make([]*expr.Program, len(t.outputs))}
+	var conditions []string
+	for i, entry := range definition.Inputs {
+		tst, err := parseTest(entry)
+		if err == nil {
+			err = tst.compile(t.inputs[i].Expression, options)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("rule %d, input %s: %w", number, t.inputs[i].Name, err)
+		}
+		r.tests[i] = tst
+		if tst.op != "" {
+			conditions = append(conditions, "("+tst.expression(t.inputs[i].Expression)+")")
+		}
+	}
+	if len(conditions) > 0 {
+		condition, err := expr.CompileAs[bool](strings.Join(conditions, " && "), options...)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", number, err)
+		}
+		r.condition = condition
+	}
+
+	for i, entry := range definition.Outputs {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		output, err := expr.Compile(entry, options...)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d, output %s: %w", number, t.outputs[i].Name, err)
+		}
+		r.outputs[i] = output
+	}
+	return r, nil
+}
+
+// Name returns the name of the table
+func (t *Table) Name() string {
+	return t.name
+}
+
+// HitPolicy returns the hit policy of the table
+func (t *Table) HitPolicy() HitPolicy {
+	return t.policy
+}
+
+// Issues returns the overlapping and missing rules found when the table was
+// compiled
+func (t *Table) Issues() []Issue {
+	return append([]Issue(nil), t.issues...)
+}
+
+// Evaluate evaluates the table against an environment, a map or a struct
+// as accepted by expr.Run. The environment is converted once for all the
+// rules.
+func (t *Table) Evaluate(environment interface{}) (*Result, error) {
+	session := expr.NewSession(environment)
+	defer session.Close()
+
+	var matched []*rule
+	for _, r := range t.rules {
+		if r.condition != nil {
+			value, err := session.Run(r.condition)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %w", r.number, err)
+			}
+			if holds, ok := value.(bool); !ok || !holds {
+				continue
+			}
+		}
+		matched = append(matched, r)
+		if t.policy == First {
+			break
+		}
+	}
+	if t.policy == Unique && len(matched) > 1 {
+		return nil, fmt.Errorf("rules %d and %d both match, the %s hit policy allows one", matched[0].number, matched[1].number, t.policy)
+	}
+
+	result := &Result{}
+	for _, r := range matched {
+		outputs := make(map[string]interface{}, len(t.outputs))
+		for i, output := range r.outputs {
+			var value interface{}
+			if output != nil {
+				var err error
+				if value, err = session.Run(output); err != nil {
+					return nil, fmt.Errorf("rule %d, output %s: %w", r.number, t.outputs[i].Name, err)
+				}
+			}
+			outputs[t.outputs[i].Name] = value
+		}
+		result.Rules = append(result.Rules, r.number)
+		result.Outputs = append(result.Outputs, outputs)
+	}
+
+	if t.policy == Priority && len(result.Rules) > 1 {
+		best := 0
+		for i := 1; i < len(result.Outputs); i++ {
+			if t.ranksBefore(result.Outputs[i], result.Outputs[best]) {
+				best = i
+			}
+		}
+		result.Rules = result.Rules[best : best+1]
+		result.Outputs = result.Outputs[best : best+1]
+	}
+
+	var err error
+	result.Value, err = t.value(result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ranksBefore reports whether outputs a come before outputs b in the output
+// values. Outputs are compared in column order; values not listed rank last.
+func (t *Table) ranksBefore(a, b map[string]interface{}) bool {
+	for i, values := range t.priorities {
+		if values == nil {
+			continue
+		}
+		name := t.outputs[i].Name
+		if ra, rb := rank(values, a[name]), rank(values, b[name]); ra != rb {
+			return ra < rb
+		}
+	}
+	return false
+}
+
+// rank returns the position of a value in the output values
+func rank(values []interface{}, value interface{}) int {
+	for i, v := range values {
+		if equal(v, value) {
+			return i
+		}
+	}
+	return len(values)
+}
+
+// value computes the value of the table from the outputs of the rules
+func (t *Table) value(result *Result) (interface{}, error) {
+	single := func(outputs map[string]interface{}) interface{} {
+		if len(t.outputs) == 1 {
+			return outputs[t.outputs[0].Name]
+		}
+		return outputs
+	}
+
+	switch t.policy {
+	case Collect:
+		values := make([]interface{}, len(result.Outputs))
+		for i, outputs := range result.Outputs {
+			values[i] = single(outputs)
+		}
+		return values, nil
+	case CollectCount:
+		return int64(len(result.Rules)), nil
+	case CollectSum, CollectMin, CollectMax:
+		return t.aggregate(result)
+	}
+	if len(result.Outputs) == 0 {
+		return nil, nil
+	}
+	return single(result.Outputs[0]), nil
+}
+
+// aggregate reduces the numeric outputs of the rules. Nil outputs are
+// skipped; the result is nil when there is no output left.
+func (t *Table) aggregate(result *Result) (interface{}, error) {
+	name := t.outputs[0].Name
+	var aggregated interface{}
+	for i, outputs := range result.Outputs {
+		value := outputs[name]
+		if value == nil {
+			continue
+		}
+		if _, ok := number(value); !ok {
+			return nil, fmt.Errorf("rule %d, output %s: cannot aggregate %T, not a number", result.Rules[i], name, value)
+		}
+		if aggregated == nil {
+			aggregated = value
+			continue
+		}
+
+		a, _ := number(aggregated)
+		v, _ := number(value)
+		switch t.policy {
+		case CollectSum:
+			ai, aInt := aggregated.(int64)
+			vi, vInt := value.(int64)
+			if aInt && vInt {
+				aggregated = ai + vi
+			} else {
+				aggregated = a + v
+			}
+		case CollectMin:
+			if v < a {
+				aggregated = value
+			}
+		case CollectMax:
+			if v > a {
+				aggregated = value
+			}
+		}
+	}
+	return aggregated, nil
+}
+
+// number returns a numeric value as a float64
+func number(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// equal compares two values, numbers by value whatever their type
+func equal(a, b interface{}) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+	switch a.(type) {
+	case string, bool, nil:
+		return a == b
+	}
+	return false
+}
@@ -0,0 +1,236 @@
+package decision
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mredencom/expr"
+)
+
+var orderEnv = expr.Env(map[string]interface{}{
+	"order": map[string]interface{}{"amount": 0, "country": "", "vip": false},
+	"limit": 0,
+})
+
+func order(amount int, country string, vip bool) map[string]interface{} {
+	return map[string]interface{}{
+		"order": map[string]interface{}{"amount": amount, "country": country, "vip": vip},
+		"limit": 500,
+	}
+}
+
+func discountTable(policy HitPolicy, outputs ...Column) Definition {
+	if len(outputs) == 0 {
+		outputs = []Column{{Name: "discount"}}
+	}
+	return Definition{
+		HitPolicy: policy,
+		Inputs:    []Column{{Name: "amount", Expression: "order.amount"}, {Expression: "order.country"}},
+		Outputs:   outputs,
+		Rules: []Rule{
+			{Inputs: []string{">= 1000", "-"}, Outputs: []string{"20"}},
+			{Inputs: []string{"> limit", `in ["NO", "SE"]`}, Outputs: []string{"10"}},
+			{Inputs: []string{"-", `"DK"`}, Outputs: []string{"5"}},
+			{Inputs: []string{"-", `not in ["NO", "SE", "DK"]`}, Outputs: []string{"0"}},
+		},
+	}
+}
+
+func evaluate(t *testing.T, definition Definition, env map[string]interface{}) *Result {
+	t.Helper()
+	table, err := New(definition, orderEnv)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	result, err := table.Evaluate(env)
+	if err != nil {
+		t.Fatalf("Evaluate error: %v", err)
+	}
+	return result
+}
+
+func TestHitPolicies(t *testing.T) {
+	tests := []struct {
+		policy HitPolicy
+		env    map[string]interface{}
+		rules  []int
+		value  interface{}
+	}{
+		{First, order(2000, "NO", false), []int{1}, int64(20)},
+		{First, order(600, "SE", false), []int{2}, int64(10)},
+		{First, order(100, "SE", false), nil, nil},
+		{Collect, order(2000, "NO", false), []int{1, 2}, []interface{}{int64(20), int64(10)}},
+		{Collect, order(100, "SE", false), nil, []interface{}{}},
+		{CollectSum, order(2000, "NO", false), []int{1, 2}, int64(30)},
+		{CollectMin, order(2000, "NO", false), []int{1, 2}, int64(10)},
+		{CollectMax, order(2000, "DK", false), []int{1, 3}, int64(20)},
+		{CollectCount, order(2000, "DK", false), []int{1, 3}, int64(2)},
+		{CollectSum, order(100, "SE", false), nil, nil},
+		{Unique, order(600, "NO", false), []int{2}, int64(10)},
+	}
+	for _, tt := range tests {
+		result := evaluate(t, discountTable(tt.policy), tt.env)
+		if !reflect.DeepEqual(result.Rules, tt.rules) || !reflect.DeepEqual(result.Value, tt.value) {
+			t.Errorf("%s %v: expected rules %v and %#v, got %v and %#v", tt.policy, tt.env["order"], tt.rules, tt.value, result.Rules, result.Value)
+		}
+	}
+
+	table, err := New(discountTable(Unique), orderEnv)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	if _, err := table.Evaluate(order(2000, "NO", false)); err == nil || !strings.Contains(err.Error(), "rules 1 and 2 both match") {
+		t.Errorf("Expected a unique hit policy violation, got %v", err)
+	}
+}
+
+func TestFloatInputs(t *testing.T) {
+	definition := Definition{
+		HitPolicy: Unique,
+		Inputs:    []Column{{Name: "amount", Expression: "order.amount"}},
+		Outputs:   []Column{{Name: "tier"}},
+		Rules: []Rule{
+			{Inputs: []string{"> 100"}, Outputs: []string{`"high"`}},
+			{Inputs: []string{"in [50, 100]"}, Outputs: []string{`"edge"`}},
+			{Inputs: []string{"< 50"}, Outputs: []string{`"low"`}},
+		},
+	}
+	env := expr.Env(map[string]interface{}{"order": map[string]interface{}{"amount": 0.0}})
+	table, err := New(definition, env)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+
+	tests := []struct {
+		amount float64
+		tier   interface{}
+	}{
+		{150.5, "high"},
+		{100.0, "edge"},
+		{50.0, "edge"},
+		{49.5, "low"},
+		{75.25, nil},
+	}
+	for _, tt := range tests {
+		result, err := table.Evaluate(map[string]interface{}{"order": map[string]interface{}{"amount": tt.amount}})
+		if err != nil {
+			t.Errorf("%v: Evaluate error: %v", tt.amount, err)
+			continue
+		}
+		if result.Value != tt.tier {
+			t.Errorf("%v: expected %v, got %v", tt.amount, tt.tier, result.Value)
+		}
+	}
+
+	// Checking agrees with evaluation: the amounts between 50 and 100 match
+	// no rule
+	issues := table.Issues()
+	if len(issues) != 1 || issues[0].Kind != Gap {
+		t.Errorf("Expected one gap, got %v", issues)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	definition := Definition{
+		HitPolicy: "P",
+		Inputs:    []Column{{Expression: "order.amount"}, {Expression: "order.vip"}},
+		Outputs:   []Column{{Name: "tier", Values: `"gold", "silver", "bronze"`}, {Name: "note"}},
+		Rules: []Rule{
+			{Inputs: []string{"-", "-"}, Outputs: []string{`"bronze"`, `"default"`}},
+			{Inputs: []string{">= 1000", "-"}, Outputs: []string{`"silver"`, `"amount " + string(order.amount)`}},
+			{Inputs: []string{"-", "true"}, Outputs: []string{`"gold"`, ""}},
+		},
+	}
+
+	result := evaluate(t, definition, order(2000, "NO", false))
+	expected := map[string]interface{}{"tier": "silver", "note": "amount 2000"}
+	if !reflect.DeepEqual(result.Rules, []int{2}) || !reflect.DeepEqual(result.Value, expected) {
+		t.Errorf("Expected rule 2 with %v, got %v with %v", expected, result.Rules, result.Value)
+	}
+
+	result = evaluate(t, definition, order(2000, "NO", true))
+	expected = map[string]interface{}{"tier": "gold", "note": nil}
+	if !reflect.DeepEqual(result.Rules, []int{3}) || !reflect.DeepEqual(result.Value, expected) {
+		t.Errorf("Expected rule 3 with %v, got %v with %v", expected, result.Rules, result.Value)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	invalid := []struct {
+		definition Definition
+		expected   string
+	}{
+		{Definition{HitPolicy: "ANY"}, `unknown hit policy "ANY"`},
+		{Definition{Inputs: []Column{{Expression: "order.amount"}}}, "no outputs"},
+		{discountTable(CollectSum, Column{Name: "a"}, Column{Name: "b"}), "needs a single output"},
+		{discountTable(Priority), "needs output values"},
+		{discountTable(First, Column{Name: "discount", Values: "1, limit"}), "values of discount: limit is not a constant"},
+		{Definition{Inputs: []Column{{Expression: "order.amount"}}, Outputs: []Column{{Name: "x"}}, Rules: []Rule{{Inputs: []string{">"}, Outputs: []string{"1"}}}}, "rule 1, input order.amount: missing value after >"},
+		{Definition{Inputs: []Column{{Expression: "order.amount"}}, Outputs: []Column{{Name: "x"}}, Rules: []Rule{{Inputs: []string{`"big"`}, Outputs: []string{"1"}}}}, "rule 1, input order.amount: type check error"},
+		{Definition{Inputs: []Column{{Expression: "order.amount"}}, Outputs: []Column{{Name: "x"}}, Rules: []Rule{{Inputs: []string{"in [1,"}, Outputs: []string{"1"}}}}, "rule 1, input order.amount:"},
+		{Definition{Inputs: []Column{{Expression: "order.amount"}}, Outputs: []Column{{Name: "x"}}, Rules: []Rule{{Inputs: []string{"-"}, Outputs: []string{"1 +"}}}}, "rule 1, output x:"},
+		{Definition{Inputs: []Column{{Expression: "order.amount"}}, Outputs: []Column{{Name: "x"}}, Rules: []Rule{{Inputs: []string{"-", "-"}, Outputs: []string{"1"}}}}, "rule 1 has 2 input entries"},
+	}
+	for _, tt := range invalid {
+		if _, err := New(tt.definition, orderEnv); err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("Expected an error containing %q, got %v", tt.expected, err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	csvTable := `C+,     order.amount, order.country,     out:discount
+values, ,             """NO"", ""SE"", ""DK""",
+bulk,   >= 1000,      -,                 20
+nordic, > limit,      "in [""NO"", ""SE""]", 10
+,       -,            """DK""",          5
+`
+	jsonTable := `{
+		"hitPolicy": "COLLECT SUM",
+		"inputs": [{"expression": "order.amount"}, {"expression": "order.country", "values": "\"NO\", \"SE\", \"DK\""}],
+		"outputs": [{"name": "discount"}],
+		"rules": [
+			{"description": "bulk", "inputs": [">= 1000", "-"], "outputs": ["20"]},
+			{"description": "nordic", "inputs": ["> limit", "in [\"NO\", \"SE\"]"], "outputs": ["10"]},
+			{"inputs": ["-", "\"DK\""], "outputs": ["5"]}
+		]
+	}`
+
+	fromCSV, err := LoadCSV(strings.NewReader(csvTable), orderEnv)
+	if err != nil {
+		t.Fatalf("LoadCSV error: %v", err)
+	}
+	fromJSON, err := LoadJSON(strings.NewReader(jsonTable), orderEnv)
+	if err != nil {
+		t.Fatalf("LoadJSON error: %v", err)
+	}
+
+	for _, table := range []*Table{fromCSV, fromJSON} {
+		if table.HitPolicy() != CollectSum {
+			t.Errorf("Expected COLLECT SUM, got %s", table.HitPolicy())
+		}
+		result, err := table.Evaluate(order(2000, "NO", false))
+		if err != nil || result.Value != int64(30) {
+			t.Errorf("Expected 30, got %v (%v)", result, err)
+		}
+		if !reflect.DeepEqual(table.Issues(), fromJSON.Issues()) {
+			t.Errorf("Expected the same issues, got %v and %v", table.Issues(), fromJSON.Issues())
+		}
+	}
+
+	if _, err := LoadCSV(strings.NewReader("F, out:x, order.amount\n")); err == nil || !strings.Contains(err.Error(), "follows the outputs") {
+		t.Errorf("Expected an error for an input after the outputs, got %v", err)
+	}
+	if _, err := LoadJSON(strings.NewReader(`{"hitPolicy": "F", "input": []}`)); err == nil || !strings.Contains(err.Error(), "invalid decision table") {
+		t.Errorf("Expected an error for an unknown field, got %v", err)
+	}
+}
+
+func TestParseHitPolicy(t *testing.T) {
+	for text, expected := range map[string]HitPolicy{"": Unique, "u": Unique, "first": First, "C#": CollectCount, " collect   max ": CollectMax} {
+		if policy, err := ParseHitPolicy(text); err != nil || policy != expected {
+			t.Errorf("%q: expected %s, got %s (%v)", text, expected, policy, err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package decision
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mredencom/expr"
+)
+
+// outputPrefix marks the output columns in the header of a CSV table
+const outputPrefix = "out:"
+
+// LoadJSON reads the definition of a table as JSON and compiles it
+func LoadJSON(r io.Reader, options ...expr.Option) (*Table, error) {
+	var definition Definition
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&definition); err != nil {
+		return nil, fmt.Errorf("invalid decision table: %v", err)
+	}
+	return New(definition, options...)
+}
+
+// LoadCSV reads a table as CSV and compiles it. The first cell of the
+// header holds the hit policy, the following cells the expressions of the
+// inputs and then the names of the outputs prefixed with "out:". A second
+// row starting with "values" may list the values of the columns. Each
+// other row is a rule: a description, which may be empty, the tests of the
+// inputs and the expressions of the outputs.
+//
+//	F,      order.amount, order.country,     out:discount
+//	values, ,             """NO"", ""SE""",
+//	bulk,   >= 1000,      -,                 20
+//	nordic, -,            "in [""NO""]",     10
+//	other,  -,            -,                 0
+func LoadCSV(r io.Reader, options ...expr.Option) (*Table, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid decision table: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("invalid decision table: no header")
+	}
+
+	header := records[0]
+	definition := Definition{HitPolicy: HitPolicy(header[0])}
+	for i, cell := range header[1:] {
+		cell = strings.TrimSpace(cell)
+		if strings.HasPrefix(cell, outputPrefix) {
+			definition.Outputs = append(definition.Outputs, Column{Name: strings.TrimSpace(cell[len(outputPrefix):])})
+			continue
+		}
+		if len(definition.Outputs) > 0 {
+			return nil, fmt.Errorf("invalid decision table: input %s in column %d follows the outputs", cell, i+2)
+		}
+		definition.Inputs = append(definition.Inputs, Column{Expression: cell})
+	}
+
+	rows := records[1:]
+	if len(rows) > 0 && strings.EqualFold(strings.TrimSpace(rows[0][0]), "values") {
+		for i, cell := range rows[0][1:] {
+			if i < len(definition.Inputs) {
+				definition.Inputs[i].Values = cell
+			} else {
+				definition.Outputs[i-len(definition.Inputs)].Values = cell
+			}
+		}
+		rows = rows[1:]
+	}
+
+	inputs := len(definition.Inputs)
+	for _, row := range rows {
+		definition.Rules = append(definition.Rules, Rule{
+			Description: strings.TrimSpace(row[0]),
+			Inputs:      row[1 : 1+inputs],
+			Outputs:     row[1+inputs:],
+		})
+	}
+	return New(definition, options...)
+}
@@ -0,0 +1,217 @@
+package decision
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mredencom/expr"
+	"github.com/mredencom/expr/ast"
+	"github.com/mredencom/expr/ast/format"
+	"github.com/mredencom/expr/lexer"
+	"github.com/mredencom/expr/parser"
+)
+
+// test is a unary test of an input entry
+type test struct {
+	op       string        // Comparison, "in" or "not in"; empty matches any value
+	operands []string      // Expressions the input is compared with
+	values   []interface{} // Values of the operands when they are all constants
+	constant bool
+}
+
+// comparisons are the operators starting a comparison test, longest first
+var comparisons = []string{"<=", ">=", "==", "!=", "<", ">"}
+
+// parseTest parses an input entry: "-" or nothing, a comparison such as
+// "> 100", a list test such as `in ["A", "B"]` or `not in [1, 2]`, or an
+// expression the input must be equal to
+func parseTest(entry string) (test, error) {
+	entry = strings.TrimSpace(entry)
+	if entry == "" || entry == "-" {
+		return test{}, nil
+	}
+
+	for _, op := range []string{"not in", "in"} {
+		rest := strings.TrimSpace(strings.TrimPrefix(entry, op))
+		if strings.HasPrefix(entry, op) && strings.HasPrefix(rest, "[") {
+			operands, err := listElements(rest)
+			if err != nil {
+				return test{}, err
+			}
+			return test{op: op, operands: operands}, nil
+		}
+	}
+
+	for _, op := range comparisons {
+		if strings.HasPrefix(entry, op) {
+			operand := strings.TrimSpace(entry[len(op):])
+			if operand == "" {
+				return test{}, fmt.Errorf("missing value after %s", op)
+			}
+			return test{op: op, operands: []string{operand}}, nil
+		}
+	}
+	return test{op: "==", operands: []string{entry}}, nil
+}
+
+// expression returns the boolean expression applying the test to an input
+func (t test) expression(input string) string {
+	switch t.op {
+	case "":
+		return "true"
+	case "in", "not in":
+		if len(t.operands) == 0 {
+			return fmt.Sprint(t.op == "not in")
+		}
+		comparisons := make([]string, len(t.operands))
+		for i, operand := range t.operands {
+			comparisons[i] = fmt.Sprintf("(%s) == (%s)", input, operand)
+		}
+		list := strings.Join(comparisons, " || ")
+		if t.op == "not in" {
+			return "!(" + list + ")"
+		}
+		return list
+	}
+	return fmt.Sprintf("(%s) %s (%s)", input, t.op, t.operands[0])
+}
+
+// compile checks the test against its input and computes the values of its
+// operands when they are constants
+func (t *test) compile(input string, options []expr.Option) error {
+	if t.op == "" {
+		return nil
+	}
+	if _, err := expr.CompileAs[bool](t.expression(input), options...); err != nil {
+		return err
+	}
+
+	t.constant = true
+	for _, operand := range t.operands {
+		value, constant, err := constantValue(operand, options)
+		if err != nil {
+			return err
+		}
+		if !constant {
+			t.constant, t.values = false, nil
+			return nil
+		}
+		t.values = append(t.values, value)
+	}
+	return nil
+}
+
+// holds applies the test to a value when its operands are constants
+func (t test) holds(value interface{}) bool {
+	switch t.op {
+	case "":
+		return true
+	case "in", "not in":
+		found := false
+		for _, v := range t.values {
+			found = found || equal(v, value)
+		}
+		return found == (t.op == "in")
+	case "==":
+		return equal(value, t.values[0])
+	case "!=":
+		return !equal(value, t.values[0])
+	}
+
+	c, ok := compare(value, t.values[0])
+	if !ok {
+		return false
+	}
+	switch t.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
+
+// compare orders two numbers or two strings
+func compare(a, b interface{}) (int, bool) {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		switch {
+		case !ok:
+			return 0, false
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	x, ok := a.(string)
+	y, ok2 := b.(string)
+	if !ok || !ok2 {
+		return 0, false
+	}
+	return strings.Compare(x, y), true
+}
+
+// constantValue evaluates an expression that uses no variables or functions
+func constantValue(expression string, options []expr.Option) (interface{}, bool, error) {
+	program, err := expr.Compile(expression, options...)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(program.Variables()) > 0 || len(program.Functions()) > 0 {
+		return nil, false, nil
+	}
+	value, err := expr.Run(program, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// columnValues evaluates the values listed for a column, nil without values
+func columnValues(column Column, options []expr.Option) ([]interface{}, error) {
+	if strings.TrimSpace(column.Values) == "" {
+		return nil, nil
+	}
+	elements, err := listElements("[" + column.Values + "]")
+	if err != nil {
+		return nil, fmt.Errorf("values of %s: %w", column.Name, err)
+	}
+
+	values := make([]interface{}, len(elements))
+	for i, element := range elements {
+		value, constant, err := constantValue(element, options)
+		if err == nil && !constant {
+			err = fmt.Errorf("%s is not a constant", element)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("values of %s: %w", column.Name, err)
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// listElements returns the source of the elements of a list literal
+func listElements(list string) ([]string, error) {
+	prs := parser.New(lexer.New(list))
+	program := prs.ParseProgram()
+	if errs := prs.SourceErrors(); len(errs) > 0 {
+		return nil, errs[0]
+	}
+	if len(program.Statements) == 1 {
+		if stmt, ok := program.Statements[0].(*ast.ExpressionStatement); ok {
+			if literal, ok := stmt.Expression.(*ast.ArrayLiteral); ok {
+				elements := make([]string, len(literal.Elements))
+				for i, element := range literal.Elements {
+					elements[i] = format.Node(element)
+				}
+				return elements, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("expected a list, got %s", list)
+}
@@ -0,0 +1,190 @@
+package env
+
+import (
+	"reflect"
+
+	"github.com/mredencom/expr/types"
+)
+
+var valueInterface = reflect.TypeOf((*types.Value)(nil)).Elem()
+
+// ConvertTo converts an expression value to the Go type t. Maps and slices
+// converted from Go values with methods give back the original value. Other
+// maps are converted to structs only when their keys are exactly the fields
+// exposed by the struct layout. Ints are only accepted for float types when
+// widen is true, so that exact matches can be preferred when choosing
+// between overloads.
+func ConvertTo(value types.Value, t reflect.Type, tagName string, widen bool) (reflect.Value, bool) {
+	if t.Implements(valueInterface) {
+		if value == nil || !reflect.TypeOf(value).AssignableTo(t) {
+			return reflect.Value{}, false
+		}
+		return reflect.ValueOf(value), true
+	}
+
+	if value == nil {
+		value = types.NewNil()
+	}
+	if source := SourceOf(value); source.IsValid() {
+		if source.Type().AssignableTo(t) {
+			return source, true
+		}
+		if source.Type().Elem().AssignableTo(t) {
+			return source.Elem(), true
+		}
+	}
+	if _, isNil := value.(*types.NilValue); isNil {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+			return reflect.Zero(t), true
+		}
+		return reflect.Value{}, false
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if v, ok := value.(*types.BoolValue); ok {
+			return reflect.ValueOf(v.Value()).Convert(t), true
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v, ok := value.(*types.IntValue); ok {
+			out := reflect.New(t).Elem()
+			if out.OverflowInt(v.Value()) {
+				return reflect.Value{}, false
+			}
+			out.SetInt(v.Value())
+			return out, true
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v, ok := value.(*types.IntValue); ok && v.Value() >= 0 {
+			out := reflect.New(t).Elem()
+			if out.OverflowUint(uint64(v.Value())) {
+				return reflect.Value{}, false
+			}
+			out.SetUint(uint64(v.Value()))
+			return out, true
+		}
+	case reflect.Float32, reflect.Float64:
+		switch v := value.(type) {
+		case *types.FloatValue:
+			return reflect.ValueOf(v.Value()).Convert(t), true
+		case *types.IntValue:
+			if widen {
+				return reflect.ValueOf(float64(v.Value())).Convert(t), true
+			}
+		}
+	case reflect.String:
+		if v, ok := value.(*types.StringValue); ok {
+			return reflect.ValueOf(v.Value()).Convert(t), true
+		}
+	case reflect.Slice:
+		if v, ok := value.(*types.SliceValue); ok {
+			out := reflect.MakeSlice(t, v.Len(), v.Len())
+			for i, element := range v.Values() {
+				converted, ok := ConvertTo(element, t.Elem(), tagName, widen)
+				if !ok {
+					return reflect.Value{}, false
+				}
+				out.Index(i).Set(converted)
+			}
+			return out, true
+		}
+	case reflect.Map:
+		if v, ok := value.(*types.MapValue); ok && t.Key().Kind() == reflect.String {
+			out := reflect.MakeMapWithSize(t, v.Len())
+			for key, element := range v.Values() {
+				converted, ok := ConvertTo(element, t.Elem(), tagName, widen)
+				if !ok {
+					return reflect.Value{}, false
+				}
+				out.SetMapIndex(reflect.ValueOf(key).Convert(t.Key()), converted)
+			}
+			return out, true
+		}
+	case reflect.Struct:
+		if v, ok := value.(*types.MapValue); ok {
+			return convertToStruct(v, t, tagName, widen)
+		}
+	case reflect.Ptr:
+		converted, ok := ConvertTo(value, t.Elem(), tagName, widen)
+		if !ok {
+			return reflect.Value{}, false
+		}
+		out := reflect.New(t.Elem())
+		out.Elem().Set(converted)
+		return out, true
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			return reflect.ValueOf(ToInterface(value)), true
+		}
+	}
+
+	return reflect.Value{}, false
+}
+
+// convertToStruct builds a struct from a map holding exactly its exposed fields
+func convertToStruct(value *types.MapValue, t reflect.Type, tagName string, widen bool) (reflect.Value, bool) {
+	layout := LayoutOf(t, tagName)
+	if value.Len() != len(layout.Fields) {
+		return reflect.Value{}, false
+	}
+
+	out := reflect.New(t).Elem()
+	for _, field := range layout.Fields {
+		fieldValue, exists := value.Get(field.Name)
+		if !exists {
+			return reflect.Value{}, false
+		}
+		converted, ok := ConvertTo(fieldValue, field.Type, tagName, widen)
+		if !ok {
+			return reflect.Value{}, false
+		}
+		settableField(out, field.Index).Set(converted)
+	}
+	return out, true
+}
+
+// settableField returns the field at the index path, allocating nil embedded
+// pointers on the way
+func settableField(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// ToInterface converts an expression value to a plain Go value: slices become
+// []interface{} and maps become map[string]interface{}
+func ToInterface(value types.Value) interface{} {
+	switch v := value.(type) {
+	case nil, *types.NilValue:
+		return nil
+	case *types.BoolValue:
+		return v.Value()
+	case *types.IntValue:
+		return v.Value()
+	case *types.FloatValue:
+		return v.Value()
+	case *types.StringValue:
+		return v.Value()
+	case *types.SliceValue:
+		result := make([]interface{}, v.Len())
+		for i, element := range v.Values() {
+			result[i] = ToInterface(element)
+		}
+		return result
+	case *types.MapValue:
+		result := make(map[string]interface{}, v.Len())
+		for key, element := range v.Values() {
+			result[key] = ToInterface(element)
+		}
+		return result
+	}
+	return value
+}
@@ -0,0 +1,53 @@
+package env
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mredencom/expr/types"
+)
+
+func TestConvertTo(t *testing.T) {
+	keyType := types.TypeInfo{Kind: types.KindString, Name: "string"}
+	item := types.NewMap(map[string]types.Value{
+		"Name":  types.NewString("pen"),
+		"price": types.NewInt(2),
+	}, keyType, keyType)
+
+	// Ints are only accepted for floats when widening
+	if _, ok := ConvertTo(item, reflect.TypeOf(reflectItem{}), "expr", false); ok {
+		t.Error("Expected int price not to convert exactly to float64")
+	}
+	converted, ok := ConvertTo(item, reflect.TypeOf(reflectItem{}), "expr", true)
+	if !ok {
+		t.Fatal("Expected map to convert to reflectItem")
+	}
+	if got := converted.Interface().(reflectItem); got != (reflectItem{Name: "pen", Price: 2}) {
+		t.Errorf("Unexpected struct %+v", got)
+	}
+
+	// Maps must hold exactly the exposed fields
+	partial := types.NewMap(map[string]types.Value{"Name": types.NewString("pen")}, keyType, keyType)
+	if _, ok := ConvertTo(partial, reflect.TypeOf(reflectItem{}), "expr", true); ok {
+		t.Error("Expected map with missing fields not to convert")
+	}
+
+	slice := types.NewSlice([]types.Value{types.NewInt(1), types.NewInt(2)}, types.TypeInfo{Kind: types.KindInt64, Name: "int"})
+	ints, ok := ConvertTo(slice, reflect.TypeOf([]int8{}), "", false)
+	if !ok || !reflect.DeepEqual(ints.Interface(), []int8{1, 2}) {
+		t.Errorf("Expected []int8{1, 2}, got %v", ints)
+	}
+	if _, ok := ConvertTo(types.NewInt(300), reflect.TypeOf(int8(0)), "", false); ok {
+		t.Error("Expected overflowing int not to convert")
+	}
+
+	ptr, ok := ConvertTo(types.NewString("x"), reflect.TypeOf((*string)(nil)), "", false)
+	if !ok || *ptr.Interface().(*string) != "x" {
+		t.Error("Expected string to convert to *string")
+	}
+
+	generic, ok := ConvertTo(slice, reflect.TypeOf((*interface{})(nil)).Elem(), "", false)
+	if !ok || !reflect.DeepEqual(generic.Interface(), []interface{}{int64(1), int64(2)}) {
+		t.Errorf("Expected []interface{}, got %v", generic)
+	}
+}
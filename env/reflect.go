@@ -0,0 +1,338 @@
+package env
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/mredencom/expr/types"
+)
+
+// FieldLayout describes a struct field as it is exposed to expressions
+type FieldLayout struct {
+	Name  string // Name used in expressions, after tag renaming
+	Index []int  // Index path of the field, including promoted embedded fields
+	Type  reflect.Type
+}
+
+// StructLayout is the list of fields of a struct type visible to expressions.
+// Layouts are computed once per type and tag name and then cached.
+type StructLayout struct {
+	Type   reflect.Type
+	Fields []FieldLayout
+	byName map[string]int
+}
+
+// Field returns the field exposed under the given expression name
+func (l *StructLayout) Field(name string) (FieldLayout, bool) {
+	i, ok := l.byName[name]
+	if !ok {
+		return FieldLayout{}, false
+	}
+	return l.Fields[i], true
+}
+
+// layoutKey identifies a cached struct layout
+type layoutKey struct {
+	typ     reflect.Type
+	tagName string
+}
+
+// layoutCache holds *StructLayout values keyed by layoutKey
+var layoutCache sync.Map
+
+// LayoutOf returns the cached layout of a struct type. Fields are renamed or
+// hidden ("-") according to the struct tag called tagName; an empty tagName
+// uses the Go field names.
+func LayoutOf(t reflect.Type, tagName string) *StructLayout {
+	key := layoutKey{typ: t, tagName: tagName}
+	if cached, ok := layoutCache.Load(key); ok {
+		return cached.(*StructLayout)
+	}
+
+	layout := buildLayout(t, tagName)
+	actual, _ := layoutCache.LoadOrStore(key, layout)
+	return actual.(*StructLayout)
+}
+
+// buildLayout computes the layout of a struct type
+func buildLayout(t reflect.Type, tagName string) *StructLayout {
+	layout := &StructLayout{
+		Type:   t,
+		byName: make(map[string]int),
+	}
+
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() {
+			continue
+		}
+
+		name := field.Name
+		renamed := false
+		if tagName != "" {
+			if tag, ok := field.Tag.Lookup(tagName); ok {
+				tagged := strings.Split(tag, ",")[0]
+				if tagged == "-" {
+					continue
+				}
+				if tagged != "" {
+					name = tagged
+					renamed = true
+				}
+			}
+		}
+
+		// Embedded structs are flattened: their fields are promoted instead
+		if field.Anonymous && !renamed && isStructType(field.Type) {
+			continue
+		}
+
+		if !isSupportedKind(field.Type.Kind()) {
+			continue
+		}
+
+		// Shallower fields shadow deeper ones with the same name
+		if existing, ok := layout.byName[name]; ok {
+			if len(layout.Fields[existing].Index) <= len(field.Index) {
+				continue
+			}
+			layout.Fields[existing] = FieldLayout{Name: name, Index: field.Index, Type: field.Type}
+			continue
+		}
+
+		layout.byName[name] = len(layout.Fields)
+		layout.Fields = append(layout.Fields, FieldLayout{
+			Name:  name,
+			Index: field.Index,
+			Type:  field.Type,
+		})
+	}
+
+	return layout
+}
+
+// isStructType reports whether t is a struct or a pointer to a struct
+func isStructType(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
+
+// isSupportedKind reports whether values of the kind can be converted
+func isSupportedKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer, reflect.Complex64, reflect.Complex128:
+		return false
+	}
+	return true
+}
+
+// fieldByIndex returns the field at the index path, or false when the path
+// goes through a nil embedded pointer
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// StructEnvironment flattens a struct, or a pointer to one, into top-level
+// environment variables. It returns false when value is not a struct.
+func StructEnvironment(value interface{}, tagName string) (map[string]interface{}, bool) {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, false
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, false
+	}
+
+	layout := LayoutOf(v.Type(), tagName)
+	variables := make(map[string]interface{}, len(layout.Fields))
+	for _, field := range layout.Fields {
+		fv, ok := fieldByIndex(v, field.Index)
+		if !ok {
+			variables[field.Name] = nil
+			continue
+		}
+		if fv.CanInterface() {
+			variables[field.Name] = fv.Interface()
+		}
+	}
+
+	return variables, true
+}
+
+// ConvertReflect converts an arbitrary Go value to a types.Value using
+// reflection. Structs become maps keyed by their exposed field names.
+func ConvertReflect(value interface{}, tagName string) (types.Value, error) {
+	if value == nil {
+		return types.NewNil(), nil
+	}
+	return convertReflectValue(reflect.ValueOf(value), tagName)
+}
+
+// convertReflectValue converts a reflected value to a types.Value
+func convertReflectValue(v reflect.Value, tagName string) (types.Value, error) {
+	if v.CanInterface() {
+		if value, ok := v.Interface().(types.Value); ok {
+			return value, nil
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return types.NewBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return types.NewInt(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return types.NewInt(int64(v.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return types.NewFloat(v.Float()), nil
+	case reflect.String:
+		return types.NewString(v.String()), nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return types.NewNil(), nil
+		}
+		return convertReflectValue(v.Elem(), tagName)
+	case reflect.Struct:
+		converted, err := convertStruct(v, tagName)
+		if err != nil {
+			return nil, err
+		}
+		return withSource(converted, v), nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return withSource(types.NewSlice([]types.Value{}, TypeInfoOf(v.Type().Elem())), v), nil
+		}
+		values := make([]types.Value, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			converted, err := convertReflectValue(v.Index(i), tagName)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert slice element %d: %v", i, err)
+			}
+			values[i] = converted
+		}
+		return withSource(types.NewSlice(values, TypeInfoOf(v.Type().Elem())), v), nil
+	case reflect.Map:
+		values := make(map[string]types.Value, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := iter.Key()
+			var name string
+			if key.Kind() == reflect.String {
+				name = key.String()
+			} else {
+				name = fmt.Sprint(key)
+			}
+			converted, err := convertReflectValue(iter.Value(), tagName)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert map value for key %s: %v", name, err)
+			}
+			values[name] = converted
+		}
+		keyType := types.TypeInfo{Kind: types.KindString, Name: "string", Size: -1}
+		return withSource(types.NewMap(values, keyType, TypeInfoOf(v.Type().Elem())), v), nil
+	case reflect.Invalid:
+		return types.NewNil(), nil
+	}
+
+	return nil, fmt.Errorf("unsupported type: %s", v.Type())
+}
+
+// withSource attaches the Go value to a converted map or slice when its type
+// has exported methods, so that expressions can call them. The value is
+// attached by pointer, taking the address of addressable values and copying
+// the others, so that methods with pointer receivers can be called too.
+func withSource(converted types.Value, v reflect.Value) types.Value {
+	t := v.Type()
+	if reflect.PtrTo(t).NumMethod() == 0 || !v.CanInterface() {
+		return converted
+	}
+
+	var receiver reflect.Value
+	if v.CanAddr() {
+		receiver = v.Addr()
+	} else {
+		receiver = reflect.New(t)
+		receiver.Elem().Set(v)
+	}
+
+	switch value := converted.(type) {
+	case *types.MapValue:
+		value.SetSource(receiver.Interface())
+	case *types.SliceValue:
+		value.SetSource(receiver.Interface())
+	}
+	return converted
+}
+
+// SourceOf returns the pointer to the Go value a map or slice was converted
+// from, or an invalid value when it was not converted from a type with methods
+func SourceOf(value types.Value) reflect.Value {
+	switch v := value.(type) {
+	case *types.MapValue:
+		return reflect.ValueOf(v.Source())
+	case *types.SliceValue:
+		return reflect.ValueOf(v.Source())
+	}
+	return reflect.Value{}
+}
+
+// convertStruct converts a struct to a map of its exposed fields
+func convertStruct(v reflect.Value, tagName string) (types.Value, error) {
+	layout := LayoutOf(v.Type(), tagName)
+	fields := make(map[string]types.Value, len(layout.Fields))
+	for _, field := range layout.Fields {
+		fv, ok := fieldByIndex(v, field.Index)
+		if !ok {
+			fields[field.Name] = types.NewNil()
+			continue
+		}
+		converted, err := convertReflectValue(fv, tagName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert field %s: %v", field.Name, err)
+		}
+		fields[field.Name] = converted
+	}
+
+	keyType := types.TypeInfo{Kind: types.KindString, Name: "string", Size: -1}
+	valueType := types.TypeInfo{Kind: types.KindInterface, Name: "interface{}", Size: -1}
+	return types.NewMap(fields, keyType, valueType), nil
+}
+
+// TypeInfoOf returns the type information for a Go type
+func TypeInfoOf(t reflect.Type) types.TypeInfo {
+	switch t.Kind() {
+	case reflect.Bool:
+		return types.TypeInfo{Kind: types.KindBool, Name: "bool", Size: 1}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return types.TypeInfo{Kind: types.KindInt64, Name: "int", Size: 8}
+	case reflect.Float32, reflect.Float64:
+		return types.TypeInfo{Kind: types.KindFloat64, Name: "float64", Size: 8}
+	case reflect.String:
+		return types.TypeInfo{Kind: types.KindString, Name: "string", Size: -1}
+	case reflect.Struct:
+		return types.TypeInfo{Kind: types.KindStruct, Name: t.Name(), Size: -1}
+	case reflect.Map:
+		return types.TypeInfo{Kind: types.KindMap, Name: t.String(), Size: -1}
+	case reflect.Slice, reflect.Array:
+		return types.TypeInfo{Kind: types.KindSlice, Name: t.String(), Size: -1}
+	case reflect.Ptr:
+		return TypeInfoOf(t.Elem())
+	}
+	return types.TypeInfo{Kind: types.KindInterface, Name: "interface{}", Size: -1}
+}
@@ -0,0 +1,177 @@
+package env
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mredencom/expr/types"
+)
+
+type reflectBase struct {
+	ID int
+}
+
+type ReflectAudit struct {
+	Owner string
+}
+
+type reflectAddress struct {
+	City string `expr:"city"`
+}
+
+type reflectItem struct {
+	Name  string
+	Price float64 `expr:"price"`
+}
+
+type reflectOrder struct {
+	reflectBase
+	*ReflectAudit
+	Name     string          `expr:"name"`
+	Secret   string          `expr:"-"`
+	Address  *reflectAddress `expr:"address"`
+	Items    []reflectItem   `expr:"items"`
+	Handler  func()
+	internal int
+}
+
+func TestLayoutOf(t *testing.T) {
+	typ := reflect.TypeOf(reflectOrder{})
+	layout := LayoutOf(typ, "expr")
+
+	for _, name := range []string{"ID", "Owner", "name", "address", "items"} {
+		if _, ok := layout.Field(name); !ok {
+			t.Errorf("Expected field %s in layout", name)
+		}
+	}
+	for _, name := range []string{"Name", "Secret", "Handler", "internal", "reflectBase", "ReflectAudit"} {
+		if _, ok := layout.Field(name); ok {
+			t.Errorf("Expected field %s to be hidden", name)
+		}
+	}
+
+	if LayoutOf(typ, "expr") != layout {
+		t.Error("Expected layout to be cached")
+	}
+
+	untagged := LayoutOf(typ, "")
+	if _, ok := untagged.Field("Name"); !ok {
+		t.Error("Expected Go field name without tag name")
+	}
+	if _, ok := untagged.Field("Secret"); !ok {
+		t.Error("Expected Secret to be visible without tag name")
+	}
+}
+
+func TestStructEnvironment(t *testing.T) {
+	order := &reflectOrder{
+		reflectBase: reflectBase{ID: 7},
+		Name:        "order",
+		Address:     &reflectAddress{City: "Paris"},
+	}
+
+	variables, ok := StructEnvironment(order, "expr")
+	if !ok {
+		t.Fatal("Expected struct pointer to be accepted")
+	}
+	if variables["name"] != "order" {
+		t.Errorf("Expected name to be 'order', got %v", variables["name"])
+	}
+	if variables["ID"] != 7 {
+		t.Errorf("Expected promoted ID to be 7, got %v", variables["ID"])
+	}
+	if owner, exists := variables["Owner"]; !exists || owner != nil {
+		t.Errorf("Expected nil Owner through nil embedded pointer, got %v", owner)
+	}
+
+	if _, ok := StructEnvironment(map[string]interface{}{}, "expr"); ok {
+		t.Error("Expected map to be rejected")
+	}
+	if _, ok := StructEnvironment((*reflectOrder)(nil), "expr"); ok {
+		t.Error("Expected nil pointer to be rejected")
+	}
+}
+
+func TestConvertReflect(t *testing.T) {
+	order := reflectOrder{
+		Name:    "order",
+		Address: &reflectAddress{City: "Paris"},
+		Items:   []reflectItem{{Name: "a", Price: 1.5}, {Name: "b", Price: 2}},
+	}
+
+	value, err := ConvertReflect(order, "expr")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	fields, ok := value.(*types.MapValue)
+	if !ok {
+		t.Fatalf("Expected MapValue, got %T", value)
+	}
+
+	address, _ := fields.Get("address")
+	city, _ := address.(*types.MapValue).Get("city")
+	if city.(*types.StringValue).Value() != "Paris" {
+		t.Errorf("Expected city Paris, got %v", city)
+	}
+
+	items, _ := fields.Get("items")
+	slice, ok := items.(*types.SliceValue)
+	if !ok || slice.Len() != 2 {
+		t.Fatalf("Expected slice of 2 items, got %v", items)
+	}
+	price, _ := slice.Get(1).(*types.MapValue).Get("price")
+	if price.(*types.FloatValue).Value() != 2 {
+		t.Errorf("Expected price 2, got %v", price)
+	}
+
+	if _, exists := fields.Get("Secret"); exists {
+		t.Error("Expected Secret to be hidden")
+	}
+
+	if _, err := ConvertReflect(make(chan int), ""); err == nil {
+		t.Error("Expected error for unsupported type")
+	}
+}
+
+type reflectCounter struct {
+	Hits int
+}
+
+func (c *reflectCounter) Hit() int {
+	c.Hits++
+	return c.Hits
+}
+
+func TestConvertReflectKeepsMethods(t *testing.T) {
+	counter := &reflectCounter{}
+	value, err := ConvertReflect(counter, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	source := SourceOf(value)
+	if !source.IsValid() || source.Interface() != counter {
+		t.Fatalf("Expected the pointer to be kept, got %v", source)
+	}
+	source.MethodByName("Hit").Call(nil)
+	if counter.Hits != 1 {
+		t.Errorf("Expected the method to update the original value, got %d hits", counter.Hits)
+	}
+
+	// Values are copied so that pointer receivers can still be called
+	value, _ = ConvertReflect(reflectCounter{Hits: 5}, "")
+	if hits := SourceOf(value).MethodByName("Hit").Call(nil)[0].Int(); hits != 6 {
+		t.Errorf("Expected 6 hits, got %d", hits)
+	}
+	converted, ok := ConvertTo(value, reflect.TypeOf(reflectCounter{}), "", false)
+	if !ok || converted.Interface().(reflectCounter).Hits != 6 {
+		t.Errorf("Expected the source to be converted back, got %v", converted)
+	}
+
+	// Types without methods keep no source
+	value, _ = ConvertReflect(reflectItem{Name: "a"}, "")
+	if SourceOf(value).IsValid() {
+		t.Error("Expected no source for a type without methods")
+	}
+}
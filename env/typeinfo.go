@@ -0,0 +1,142 @@
+package env
+
+import (
+	"context"
+	"reflect"
+	"sort"
+
+	"github.com/mredencom/expr/types"
+)
+
+// TypeInfoOfValue derives the static type of a value for type checking.
+// Structs list their fields under the expression names, slices and maps
+// carry their element types and maps with string keys list their entries.
+// Values held in interfaces are described by their dynamic type.
+func TypeInfoOfValue(value interface{}, tagName string) types.TypeInfo {
+	return describeValue(reflect.ValueOf(value), tagName, make(map[reflect.Type]bool))
+}
+
+// TypeInfoOfType derives the static type of a Go type for type checking
+func TypeInfoOfType(t reflect.Type, tagName string) types.TypeInfo {
+	return describeType(t, tagName, make(map[reflect.Type]bool))
+}
+
+// describeValue describes a value, looking through interfaces and pointers
+func describeValue(v reflect.Value, tagName string, visiting map[reflect.Type]bool) types.TypeInfo {
+	for v.IsValid() && (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) {
+		if v.IsNil() {
+			if v.Kind() == reflect.Ptr {
+				return describeType(v.Type().Elem(), tagName, visiting)
+			}
+			return unknownType
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return unknownType
+	}
+
+	info := describeType(v.Type(), tagName, visiting)
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String ||
+		v.Type().Elem().Kind() != reflect.Interface {
+		return info
+	}
+
+	// Entries of map[string]interface{} are described by their values
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	for i, key := range keys {
+		info.Fields = append(info.Fields, types.FieldInfo{
+			Name:  key.String(),
+			Type:  describeValue(v.MapIndex(key), tagName, visiting),
+			Index: i,
+		})
+	}
+	return info
+}
+
+// describeType describes a Go type. Recursive types are described as
+// interface{} where they refer to themselves.
+func describeType(t reflect.Type, tagName string, visiting map[reflect.Type]bool) types.TypeInfo {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return describeType(t.Elem(), tagName, visiting)
+	case reflect.Struct, reflect.Slice, reflect.Array, reflect.Map:
+		if visiting[t] {
+			return unknownType
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+	}
+
+	info := TypeInfoOf(t)
+	switch t.Kind() {
+	case reflect.Struct:
+		if info.Name == "" {
+			info.Name = t.String()
+		}
+		for i, field := range LayoutOf(t, tagName).Fields {
+			info.Fields = append(info.Fields, types.FieldInfo{
+				Name:  field.Name,
+				Type:  describeType(field.Type, tagName, visiting),
+				Index: i,
+			})
+		}
+	case reflect.Slice, reflect.Array:
+		elemType := describeType(t.Elem(), tagName, visiting)
+		info.ElemType = &elemType
+	case reflect.Map:
+		keyType := describeType(t.Key(), tagName, visiting)
+		valType := describeType(t.Elem(), tagName, visiting)
+		info.KeyType = &keyType
+		info.ValType = &valType
+	}
+
+	// Methods of the types converted to maps and slices can be called
+	switch t.Kind() {
+	case reflect.Struct, reflect.Slice, reflect.Array, reflect.Map:
+		ptr := reflect.PtrTo(t)
+		for i := 0; i < ptr.NumMethod(); i++ {
+			method := ptr.Method(i)
+			signature := describeSignature(method.Type, 1, tagName, visiting)
+			signature.Name = method.Name
+			info.Methods = append(info.Methods, signature)
+		}
+	}
+	return info
+}
+
+// SignatureOf describes the parameters and result of a function type as
+// expressions see them. A leading context.Context parameter and a trailing
+// error result are left out, and the last parameter of a variadic function
+// is described by its element type.
+func SignatureOf(t reflect.Type, tagName string) types.MethodInfo {
+	return describeSignature(t, 0, tagName, make(map[reflect.Type]bool))
+}
+
+// describeSignature describes a function type, skipping the first skip
+// parameters such as the receiver of a method
+func describeSignature(t reflect.Type, skip int, tagName string, visiting map[reflect.Type]bool) types.MethodInfo {
+	signature := types.MethodInfo{Variadic: t.IsVariadic()}
+	if t.NumIn() > skip && t.In(skip) == contextInterface {
+		skip++ // The context is passed by the VM
+	}
+	for i := skip; i < t.NumIn(); i++ {
+		paramType := t.In(i)
+		if signature.Variadic && i == t.NumIn()-1 {
+			paramType = paramType.Elem()
+		}
+		signature.Params = append(signature.Params, describeType(paramType, tagName, visiting))
+	}
+	if t.NumOut() > 0 && t.Out(0) != errorInterface {
+		signature.Returns = append(signature.Returns, describeType(t.Out(0), tagName, visiting))
+	}
+	return signature
+}
+
+var unknownType = types.TypeInfo{Kind: types.KindInterface, Name: "interface{}", Size: -1}
+
+var (
+	contextInterface = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorInterface   = reflect.TypeOf((*error)(nil)).Elem()
+)
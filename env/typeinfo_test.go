@@ -0,0 +1,88 @@
+package env
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/mredencom/expr/types"
+)
+
+type typeInfoNode struct {
+	Value int
+	Next  *typeInfoNode
+}
+
+func TestTypeInfoOfValue(t *testing.T) {
+	info := TypeInfoOfValue(map[string]interface{}{
+		"order": &reflectOrder{},
+		"count": 3,
+		"meta":  map[string]interface{}{"tags": []string{"a"}},
+		"none":  nil,
+	}, "expr")
+
+	if info.Kind != types.KindMap || len(info.Fields) != 4 {
+		t.Fatalf("expected map with 4 entries, got %v with %d", info.Kind, len(info.Fields))
+	}
+
+	fields := make(map[string]types.TypeInfo)
+	for _, field := range info.Fields {
+		fields[field.Name] = field.Type
+	}
+	if fields["count"].Kind != types.KindInt64 {
+		t.Errorf("count: expected int, got %v", fields["count"].Kind)
+	}
+	if fields["none"].Kind != types.KindInterface {
+		t.Errorf("none: expected interface{}, got %v", fields["none"].Kind)
+	}
+	if tags := fields["meta"].Fields[0].Type; tags.Kind != types.KindSlice || tags.ElemType.Kind != types.KindString {
+		t.Errorf("meta.tags: expected []string, got %s", tags.Name)
+	}
+
+	order := fields["order"]
+	if order.Kind != types.KindStruct {
+		t.Fatalf("order: expected struct, got %v", order.Kind)
+	}
+	names := make(map[string]types.TypeInfo)
+	for _, field := range order.Fields {
+		names[field.Name] = field.Type
+	}
+	if _, ok := names["Secret"]; ok {
+		t.Error("fields skipped by the tag should not be listed")
+	}
+	if city := names["address"].Fields; len(city) != 1 || city[0].Name != "city" {
+		t.Errorf("address: expected field city, got %v", city)
+	}
+	if items := names["items"]; items.ElemType == nil || items.ElemType.Fields[1].Name != "price" {
+		t.Errorf("items: expected element fields, got %v", items.ElemType)
+	}
+}
+
+func TestTypeInfoOfRecursiveType(t *testing.T) {
+	info := TypeInfoOfValue(typeInfoNode{}, "")
+
+	if len(info.Fields) != 2 {
+		t.Fatalf("expected 2 fields, got %d", len(info.Fields))
+	}
+	if next := info.Fields[1].Type; next.Kind != types.KindInterface {
+		t.Errorf("recursive field: expected interface{}, got %v", next.Kind)
+	}
+}
+
+func TestTypeInfoMethods(t *testing.T) {
+	info := TypeInfoOfValue(reflectCounter{}, "")
+	if len(info.Methods) != 1 || info.Methods[0].Name != "Hit" {
+		t.Fatalf("expected method Hit, got %+v", info.Methods)
+	}
+	if hit := info.Methods[0]; len(hit.Params) != 0 || len(hit.Returns) != 1 || hit.Returns[0].Kind != types.KindInt64 {
+		t.Errorf("unexpected signature of Hit: %+v", hit)
+	}
+
+	signature := SignatureOf(reflect.TypeOf(func(ctx context.Context, name string, values ...float64) (bool, error) { return false, nil }), "")
+	if len(signature.Params) != 2 || signature.Params[1].Kind != types.KindFloat64 || !signature.Variadic {
+		t.Errorf("unexpected parameters: %+v", signature)
+	}
+	if len(signature.Returns) != 1 || signature.Returns[0].Kind != types.KindBool {
+		t.Errorf("unexpected results: %+v", signature.Returns)
+	}
+}
@@ -0,0 +1,205 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mredencom/expr/lexer"
+	"github.com/mredencom/expr/vm"
+)
+
+// CompileError represents a compilation error. Errors located in the source
+// carry the span of the offending token and a snippet pointing at it.
+type CompileError struct {
+	Message  string
+	Position int // byte offset of the offending span
+	Line     int
+	Column   int
+	Length   int    // length of the offending span in bytes
+	Phase    string // parse, type check or compilation
+	Snippet  string // source line with carets under the offending span
+	Cause    error
+}
+
+func (e *CompileError) Error() string {
+	phase := e.Phase
+	if phase == "" {
+		phase = "compile"
+	}
+	if e.Line == 0 {
+		return fmt.Sprintf("%s error: %s", phase, e.Message)
+	}
+	return fmt.Sprintf("%s error at line %d, column %d: %s", phase, e.Line, e.Column, e.Message)
+}
+
+// Unwrap returns the underlying cause
+func (e *CompileError) Unwrap() error {
+	return e.Cause
+}
+
+// CompileErrors lists all errors found while compiling an expression
+type CompileErrors []*CompileError
+
+func (e CompileErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap returns the errors so that errors.Is and errors.As inspect each of them
+func (e CompileErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// RuntimeError represents a runtime error. The position is set when the
+// failing part of the expression is known.
+type RuntimeError struct {
+	Message  string
+	Cause    error
+	Position int // byte offset of the failing span
+	Line     int
+	Column   int
+	Length   int    // length of the failing span in bytes
+	Snippet  string // source line with carets under the failing span
+}
+
+func (e *RuntimeError) Error() string {
+	message := e.Message
+	if e.Cause != nil && e.Cause.Error() != e.Message {
+		message = fmt.Sprintf("%s (caused by: %v)", e.Message, e.Cause)
+	}
+	if e.Line == 0 {
+		return fmt.Sprintf("runtime error: %s", message)
+	}
+	return fmt.Sprintf("runtime error at line %d, column %d: %s", e.Line, e.Column, message)
+}
+
+// Unwrap returns the underlying cause
+func (e *RuntimeError) Unwrap() error {
+	return e.Cause
+}
+
+// Helper functions for creating errors
+
+// NewCompileError creates a new compile error
+func NewCompileError(message string, line, column int) *CompileError {
+	return &CompileError{
+		Message: message,
+		Line:    line,
+		Column:  column,
+	}
+}
+
+// NewRuntimeError creates a new runtime error
+func NewRuntimeError(message string, cause error) *RuntimeError {
+	return &RuntimeError{
+		Message: message,
+		Cause:   cause,
+	}
+}
+
+// newCompileErrors converts errors located in the source into compile errors
+func newCompileErrors(phase, source string, errs []*lexer.SourceError) CompileErrors {
+	compileErrors := make(CompileErrors, len(errs))
+	for i, err := range errs {
+		compileErrors[i] = newCompileError(phase, source, err)
+	}
+	return compileErrors
+}
+
+// newCompileError converts an error located in the source into a compile
+// error. Without a known end the span covers the token at the position.
+func newCompileError(phase, source string, err *lexer.SourceError) *CompileError {
+	compileErr := &CompileError{Message: err.Message, Phase: phase, Cause: err.Cause}
+	if !err.Pos.Valid() {
+		return compileErr
+	}
+
+	offset := err.Pos.Offset
+	end := err.End.Offset
+	if !err.End.Valid() || end <= offset {
+		end = tokenEnd(source, offset)
+	}
+
+	compileErr.Position = offset
+	compileErr.Line = err.Pos.Line
+	compileErr.Column = err.Pos.Column
+	compileErr.Length = end - offset
+	compileErr.Snippet = sourceSnippet(source, offset, end-offset)
+	return compileErr
+}
+
+// newRuntimeError converts an execution error into a runtime error located
+// at the source of the failing instruction, when known
+func newRuntimeError(source string, err error) *RuntimeError {
+	runtimeErr := &RuntimeError{Message: err.Error(), Cause: err}
+
+	var instructionErr *vm.InstructionError
+	if !errors.As(err, &instructionErr) || !instructionErr.Pos.Valid() {
+		return runtimeErr
+	}
+
+	pos := instructionErr.Pos
+	end := tokenEnd(source, pos.Offset)
+	runtimeErr.Position = pos.Offset
+	runtimeErr.Line = pos.Line
+	runtimeErr.Column = pos.Column
+	runtimeErr.Length = end - pos.Offset
+	runtimeErr.Snippet = sourceSnippet(source, pos.Offset, end-pos.Offset)
+	return runtimeErr
+}
+
+// tokenEnd returns the offset just after the token starting at offset
+func tokenEnd(source string, offset int) int {
+	if offset >= len(source) {
+		return offset
+	}
+	tok := lexer.New(source[offset:]).NextToken()
+	if tok.Type == lexer.EOF {
+		return offset
+	}
+	return offset + tok.End.Offset
+}
+
+// sourceSnippet returns the source line containing offset followed by a line
+// with carets under the span
+func sourceSnippet(source string, offset, length int) string {
+	if offset > len(source) {
+		offset = len(source)
+	}
+	start := strings.LastIndexByte(source[:offset], '\n') + 1
+	end := strings.IndexByte(source[offset:], '\n')
+	if end < 0 {
+		end = len(source)
+	} else {
+		end += offset
+	}
+
+	// Indent with the whitespace of the line so that tabs line up
+	var indent strings.Builder
+	for _, r := range source[start:offset] {
+		if r == '\t' {
+			indent.WriteRune('\t')
+		} else {
+			indent.WriteByte(' ')
+		}
+	}
+
+	spanEnd := offset + length
+	if spanEnd > end {
+		spanEnd = end
+	}
+	width := utf8.RuneCountInString(source[offset:spanEnd])
+	if width < 1 {
+		width = 1
+	}
+	return source[start:end] + "\n" + indent.String() + strings.Repeat("^", width)
+}
@@ -0,0 +1,191 @@
+package expr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCompileErrorSpans(t *testing.T) {
+	tests := []struct {
+		expression string
+		options    []Option
+		phase      string
+		line       int
+		column     int
+		length     int
+		snippet    string
+	}{
+		{"1 + + 2", nil, "parse", 1, 5, 1, "1 + + 2\n    ^"},
+		{"(1 + 2", nil, "parse", 1, 7, 0, "(1 + 2\n      ^"},
+		{"a @ b", nil, "parse", 1, 3, 1, "a @ b\n  ^"},
+		{"1 +\n\tmissing", nil, "compilation", 2, 2, 7, "\tmissing\n\t^^^^^^^"},
+		{
+			`user.Name == "x" || user.agee > 18`,
+			[]Option{Env(map[string]interface{}{"user": testUser{}}), Tags(Tag{Name: "expr"})},
+			"type check", 1, 26, 4, "user.Name == \"x\" || user.agee > 18\n                         ^^^^",
+		},
+		{
+			`user.Name >= 18`,
+			[]Option{Env(map[string]interface{}{"user": testUser{}})},
+			"type check", 1, 11, 2, "user.Name >= 18\n          ^^",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			_, err := Compile(tt.expression, tt.options...)
+
+			var compileErr *CompileError
+			if !errors.As(err, &compileErr) {
+				t.Fatalf("expected a CompileError, got %T: %v", err, err)
+			}
+			if compileErr.Phase != tt.phase {
+				t.Errorf("expected phase %q, got %q", tt.phase, compileErr.Phase)
+			}
+			if compileErr.Line != tt.line || compileErr.Column != tt.column || compileErr.Length != tt.length {
+				t.Errorf("expected span %d:%d+%d, got %d:%d+%d", tt.line, tt.column, tt.length,
+					compileErr.Line, compileErr.Column, compileErr.Length)
+			}
+			if compileErr.Snippet != tt.snippet {
+				t.Errorf("expected snippet\n%s\ngot\n%s", tt.snippet, compileErr.Snippet)
+			}
+		})
+	}
+}
+
+func TestRuntimeErrorSpans(t *testing.T) {
+	env := map[string]interface{}{"a": 1, "b": 0, "items": []int{1, 2}}
+	tests := []struct {
+		expression string
+		line       int
+		column     int
+		snippet    string
+	}{
+		{"a / b", 1, 3, "a / b\n  ^"},
+		{"a +\n\titems[5]", 2, 7, "\titems[5]\n\t     ^"},
+		{"map(items, (v) => v % b)", 1, 21, "map(items, (v) => v % b)\n                    ^"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			program, err := Compile(tt.expression, Env(env))
+			if err != nil {
+				t.Fatalf("unexpected compile error: %v", err)
+			}
+
+			_, err = Run(program, env)
+			var runtimeErr *RuntimeError
+			if !errors.As(err, &runtimeErr) {
+				t.Fatalf("expected a RuntimeError, got %T: %v", err, err)
+			}
+			if runtimeErr.Line != tt.line || runtimeErr.Column != tt.column {
+				t.Errorf("expected error at %d:%d, got %d:%d", tt.line, tt.column, runtimeErr.Line, runtimeErr.Column)
+			}
+			if runtimeErr.Snippet != tt.snippet {
+				t.Errorf("expected snippet\n%s\ngot\n%s", tt.snippet, runtimeErr.Snippet)
+			}
+		})
+	}
+}
+
+func TestProgramPositionAt(t *testing.T) {
+	program, err := Compile("1 +\n  x", Env(map[string]interface{}{"x": 2}))
+	if err != nil {
+		t.Fatalf("unexpected compile error: %v", err)
+	}
+
+	var lines []int
+	for _, entry := range program.SourceMap() {
+		pos, ok := program.PositionAt(entry.Offset)
+		if !ok || pos != entry.Pos {
+			t.Errorf("expected position %v at %d, got %v", entry.Pos, entry.Offset, pos)
+		}
+		lines = append(lines, pos.Line)
+	}
+	if fmt.Sprint(lines) != "[1 2 1]" {
+		t.Errorf("expected instructions on lines [1 2 1], got %v", lines)
+	}
+}
+
+func TestCompileErrorList(t *testing.T) {
+	env := map[string]interface{}{"user": testUser{Name: "Ann"}}
+	_, err := Compile(`user.Nmae == "Ann" && user.Age > "18"`, Env(env))
+
+	var list CompileErrors
+	if !errors.As(err, &list) {
+		t.Fatalf("expected CompileErrors, got %T: %v", err, err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("expected 2 errors, got %d: %v", len(list), list)
+	}
+	if !strings.Contains(list[0].Message, "user.Nmae: unknown field") || list[0].Column != 6 {
+		t.Errorf("unexpected first error: %v", list[0])
+	}
+	if !strings.Contains(list[1].Message, "cannot compare int with string") || list[1].Column != 32 {
+		t.Errorf("unexpected second error: %v", list[1])
+	}
+}
+
+func TestErrorCauses(t *testing.T) {
+	errInvalid := errors.New("invalid currency")
+
+	t.Run("ConstExpr", func(t *testing.T) {
+		_, err := Compile(`rate("XXX")`,
+			WithBuiltin("rate", func(currency string) (float64, error) {
+				return 0, fmt.Errorf("rate of %s: %w", currency, errInvalid)
+			}),
+			ConstExpr("rate"),
+		)
+		if !errors.Is(err, errInvalid) {
+			t.Fatalf("expected the cause to be found, got %v", err)
+		}
+		var compileErr *CompileError
+		if !errors.As(err, &compileErr) || compileErr.Column != 1 || compileErr.Length != 4 {
+			t.Errorf("expected the error to cover the call, got %+v", compileErr)
+		}
+	})
+
+	t.Run("Operator", func(t *testing.T) {
+		add := func(a, b testMoney) (testMoney, error) {
+			if a.Currency != b.Currency {
+				return testMoney{}, errInvalid
+			}
+			return testMoney{Amount: a.Amount + b.Amount, Currency: a.Currency}, nil
+		}
+		env := map[string]interface{}{
+			"a": testMoney{Amount: 1, Currency: "EUR"},
+			"b": testMoney{Amount: 1, Currency: "USD"},
+		}
+		program, err := Compile("a + b", Env(env), WithOperatorFunc("+", 0, add))
+		if err != nil {
+			t.Fatalf("unexpected compile error: %v", err)
+		}
+
+		_, err = Run(program, env)
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) {
+			t.Fatalf("expected a RuntimeError, got %T: %v", err, err)
+		}
+		if !errors.Is(err, errInvalid) {
+			t.Errorf("expected the cause to be found, got %v", err)
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		program, err := Compile("1 + 2")
+		if err != nil {
+			t.Fatalf("unexpected compile error: %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err = RunContext(ctx, program, nil)
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) || !errors.Is(err, context.Canceled) {
+			t.Errorf("expected a cancelled RuntimeError, got %T: %v", err, err)
+		}
+	})
+}
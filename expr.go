@@ -1,13 +1,18 @@
 package expr
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"reflect"
 	"time"
 
 	"github.com/mredencom/expr/ast"
+	"github.com/mredencom/expr/checker"
 	"github.com/mredencom/expr/compiler"
 	"github.com/mredencom/expr/env"
 	"github.com/mredencom/expr/lexer"
+	"github.com/mredencom/expr/modules"
 	"github.com/mredencom/expr/parser"
 	"github.com/mredencom/expr/types"
 	"github.com/mredencom/expr/vm"
@@ -19,6 +24,10 @@ type Program struct {
 	envAdapter    *env.Adapter
 	config        *Config
 	variableOrder []string
+	operators     map[string][]*vm.OperatorFunc
+	functions     map[string]*vm.GoFunction
+	modules       *modules.Registry
+	references    *compiler.References
 
 	// Performance metrics
 	compileTime time.Duration
@@ -32,10 +41,18 @@ type Config struct {
 	disableAllBuiltins      bool
 	builtins                map[string]interface{}
 	operators               map[string]int
+	operatorFuncs           map[string][]interface{}
+	constExprs              []string
+	tagName                 string
+	patches                 []ast.Visitor
+	modules                 []*modules.Module
+	policy                  *Policy
+	variables               []string // Untyped variables declared without an environment, see PartialEval
 
 	// Type checking options
 	expectedType       AsKind
 	enableTypeChecking bool
+	resultType         reflect.Type // Go type the result is converted to, see CompileAs
 
 	// Performance options
 	enableCache        bool
@@ -77,73 +94,169 @@ var globalStats = &Statistics{}
 
 // Compile compiles an expression string into a Program
 func Compile(expression string, options ...Option) (*Program, error) {
-	start := time.Now()
+	return compile(expression, newConfig(options), nil)
+}
 
-	// Apply configuration options
+// newConfig returns the default configuration with options applied
+func newConfig(options []Option) *Config {
 	config := &Config{
 		enableCache:        true,
 		enableOptimization: true,
 		maxExecutionTime:   time.Second * 30,
 		builtins:           make(map[string]interface{}),
 		operators:          make(map[string]int),
+		operatorFuncs:      make(map[string][]interface{}),
 	}
 
 	for _, option := range options {
 		option(config)
 	}
+	return config
+}
+
+// compile compiles an expression with a configuration. Variables with known
+// values are replaced by them and the expression is simplified before it is
+// compiled.
+func compile(expression string, config *Config, known map[string]types.Value) (*Program, error) {
+	start := time.Now()
 
-	// Parse the expression
+	if config.policy != nil {
+		if err := config.policy.checkSourceLength(expression); err != nil {
+			return nil, err
+		}
+	}
+
+	// Parse the expression, accepting the registered custom operators
+	precedences := make(map[string]parser.Precedence, len(config.operators))
+	for symbol, precedence := range config.operators {
+		precedences[symbol] = parser.Precedence(precedence)
+	}
 	l := lexer.New(expression)
-	p := parser.New(l)
+	p := parser.NewWithOperators(l, precedences)
 	program := p.ParseProgram()
 
 	if len(p.Errors()) > 0 {
-		return nil, fmt.Errorf("parse errors: %v", p.Errors())
+		return nil, newCompileErrors("parse", expression, p.SourceErrors())
 	}
 
 	if len(program.Statements) == 0 {
 		return nil, fmt.Errorf("no statements found in expression")
 	}
 
-	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
-	if !ok {
-		return nil, fmt.Errorf("expected expression statement")
+	// Apply AST patches before compilation
+	for _, patch := range config.patches {
+		var root ast.Node = program
+		ast.Walk(&root, patch)
+	}
+
+	registry, err := moduleRegistry(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.policy != nil {
+		if err := config.policy.check(program, expression, config, registry); err != nil {
+			return nil, err
+		}
 	}
 
 	// Compile to bytecode
 	comp := compiler.New()
+	comp.SetModuleRegistry(registry)
 
 	// Add custom built-in functions
-	for name := range config.builtins {
+	functions, err := goFunctions(config)
+	if err != nil {
+		return nil, err
+	}
+	for name := range functions {
 		comp.DefineBuiltin(name)
 	}
 
+	// Evaluate pure custom functions with constant arguments at compile time
+	for _, name := range config.constExprs {
+		goFunc, exists := functions[name]
+		if !exists {
+			return nil, fmt.Errorf("const expression %s is not a registered function", name)
+		}
+		comp.DefineConstFunc(name, func(args []types.Value) (types.Value, error) {
+			return goFunc.Call(context.Background(), args, config.tagName)
+		})
+	}
+
+	// Add Go implementations of custom operators
+	operators := make(map[string][]*vm.OperatorFunc, len(config.operatorFuncs))
+	for symbol, funcs := range config.operatorFuncs {
+		if err := comp.DefineOperator(symbol); err != nil {
+			return nil, fmt.Errorf("operator error: %v", err)
+		}
+		for _, fn := range funcs {
+			operatorFunc, err := vm.NewOperatorFunc(fn)
+			if err != nil {
+				return nil, fmt.Errorf("operator error: %s: %v", symbol, err)
+			}
+			operators[symbol] = append(operators[symbol], operatorFunc)
+		}
+	}
+
 	// Add environment if provided
 	if config.env != nil {
 		adapter := env.New()
-		if envMap, ok := config.env.(map[string]interface{}); ok {
+		if envMap, ok := environmentVariables(config.env, config.tagName); ok {
 			err := comp.AddEnvironment(envMap, adapter)
 			if err != nil {
 				return nil, fmt.Errorf("environment error: %v", err)
 			}
 		}
+	} else if len(config.variables) > 0 {
+		declared := make(map[string]interface{}, len(config.variables))
+		for _, name := range config.variables {
+			declared[name] = nil
+		}
+		if err := comp.AddEnvironment(declared, env.New()); err != nil {
+			return nil, fmt.Errorf("environment error: %v", err)
+		}
+	}
+
+	if known != nil {
+		program = comp.PartialEval(program, known)
 	}
 
-	err := comp.Compile(stmt.Expression)
+	// Check the expression against the types of the environment. Without an
+	// environment the checker only infers the type of the result.
+	if config.env != nil || config.resultType != nil {
+		resultType, errs := checkTypes(program, config, registry)
+		if config.env != nil && len(errs) > 0 {
+			return nil, newCompileErrors("type check", expression, errs)
+		}
+		if config.resultType != nil && len(errs) == 0 {
+			if err := validateResultType(resultType, config.resultType, config.tagName); err != nil {
+				return nil, CompileErrors{{Message: err.Error(), Phase: "type validation", Cause: err}}
+			}
+		}
+	}
+
+	err = comp.Compile(program)
 	if err != nil {
-		return nil, fmt.Errorf("compilation error: %v", err)
+		var sourceErr *lexer.SourceError
+		if !errors.As(err, &sourceErr) {
+			sourceErr = &lexer.SourceError{Message: err.Error(), Cause: err}
+		}
+		return nil, CompileErrors{newCompileError("compilation", expression, sourceErr)}
 	}
 
 	// Perform type checking if enabled
 	if config.enableTypeChecking {
-		err = validateExpectedType(stmt.Expression, config.expectedType)
+		result := program.Statements[len(program.Statements)-1].(*ast.ExpressionStatement)
+		err = validateExpectedType(result.Expression, config.expectedType)
 		if err != nil {
-			return nil, fmt.Errorf("type validation error: %v", err)
+			return nil, CompileErrors{{Message: err.Error(), Phase: "type validation", Cause: err}}
 		}
 	}
 
 	bytecode := comp.Bytecode()
 	variableOrder := comp.GetVariableOrder()
+	references := comp.References(program)
 	compileTime := time.Since(start)
 
 	// Update global statistics
@@ -159,6 +272,10 @@ func Compile(expression string, options ...Option) (*Program, error) {
 		envAdapter:    env.New(),
 		config:        config,
 		variableOrder: variableOrder,
+		operators:     operators,
+		functions:     functions,
+		modules:       registry,
+		references:    references,
 		compileTime:   compileTime,
 		source:        expression,
 	}, nil
@@ -173,56 +290,87 @@ func Run(program *Program, environment interface{}) (interface{}, error) {
 	return result.Value, nil
 }
 
+// RunContext executes a compiled program, stopping as soon as ctx is cancelled
+// or its deadline passes
+func RunContext(ctx context.Context, program *Program, environment interface{}) (interface{}, error) {
+	result, err := RunWithResultContext(ctx, program, environment)
+	if err != nil {
+		return nil, err
+	}
+	return result.Value, nil
+}
+
 // RunWithResult executes a program and returns detailed result information
 func RunWithResult(program *Program, environment interface{}) (*Result, error) {
+	return RunWithResultContext(context.Background(), program, environment)
+}
+
+// RunWithResultContext is like RunWithResult but honours cancellation of ctx.
+// The configured timeout, if any, is applied on top of ctx.
+func RunWithResultContext(ctx context.Context, program *Program, environment interface{}) (*Result, error) {
+	result, execTime, err := runProgram(ctx, program, environment)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert result to Go value
+	var goValue interface{}
+	if result != nil {
+		goValue = convertTypesValueToGoValue(result)
+	}
+
+	return &Result{
+		Value:         goValue,
+		Type:          inferResultType(result),
+		ExecutionTime: execTime,
+		MemoryUsed:    0, // TODO: implement memory tracking
+	}, nil
+}
+
+// runProgram executes a program on a pooled VM and returns its result as an
+// expression value together with the execution time
+func runProgram(ctx context.Context, program *Program, environment interface{}) (types.Value, time.Duration, error) {
 	start := time.Now()
 
+	if program.config.maxExecutionTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, program.config.maxExecutionTime)
+		defer cancel()
+	}
+
 	// Get VM from pool instead of creating new one
 	machine := vm.GlobalVMPool.Get()
 	defer vm.GlobalVMPool.Put(machine) // Return to pool when done
 
 	// Set up the VM with program data
 	machine.SetConstants(program.bytecode.Constants)
+	machine.SetTagName(program.config.tagName)
+	machine.SetOperators(program.operators)
+	machine.SetModules(program.modules)
+	machine.SetSourceMap(program.bytecode.Positions)
 
 	if environment != nil {
-		if envMap, ok := environment.(map[string]interface{}); ok {
+		if envMap, ok := environmentVariables(environment, program.config.tagName); ok {
 			err := machine.SetEnvironment(envMap, program.variableOrder)
 			if err != nil {
-				return nil, fmt.Errorf("environment setup error: %v", err)
+				return nil, 0, &RuntimeError{Message: "environment setup error", Cause: err}
 			}
 		}
 	}
 
 	// Set custom builtins if any
-	if len(program.config.builtins) > 0 {
-		for name, fn := range program.config.builtins {
-			machine.SetCustomBuiltin(name, fn)
-		}
-	}
-
-	// Execute with timeout if configured
-	var result types.Value
-	var execErr error
-
-	if program.config.maxExecutionTime > 0 {
-		done := make(chan struct{})
-		go func() {
-			defer close(done)
-			result, execErr = machine.RunInstructionsWithResult(program.bytecode.Instructions)
-		}()
-
-		select {
-		case <-done:
-			// Execution completed
-		case <-time.After(program.config.maxExecutionTime):
-			return nil, fmt.Errorf("execution timeout after %v", program.config.maxExecutionTime)
-		}
-	} else {
-		result, execErr = machine.RunInstructionsWithResult(program.bytecode.Instructions)
+	for name, fn := range program.functions {
+		machine.SetCustomBuiltin(name, fn)
 	}
 
+	// Execute on the calling goroutine; the VM stops cooperatively once ctx is done,
+	// so it is never still running when it goes back to the pool
+	result, execErr := machine.RunInstructionsWithContext(ctx, program.bytecode.Instructions)
 	if execErr != nil {
-		return nil, fmt.Errorf("execution error: %v", execErr)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, 0, contextError(program, ctxErr)
+		}
+		return nil, 0, newRuntimeError(program.source, execErr)
 	}
 
 	execTime := time.Since(start)
@@ -235,18 +383,27 @@ func RunWithResult(program *Program, environment interface{}) (*Result, error) {
 		globalStats.TotalExecutions,
 	)
 
-	// Convert result to Go value
-	var goValue interface{}
-	if result != nil {
-		goValue = convertTypesValueToGoValue(result)
+	return result, execTime, nil
+}
+
+// environmentVariables returns the top-level variables of an environment, which
+// is either a map or a struct (or pointer to one) whose fields become variables
+func environmentVariables(environment interface{}, tagName string) (map[string]interface{}, bool) {
+	if envMap, ok := environment.(map[string]interface{}); ok {
+		return envMap, true
 	}
+	return env.StructEnvironment(environment, tagName)
+}
 
-	return &Result{
-		Value:         goValue,
-		Type:          inferResultType(result),
-		ExecutionTime: execTime,
-		MemoryUsed:    0, // TODO: implement memory tracking
-	}, nil
+// contextError describes why an execution was stopped by its context
+func contextError(program *Program, err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		if program.config.maxExecutionTime > 0 {
+			return &RuntimeError{Message: fmt.Sprintf("execution timeout after %v", program.config.maxExecutionTime), Cause: err}
+		}
+		return &RuntimeError{Message: "execution timeout", Cause: err}
+	}
+	return &RuntimeError{Message: "execution cancelled", Cause: err}
 }
 
 // Eval is a convenience function that compiles and runs an expression in one call
@@ -302,13 +459,23 @@ func WithBuiltin(name string, fn interface{}) Option {
 	}
 }
 
-// WithOperator adds a custom operator with precedence
+// WithOperator adds a custom operator with precedence. Implementations are
+// registered with WithOperatorFunc or Operators.
 func WithOperator(op string, precedence int) Option {
 	return func(c *Config) {
 		c.operators[op] = precedence
 	}
 }
 
+// WithOperatorFunc adds a Go implementation of an operator. Several
+// implementations may be registered for the same operator; the one matching
+// the operand types is called. Built-in arithmetic and comparison operators
+// such as + fall back to their usual behaviour when no implementation
+// matches; the other built-in operators cannot be overloaded.
+func WithOperatorFunc(op string, precedence int, fn interface{}) Option {
+	return Operators(map[string]Operator{op: {Symbol: op, Precedence: precedence, Func: fn}})
+}
+
 // EnableCache enables instruction caching
 func EnableCache() Option {
 	return func(c *Config) {
@@ -384,6 +551,57 @@ func (p *Program) Source() string {
 	return p.source
 }
 
+// SourceMap returns the source positions of the program instructions
+func (p *Program) SourceMap() vm.SourceMap {
+	return p.bytecode.Positions
+}
+
+// PositionAt returns the source position of the instruction at offset ip
+func (p *Program) PositionAt(ip int) (lexer.Position, bool) {
+	return p.bytecode.Positions.Lookup(ip)
+}
+
+// Disassembly returns the decoded instructions of the program followed by
+// the lambda and placeholder bodies in its constants, with constant values,
+// variable names and source positions resolved
+func (p *Program) Disassembly() ([]vm.Code, error) {
+	return vm.Disassemble(p.bytecode, p.variableOrder)
+}
+
+// Disassemble returns an annotated listing of the program instructions: the
+// offset, the source position where it changes, the opcode with its
+// operands and what the operands refer to
+func (p *Program) Disassemble() string {
+	codes, err := p.Disassembly()
+	if err != nil {
+		return err.Error() + "\n"
+	}
+	return vm.Listing(codes)
+}
+
+// Variables returns the environment variables the program reads, in order
+func (p *Program) Variables() []string {
+	return append([]string(nil), p.references.Variables...)
+}
+
+// Paths returns the member paths the program reads from its variables, such
+// as user.address.city, in order. Variables used as a whole, e.g. passed to
+// a function, are listed by their name.
+func (p *Program) Paths() []string {
+	return append([]string(nil), p.references.Paths...)
+}
+
+// Functions returns the builtin and custom functions the program calls, in
+// order. Module functions are listed as module.function.
+func (p *Program) Functions() []string {
+	return append([]string(nil), p.references.Functions...)
+}
+
+// Modules returns the modules whose functions the program calls, in order
+func (p *Program) Modules() []string {
+	return append([]string(nil), p.references.Modules...)
+}
+
 // CompileTime returns the compilation time
 func (p *Program) CompileTime() time.Duration {
 	return p.compileTime
@@ -414,6 +632,64 @@ func updateAverage(currentAvg time.Duration, newValue time.Duration, count int64
 	return time.Duration((int64(currentAvg)*(count-1) + int64(newValue)) / count)
 }
 
+// checkTypes type checks an expression against the variables of the
+// environment and the signatures of the custom and module functions, and
+// returns the type of its result
+func checkTypes(program *ast.Program, config *Config, registry *modules.Registry) (types.TypeInfo, []*lexer.SourceError) {
+	variables := make(map[string]types.TypeInfo)
+	for _, field := range env.TypeInfoOfValue(config.env, config.tagName).Fields {
+		variables[field.Name] = field.Type
+	}
+
+	functions := make(map[string]*checker.FunctionInfo)
+	for name, fn := range config.builtins {
+		if funcInfo, ok := functionInfo(name, fn, config.tagName); ok {
+			functions[name] = funcInfo
+		}
+	}
+
+	operators := make([]string, 0, len(config.operatorFuncs))
+	for symbol := range config.operatorFuncs {
+		operators = append(operators, symbol)
+	}
+
+	c := checker.New().Lenient().WithEnvironment(variables).WithFunctions(functions).WithOperators(operators).
+		WithModules(moduleFunctionInfos(registry))
+	c.Check(program)
+	return c.ResultType(), c.SourceErrors()
+}
+
+// goFunctions wraps the custom functions of a configuration so that they
+// can be called with expression values
+func goFunctions(config *Config) (map[string]*vm.GoFunction, error) {
+	functions := make(map[string]*vm.GoFunction, len(config.builtins))
+	for name, fn := range config.builtins {
+		goFunc, err := vm.NewGoFunction(name, fn)
+		if err != nil {
+			return nil, fmt.Errorf("function error: %v", err)
+		}
+		functions[name] = goFunc
+	}
+	return functions, nil
+}
+
+// functionInfo describes the signature of a Go function for type checking
+func functionInfo(name string, fn interface{}, tagName string) (*checker.FunctionInfo, bool) {
+	t := reflect.TypeOf(fn)
+	if t == nil || t.Kind() != reflect.Func {
+		return nil, false
+	}
+
+	signature := env.SignatureOf(t, tagName)
+	funcInfo := &checker.FunctionInfo{
+		Name:     name,
+		Params:   signature.Params,
+		Returns:  signature.Returns,
+		Variadic: signature.Variadic,
+	}
+	return funcInfo, true
+}
+
 // validateExpectedType performs compile-time type validation
 func validateExpectedType(expr ast.Expression, expectedType AsKind) error {
 	if expectedType == AsAny {
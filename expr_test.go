@@ -1,8 +1,16 @@
 package expr
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/mredencom/expr/vm"
 )
 
 func TestCompile(t *testing.T) {
@@ -163,6 +171,96 @@ func TestRunWithResult(t *testing.T) {
 	}
 }
 
+func TestRunContext(t *testing.T) {
+	numbers := make([]int, 10000)
+	for i := range numbers {
+		numbers[i] = i
+	}
+	env := map[string]interface{}{"numbers": numbers}
+
+	program, err := Compile("numbers | filter(# > 10) | map(# * 2) | count", Env(env))
+	if err != nil {
+		t.Fatalf("Compilation error: %v", err)
+	}
+
+	t.Run("Completes", func(t *testing.T) {
+		result, err := RunContext(context.Background(), program, env)
+		if err != nil {
+			t.Fatalf("Runtime error: %v", err)
+		}
+		if result != int64(9989) {
+			t.Errorf("Expected 9989, got %v", result)
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := RunContext(ctx, program, env)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected context.Canceled, got %v", err)
+		}
+	})
+
+	t.Run("DeadlineExceeded", func(t *testing.T) {
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+
+		_, err := RunContext(ctx, program, env)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "timeout") {
+			t.Errorf("Expected timeout error, got %v", err)
+		}
+	})
+
+	t.Run("DeadlineDuringRun", func(t *testing.T) {
+		// The pipeline takes about a second; the deadline must stop it partway
+		large := map[string]interface{}{"numbers": make([]int, 3000000)}
+		long, err := Compile("numbers | map(# * 2) | filter(# >= 0) | count", Env(large))
+		if err != nil {
+			t.Fatalf("Compilation error: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err = RunContext(ctx, long, large)
+		elapsed := time.Since(start)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+		}
+		if elapsed > 500*time.Millisecond {
+			t.Errorf("Expected the run to stop soon after the deadline, took %v", elapsed)
+		}
+	})
+
+	t.Run("ConfiguredTimeout", func(t *testing.T) {
+		timed, err := Compile("numbers | filter(# > 10) | count", Env(env), WithTimeout(time.Nanosecond))
+		if err != nil {
+			t.Fatalf("Compilation error: %v", err)
+		}
+
+		_, err = Run(timed, env)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+		}
+	})
+
+	t.Run("PooledVMReusable", func(t *testing.T) {
+		// A cancelled run must leave the pooled VM usable for the next run
+		result, err := Run(program, env)
+		if err != nil {
+			t.Fatalf("Runtime error: %v", err)
+		}
+		if result != int64(9989) {
+			t.Errorf("Expected 9989, got %v", result)
+		}
+	})
+}
+
 func TestEvalWithResult(t *testing.T) {
 	result, err := EvalWithResult("5 + 3", nil)
 	if err != nil {
@@ -432,6 +530,491 @@ func TestComplexExpressions(t *testing.T) {
 	}
 }
 
+func TestShortCircuit(t *testing.T) {
+	env := map[string]interface{}{
+		"user":     nil,
+		"admin":    map[string]interface{}{"age": 42},
+		"zero":     0,
+		"fallback": "default",
+		"people": []interface{}{
+			nil,
+			map[string]interface{}{"age": 30},
+			map[string]interface{}{"age": 12},
+		},
+	}
+
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		// The right operand would fail if it were evaluated
+		{"user != null && user.age > 18", "false"},
+		{"user == null || user.age > 18", "true"},
+		{"admin != null && admin.age > 18", "true"},
+		{"zero != 0 && 10 / zero > 1", "false"},
+		{"zero == 0 || 10 / zero > 1", "true"},
+		{"user ?? fallback", "default"},
+		{"admin.age ?? 10 / zero", "42"},
+		{"user ?? null ?? fallback", "default"},
+		{"1 && \"yes\"", "true"},
+
+		// Placeholder expressions
+		{"people | filter(# != null && #.age > 18)", "[map[age:30]]"},
+		{"people | filter(# == null || #.age < 18) | count", "2"},
+		{"people | map(# ?? 0)", "[0 map[age:30] map[age:12]]"},
+
+		// Lambdas
+		{"people | filter(p => p != null && p.age > 18)", "[map[age:30]]"},
+		{"people | map(p => p == null || p.age < 18)", "[true false true]"},
+		{"people | filter(p => p != null) | map(p => [1, 2] | map(n => n + p.age))", "[[31 32] [13 14]]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			result, err := Eval(tt.expression, env)
+			if err != nil {
+				t.Fatalf("Eval error: %v", err)
+			}
+
+			if fmt.Sprint(result) != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+
+	if _, err := Eval("zero == 0 && 10 / zero > 1", env); err == nil {
+		t.Error("Expected division by zero when the right operand runs")
+	}
+}
+
+func TestLambdaExpressions(t *testing.T) {
+	env := map[string]interface{}{
+		"numbers": []int{1, 2, 3, 4, 5},
+		"offset":  10,
+	}
+
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{"numbers | filter(x => x > 3)", "[4 5]"},
+		{"numbers | map(x => x * 2)", "[2 4 6 8 10]"},
+		{"numbers | map(x => x + offset)", "[11 12 13 14 15]"},
+		{"numbers | reduce((acc, x) => acc + x)", "15"},
+		{"filter(numbers, x => x % 2 == 0)", "[2 4]"},
+		{"reduce(numbers, (acc, x) => acc * x, 1)", "120"},
+		{"(x => x * x)(7)", "49"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			result, err := Eval(tt.expression, env)
+			if err != nil {
+				t.Fatalf("Eval error: %v", err)
+			}
+
+			if fmt.Sprint(result) != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestLetBindings(t *testing.T) {
+	env := map[string]interface{}{
+		"items": []map[string]interface{}{{"price": 10.0}, {"price": 30.0}},
+		"rate":  2,
+	}
+
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{"let subtotal = sum(items | map(#.price)); let tax = subtotal * 0.2; subtotal + tax", "48"},
+		{"let r = rate; [1, 2, 3] | map(# * r)", "[2 4 6]"},
+		{"let r = rate + 1; filter([1, 2, 3, 4], x => x >= r)", "[3 4]"},
+		{"let n = len(items); n > 1 ? 'many' : 'few';", "many"},
+		{"let count = len(items); let max = 100; count * max", "200"},
+		{"let rate = rate * 10; items | map(#.price * rate)", "[200 600]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			program, err := Compile(tt.expression, Env(env))
+			if err != nil {
+				t.Fatalf("Compile error: %v", err)
+			}
+
+			// Bindings do not leak between runs of the program
+			for i := 0; i < 2; i++ {
+				result, err := Run(program, env)
+				if err != nil {
+					t.Fatalf("Run error: %v", err)
+				}
+				if fmt.Sprint(result) != tt.expected {
+					t.Errorf("Expected %v, got %v", tt.expected, result)
+				}
+			}
+		})
+	}
+
+	if _, err := Compile("let label = 'x'; label * rate", Env(env)); err == nil || !strings.Contains(err.Error(), "type check error") {
+		t.Errorf("expected let binding types to be checked, got %v", err)
+	}
+	if _, err := Compile("let r = 1; let r = 2; r", Env(env)); err == nil || !strings.Contains(err.Error(), "name is already defined") {
+		t.Errorf("expected redefinition error, got %v", err)
+	}
+}
+
+func TestGoFunctions(t *testing.T) {
+	type point struct{ X, Y int }
+	type userKey struct{}
+	errNegative := errors.New("negative value")
+
+	env := map[string]interface{}{
+		"xs":    []int{1, 2, 3},
+		"p":     map[string]interface{}{"X": 3, "Y": 4},
+		"names": map[string]interface{}{"a": "ann"},
+	}
+	options := []Option{
+		Env(env),
+		WithBuiltin("double", func(x int) int { return 2 * x }),
+		WithBuiltin("sum", func(base float64, xs ...float64) float64 {
+			for _, x := range xs {
+				base += x
+			}
+			return base
+		}),
+		WithBuiltin("total", func(xs []int64) int64 {
+			var total int64
+			for _, x := range xs {
+				total += x
+			}
+			return total
+		}),
+		WithBuiltin("norm", func(p point) int { return p.X*p.X + p.Y*p.Y }),
+		WithBuiltin("keys", func(m map[string]string) int { return len(m) }),
+		WithBuiltin("upper", func(s string) string { return "<" + s + ">" }),
+		WithBuiltin("user", func(ctx context.Context, id int) string {
+			return fmt.Sprintf("%v-%d", ctx.Value(userKey{}), id)
+		}),
+		WithBuiltin("sqrtOf", func(x float64) (float64, error) {
+			if x < 0 {
+				return 0, errNegative
+			}
+			return math.Sqrt(x), nil
+		}),
+	}
+
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{"double(21)", "42"},
+		{"sum(1, 2.5, 3)", "6.5"},
+		{"sum(1)", "1"},
+		{"total(xs)", "6"},
+		{"norm(p)", "25"},
+		{"keys(names)", "1"},
+		{"user(7)", "ann-7"},
+		{"sqrtOf(16)", "4"},
+		{"xs | map(v => double(v))", "[2 4 6]"},
+		{`upper("abc")`, "<abc>"},
+		{`upper(names.a)`, "<ann>"},
+	}
+
+	ctx := context.WithValue(context.Background(), userKey{}, "ann")
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			program, err := Compile(tt.expression, options...)
+			if err != nil {
+				t.Fatalf("Compile error: %v", err)
+			}
+			result, err := RunContext(ctx, program, env)
+			if err != nil {
+				t.Fatalf("Run error: %v", err)
+			}
+			if fmt.Sprint(result) != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+
+	program, err := Compile("sqrtOf(-1)", options...)
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+	_, err = Run(program, env)
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) || !errors.Is(err, errNegative) {
+		t.Errorf("Expected a runtime error wrapping the function error, got %v", err)
+	}
+
+	if _, err := Compile("user('x')", options...); err == nil || !strings.Contains(err.Error(), "function user argument 1 type mismatch") {
+		t.Errorf("Expected the context parameter to be skipped when checking arguments, got %v", err)
+	}
+	if _, err := Compile("f(1)", WithBuiltin("f", 42)); err == nil || !strings.Contains(err.Error(), "f must be a function") {
+		t.Errorf("Expected an error for a non-function builtin, got %v", err)
+	}
+}
+
+// methodOrder is an environment value with methods for TestGoMethods
+type methodOrder struct {
+	Prices []float64
+	Roles  methodRoles
+	visits int
+}
+
+// methodRoles is a named slice type with methods
+type methodRoles []string
+
+var errNoLimit = errors.New("limit must be positive")
+
+func (o methodOrder) Total() float64 {
+	total := 0.0
+	for _, price := range o.Prices {
+		total += price
+	}
+	return total
+}
+
+func (o methodOrder) Within(limit float64) (bool, error) {
+	if limit <= 0 {
+		return false, errNoLimit
+	}
+	return o.Total() <= limit, nil
+}
+
+func (o *methodOrder) Visit() int {
+	o.visits++
+	return o.visits
+}
+
+func (r methodRoles) Has(role string) bool {
+	for _, candidate := range r {
+		if candidate == role {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGoMethods(t *testing.T) {
+	order := &methodOrder{Prices: []float64{40, 70.5}, Roles: methodRoles{"admin"}}
+	env := map[string]interface{}{"order": order, "snapshot": *order}
+	options := []Option{
+		Env(env),
+		WithBuiltin("discount", func(o methodOrder, rate float64) float64 { return o.Total() * (1 - rate) }),
+	}
+
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{`order.Total() > 100`, "true"},
+		{`order.Roles.Has("admin") && !order.Roles.Has("guest")`, "true"},
+		{`order.Within(200)`, "true"},
+		{`order.Visit() + order.Visit()`, "3"},
+		{`snapshot.Visit()`, "1"},
+		{`discount(order, 0.5)`, "55.25"},
+		{`[order, snapshot] | map(#.Total())`, "[110.5 110.5]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			program, err := Compile(tt.expression, options...)
+			if err != nil {
+				t.Fatalf("Compile error: %v", err)
+			}
+			result, err := Run(program, env)
+			if err != nil {
+				t.Fatalf("Run error: %v", err)
+			}
+			if fmt.Sprint(result) != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+
+	// Pointer receivers update the value in the environment
+	if order.visits != 2 {
+		t.Errorf("Expected 2 visits, got %d", order.visits)
+	}
+
+	program, err := Compile(`order.Within(0)`, options...)
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+	if _, err := Run(program, env); !errors.Is(err, errNoLimit) {
+		t.Errorf("Expected a runtime error wrapping the method error, got %v", err)
+	}
+
+	errorTests := map[string]string{
+		`order.Within("high")`: "function methodOrder.Within argument 1 type mismatch: expected float64, got string",
+		`order.Total(1)`:       "function methodOrder.Total expects 0 arguments, got 1",
+		`order.Cancel()`:       "order.Cancel: unknown method",
+	}
+	for expression, expected := range errorTests {
+		if _, err := Compile(expression, options...); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected error containing %q, got %v", expression, expected, err)
+		}
+	}
+}
+
+func TestImports(t *testing.T) {
+	env := map[string]interface{}{"name": " ann ", "x": -3}
+
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{`import "strings" as s; s.upper(name)`, " ANN "},
+		{`from math import sqrt, pow; sqrt(pow(x, 2))`, "3"},
+		{`from strings import upper, trim; upper(trim(name))`, "ANN"},
+		{`import "math" as m; [1, 4, 9] | map(v => m.sqrt(v))`, "[1 2 3]"},
+		{`math.abs(x) + strings.length("abc")`, "6"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			result, err := Eval(tt.expression, env)
+			if err != nil {
+				t.Fatalf("Eval error: %v", err)
+			}
+			if fmt.Sprint(result) != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+
+	_, err := Compile(`import "strings" as s; s.shout(name)`, Env(env))
+	if err == nil || !strings.Contains(err.Error(), "line 1, column 31: function 'shout' not found in module 'strings'") {
+		t.Errorf("expected unknown function error at compile time, got %v", err)
+	}
+}
+
+type testMoney struct {
+	Amount   float64
+	Currency string
+}
+
+type testVector struct {
+	X, Y int
+}
+
+func TestCustomOperators(t *testing.T) {
+	addMoney := func(a, b testMoney) (testMoney, error) {
+		if a.Currency != b.Currency {
+			return testMoney{}, fmt.Errorf("cannot add %s to %s", b.Currency, a.Currency)
+		}
+		return testMoney{Amount: a.Amount + b.Amount, Currency: a.Currency}, nil
+	}
+	addVector := func(a, b testVector) testVector {
+		return testVector{X: a.X + b.X, Y: a.Y + b.Y}
+	}
+	scale := func(a testMoney, factor float64) testMoney {
+		return testMoney{Amount: a.Amount * factor, Currency: a.Currency}
+	}
+	between := func(value float64, bounds []float64) bool {
+		return len(bounds) == 2 && value >= bounds[0] && value <= bounds[1]
+	}
+
+	env := map[string]interface{}{
+		"price":    testMoney{Amount: 10, Currency: "EUR"},
+		"shipping": testMoney{Amount: 2.5, Currency: "EUR"},
+		"refund":   testMoney{Amount: 1, Currency: "USD"},
+		"v":        testVector{X: 1, Y: 2},
+		"w":        testVector{X: 3, Y: 4},
+		"age":      30,
+		"ages":     []int{10, 30, 70},
+	}
+
+	options := []Option{
+		Env(env),
+		Operators(map[string]Operator{
+			"+":       {Symbol: "+", Func: addMoney},
+			"*":       {Symbol: "*", Func: scale},
+			"between": {Symbol: "between", Precedence: 7, Func: between},
+			"<>":      {Symbol: "<>", Precedence: 7, Func: func(a, b int) bool { return a != b }},
+		}),
+		WithOperatorFunc("+", 0, addVector),
+	}
+
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{"price + shipping", "map[Amount:12.5 Currency:EUR]"},
+		{"(price + shipping).Amount", "12.5"},
+		{"v + w", "map[X:4 Y:6]"},
+		{"price * 2", "map[Amount:20 Currency:EUR]"},
+		{"1 + 2 * 3", "7"},
+		{"\"a\" + \"b\"", "ab"},
+		{"age between [18, 65]", "true"},
+		{"age + 40 between [18, 65]", "false"},
+		{"age <> 30 || age <> 31", "true"},
+		{"ages | filter(# <> 30)", "[10 70]"},
+		{"ages | map(# + 1)", "[11 31 71]"},
+		{"ages | filter(a => a between [18, 65])", "[30]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			program, err := Compile(tt.expression, options...)
+			if err != nil {
+				t.Fatalf("Compile error: %v", err)
+			}
+
+			result, err := Run(program, env)
+			if err != nil {
+				t.Fatalf("Run error: %v", err)
+			}
+
+			if fmt.Sprint(result) != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+
+	t.Run("implementation error", func(t *testing.T) {
+		program, err := Compile("price + refund", options...)
+		if err != nil {
+			t.Fatalf("Compile error: %v", err)
+		}
+		if _, err := Run(program, env); err == nil || !strings.Contains(err.Error(), "cannot add USD to EUR") {
+			t.Errorf("Expected currency error, got %v", err)
+		}
+	})
+
+	t.Run("no matching implementation", func(t *testing.T) {
+		program, err := Compile("price <> shipping", options...)
+		if err != nil {
+			t.Fatalf("Compile error: %v", err)
+		}
+		if _, err := Run(program, env); err == nil || !strings.Contains(err.Error(), "no implementation of operator <>") {
+			t.Errorf("Expected missing implementation error, got %v", err)
+		}
+	})
+
+	t.Run("invalid implementation", func(t *testing.T) {
+		if _, err := Compile("age", WithOperatorFunc("between", 7, func(a int) bool { return true })); err == nil {
+			t.Error("Expected error for an implementation with one argument")
+		}
+		if _, err := Compile("age", WithOperatorFunc("&&", 6, func(a, b bool) bool { return a })); err == nil {
+			t.Error("Expected error for overloading &&")
+		}
+	})
+
+	t.Run("built-in operator without fallback", func(t *testing.T) {
+		for _, symbol := range []string{"**", "in"} {
+			_, err := Compile("2 "+symbol+" [2]", WithOperatorFunc(symbol, 0, addVector))
+			if err == nil || !strings.Contains(err.Error(), "operator "+symbol+" cannot be overloaded") {
+				t.Errorf("Expected overloading %s to be rejected, got %v", symbol, err)
+			}
+		}
+	})
+}
+
 // Benchmark tests
 func BenchmarkCompile(b *testing.B) {
 	expression := "x + y * z"
@@ -443,6 +1026,69 @@ func BenchmarkCompile(b *testing.B) {
 	}
 }
 
+type testUser struct {
+	Name    string
+	Age     int
+	Address *testAddress `expr:"address"`
+}
+
+type testAddress struct {
+	City string `expr:"city"`
+}
+
+func TestCompileTypeChecking(t *testing.T) {
+	env := map[string]interface{}{
+		"user":  testUser{Name: "Ann", Age: 30, Address: &testAddress{City: "Oslo"}},
+		"order": map[string]interface{}{"total": 99.5, "items": []string{"a", "b"}},
+		"limit": 100,
+	}
+	options := []Option{
+		Env(env),
+		Tags(Tag{Name: "expr"}),
+		WithBuiltin("discount", func(total float64, percent int) float64 {
+			return total * float64(100-percent) / 100
+		}),
+	}
+
+	valid := []string{
+		`user.Age >= 18 && user.address.city == "Oslo"`,
+		`order.total < limit`,
+		`order.missing ?? 0`,
+		`filter(order.items, # != "a")`,
+	}
+	for _, expression := range valid {
+		if _, err := Compile(expression, options...); err != nil {
+			t.Errorf("%s: unexpected error: %v", expression, err)
+		}
+	}
+
+	invalid := []struct {
+		expression string
+		expected   string
+	}{
+		{`user.Agee > 18`, "line 1, column 6: user.Agee: unknown field"},
+		{`user.address.zip == ""`, "user.address.zip: unknown field"},
+		{`user.Name == 18`, "cannot compare string with int"},
+		{`order.total > "high"`, "cannot compare float64 with string"},
+		{`discount(user.Name, 10)`, "function discount argument 1 type mismatch"},
+	}
+	for _, tt := range invalid {
+		_, err := Compile(tt.expression, options...)
+		if err == nil {
+			t.Errorf("%s: expected type check error", tt.expression)
+			continue
+		}
+		if !strings.Contains(err.Error(), "type check error") || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.expression, tt.expected, err)
+		}
+	}
+
+	// Without an environment nothing is known about the variables
+	if _, err := Compile(`user.Agee > 18`, AllowUndefinedVariables()); err != nil && strings.Contains(err.Error(), "type check") {
+		t.Errorf("unexpected type check without environment: %v", err)
+	}
+}
+
 func BenchmarkRun(b *testing.B) {
 	program, err := Compile("x + y * z")
 	if err != nil {
@@ -479,3 +1125,97 @@ func BenchmarkEval(b *testing.B) {
 		}
 	}
 }
+
+func TestProgramReferences(t *testing.T) {
+	env := map[string]interface{}{
+		"user":  map[string]interface{}{"name": "Ann", "age": 30},
+		"items": []map[string]interface{}{{"name": "tea", "price": 2}},
+		"key":   "name",
+		"limit": 18,
+		"n":     4,
+	}
+	tests := []struct {
+		expression string
+		variables  []string
+		paths      []string
+		functions  []string
+		modules    []string
+	}{
+		{
+			`user.address.city == "Oslo" && user.age >= limit`,
+			[]string{"limit", "user"},
+			[]string{"limit", "user.address.city", "user.age"},
+			nil,
+			nil,
+		},
+		{
+			`let s = user.name; upper(s) + user["nick"] + user[key]`,
+			[]string{"key", "user"},
+			[]string{"key", "user", "user.name", "user.nick"},
+			[]string{"upper"},
+			nil,
+		},
+		{
+			`items | filter(x => x.price > limit) | map(x => x.name)`,
+			[]string{"items", "limit"},
+			[]string{"items", "limit"},
+			[]string{"filter", "map"},
+			nil,
+		},
+		{
+			`import "strings" as s; s.upper(user.name) + string(math.sqrt(n))`,
+			[]string{"n", "user"},
+			[]string{"n", "user.name"},
+			[]string{"math.sqrt", "string", "strings.upper"},
+			[]string{"math", "strings"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			program, err := Compile(tt.expression, Env(env))
+			if err != nil {
+				t.Fatalf("Compile error: %v", err)
+			}
+			if !reflect.DeepEqual(program.Variables(), tt.variables) {
+				t.Errorf("Expected variables %v, got %v", tt.variables, program.Variables())
+			}
+			if !reflect.DeepEqual(program.Paths(), tt.paths) {
+				t.Errorf("Expected paths %v, got %v", tt.paths, program.Paths())
+			}
+			if !reflect.DeepEqual(program.Functions(), tt.functions) {
+				t.Errorf("Expected functions %v, got %v", tt.functions, program.Functions())
+			}
+			if !reflect.DeepEqual(program.Modules(), tt.modules) {
+				t.Errorf("Expected modules %v, got %v", tt.modules, program.Modules())
+			}
+		})
+	}
+}
+
+func TestProgramDisassemble(t *testing.T) {
+	env := map[string]interface{}{"items": []int{1, 2, 3}, "k": 2, "name": "tea"}
+	program, err := Compile(`let y = 3; items | map(x => x + y + k) | sum() > len(name)`, Env(env))
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+
+	codes, err := program.Disassembly()
+	if err != nil {
+		t.Fatalf("Disassembly error: %v", err)
+	}
+	if len(codes) != 2 || codes[0].Name != "main" || !strings.HasPrefix(codes[1].Name, "function constant") {
+		t.Fatalf("Expected main and the lambda body, got %+v", codes)
+	}
+	first := codes[0].Instructions[0]
+	if first.Op != vm.OpConstant || first.Comment != "3" || first.Pos.Line != 1 || first.Pos.Column != 9 {
+		t.Errorf("Expected the let value first, got %+v", first)
+	}
+
+	listing := program.Disassemble()
+	for _, want := range []string{"; y\n", "; items\n", `; "map"`, "; len, 1 argument\n", "; x\n", "; k\n"} {
+		if !strings.Contains(listing, want) {
+			t.Errorf("Expected listing to contain %q, got:\n%s", want, listing)
+		}
+	}
+}
@@ -35,11 +35,14 @@ func (fe *FastExecution) FastRun(program *Program, environment interface{}) (int
 	fe.machine.SetConstants(program.bytecode.Constants)
 
 	// Set environment if needed
+	fe.machine.SetTagName(program.config.tagName)
+	fe.machine.SetOperators(program.operators)
+	fe.machine.SetSourceMap(program.bytecode.Positions)
 	if environment != nil {
-		if envMap, ok := environment.(map[string]interface{}); ok {
+		if envMap, ok := environmentVariables(environment, program.config.tagName); ok {
 			err := fe.machine.SetEnvironment(envMap, program.variableOrder)
 			if err != nil {
-				return nil, err
+				return nil, &RuntimeError{Message: "environment setup error", Cause: err}
 			}
 		}
 	}
@@ -47,7 +50,7 @@ func (fe *FastExecution) FastRun(program *Program, environment interface{}) (int
 	// Execute instructions directly
 	err := fe.machine.RunInstructions(program.bytecode.Instructions)
 	if err != nil {
-		return nil, err
+		return nil, newRuntimeError(program.source, err)
 	}
 
 	// Get result with minimal conversion
@@ -0,0 +1,29 @@
+package lexer
+
+import "fmt"
+
+// SourceError is an error located at a span of the source code
+type SourceError struct {
+	Pos     Position // start of the offending span
+	End     Position // end of the offending span, invalid when unknown
+	Message string
+	Cause   error // underlying error, if any
+}
+
+// NewSourceError creates an error covering the span from pos to end
+func NewSourceError(pos, end Position, message string) *SourceError {
+	return &SourceError{Pos: pos, End: end, Message: message}
+}
+
+// Error returns the message prefixed with the position
+func (e *SourceError) Error() string {
+	if !e.Pos.Valid() {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+// Unwrap returns the underlying cause
+func (e *SourceError) Unwrap() error {
+	return e.Cause
+}
@@ -2,6 +2,7 @@ package lexer
 
 import (
 	"fmt"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
@@ -15,6 +16,8 @@ type Lexer struct {
 	line      int  // current line number (1-based)
 	column    int  // current column number (1-based)
 	lineStart int  // position where current line starts
+
+	operators []string // custom operator symbols, longest first
 }
 
 // New creates a new lexer instance
@@ -79,23 +82,50 @@ func (l *Lexer) peekCharN(n int) rune {
 	return char
 }
 
-// currentPosition returns the current position
+// currentPosition returns the position of the current character. The
+// column counter is one ahead of the current character, and a newline still
+// belongs to the line it ends.
 func (l *Lexer) currentPosition() Position {
+	if l.char == '\n' {
+		lineStart := strings.LastIndexByte(l.input[:l.position], '\n') + 1
+		return Position{
+			Line:   l.line - 1,
+			Column: utf8.RuneCountInString(l.input[lineStart:l.position]) + 1,
+			Offset: l.position,
+		}
+	}
 	return Position{
 		Line:   l.line,
-		Column: l.column,
+		Column: l.column - 1,
 		Offset: l.position,
 	}
 }
 
 // NextToken scans the input and returns the next token
 func (l *Lexer) NextToken() Token {
+	tok := l.nextToken()
+	tok.End = l.currentPosition()
+	if tok.Type == EOF {
+		tok.End = tok.Position
+	}
+	return tok
+}
+
+// nextToken scans the next token
+func (l *Lexer) nextToken() Token {
 	var tok Token
 
 	l.skipWhitespace()
 
 	tok.Position = l.currentPosition()
 
+	if symbol := l.matchOperator(); symbol != "" {
+		for range symbol {
+			l.readChar()
+		}
+		return Token{Type: OPERATOR, Value: symbol, Position: tok.Position}
+	}
+
 	switch l.char {
 	case '+':
 		tok = Token{Type: ADD, Value: "+", Position: tok.Position}
@@ -238,6 +268,9 @@ func (l *Lexer) NextToken() Token {
 		if isLetter(l.char) {
 			tok.Value = l.readIdentifier()
 			tok.Type = LookupIdent(tok.Value)
+			if tok.Type == IDENT && l.isWordOperator(tok.Value) {
+				tok.Type = OPERATOR
+			}
 			return tok // Don't advance char, readIdentifier already did
 		} else if isDigit(l.char) {
 			tok.Type = NUMBER
@@ -386,6 +419,55 @@ func (l *Lexer) Reset(input string) {
 	l.readChar()
 }
 
+// AddOperator registers a custom operator symbol. Word operators such as
+// "between" are recognised as whole identifiers; other symbols are matched
+// before the built-in tokens, longest first.
+func (l *Lexer) AddOperator(symbol string) {
+	if symbol == "" {
+		return
+	}
+	for _, existing := range l.operators {
+		if existing == symbol {
+			return
+		}
+	}
+
+	i := 0
+	for i < len(l.operators) && len(l.operators[i]) >= len(symbol) {
+		i++
+	}
+	l.operators = append(l.operators, "")
+	copy(l.operators[i+1:], l.operators[i:])
+	l.operators[i] = symbol
+}
+
+// matchOperator returns the longest custom symbol operator at the current position
+func (l *Lexer) matchOperator() string {
+	if l.char == 0 {
+		return ""
+	}
+	for _, symbol := range l.operators {
+		first, _ := utf8.DecodeRuneInString(symbol)
+		if isLetter(first) {
+			continue
+		}
+		if strings.HasPrefix(l.input[l.position:], symbol) {
+			return symbol
+		}
+	}
+	return ""
+}
+
+// isWordOperator reports whether an identifier is a custom word operator
+func (l *Lexer) isWordOperator(ident string) bool {
+	for _, symbol := range l.operators {
+		if symbol == ident {
+			return true
+		}
+	}
+	return false
+}
+
 // isWildcardContext determines if * should be treated as wildcard or multiplication
 func (l *Lexer) isWildcardContext() bool {
 	// Look backward to see if we're in a member access context
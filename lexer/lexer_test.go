@@ -248,19 +248,23 @@ func TestWhitespace(t *testing.T) {
 
 // TestPosition tests position tracking
 func TestPosition(t *testing.T) {
-	input := "a\nb"
+	input := "a\n  bc"
 	lexer := New(input)
 
 	token1 := lexer.NextToken()
-	if token1.Position.Line != 1 || token1.Position.Column != 2 {
-		t.Errorf("Expected position (1,2), got (%d,%d)",
+	if token1.Position.Line != 1 || token1.Position.Column != 1 {
+		t.Errorf("Expected position (1,1), got (%d,%d)",
 			token1.Position.Line, token1.Position.Column)
 	}
 
 	token2 := lexer.NextToken()
-	if token2.Position.Line != 2 || token2.Position.Column != 2 {
-		t.Errorf("Expected position (2,2), got (%d,%d)",
-			token2.Position.Line, token2.Position.Column)
+	if token2.Position.Line != 2 || token2.Position.Column != 3 || token2.Position.Offset != 4 {
+		t.Errorf("Expected position (2,3) at offset 4, got (%d,%d) at offset %d",
+			token2.Position.Line, token2.Position.Column, token2.Position.Offset)
+	}
+	if token2.End.Line != 2 || token2.End.Column != 5 || token2.End.Offset != 6 {
+		t.Errorf("Expected end (2,5) at offset 6, got (%d,%d) at offset %d",
+			token2.End.Line, token2.End.Column, token2.End.Offset)
 	}
 }
 
@@ -324,6 +328,35 @@ func TestArithmeticOperators(t *testing.T) {
 	}
 }
 
+// TestCustomOperators tests user-defined symbol and word operators
+func TestCustomOperators(t *testing.T) {
+	lexer := New("a <> b <=> c between d <= e betweenness")
+	lexer.AddOperator("<>")
+	lexer.AddOperator("<=>")
+	lexer.AddOperator("between")
+
+	expected := []Token{
+		{Type: IDENT, Value: "a"},
+		{Type: OPERATOR, Value: "<>"},
+		{Type: IDENT, Value: "b"},
+		{Type: OPERATOR, Value: "<=>"},
+		{Type: IDENT, Value: "c"},
+		{Type: OPERATOR, Value: "between"},
+		{Type: IDENT, Value: "d"},
+		{Type: LE, Value: "<="},
+		{Type: IDENT, Value: "e"},
+		{Type: IDENT, Value: "betweenness"},
+		{Type: EOF, Value: ""},
+	}
+
+	for i, want := range expected {
+		token := lexer.NextToken()
+		if token.Type != want.Type || token.Value != want.Value {
+			t.Errorf("Token[%d] - expected %s, got %s", i, want, token)
+		}
+	}
+}
+
 // TestBitwiseOperators tests bitwise operators
 func TestBitwiseOperators(t *testing.T) {
 	input := "& | ^ ~ << >>"
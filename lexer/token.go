@@ -75,6 +75,9 @@ const (
 	// Destructuring operators
 	SPREAD // ... (spread/rest operator)
 
+	// Custom operators
+	OPERATOR // user-defined symbol or word operator
+
 	// Keywords
 	IF
 	ELSE
@@ -86,6 +89,7 @@ const (
 	IMPORT
 	AS
 	FROM
+	LET
 )
 
 // Token represents a lexical token
@@ -93,6 +97,7 @@ type Token struct {
 	Type     TokenType
 	Value    string
 	Position Position
+	End      Position // position just after the token
 }
 
 // String returns a string representation of the token
@@ -231,6 +236,8 @@ func (tt TokenType) String() string {
 		return "as"
 	case FROM:
 		return "from"
+	case LET:
+		return "let"
 	case ARROW:
 		return "=>"
 	case PIPE:
@@ -245,6 +252,8 @@ func (tt TokenType) String() string {
 		return "??"
 	case SPREAD:
 		return "..."
+	case OPERATOR:
+		return "OPERATOR"
 	default:
 		return fmt.Sprintf("TokenType(%d)", int(tt))
 	}
@@ -265,6 +274,7 @@ var Keywords = map[string]TokenType{
 	"import":     IMPORT,
 	"as":         AS,
 	"from":       FROM,
+	"let":        LET,
 }
 
 // LookupIdent checks if an identifier is a keyword
@@ -0,0 +1,127 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mredencom/expr/checker"
+	"github.com/mredencom/expr/modules"
+	"github.com/mredencom/expr/types"
+)
+
+// ModuleInfo describes a module that expressions can call
+type ModuleInfo struct {
+	Name        string
+	Description string
+	Functions   []ModuleFunctionInfo
+}
+
+// ModuleFunctionInfo describes a module function. Params is nil when the
+// function accepts any arguments.
+type ModuleFunctionInfo struct {
+	Name        string
+	Description string
+	Signature   string // e.g. "geo.distance(float64, float64) float64"
+	Params      []types.TypeInfo
+	Returns     types.TypeInfo
+	Variadic    bool
+}
+
+// WithModule registers a module for this program only. Its functions are
+// called as name.function(...) or imported, and calls are checked against
+// the declared parameter types when the program is compiled.
+func WithModule(name, description string, functions map[string]*modules.ModuleFunction) Option {
+	return func(c *Config) {
+		c.modules = append(c.modules, &modules.Module{Name: name, Description: description, Functions: functions})
+	}
+}
+
+// RegisterModule registers a module for all programs compiled afterwards
+func RegisterModule(name, description string, functions map[string]*modules.ModuleFunction) error {
+	return modules.DefaultRegistry.RegisterModule(name, description, functions)
+}
+
+// ListModules describes the modules available to expressions compiled with
+// the given options, ordered by name
+func ListModules(options ...Option) ([]ModuleInfo, error) {
+	registry, err := moduleRegistry(newConfig(options))
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []ModuleInfo
+	for _, module := range registry.Modules() {
+		info := ModuleInfo{Name: module.Name, Description: module.Description}
+		for _, name := range sortedKeys(module.Functions) {
+			fn := module.Functions[name]
+			info.Functions = append(info.Functions, ModuleFunctionInfo{
+				Name:        name,
+				Description: fn.Description,
+				Signature:   moduleSignature(module.Name, name, fn),
+				Params:      fn.ParamTypes,
+				Returns:     fn.ReturnType,
+				Variadic:    fn.Variadic,
+			})
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// moduleRegistry returns the registry of the modules a program can call:
+// the global modules and the modules registered with WithModule
+func moduleRegistry(config *Config) (*modules.Registry, error) {
+	if len(config.modules) == 0 {
+		return modules.DefaultRegistry, nil
+	}
+
+	registry := modules.DefaultRegistry.Clone()
+	for _, module := range config.modules {
+		if err := registry.RegisterModule(module.Name, module.Description, module.Functions); err != nil {
+			return nil, fmt.Errorf("module error: %v", err)
+		}
+	}
+	return registry, nil
+}
+
+// moduleFunctionInfos describes the signatures of the module functions for
+// type checking. Functions without parameter types are left unchecked.
+func moduleFunctionInfos(registry *modules.Registry) map[string]map[string]*checker.FunctionInfo {
+	infos := make(map[string]map[string]*checker.FunctionInfo)
+	for _, module := range registry.Modules() {
+		functions := make(map[string]*checker.FunctionInfo, len(module.Functions))
+		for name, fn := range module.Functions {
+			if fn.ParamTypes == nil {
+				functions[name] = nil
+				continue
+			}
+			funcInfo := &checker.FunctionInfo{Name: module.Name + "." + name, Params: fn.ParamTypes, Variadic: fn.Variadic}
+			if fn.ReturnType.Name != "" {
+				funcInfo.Returns = []types.TypeInfo{fn.ReturnType}
+			}
+			functions[name] = funcInfo
+		}
+		infos[module.Name] = functions
+	}
+	return infos
+}
+
+// moduleSignature formats the signature of a module function
+func moduleSignature(module, name string, fn *modules.ModuleFunction) string {
+	if fn.ParamTypes == nil {
+		return module + "." + name + "(...)"
+	}
+
+	params := make([]string, len(fn.ParamTypes))
+	for i, param := range fn.ParamTypes {
+		params[i] = param.String()
+		if fn.Variadic && i == len(fn.ParamTypes)-1 {
+			params[i] = "..." + params[i]
+		}
+	}
+	signature := module + "." + name + "(" + strings.Join(params, ", ") + ")"
+	if fn.ReturnType.Name != "" {
+		signature += " " + fn.ReturnType.String()
+	}
+	return signature
+}
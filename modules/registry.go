@@ -2,6 +2,7 @@ package modules
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/mredencom/expr/types"
@@ -50,6 +51,11 @@ func (r *Registry) RegisterModule(name string, description string, functions map
 	if _, exists := r.modules[name]; exists {
 		return fmt.Errorf("module '%s' already registered", name)
 	}
+	for functionName, function := range functions {
+		if function == nil || function.Handler == nil {
+			return fmt.Errorf("function '%s' of module '%s' has no handler", functionName, name)
+		}
+	}
 
 	module := &Module{
 		Name:        name,
@@ -121,6 +127,34 @@ func (r *Registry) ListModules() []string {
 	return names
 }
 
+// Modules returns all registered modules ordered by name
+func (r *Registry) Modules() []*Module {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	modules := make([]*Module, 0, len(r.modules))
+	for _, module := range r.modules {
+		modules = append(modules, module)
+	}
+	sort.Slice(modules, func(i, j int) bool { return modules[i].Name < modules[j].Name })
+
+	return modules
+}
+
+// Clone returns a registry with the modules registered in r. Modules
+// registered afterwards are not shared between the two registries.
+func (r *Registry) Clone() *Registry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	clone := &Registry{modules: make(map[string]*Module, len(r.modules))}
+	for name, module := range r.modules {
+		clone.modules[name] = module
+	}
+
+	return clone
+}
+
 // GetModuleInfo returns information about a module
 func (r *Registry) GetModuleInfo(name string) (*Module, error) {
 	return r.GetModule(name)
@@ -0,0 +1,164 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mredencom/expr/modules"
+	"github.com/mredencom/expr/types"
+)
+
+// geoFunctions is a test module computing distances on a line
+var geoFunctions = map[string]*modules.ModuleFunction{
+	"distance": {
+		Description: "Returns the distance between two points",
+		Handler: func(args ...interface{}) (interface{}, error) {
+			d := args[0].(float64) - args[1].(float64)
+			if d < 0 {
+				d = -d
+			}
+			return d, nil
+		},
+		ParamTypes: []types.TypeInfo{types.FloatType, types.FloatType},
+		ReturnType: types.FloatType,
+	},
+	"nearest": {
+		Description: "Returns the index of the point nearest to the origin",
+		Handler: func(args ...interface{}) (interface{}, error) {
+			nearest := 0
+			for i, arg := range args {
+				if arg.(int64) < args[nearest].(int64) {
+					nearest = i
+				}
+			}
+			return nearest, nil
+		},
+		ParamTypes: []types.TypeInfo{types.IntType},
+		ReturnType: types.IntType,
+		Variadic:   true,
+	},
+}
+
+func TestWithModule(t *testing.T) {
+	env := map[string]interface{}{"a": 1.5, "b": 4, "city": "Oslo"}
+
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{`geo.distance(a, b)`, "2.5"},
+		{`geo.nearest(5, b, 3.0)`, "2"},
+		{`import "geo" as g; g.distance(b, 10) > 5.0`, "true"},
+		{`from geo import distance; [1, 7] | map(x => distance(x, b))`, "[3 3]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			program, err := Compile(tt.expression, Env(env), WithModule("geo", "Distances", geoFunctions))
+			if err != nil {
+				t.Fatalf("Compile error: %v", err)
+			}
+			result, err := Run(program, env)
+			if err != nil {
+				t.Fatalf("Run error: %v", err)
+			}
+			if fmt.Sprint(result) != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+
+	errorTests := map[string]string{
+		`geo.distance(a)`:          "function geo.distance expects 2 arguments, got 1",
+		`geo.distance(a, city)`:    "function geo.distance argument 2 type mismatch: expected float, got string",
+		`geo.nearest(b, city)`:     "function geo.nearest argument 2 type mismatch: expected int, got string",
+		`geo.distance(a, b) + "m"`: "invalid operation: float + string",
+		`geo.area(a)`:              "function 'area' not found in module 'geo'",
+	}
+	for expression, expected := range errorTests {
+		_, err := Compile(expression, Env(env), WithModule("geo", "Distances", geoFunctions))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected error containing %q, got %v", expression, expected, err)
+		}
+	}
+
+	// Arity is also checked without an environment, and the module is
+	// only known to the programs compiled with it
+	if _, err := Compile(`geo.distance(1)`, WithModule("geo", "Distances", geoFunctions)); err == nil || !strings.Contains(err.Error(), "expects 2 arguments") {
+		t.Errorf("expected arity error without environment, got %v", err)
+	}
+	if _, err := Compile(`import "geo" as g; g.distance(1, 2)`); err == nil || !strings.Contains(err.Error(), "module 'geo' not found") {
+		t.Errorf("expected module to be unknown to other programs, got %v", err)
+	}
+	if _, err := Compile(`math.sqrt(4)`, WithModule("math", "", geoFunctions)); err == nil || !strings.Contains(err.Error(), "module 'math' already registered") {
+		t.Errorf("expected duplicate module error, got %v", err)
+	}
+}
+
+func TestRegisterModule(t *testing.T) {
+	err := RegisterModule("units", "Unit conversions", map[string]*modules.ModuleFunction{
+		"km": {
+			Handler: func(args ...interface{}) (interface{}, error) {
+				return args[0].(float64) / 1000, nil
+			},
+			ParamTypes: []types.TypeInfo{types.FloatType},
+			ReturnType: types.FloatType,
+		},
+	})
+	if err != nil {
+		t.Fatalf("RegisterModule error: %v", err)
+	}
+
+	result, err := Eval(`units.km(2500)`, nil)
+	if err != nil {
+		t.Fatalf("Eval error: %v", err)
+	}
+	if result != 2.5 {
+		t.Errorf("Expected 2.5, got %v", result)
+	}
+
+	err = RegisterModule("broken", "", map[string]*modules.ModuleFunction{"f": {}})
+	if err == nil || !strings.Contains(err.Error(), "function 'f' of module 'broken' has no handler") {
+		t.Errorf("expected missing handler error, got %v", err)
+	}
+}
+
+func TestListModules(t *testing.T) {
+	infos, err := ListModules(WithModule("geo", "Distances", geoFunctions))
+	if err != nil {
+		t.Fatalf("ListModules error: %v", err)
+	}
+
+	var geo *ModuleInfo
+	for i := range infos {
+		if i > 0 && infos[i-1].Name >= infos[i].Name {
+			t.Errorf("modules are not ordered: %s before %s", infos[i-1].Name, infos[i].Name)
+		}
+		if infos[i].Name == "geo" {
+			geo = &infos[i]
+		}
+	}
+	if geo == nil {
+		t.Fatal("geo module not listed")
+	}
+	if geo.Description != "Distances" || len(geo.Functions) != 2 {
+		t.Fatalf("unexpected geo module info: %+v", geo)
+	}
+
+	expected := []string{"geo.distance(float, float) float", "geo.nearest(...int) int"}
+	for i, fn := range geo.Functions {
+		if fn.Signature != expected[i] {
+			t.Errorf("Expected signature %q, got %q", expected[i], fn.Signature)
+		}
+	}
+	if !geo.Functions[1].Variadic || geo.Functions[0].Description == "" {
+		t.Errorf("unexpected function info: %+v", geo.Functions)
+	}
+
+	// Options for compiling are accepted as well
+	add := func(a, b int) int { return a + b }
+	if _, err := ListModules(WithBuiltin("add", add), WithOperator("<>", 7), WithOperatorFunc("+", 0, add)); err != nil {
+		t.Errorf("ListModules error: %v", err)
+	}
+}
@@ -16,11 +16,14 @@ type Parser struct {
 	curToken  lexer.Token
 	peekToken lexer.Token
 
-	errors []string
+	errors []*lexer.SourceError
 
 	// Parser functions
 	prefixParseFns map[lexer.TokenType]prefixParseFn
 	infixParseFns  map[lexer.TokenType]infixParseFn
+
+	// Precedence of custom operators
+	operators map[string]Precedence
 }
 
 type (
@@ -30,9 +33,28 @@ type (
 
 // New creates a new parser instance
 func New(l *lexer.Lexer) *Parser {
+	return NewWithOperators(l, nil)
+}
+
+// NewWithOperators creates a parser that also accepts the given custom infix
+// operators. Symbols that are already built-in operators keep their built-in
+// precedence; a precedence of LOWEST or below defaults to EQUALS.
+func NewWithOperators(l *lexer.Lexer, operators map[string]Precedence) *Parser {
 	p := &Parser{
-		lexer:  l,
-		errors: []string{},
+		lexer:     l,
+		errors:    []*lexer.SourceError{},
+		operators: make(map[string]Precedence),
+	}
+
+	for symbol, precedence := range operators {
+		if IsBuiltinOperator(symbol) {
+			continue
+		}
+		if precedence <= LOWEST {
+			precedence = EQUALS
+		}
+		l.AddOperator(symbol)
+		p.operators[symbol] = precedence
 	}
 
 	// Initialize prefix parse functions
@@ -89,6 +111,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(lexer.ARROW, p.parseLambdaExpression)
 	p.registerInfix(lexer.QUESTION_DOT, p.parseOptionalChainingExpression)
 	p.registerInfix(lexer.NULL_COALESCING, p.parseNullCoalescingExpression)
+	p.registerInfix(lexer.OPERATOR, p.parseInfixExpression)
 
 	// Read two tokens, so curToken and peekToken are both set
 	p.nextToken()
@@ -119,10 +142,25 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program.Statements = []ast.Statement{}
 
 	for p.curToken.Type != lexer.EOF {
+		if p.curToken.Type == lexer.SEMICOLON {
+			p.nextToken()
+			continue
+		}
+
+		errCount := len(p.errors)
 		stmt := p.parseStatement()
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
+
+		// Statements are separated by semicolons; after an error the rest of
+		// the statement is skipped
+		if len(p.errors) == errCount && p.peekToken.Type != lexer.SEMICOLON && p.peekToken.Type != lexer.EOF {
+			p.tokenError(p.peekToken, fmt.Sprintf("expected ';' or end of expression, got %s", p.peekToken.Type))
+		}
+		for len(p.errors) > errCount && p.peekToken.Type != lexer.SEMICOLON && p.peekToken.Type != lexer.EOF {
+			p.nextToken()
+		}
 		p.nextToken()
 	}
 
@@ -134,6 +172,10 @@ func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
 	case lexer.IMPORT:
 		return p.parseImportStatement()
+	case lexer.FROM:
+		return p.parseFromImportStatement()
+	case lexer.LET:
+		return p.parseLetStatement()
 	case lexer.LBRACKET, lexer.LBRACE:
 		// For now, skip destructuring assignment and just parse as expressions
 		fallthrough
@@ -154,25 +196,24 @@ func (p *Parser) parseImportStatement() ast.Statement {
 
 	// Expect module name as string literal
 	if p.curToken.Type != lexer.STRING {
-		p.errors = append(p.errors, fmt.Sprintf("expected string literal after 'import', got %s at %s",
-			p.curToken.Type, p.curToken.Position))
+		p.tokenError(p.curToken, fmt.Sprintf("expected string literal after 'import', got %s",
+			p.curToken.Type))
 		return nil
 	}
 
 	moduleName := p.curToken.Value
-	p.nextToken()
 
 	var alias string
 	// Check if there's an 'as' clause
-	if p.curToken.Type == lexer.AS {
+	if p.peekToken.Type == lexer.AS {
+		p.nextToken()
 		p.nextToken()
 		if p.curToken.Type != lexer.IDENT {
-			p.errors = append(p.errors, fmt.Sprintf("expected identifier after 'as', got %s at %s",
-				p.curToken.Type, p.curToken.Position))
+			p.tokenError(p.curToken, fmt.Sprintf("expected identifier after 'as', got %s",
+				p.curToken.Type))
 			return nil
 		}
 		alias = p.curToken.Value
-		p.nextToken()
 	} else {
 		// If no alias is provided, use the module name as alias
 		alias = moduleName
@@ -185,11 +226,83 @@ func (p *Parser) parseImportStatement() ast.Statement {
 	}
 }
 
+// parseFromImportStatement parses a selective import statement (e.g., from math import sqrt, pow)
+func (p *Parser) parseFromImportStatement() ast.Statement {
+	pos := p.curToken.Position
+	p.nextToken()
+
+	// The module name is an identifier or a string literal
+	if p.curToken.Type != lexer.IDENT && p.curToken.Type != lexer.STRING {
+		p.tokenError(p.curToken, fmt.Sprintf("expected module name after 'from', got %s",
+			p.curToken.Type))
+		return nil
+	}
+	moduleName := p.curToken.Value
+
+	if !p.expectPeek(lexer.IMPORT) {
+		return nil
+	}
+
+	var names []string
+	for {
+		p.nextToken()
+		if p.curToken.Type != lexer.IDENT && !p.isValidPropertyToken(p.curToken.Type) {
+			p.tokenError(p.curToken, fmt.Sprintf("expected function name in import list, got %s",
+				p.curToken.Type))
+			return nil
+		}
+		names = append(names, p.curToken.Value)
+
+		if p.peekToken.Type != lexer.COMMA {
+			break
+		}
+		p.nextToken()
+	}
+
+	return &ast.ImportStatement{
+		ModuleName: moduleName,
+		Names:      names,
+		Pos:        pos,
+	}
+}
+
+// parseLetStatement parses a let binding (e.g., let total = price * qty)
+func (p *Parser) parseLetStatement() ast.Statement {
+	pos := p.curToken.Position
+
+	if p.peekToken.Type != lexer.IDENT {
+		p.tokenError(p.peekToken, fmt.Sprintf("expected identifier after 'let', got %s",
+			p.peekToken.Type))
+		return nil
+	}
+	p.nextToken()
+	name := p.curToken.Value
+
+	if p.peekToken.Type != lexer.ASSIGN {
+		p.tokenError(p.peekToken, fmt.Sprintf("expected '=' after 'let %s', got %s",
+			name, p.peekToken.Type))
+		return nil
+	}
+	p.nextToken() // consume '='
+	p.nextToken() // move to the value
+
+	value := p.parseExpression(LOWEST)
+	if value == nil {
+		return nil
+	}
+
+	return &ast.LetStatement{
+		Name:  name,
+		Value: value,
+		Pos:   pos,
+	}
+}
+
 // expectToken checks if current token matches expected type and advances
 func (p *Parser) expectToken(expectedType lexer.TokenType) bool {
 	if p.curToken.Type != expectedType {
-		p.errors = append(p.errors, fmt.Sprintf("expected %s, got %s at %s",
-			expectedType, p.curToken.Type, p.curToken.Position))
+		p.tokenError(p.curToken, fmt.Sprintf("expected %s, got %s",
+			expectedType, p.curToken.Type))
 		return false
 	}
 	p.nextToken()
@@ -248,7 +361,7 @@ func (p *Parser) parseNumberLiteral() ast.Expression {
 	val, err := strconv.ParseFloat(p.curToken.Value, 64)
 	if err != nil {
 		msg := fmt.Sprintf("could not parse %q as float", p.curToken.Value)
-		p.errors = append(p.errors, msg)
+		p.tokenError(p.curToken, msg)
 		return nil
 	}
 
@@ -614,12 +727,20 @@ func (p *Parser) parseBuiltinFunction() ast.Expression {
 
 // peekPrecedence returns the precedence of the peek token
 func (p *Parser) peekPrecedence() Precedence {
-	return GetPrecedence(p.peekToken.Type)
+	return p.tokenPrecedence(p.peekToken)
 }
 
 // curPrecedence returns the precedence of the current token
 func (p *Parser) curPrecedence() Precedence {
-	return GetPrecedence(p.curToken.Type)
+	return p.tokenPrecedence(p.curToken)
+}
+
+// tokenPrecedence returns the precedence of a token, including custom operators
+func (p *Parser) tokenPrecedence(token lexer.Token) Precedence {
+	if token.Type == lexer.OPERATOR {
+		return p.operators[token.Value]
+	}
+	return GetPrecedence(token.Type)
 }
 
 // expectPeek checks the peek token type and advances if it matches
@@ -637,20 +758,38 @@ func (p *Parser) expectPeek(t lexer.TokenType) bool {
 
 // Errors returns the parser errors
 func (p *Parser) Errors() []string {
+	messages := make([]string, len(p.errors))
+	for i, err := range p.errors {
+		messages[i] = err.Error()
+	}
+	return messages
+}
+
+// SourceErrors returns the parser errors with the span of the offending tokens
+func (p *Parser) SourceErrors() []*lexer.SourceError {
 	return p.errors
 }
 
+// tokenError adds an error covering the given token
+func (p *Parser) tokenError(tok lexer.Token, msg string) {
+	p.errors = append(p.errors, lexer.NewSourceError(tok.Position, tok.End, msg))
+}
+
 // peekError adds a peek error
 func (p *Parser) peekError(t lexer.TokenType) {
 	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
 		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.tokenError(p.peekToken, msg)
 }
 
 // noPrefixParseFnError adds a no prefix parse function error
 func (p *Parser) noPrefixParseFnError(t lexer.TokenType) {
+	if t == lexer.ILLEGAL {
+		p.tokenError(p.curToken, fmt.Sprintf("illegal character %q", p.curToken.Value))
+		return
+	}
 	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.tokenError(p.curToken, msg)
 }
 
 // parseLambdaExpression parses lambda expressions (e.g., x => x * 2)
@@ -670,12 +809,12 @@ func (p *Parser) parseLambdaExpression(left ast.Expression) ast.Expression {
 			if ident, ok := elem.(*ast.Identifier); ok {
 				parameters = append(parameters, ident.Value)
 			} else {
-				p.errors = append(p.errors, "lambda parameters must be identifiers")
+				p.tokenError(p.curToken, "lambda parameters must be identifiers")
 				return nil
 			}
 		}
 	default:
-		p.errors = append(p.errors, "invalid lambda parameter format")
+		p.tokenError(p.curToken, "invalid lambda parameter format")
 		return nil
 	}
 
@@ -801,8 +940,8 @@ func (p *Parser) parseDestructuringAssignment() ast.Statement {
 
 	// Expect assignment operator
 	if p.peekToken.Type != lexer.ASSIGN {
-		p.errors = append(p.errors, fmt.Sprintf("expected '=' in destructuring assignment, got %s at %s",
-			p.peekToken.Type, p.peekToken.Position))
+		p.tokenError(p.peekToken, fmt.Sprintf("expected '=' in destructuring assignment, got %s",
+			p.peekToken.Type))
 		return nil
 	}
 	p.nextToken() // consume '='
@@ -829,8 +968,8 @@ func (p *Parser) parseDestructuringPattern() ast.DestructuringPattern {
 	case lexer.LBRACE:
 		return p.parseObjectDestructuringPattern()
 	default:
-		p.errors = append(p.errors, fmt.Sprintf("expected '[' or '{' for destructuring pattern, got %s at %s",
-			p.curToken.Type, p.curToken.Position))
+		p.tokenError(p.curToken, fmt.Sprintf("expected '[' or '{' for destructuring pattern, got %s",
+			p.curToken.Type))
 		return nil
 	}
 }
@@ -842,8 +981,8 @@ func (p *Parser) parseArrayDestructuringPattern() ast.DestructuringPattern {
 
 	// Current token should already be LBRACKET
 	if p.curToken.Type != lexer.LBRACKET {
-		p.errors = append(p.errors, fmt.Sprintf("expected '[' at start of array destructuring, got %s at %s",
-			p.curToken.Type, p.curToken.Position))
+		p.tokenError(p.curToken, fmt.Sprintf("expected '[' at start of array destructuring, got %s",
+			p.curToken.Type))
 		return nil
 	}
 
@@ -877,8 +1016,8 @@ func (p *Parser) parseArrayDestructuringPattern() ast.DestructuringPattern {
 
 	// Expect closing bracket
 	if p.peekToken.Type != lexer.RBRACKET {
-		p.errors = append(p.errors, fmt.Sprintf("expected ']' at end of array destructuring, got %s at %s",
-			p.peekToken.Type, p.peekToken.Position))
+		p.tokenError(p.peekToken, fmt.Sprintf("expected ']' at end of array destructuring, got %s",
+			p.peekToken.Type))
 		return nil
 	}
 	p.nextToken() // consume ']'
@@ -896,8 +1035,8 @@ func (p *Parser) parseObjectDestructuringPattern() ast.DestructuringPattern {
 
 	// Current token should already be LBRACE
 	if p.curToken.Type != lexer.LBRACE {
-		p.errors = append(p.errors, fmt.Sprintf("expected '{' at start of object destructuring, got %s at %s",
-			p.curToken.Type, p.curToken.Position))
+		p.tokenError(p.curToken, fmt.Sprintf("expected '{' at start of object destructuring, got %s",
+			p.curToken.Type))
 		return nil
 	}
 
@@ -931,8 +1070,8 @@ func (p *Parser) parseObjectDestructuringPattern() ast.DestructuringPattern {
 
 	// Expect closing brace
 	if p.peekToken.Type != lexer.RBRACE {
-		p.errors = append(p.errors, fmt.Sprintf("expected '}' at end of object destructuring, got %s at %s",
-			p.peekToken.Type, p.peekToken.Position))
+		p.tokenError(p.peekToken, fmt.Sprintf("expected '}' at end of object destructuring, got %s",
+			p.peekToken.Type))
 		return nil
 	}
 	p.nextToken() // consume '}'
@@ -951,8 +1090,8 @@ func (p *Parser) parseDestructuringElement() ast.DestructuringElement {
 	case lexer.IDENT:
 		return p.parseIdentifierElement()
 	default:
-		p.errors = append(p.errors, fmt.Sprintf("expected identifier or '...' in destructuring element, got %s at %s",
-			p.curToken.Type, p.curToken.Position))
+		p.tokenError(p.curToken, fmt.Sprintf("expected identifier or '...' in destructuring element, got %s",
+			p.curToken.Type))
 		return nil
 	}
 }
@@ -986,8 +1125,8 @@ func (p *Parser) parseRestElement() ast.DestructuringElement {
 	}
 
 	if p.peekToken.Type != lexer.IDENT {
-		p.errors = append(p.errors, fmt.Sprintf("expected identifier after '...', got %s at %s",
-			p.peekToken.Type, p.peekToken.Position))
+		p.tokenError(p.peekToken, fmt.Sprintf("expected identifier after '...', got %s",
+			p.peekToken.Type))
 		return nil
 	}
 
@@ -1005,8 +1144,8 @@ func (p *Parser) parseObjectDestructuringProperty() *ast.ObjectDestructuringProp
 	pos := p.curToken.Position
 
 	if p.curToken.Type != lexer.IDENT {
-		p.errors = append(p.errors, fmt.Sprintf("expected identifier in object destructuring, got %s at %s",
-			p.curToken.Type, p.curToken.Position))
+		p.tokenError(p.curToken, fmt.Sprintf("expected identifier in object destructuring, got %s",
+			p.curToken.Type))
 		return nil
 	}
 
@@ -1017,8 +1156,8 @@ func (p *Parser) parseObjectDestructuringProperty() *ast.ObjectDestructuringProp
 	if p.peekToken.Type == lexer.COLON {
 		p.nextToken() // consume ':'
 		if p.peekToken.Type != lexer.IDENT {
-			p.errors = append(p.errors, fmt.Sprintf("expected identifier after ':', got %s at %s",
-				p.peekToken.Type, p.peekToken.Position))
+			p.tokenError(p.peekToken, fmt.Sprintf("expected identifier after ':', got %s",
+				p.peekToken.Type))
 			return nil
 		}
 		p.nextToken() // move to value identifier
@@ -345,6 +345,42 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 	}
 }
 
+func TestCustomOperatorPrecedence(t *testing.T) {
+	operators := map[string]Precedence{
+		"between": EQUALS,
+		"<>":      EQUALS,
+		"+++":     PRODUCT,
+		"+":       LOWEST, // built-in operators keep their precedence
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a + 1 between b", "((a + 1) between b)"},
+		{"a <> b && c", "((a <> b) && c)"},
+		{"a + b +++ c", "(a + (b +++ c))"},
+		{"a + b * c", "(a + (b * c))"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := NewWithOperators(lexer.New(tt.input), operators)
+			program := p.ParseProgram()
+			checkParserErrors(t, p)
+
+			actual := program.Statements[0].String()
+			if actual != tt.expected {
+				t.Errorf("expected=%q, got=%q", tt.expected, actual)
+			}
+		})
+	}
+
+	if !IsBuiltinOperator("+") || !IsBuiltinOperator("in") || IsBuiltinOperator("<>") || IsBuiltinOperator("between") {
+		t.Error("IsBuiltinOperator misclassified an operator")
+	}
+}
+
 func TestParseCallExpression(t *testing.T) {
 	input := "myFunc(1, 2 * 3, 4 + 5)"
 
@@ -557,12 +593,86 @@ func TestParseBuiltinExpression(t *testing.T) {
 	}
 }
 
+func TestParseLetStatements(t *testing.T) {
+	input := "let subtotal = price * qty; let tax = subtotal * 0.2;\nsubtotal + tax;"
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 3 {
+		t.Fatalf("program has wrong number of statements. got=%d", len(program.Statements))
+	}
+
+	expected := []string{"let subtotal = (price * qty)", "let tax = (subtotal * 0.2)"}
+	for i, want := range expected {
+		let, ok := program.Statements[i].(*ast.LetStatement)
+		if !ok {
+			t.Fatalf("program.Statements[%d] is not ast.LetStatement. got=%T", i, program.Statements[i])
+		}
+		if let.String() != want {
+			t.Errorf("Expected %s, got %s", want, let.String())
+		}
+	}
+
+	stmt, ok := program.Statements[2].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[2] is not ast.ExpressionStatement. got=%T", program.Statements[2])
+	}
+	if stmt.String() != "(subtotal + tax)" {
+		t.Errorf("Expected (subtotal + tax), got %s", stmt.String())
+	}
+	if pos := stmt.Position(); pos.Line != 2 || pos.Column != 1 {
+		t.Errorf("Expected result at 2:1, got %s", pos)
+	}
+}
+
+func TestParseImportStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		names    []string
+	}{
+		{`import "strings" as s; s.upper(name)`, "import 'strings' as s", nil},
+		{`import "math"; math.sqrt(x)`, "import 'math' as math", nil},
+		{`from math import sqrt, pow; sqrt(pow(x, 2))`, "from math import sqrt, pow", []string{"sqrt", "pow"}},
+		{`from "strings" import contains; contains(name, "a")`, "from strings import contains", []string{"contains"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := New(lexer.New(tt.input))
+			program := p.ParseProgram()
+			checkParserErrors(t, p)
+
+			if len(program.Statements) != 2 {
+				t.Fatalf("program has wrong number of statements. got=%d", len(program.Statements))
+			}
+			stmt, ok := program.Statements[0].(*ast.ImportStatement)
+			if !ok {
+				t.Fatalf("program.Statements[0] is not ast.ImportStatement. got=%T", program.Statements[0])
+			}
+			if stmt.String() != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, stmt.String())
+			}
+			if strings.Join(stmt.Names, ",") != strings.Join(tt.names, ",") {
+				t.Errorf("Expected names %v, got %v", tt.names, stmt.Names)
+			}
+		})
+	}
+}
+
 func TestParseErrors(t *testing.T) {
 	tests := []struct {
 		input         string
 		expectedError string
 	}{
 		{"5 +", "no prefix parse function for EOF found"},
+		{"1 2", "expected ';' or end of expression, got NUMBER"},
+		{"let 1 = 2; 3", "expected identifier after 'let', got NUMBER"},
+		{"let x 2; x", "expected '=' after 'let x', got NUMBER"},
+		{"from math sqrt; 1", "expected next token to be import"},
+		{"from math import 1; 1", "expected function name in import list, got NUMBER"},
 		{"!true == false", ""}, // This should parse correctly
 	}
 
@@ -597,6 +707,27 @@ func TestParseErrors(t *testing.T) {
 	}
 }
 
+func TestParseSourceErrors(t *testing.T) {
+	p := New(lexer.New("x + @\n(1 + 2"))
+	p.ParseProgram()
+
+	errs := p.SourceErrors()
+	if len(errs) == 0 {
+		t.Fatal("Expected errors, got none")
+	}
+
+	illegal := errs[0]
+	if illegal.Message != `illegal character "@"` {
+		t.Errorf("Expected illegal character error, got %q", illegal.Message)
+	}
+	if illegal.Pos.Line != 1 || illegal.Pos.Column != 5 || illegal.End.Offset != 5 {
+		t.Errorf("Expected span 1:5 to offset 5, got %s to offset %d", illegal.Pos, illegal.End.Offset)
+	}
+	if got := p.Errors()[0]; got != `line 1, column 5: illegal character "@"` {
+		t.Errorf("Expected positioned message, got %q", got)
+	}
+}
+
 // Helper functions
 
 func checkParserErrors(t *testing.T, p *Parser) {
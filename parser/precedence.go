@@ -87,6 +87,18 @@ func GetPrecedence(tokenType lexer.TokenType) Precedence {
 	return LOWEST
 }
 
+// IsBuiltinOperator reports whether symbol is lexed as a single built-in token
+// such as "+" or "in", rather than an identifier or unknown characters
+func IsBuiltinOperator(symbol string) bool {
+	l := lexer.New(symbol)
+	tok := l.NextToken()
+	switch tok.Type {
+	case lexer.IDENT, lexer.ILLEGAL, lexer.EOF, lexer.NUMBER, lexer.STRING, lexer.BOOL, lexer.NULL:
+		return false
+	}
+	return l.NextToken().Type == lexer.EOF
+}
+
 // IsRightAssociative returns true if the operator is right-associative
 func IsRightAssociative(tokenType lexer.TokenType) bool {
 	switch tokenType {
@@ -0,0 +1,42 @@
+package expr
+
+import (
+	"fmt"
+
+	"github.com/mredencom/expr/env"
+	"github.com/mredencom/expr/types"
+)
+
+// PartialEval specializes a program for the variables whose values are known
+// in advance, such as the configuration of a tenant. known is a map or a
+// struct like the environment given to Run. The returned program no longer
+// reads the known variables: constant subexpressions are folded, ternaries
+// with a constant condition keep only the branch taken and true && x is
+// reduced to x when x is a bool. It runs with an environment holding the
+// other variables. Errors of the residual program point into the source of
+// program.
+//
+// A program loaded with LoadProgram has no environment: its variables are
+// declared without types, so the residual program is not type checked.
+func PartialEval(program *Program, known interface{}) (*Program, error) {
+	values := make(map[string]types.Value)
+	if known != nil {
+		variables, ok := environmentVariables(known, program.config.tagName)
+		if !ok {
+			return nil, fmt.Errorf("partial evaluation: known values must be a map or a struct, got %T", known)
+		}
+		for name, value := range variables {
+			converted, err := env.ConvertReflect(value, program.config.tagName)
+			if err != nil {
+				return nil, fmt.Errorf("partial evaluation: variable %s: %v", name, err)
+			}
+			values[name] = converted
+		}
+	}
+
+	config := *program.config
+	if config.env == nil {
+		config.variables = program.references.Variables
+	}
+	return compile(program.source, &config, values)
+}
@@ -0,0 +1,106 @@
+package expr
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type partialTenant struct {
+	Plan  string
+	Limit int
+	Tags  []string
+}
+
+func TestPartialEval(t *testing.T) {
+	tenant := partialTenant{Plan: "pro", Limit: 10, Tags: []string{"eu", "beta"}}
+	env := map[string]interface{}{
+		"tenant":  tenant,
+		"premium": true,
+		"rate":    1.5,
+		"req":     map[string]interface{}{"size": 3, "amount": 20.0},
+		"items":   []int{1, 2, 3},
+	}
+	known := map[string]interface{}{"tenant": tenant, "premium": true, "rate": 1.5}
+	request := map[string]interface{}{"req": env["req"], "items": env["items"]}
+
+	tests := []struct {
+		expression string
+		variables  []string
+	}{
+		{`premium && req.size < tenant.Limit`, []string{"req"}},
+		{`tenant.Plan == "pro" ? req.amount * rate : req.amount`, []string{"req"}},
+		{`let twice = tenant.Limit * 2; req.size < twice`, []string{"req"}},
+		{`items | filter(# > tenant.Limit - 9) | map(# * rate)`, []string{"items"}},
+		{`tenant.Tags[0] + "-" + string(req.size)`, []string{"req"}},
+		{`tenant.Plan == "pro" || req.size > 100`, nil},
+		{`tenant.Limit > 50 || req.amount`, []string{"req"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			program, err := Compile(tt.expression, Env(env))
+			if err != nil {
+				t.Fatalf("Compile error: %v", err)
+			}
+			residual, err := PartialEval(program, known)
+			if err != nil {
+				t.Fatalf("PartialEval error: %v", err)
+			}
+			if !reflect.DeepEqual(residual.Variables(), tt.variables) {
+				t.Errorf("Expected variables %v, got %v", tt.variables, residual.Variables())
+			}
+			if residual.BytecodeSize() >= program.BytecodeSize() {
+				t.Errorf("Expected smaller bytecode, got %d for %d", residual.BytecodeSize(), program.BytecodeSize())
+			}
+
+			expected, err := Run(program, env)
+			if err != nil {
+				t.Fatalf("Run error: %v", err)
+			}
+			result, err := Run(residual, request)
+			if err != nil {
+				t.Fatalf("Run error for residual program: %v", err)
+			}
+			if !reflect.DeepEqual(result, expected) {
+				t.Errorf("Expected %v, got %v", expected, result)
+			}
+		})
+	}
+
+	program, err := Compile(`req.size / tenant.Limit`, Env(env))
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+	if _, err := PartialEval(program, 42); err == nil || !strings.Contains(err.Error(), "known values must be a map or a struct") {
+		t.Errorf("Expected an error for known values of type int, got %v", err)
+	}
+	residual, err := PartialEval(program, map[string]interface{}{"tenant": partialTenant{}})
+	if err != nil {
+		t.Fatalf("PartialEval error: %v", err)
+	}
+	if _, err := Run(residual, request); err == nil || residual.Source() != program.Source() {
+		t.Errorf("Expected division by zero in %q, got %v", residual.Source(), err)
+	}
+
+	// A loaded program has no environment, its variables are declared untyped
+	program, err = Compile(`tenant.Limit > 5 && req.amount > 10`, Env(env))
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+	data, err := program.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Marshal error: %v", err)
+	}
+	loaded, err := LoadProgram(data)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	residual, err = PartialEval(loaded, known)
+	if err != nil {
+		t.Fatalf("PartialEval error for a loaded program: %v", err)
+	}
+	if result, err := Run(residual, request); err != nil || result != true || !reflect.DeepEqual(residual.Variables(), []string{"req"}) {
+		t.Errorf("Expected true reading req, got %v, %v reading %v", result, err, residual.Variables())
+	}
+}
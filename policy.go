@@ -0,0 +1,351 @@
+package expr
+
+import (
+	"fmt"
+
+	"github.com/mredencom/expr/ast"
+	"github.com/mredencom/expr/builtins"
+	"github.com/mredencom/expr/lexer"
+	"github.com/mredencom/expr/modules"
+	"github.com/mredencom/expr/types"
+)
+
+// Policy restricts what an expression may use, for expressions written by
+// untrusted users. It is enforced at compile time: an expression breaking
+// the policy fails to compile with one error per violation.
+//
+// Each allow list, when not nil, holds the only names that may be used; the
+// names of a deny list are always rejected. Limits of zero are not enforced.
+type Policy struct {
+	// Functions called by name: the built-in functions, the ones added
+	// with WithBuiltin and the functions of the environment, e.g. "debug".
+	// Builtins referred to without a call, e.g. [now][0], count as used
+	AllowedBuiltins []string
+	DeniedBuiltins  []string
+
+	// Module functions, listed as "module.function" or by module name for
+	// all the functions of a module, e.g. "math" or "strings.repeat"
+	AllowedModules []string
+	DeniedModules  []string
+
+	// Methods called on values, the type methods as well as the methods of
+	// Go values, e.g. "upper" or "match"
+	AllowedMethods []string
+	DeniedMethods  []string
+
+	// Operators, including "|" for pipelines, "??", "?." and "?:" for
+	// conditional expressions, e.g. "matches"
+	AllowedOperators []string
+	DeniedOperators  []string
+
+	MaxSourceLength   int // Bytes of the expression
+	MaxDepth          int // Expressions nested in one another
+	MaxNodes          int // Nodes of the syntax tree
+	MaxStringLength   int // Bytes of a string literal
+	MaxCollectionSize int // Elements of an array literal or pairs of a map literal
+	MaxPipelineDepth  int // Pipelines nested in the stages of other pipelines
+}
+
+// WithPolicy restricts the expression to what a policy allows
+func WithPolicy(policy Policy) Option {
+	return func(c *Config) {
+		c.policy = &policy
+	}
+}
+
+// checkSourceLength checks the length of an expression before it is parsed
+func (p *Policy) checkSourceLength(expression string) error {
+	if p.MaxSourceLength > 0 && len(expression) > p.MaxSourceLength {
+		message := fmt.Sprintf("expression of %d bytes exceeds the limit of %d", len(expression), p.MaxSourceLength)
+		return CompileErrors{{Message: message, Phase: "policy"}}
+	}
+	return nil
+}
+
+// check returns the violations of the policy by a parsed expression
+func (p *Policy) check(program *ast.Program, expression string, config *Config, registry *modules.Registry) error {
+	c := &policyChecker{
+		policy:    p,
+		source:    expression,
+		registry:  registry,
+		variables: make(map[string]bool),
+		imports:   make(map[string]string),
+		imported:  make(map[string]bool),
+		builtins:  make(map[string]bool),
+	}
+	for _, name := range builtins.StandardBuiltinNames {
+		c.builtins[name] = true
+	}
+	for name := range config.builtins {
+		c.builtins[name] = true
+	}
+	if config.env != nil {
+		envMap, _ := environmentVariables(config.env, config.tagName)
+		for name := range envMap {
+			c.variables[name] = true
+		}
+	}
+	for _, name := range config.variables {
+		c.variables[name] = true
+	}
+
+	ast.Inspect(program, c.enter)
+	if p.MaxNodes > 0 && c.nodes > p.MaxNodes {
+		c.errs = append(c.errs, &CompileError{
+			Message: fmt.Sprintf("expression of %d nodes exceeds the limit of %d", c.nodes, p.MaxNodes),
+			Phase:   "policy",
+		})
+	}
+	if len(c.errs) > 0 {
+		return c.errs
+	}
+	return nil
+}
+
+// policyChecker walks a syntax tree collecting the violations of a policy
+type policyChecker struct {
+	policy   *Policy
+	source   string
+	registry *modules.Registry
+
+	variables map[string]bool   // Names of the environment and of let statements
+	imports   map[string]string // Import alias to module
+	imported  map[string]bool   // Functions imported by name
+	builtins  map[string]bool   // Names the compiler resolves to builtins
+	params    []map[string]bool // Parameters of the enclosing lambdas
+
+	stack     []ast.Node // Nodes enclosing the current one
+	pipelines []int      // Pipeline nesting of the nodes of the stack
+	nodes     int
+	depth     int // Expressions on the stack
+	deep      bool
+	nested    bool
+	errs      CompileErrors
+}
+
+// enter checks a node before its children, or leaves the last node entered
+// when node is nil
+func (c *policyChecker) enter(node ast.Node) bool {
+	if node == nil {
+		switch n := c.stack[len(c.stack)-1].(type) {
+		case *ast.LetStatement:
+			// The value of a let statement still sees the names it shadows
+			c.variables[n.Name] = true
+		case *ast.LambdaExpression:
+			c.params = c.params[:len(c.params)-1]
+		}
+		if _, ok := c.stack[len(c.stack)-1].(ast.Expression); ok {
+			c.depth--
+		}
+		c.stack = c.stack[:len(c.stack)-1]
+		c.pipelines = c.pipelines[:len(c.pipelines)-1]
+		return false
+	}
+
+	var parent ast.Node
+	pipelines := 0
+	if len(c.stack) > 0 {
+		parent = c.stack[len(c.stack)-1]
+		pipelines = c.pipelines[len(c.pipelines)-1]
+	}
+	c.stack = append(c.stack, node)
+	if _, ok := node.(*ast.Program); !ok {
+		c.nodes++
+	}
+	if _, ok := node.(ast.Expression); ok {
+		c.depth++
+		if limit := c.policy.MaxDepth; limit > 0 && c.depth > limit && !c.deep {
+			c.deep = true
+			c.violation(node, "expression nesting exceeds the limit of %d levels", limit)
+		}
+	}
+
+	// A pipeline continuing the one on its right is at the same level
+	if pipe, ok := node.(*ast.PipeExpression); ok {
+		if chain, ok := parent.(*ast.PipeExpression); !ok || chain.Left != ast.Expression(pipe) {
+			pipelines++
+		}
+		if limit := c.policy.MaxPipelineDepth; limit > 0 && pipelines > limit && !c.nested {
+			c.nested = true
+			c.violation(node, "pipeline nesting exceeds the limit of %d levels", limit)
+		}
+	}
+	c.pipelines = append(c.pipelines, pipelines)
+
+	switch n := node.(type) {
+	case *ast.LambdaExpression:
+		params := make(map[string]bool, len(n.Parameters))
+		for _, name := range n.Parameters {
+			params[name] = true
+		}
+		c.params = append(c.params, params)
+	case *ast.Identifier:
+		c.checkIdentifier(n, parent)
+	}
+	c.check(node)
+	return true
+}
+
+// check checks the names, operators and literals used by a node
+func (c *policyChecker) check(node ast.Node) {
+	p := c.policy
+	switch n := node.(type) {
+	case *ast.ImportStatement:
+		if len(n.Names) == 0 {
+			alias := n.Alias
+			if alias == "" {
+				alias = n.ModuleName
+			}
+			c.imports[alias] = n.ModuleName
+		}
+		for _, name := range n.Names {
+			c.imported[name] = true
+			c.checkModuleFunction(n, n.ModuleName, name)
+		}
+	case *ast.BuiltinExpression:
+		// Functions imported by name were checked with their import
+		if !c.imported[n.Name] {
+			c.checkName(n, "builtin", n.Name, p.AllowedBuiltins, p.DeniedBuiltins)
+		}
+	case *ast.PipeExpression:
+		c.checkName(n, "operator", "|", p.AllowedOperators, p.DeniedOperators)
+		if ident, ok := n.Right.(*ast.Identifier); ok && !c.imported[ident.Value] {
+			c.checkName(ident, "builtin", ident.Value, p.AllowedBuiltins, p.DeniedBuiltins)
+		}
+	case *ast.CallExpression:
+		c.checkCall(n)
+	case *ast.ModuleCallExpression:
+		if module, ok := c.resolveModule(n.Module); ok {
+			c.checkModuleFunction(n, module, n.Function)
+		}
+	case *ast.InfixExpression:
+		c.checkName(n, "operator", n.Operator, p.AllowedOperators, p.DeniedOperators)
+	case *ast.PrefixExpression:
+		c.checkName(n, "operator", n.Operator, p.AllowedOperators, p.DeniedOperators)
+	case *ast.NullCoalescingExpression:
+		c.checkName(n, "operator", "??", p.AllowedOperators, p.DeniedOperators)
+	case *ast.OptionalChainingExpression:
+		c.checkName(n, "operator", "?.", p.AllowedOperators, p.DeniedOperators)
+	case *ast.ConditionalExpression:
+		c.checkName(n, "operator", "?:", p.AllowedOperators, p.DeniedOperators)
+	case *ast.Literal:
+		if s, ok := n.Value.(*types.StringValue); ok && p.MaxStringLength > 0 && len(s.Value()) > p.MaxStringLength {
+			c.violation(n, "string literal of %d bytes exceeds the limit of %d", len(s.Value()), p.MaxStringLength)
+		}
+	case *ast.ArrayLiteral:
+		if p.MaxCollectionSize > 0 && len(n.Elements) > p.MaxCollectionSize {
+			c.violation(n, "array literal of %d elements exceeds the limit of %d", len(n.Elements), p.MaxCollectionSize)
+		}
+	case *ast.MapLiteral:
+		if p.MaxCollectionSize > 0 && len(n.Pairs) > p.MaxCollectionSize {
+			c.violation(n, "map literal of %d pairs exceeds the limit of %d", len(n.Pairs), p.MaxCollectionSize)
+		}
+	}
+}
+
+// checkIdentifier checks a builtin referred to by name rather than called,
+// e.g. [now][0] or (ok ? upper : lower)(name)
+func (c *policyChecker) checkIdentifier(ident *ast.Identifier, parent ast.Node) {
+	switch n := parent.(type) {
+	case *ast.MemberExpression:
+		if n.Property == ast.Expression(ident) {
+			return
+		}
+	case *ast.OptionalChainingExpression:
+		if n.Property == ast.Expression(ident) {
+			return
+		}
+	case *ast.MapLiteral:
+		for _, pair := range n.Pairs {
+			if pair.Key == ast.Expression(ident) {
+				return
+			}
+		}
+	case *ast.PipeExpression:
+		// Checked with the pipeline
+		if n.Right == ast.Expression(ident) {
+			return
+		}
+	}
+	if !c.builtins[ident.Value] || c.variables[ident.Value] || c.imported[ident.Value] {
+		return
+	}
+	for _, params := range c.params {
+		if params[ident.Value] {
+			return
+		}
+	}
+	c.checkName(ident, "builtin", ident.Value, c.policy.AllowedBuiltins, c.policy.DeniedBuiltins)
+}
+
+// checkCall checks a call of a module function or of a method
+func (c *policyChecker) checkCall(call *ast.CallExpression) {
+	var object ast.Expression
+	var property ast.Expression
+	switch fn := call.Function.(type) {
+	case *ast.MemberExpression:
+		object, property = fn.Object, fn.Property
+	case *ast.OptionalChainingExpression:
+		object, property = fn.Object, fn.Property
+	default:
+		return
+	}
+	method, ok := property.(*ast.Identifier)
+	if !ok {
+		return
+	}
+
+	if ident, ok := object.(*ast.Identifier); ok {
+		if module, ok := c.resolveModule(ident.Value); ok {
+			c.checkModuleFunction(method, module, method.Value)
+			return
+		}
+	}
+	c.checkName(method, "method", method.Value, c.policy.AllowedMethods, c.policy.DeniedMethods)
+}
+
+// resolveModule returns the module a name refers to the way the compiler
+// does: an import alias, or a registered module not shadowed by a variable
+func (c *policyChecker) resolveModule(name string) (string, bool) {
+	if module, ok := c.imports[name]; ok {
+		return module, true
+	}
+	if c.variables[name] {
+		return "", false
+	}
+	return name, c.registry.HasModule(name)
+}
+
+// checkModuleFunction checks a module function against the module lists
+func (c *policyChecker) checkModuleFunction(node ast.Node, module, function string) {
+	name := module + "." + function
+	p := c.policy
+	denied := contains(p.DeniedModules, module) || contains(p.DeniedModules, name)
+	allowed := p.AllowedModules == nil || contains(p.AllowedModules, module) || contains(p.AllowedModules, name)
+	if denied || !allowed {
+		c.violation(node, "module function %s is not allowed", name)
+	}
+}
+
+// checkName checks a name against an allow list and a deny list
+func (c *policyChecker) checkName(node ast.Node, kind, name string, allow, deny []string) {
+	if contains(deny, name) || (allow != nil && !contains(allow, name)) {
+		c.violation(node, "%s %s is not allowed", kind, name)
+	}
+}
+
+// violation records a violation located at a node
+func (c *policyChecker) violation(node ast.Node, format string, args ...interface{}) {
+	err := &lexer.SourceError{Message: fmt.Sprintf(format, args...), Pos: node.Position()}
+	c.errs = append(c.errs, newCompileError("policy", c.source, err))
+}
+
+// contains reports whether a list holds a name
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
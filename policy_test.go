@@ -0,0 +1,126 @@
+package expr
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	env := map[string]interface{}{"name": "ann", "items": []int{1, 2, 3}}
+	policy := Policy{
+		DeniedBuiltins:    []string{"debug"},
+		AllowedModules:    []string{"math", "strings.upper"},
+		DeniedMethods:     []string{"test"},
+		DeniedOperators:   []string{"matches"},
+		MaxStringLength:   8,
+		MaxCollectionSize: 3,
+		MaxDepth:          6,
+		MaxPipelineDepth:  1,
+	}
+
+	tests := []struct {
+		expression string
+		violation  string // Empty when the expression complies
+	}{
+		{`len(name) > 2 && name != "bob"`, ""},
+		{`items | filter(# > 1) | map(# * 2)`, ""},
+		{`math.sqrt(16.0)`, ""},
+		{`import "strings" as s; s.upper(name)`, ""},
+		{`debug(name)`, "builtin debug is not allowed"},
+		{`name | debug`, "builtin debug is not allowed"},
+		{`strings.repeat(name, 3)`, "module function strings.repeat is not allowed"},
+		{`from strings import repeat; repeat(name, 3)`, "module function strings.repeat is not allowed"},
+		{`name | #.test("a+")`, "method test is not allowed"},
+		{`name matches "a+"`, "operator matches is not allowed"},
+		{`name + "very long text"`, "string literal of 14 bytes exceeds the limit of 8"},
+		{`[1, 2, 3, 4]`, "array literal of 4 elements exceeds the limit of 3"},
+		{`{"a": 1, "b": 2, "c": 3, "d": 4}`, "map literal of 4 pairs exceeds the limit of 3"},
+		{`-(-(-(-(-(-(-1))))))`, "expression nesting exceeds the limit of 6 levels"},
+		{`items | map([#] | filter(# > 1))`, "pipeline nesting exceeds the limit of 1 levels"},
+	}
+
+	for _, tt := range tests {
+		_, err := Compile(tt.expression, Env(env), WithPolicy(policy))
+		if tt.violation == "" {
+			if err != nil {
+				t.Errorf("%s: expected no violation, got %v", tt.expression, err)
+			}
+			continue
+		}
+		var compileErr *CompileError
+		if !errors.As(err, &compileErr) || compileErr.Phase != "policy" || compileErr.Message != tt.violation {
+			t.Errorf("%s: expected violation %q, got %v", tt.expression, tt.violation, err)
+		}
+	}
+}
+
+func TestPolicyBuiltinReferences(t *testing.T) {
+	env := map[string]interface{}{"s": "Ann", "m": map[string]interface{}{"now": 1}, "trim": "a"}
+	policy := Policy{DeniedBuiltins: []string{"now", "upper"}}
+
+	tests := []struct {
+		expression string
+		violation  string
+	}{
+		{`now`, "builtin now is not allowed"},
+		{`[now][0]`, "builtin now is not allowed"},
+		{`(true ? upper : lower)(s)`, "builtin upper is not allowed"},
+		{`let f = now; f`, "builtin now is not allowed"},
+		{`let now = 1; now + 1`, ""},
+		{`[1, 2] | map(now => now * 2)`, ""},
+		{`m.now`, ""},
+		{`trim + s`, ""},
+	}
+
+	for _, tt := range tests {
+		_, err := Compile(tt.expression, Env(env), WithPolicy(policy))
+		if tt.violation == "" {
+			if err != nil {
+				t.Errorf("%s: expected no violation, got %v", tt.expression, err)
+			}
+			continue
+		}
+		var compileErr *CompileError
+		if !errors.As(err, &compileErr) || compileErr.Phase != "policy" || compileErr.Message != tt.violation {
+			t.Errorf("%s: expected violation %q, got %v", tt.expression, tt.violation, err)
+		}
+	}
+}
+
+func TestPolicyListsViolations(t *testing.T) {
+	policy := Policy{AllowedBuiltins: []string{"len"}, AllowedOperators: []string{"+"}, MaxNodes: 5}
+	_, err := Compile(`upper("a") + len("b") - debug(1)`, WithPolicy(policy))
+
+	var errs CompileErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected compile errors, got %v", err)
+	}
+	expected := []string{
+		"operator - is not allowed",
+		"builtin upper is not allowed",
+		"builtin debug is not allowed",
+		"expression of 9 nodes exceeds the limit of 5",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d violations, got %v", len(expected), err)
+	}
+	for i, message := range expected {
+		if errs[i].Message != message {
+			t.Errorf("Violation %d: expected %q, got %q", i, message, errs[i].Message)
+		}
+	}
+	if errs[1].Line != 1 || errs[1].Column != 1 || !strings.Contains(errs[1].Snippet, "^^^^^") {
+		t.Errorf("Expected the violation to point at upper, got %+v", errs[1])
+	}
+}
+
+func TestPolicySourceLength(t *testing.T) {
+	_, err := Compile(`1 + 2 + 3`, WithPolicy(Policy{MaxSourceLength: 5}))
+	if err == nil || err.Error() != "policy error: expression of 9 bytes exceeds the limit of 5" {
+		t.Errorf("Expected a source length violation, got %v", err)
+	}
+	if _, err := Compile(`1 + 2`, WithPolicy(Policy{MaxSourceLength: 5})); err != nil {
+		t.Errorf("Expected no violation, got %v", err)
+	}
+}
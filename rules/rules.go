@@ -0,0 +1,181 @@
+// Package rules evaluates sets of named rules. A rule has a boolean
+// condition and an optional outcome expression evaluated when the condition
+// holds; rules are tried by priority and the mode of the set decides how
+// many of them fire.
+package rules
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/mredencom/expr"
+)
+
+// Mode selects which rules of a set fire
+type Mode int
+
+const (
+	// FirstMatch fires the first rule whose condition holds. The outcome
+	// of the evaluation is the outcome of that rule.
+	FirstMatch Mode = iota
+	// AllMatches fires every rule whose condition holds. The evaluation
+	// has no outcome of its own; the outcomes are reported per rule.
+	AllMatches
+	// Collect fires every rule whose condition holds. The outcome of the
+	// evaluation is the list of their outcomes in order.
+	Collect
+)
+
+// String returns the name of the mode
+func (m Mode) String() string {
+	switch m {
+	case FirstMatch:
+		return "first-match"
+	case AllMatches:
+		return "all-matches"
+	case Collect:
+		return "collect"
+	}
+	return fmt.Sprintf("Mode(%d)", int(m))
+}
+
+// Rule is a named condition with the outcome produced when it holds
+type Rule struct {
+	Name      string
+	Priority  int    // Rules with higher priorities are tried first
+	Condition string // Boolean expression
+	Outcome   string // Expression evaluated when the rule fires, optional
+}
+
+// Fired is a rule whose condition held during an evaluation
+type Fired struct {
+	Rule     string
+	Priority int
+	Outcome  interface{} // Value of the outcome expression, nil without one
+}
+
+// Result is the result of evaluating a rule set
+type Result struct {
+	Fired   []Fired     // Rules that fired, in the order they were tried
+	Outcome interface{} // Outcome of the evaluation as defined by the mode
+}
+
+// Matched reports whether the named rule fired
+func (r *Result) Matched(name string) bool {
+	for _, fired := range r.Fired {
+		if fired.Rule == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RuleSet is a compiled set of rules. It is safe for concurrent use.
+type RuleSet struct {
+	mode  Mode
+	rules []*compiledRule // Ordered by priority, then by definition
+}
+
+// compiledRule holds the programs of a rule
+type compiledRule struct {
+	Rule
+	condition *expr.Program
+	outcome   *expr.Program // nil without an outcome
+}
+
+// New compiles a rule set. The options apply to every condition and
+// outcome, typically expr.Env to declare the variables of the environments
+// the set is evaluated against. Rule names must be unique.
+func New(mode Mode, rules []Rule, options ...expr.Option) (*RuleSet, error) {
+	if mode < FirstMatch || mode > Collect {
+		return nil, fmt.Errorf("unknown rule mode %d", int(mode))
+	}
+
+	set := &RuleSet{mode: mode, rules: make([]*compiledRule, 0, len(rules))}
+	names := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule with condition %q has no name", rule.Condition)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicate rule %s", rule.Name)
+		}
+		names[rule.Name] = true
+
+		compiled := &compiledRule{Rule: rule}
+		var err error
+		if compiled.condition, err = expr.CompileAs[bool](rule.Condition, options...); err != nil {
+			return nil, fmt.Errorf("rule %s: condition: %w", rule.Name, err)
+		}
+		if rule.Outcome != "" {
+			if compiled.outcome, err = expr.Compile(rule.Outcome, options...); err != nil {
+				return nil, fmt.Errorf("rule %s: outcome: %w", rule.Name, err)
+			}
+		}
+		set.rules = append(set.rules, compiled)
+	}
+
+	sort.SliceStable(set.rules, func(i, j int) bool {
+		return set.rules[i].Priority > set.rules[j].Priority
+	})
+	return set, nil
+}
+
+// Mode returns the mode of the rule set
+func (s *RuleSet) Mode() Mode {
+	return s.mode
+}
+
+// Rules returns the rules of the set in the order they are tried
+func (s *RuleSet) Rules() []Rule {
+	rules := make([]Rule, len(s.rules))
+	for i, rule := range s.rules {
+		rules[i] = rule.Rule
+	}
+	return rules
+}
+
+// Evaluate tries the rules against an environment, a map or a struct as
+// accepted by expr.Run. The environment is converted once for all the
+// rules. Evaluation stops at the first condition or outcome that fails.
+func (s *RuleSet) Evaluate(environment interface{}) (*Result, error) {
+	session := expr.NewSession(environment)
+	defer session.Close()
+
+	result := &Result{}
+	var outcomes []interface{}
+	for _, rule := range s.rules {
+		value, err := session.Run(rule.condition)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: condition: %w", rule.Name, err)
+		}
+		holds, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("rule %s: condition returned %T, not a bool", rule.Name, value)
+		}
+		if !holds {
+			continue
+		}
+
+		fired := Fired{Rule: rule.Name, Priority: rule.Priority}
+		if rule.outcome != nil {
+			if fired.Outcome, err = session.Run(rule.outcome); err != nil {
+				return nil, fmt.Errorf("rule %s: outcome: %w", rule.Name, err)
+			}
+		}
+		result.Fired = append(result.Fired, fired)
+
+		switch s.mode {
+		case FirstMatch:
+			result.Outcome = fired.Outcome
+			return result, nil
+		case Collect:
+			outcomes = append(outcomes, fired.Outcome)
+		}
+	}
+
+	if s.mode == Collect {
+		result.Outcome = outcomes
+	}
+	return result, nil
+}
@@ -0,0 +1,126 @@
+package rules
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mredencom/expr"
+)
+
+type order struct {
+	Amount  int
+	Country string
+	VIP     bool
+}
+
+var discountRules = []Rule{
+	{Name: "small", Priority: 1, Condition: `order.Amount < 100`, Outcome: `0`},
+	{Name: "vip", Priority: 10, Condition: `order.VIP`, Outcome: `order.Amount / 5`},
+	{Name: "bulk", Priority: 5, Condition: `order.Amount >= 1000`, Outcome: `order.Amount / 10`},
+	{Name: "nordic", Priority: 5, Condition: `order.Country == "NO" || order.Country == "SE"`},
+}
+
+func evaluate(t *testing.T, mode Mode, o order) *Result {
+	t.Helper()
+	set, err := New(mode, discountRules, expr.Env(map[string]interface{}{"order": order{}}))
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	result, err := set.Evaluate(map[string]interface{}{"order": o})
+	if err != nil {
+		t.Fatalf("Evaluate error: %v", err)
+	}
+	return result
+}
+
+func firedNames(result *Result) []string {
+	var names []string
+	for _, fired := range result.Fired {
+		names = append(names, fired.Rule)
+	}
+	return names
+}
+
+func TestModes(t *testing.T) {
+	o := order{Amount: 2000, Country: "NO", VIP: true}
+
+	first := evaluate(t, FirstMatch, o)
+	if !reflect.DeepEqual(firedNames(first), []string{"vip"}) || first.Outcome != int64(400) {
+		t.Errorf("first-match: expected vip with 400, got %v with %v", firedNames(first), first.Outcome)
+	}
+
+	all := evaluate(t, AllMatches, o)
+	if !reflect.DeepEqual(firedNames(all), []string{"vip", "bulk", "nordic"}) || all.Outcome != nil {
+		t.Errorf("all-matches: expected vip, bulk and nordic without outcome, got %v with %v", firedNames(all), all.Outcome)
+	}
+	if all.Fired[1].Outcome != int64(200) || all.Fired[2].Outcome != nil || !all.Matched("nordic") || all.Matched("small") {
+		t.Errorf("all-matches: unexpected fired rules %+v", all.Fired)
+	}
+
+	collect := evaluate(t, Collect, o)
+	if !reflect.DeepEqual(collect.Outcome, []interface{}{int64(400), int64(200), nil}) {
+		t.Errorf("collect: expected [400 200 <nil>], got %v", collect.Outcome)
+	}
+
+	none := evaluate(t, FirstMatch, order{Amount: 500, Country: "DK"})
+	if len(none.Fired) != 0 || none.Outcome != nil {
+		t.Errorf("Expected no rule to fire, got %+v", none)
+	}
+}
+
+func TestRulesOrder(t *testing.T) {
+	set, err := New(AllMatches, discountRules, expr.Env(map[string]interface{}{"order": order{}}))
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	var names []string
+	for _, rule := range set.Rules() {
+		names = append(names, rule.Name)
+	}
+	// Rules of equal priority keep their order
+	if !reflect.DeepEqual(names, []string{"vip", "bulk", "nordic", "small"}) {
+		t.Errorf("Unexpected order %v", names)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	options := expr.Env(map[string]interface{}{"n": 1, "name": ""})
+	invalid := []struct {
+		rules    []Rule
+		expected string
+	}{
+		{[]Rule{{Condition: `n > 1`}}, "has no name"},
+		{[]Rule{{Name: "a", Condition: `n > 1`}, {Name: "a", Condition: `n > 2`}}, "duplicate rule a"},
+		{[]Rule{{Name: "a", Condition: `n +`}}, "rule a: condition:"},
+		{[]Rule{{Name: "a", Condition: `name`}}, "cannot be used as bool"},
+		{[]Rule{{Name: "a", Condition: `n > 1`, Outcome: `missing + 1`}}, "rule a: outcome:"},
+	}
+	for _, tt := range invalid {
+		if _, err := New(FirstMatch, tt.rules, options); err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("Expected an error containing %q, got %v", tt.expected, err)
+		}
+	}
+
+	set, err := New(FirstMatch, []Rule{{Name: "ratio", Condition: `10 / n > 1`}}, options)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	if _, err := set.Evaluate(map[string]interface{}{"n": 0, "name": ""}); err == nil || !strings.Contains(err.Error(), "rule ratio: condition:") {
+		t.Errorf("Expected a runtime error naming the rule, got %v", err)
+	}
+}
+
+func BenchmarkEvaluate(b *testing.B) {
+	set, err := New(AllMatches, discountRules, expr.Env(map[string]interface{}{"order": order{}}))
+	if err != nil {
+		b.Fatalf("New error: %v", err)
+	}
+	environment := map[string]interface{}{"order": order{Amount: 2000, Country: "NO", VIP: true}}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := set.Evaluate(environment); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package expr
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/mredencom/expr/compiler"
+	"github.com/mredencom/expr/env"
+	"github.com/mredencom/expr/modules"
+	"github.com/mredencom/expr/vm"
+)
+
+// programMagic starts every encoded program
+const programMagic = "EXPR"
+
+// programFormat is the version of the program encoding
+const programFormat = 3
+
+// Config flags stored in encoded programs
+const (
+	flagAllowUndefinedVariables = 1 << iota
+	flagDisableAllBuiltins
+	flagEnableTypeChecking
+	flagEnableCache
+	flagEnableOptimization
+	flagEnableDebug
+	flagEnableProfiling
+)
+
+// MarshalBinary encodes the compiled program so that it can be stored and
+// loaded with LoadProgram without compiling it again. Go functions are not
+// encoded: custom functions and operators are recorded by name and signature
+// and modules registered with WithModule by their function names; they must
+// be provided again when loading.
+func (p *Program) MarshalBinary() ([]byte, error) {
+	bytecode, err := p.bytecode.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("encode program: %w", err)
+	}
+
+	e := &vm.Encoder{}
+	e.WriteUint(programFormat)
+	e.WriteString(p.source)
+	e.WriteBytes(bytecode)
+	e.WriteStrings(p.variableOrder)
+	e.WriteStrings(p.references.Variables)
+	e.WriteStrings(p.references.Paths)
+	e.WriteStrings(p.references.Functions)
+	e.WriteStrings(p.references.Modules)
+	encodeConfig(e, p.config)
+
+	return append([]byte(programMagic), e.Data()...), nil
+}
+
+// LoadProgram decodes a program encoded by Program.MarshalBinary. The
+// custom functions, operators and modules the program was compiled with must
+// be passed again as options with the same signatures; other options
+// override the stored configuration, e.g. WithTimeout.
+func LoadProgram(data []byte, options ...Option) (*Program, error) {
+	if len(data) < len(programMagic) || string(data[:len(programMagic)]) != programMagic {
+		return nil, fmt.Errorf("load program: not an encoded program")
+	}
+	d := vm.NewDecoder(data[len(programMagic):])
+
+	if format := d.ReadUint(); format != programFormat {
+		if err := d.Finish(); err != nil {
+			return nil, fmt.Errorf("load program: %w", err)
+		}
+		return nil, fmt.Errorf("load program: unsupported format %d, expected %d", format, programFormat)
+	}
+	source := d.ReadString()
+	encodedBytecode := d.ReadBytes()
+	variableOrder := d.ReadStrings()
+	references := &compiler.References{
+		Variables: d.ReadStrings(),
+		Paths:     d.ReadStrings(),
+		Functions: d.ReadStrings(),
+		Modules:   d.ReadStrings(),
+	}
+	config, refs := decodeConfig(d)
+	if err := d.Finish(); err != nil {
+		return nil, fmt.Errorf("load program: %w", err)
+	}
+
+	bytecode := &vm.Bytecode{}
+	if err := bytecode.UnmarshalBinary(encodedBytecode); err != nil {
+		return nil, fmt.Errorf("load program: %w", err)
+	}
+
+	for _, option := range options {
+		option(config)
+	}
+
+	operators, err := refs.bind(config)
+	if err != nil {
+		return nil, fmt.Errorf("load program: %w", err)
+	}
+	registry, err := moduleRegistry(config)
+	if err != nil {
+		return nil, fmt.Errorf("load program: %w", err)
+	}
+	functions, err := goFunctions(config)
+	if err != nil {
+		return nil, fmt.Errorf("load program: %w", err)
+	}
+
+	return &Program{
+		bytecode:      bytecode,
+		envAdapter:    env.New(),
+		config:        config,
+		variableOrder: variableOrder,
+		operators:     operators,
+		functions:     functions,
+		modules:       registry,
+		references:    references,
+		source:        source,
+	}, nil
+}
+
+// functionRefs are the Go functions an encoded program was compiled with,
+// recorded by their signatures
+type functionRefs struct {
+	builtins  map[string]string
+	operators map[string][]string
+	modules   map[string][]string
+}
+
+// encodeConfig writes the configuration of a program. Functions are written
+// as references.
+func encodeConfig(e *vm.Encoder, config *Config) {
+	var flags uint64
+	for flag, set := range map[uint64]bool{
+		flagAllowUndefinedVariables: config.allowUndefinedVariables,
+		flagDisableAllBuiltins:      config.disableAllBuiltins,
+		flagEnableTypeChecking:      config.enableTypeChecking,
+		flagEnableCache:             config.enableCache,
+		flagEnableOptimization:      config.enableOptimization,
+		flagEnableDebug:             config.enableDebug,
+		flagEnableProfiling:         config.enableProfiling,
+	} {
+		if set {
+			flags |= flag
+		}
+	}
+	e.WriteUint(flags)
+	e.WriteString(config.tagName)
+	e.WriteInt(int64(config.expectedType))
+	e.WriteInt(int64(config.maxExecutionTime))
+	e.WriteStrings(config.constExprs)
+
+	symbols := sortedKeys(config.operators)
+	e.WriteUint(uint64(len(symbols)))
+	for _, symbol := range symbols {
+		e.WriteString(symbol)
+		e.WriteInt(int64(config.operators[symbol]))
+	}
+
+	names := sortedKeys(config.builtins)
+	e.WriteUint(uint64(len(names)))
+	for _, name := range names {
+		e.WriteString(name)
+		e.WriteString(signature(config.builtins[name]))
+	}
+
+	symbols = sortedKeys(config.operatorFuncs)
+	e.WriteUint(uint64(len(symbols)))
+	for _, symbol := range symbols {
+		e.WriteString(symbol)
+		funcs := config.operatorFuncs[symbol]
+		e.WriteUint(uint64(len(funcs)))
+		for _, fn := range funcs {
+			e.WriteString(signature(fn))
+		}
+	}
+
+	e.WriteUint(uint64(len(config.modules)))
+	for _, module := range config.modules {
+		e.WriteString(module.Name)
+		e.WriteStrings(sortedKeys(module.Functions))
+	}
+}
+
+// decodeConfig reads the configuration written by encodeConfig
+func decodeConfig(d *vm.Decoder) (*Config, *functionRefs) {
+	flags := d.ReadUint()
+	config := &Config{
+		allowUndefinedVariables: flags&flagAllowUndefinedVariables != 0,
+		disableAllBuiltins:      flags&flagDisableAllBuiltins != 0,
+		enableTypeChecking:      flags&flagEnableTypeChecking != 0,
+		enableCache:             flags&flagEnableCache != 0,
+		enableOptimization:      flags&flagEnableOptimization != 0,
+		enableDebug:             flags&flagEnableDebug != 0,
+		enableProfiling:         flags&flagEnableProfiling != 0,
+		tagName:                 d.ReadString(),
+		expectedType:            AsKind(d.ReadInt()),
+		maxExecutionTime:        time.Duration(d.ReadInt()),
+		constExprs:              d.ReadStrings(),
+		builtins:                make(map[string]interface{}),
+		operators:               make(map[string]int),
+	}
+
+	for i, n := 0, d.ReadLen(); i < n; i++ {
+		symbol := d.ReadString()
+		config.operators[symbol] = int(d.ReadInt())
+	}
+
+	refs := &functionRefs{builtins: make(map[string]string), operators: make(map[string][]string), modules: make(map[string][]string)}
+	for i, n := 0, d.ReadLen(); i < n; i++ {
+		name := d.ReadString()
+		refs.builtins[name] = d.ReadString()
+	}
+	for i, n := 0, d.ReadLen(); i < n; i++ {
+		symbol := d.ReadString()
+		for j, m := 0, d.ReadLen(); j < m; j++ {
+			refs.operators[symbol] = append(refs.operators[symbol], d.ReadString())
+		}
+	}
+	for i, n := 0, d.ReadLen(); i < n; i++ {
+		name := d.ReadString()
+		refs.modules[name] = d.ReadStrings()
+	}
+	return config, refs
+}
+
+// bind checks that the configuration provides the functions the program was
+// compiled with and returns the operator implementations
+func (refs *functionRefs) bind(config *Config) (map[string][]*vm.OperatorFunc, error) {
+	for _, name := range sortedKeys(refs.builtins) {
+		fn, ok := config.builtins[name]
+		if !ok {
+			return nil, fmt.Errorf("function %s is not provided", name)
+		}
+		if got, want := signature(fn), refs.builtins[name]; got != want {
+			return nil, fmt.Errorf("function %s has signature %s, program was compiled with %s", name, got, want)
+		}
+	}
+
+	for _, name := range sortedKeys(refs.modules) {
+		var module *modules.Module
+		for _, m := range config.modules {
+			if m.Name == name {
+				module = m
+			}
+		}
+		if module == nil {
+			return nil, fmt.Errorf("module %s is not provided", name)
+		}
+		for _, function := range refs.modules[name] {
+			if _, ok := module.Functions[function]; !ok {
+				return nil, fmt.Errorf("module %s has no function %s, program was compiled with it", name, function)
+			}
+		}
+	}
+
+	operators := make(map[string][]*vm.OperatorFunc, len(refs.operators))
+	for _, symbol := range sortedKeys(refs.operators) {
+		want, funcs := refs.operators[symbol], config.operatorFuncs[symbol]
+		if len(funcs) != len(want) {
+			return nil, fmt.Errorf("operator %s has %d implementations, program was compiled with %d", symbol, len(funcs), len(want))
+		}
+		for i, fn := range funcs {
+			if got := signature(fn); got != want[i] {
+				return nil, fmt.Errorf("operator %s implementation %d has signature %s, program was compiled with %s", symbol, i+1, got, want[i])
+			}
+			operatorFunc, err := vm.NewOperatorFunc(fn)
+			if err != nil {
+				return nil, fmt.Errorf("operator %s: %v", symbol, err)
+			}
+			operators[symbol] = append(operators[symbol], operatorFunc)
+		}
+	}
+	return operators, nil
+}
+
+// signature describes the type of a Go function
+func signature(fn interface{}) string {
+	if fn == nil {
+		return "nil"
+	}
+	return reflect.TypeOf(fn).String()
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
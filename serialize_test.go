@@ -0,0 +1,198 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mredencom/expr/modules"
+	"github.com/mredencom/expr/vm"
+)
+
+func TestProgramMarshalBinary(t *testing.T) {
+	env := map[string]interface{}{
+		"items": []int{1, 2, 3, 4},
+		"x":     3,
+		"user":  map[string]interface{}{"name": "Ann"},
+	}
+	expressions := []string{
+		"map(items, (v) => v * x)",
+		"items | filter(# > 2) | map(# * 10)",
+		`{"a": [1, 2.5, "s"], "b": true}`,
+		`x > 2 ? user.name : "nobody"`,
+		"items | map(#.value)",
+	}
+
+	for _, expression := range expressions {
+		t.Run(expression, func(t *testing.T) {
+			program, err := Compile(expression, Env(env))
+			if err != nil {
+				t.Fatalf("unexpected compile error: %v", err)
+			}
+			expected, err := Run(program, env)
+			if err != nil {
+				t.Fatalf("unexpected runtime error: %v", err)
+			}
+
+			data, err := program.MarshalBinary()
+			if err != nil {
+				t.Fatalf("unexpected encoding error: %v", err)
+			}
+			loaded, err := LoadProgram(data)
+			if err != nil {
+				t.Fatalf("unexpected loading error: %v", err)
+			}
+			if loaded.Source() != expression {
+				t.Errorf("expected source %q, got %q", expression, loaded.Source())
+			}
+			if fmt.Sprint(loaded.Paths(), loaded.Functions()) != fmt.Sprint(program.Paths(), program.Functions()) {
+				t.Errorf("expected references %v %v, got %v %v", program.Paths(), program.Functions(), loaded.Paths(), loaded.Functions())
+			}
+
+			result, err := Run(loaded, env)
+			if err != nil {
+				t.Fatalf("unexpected runtime error: %v", err)
+			}
+			if fmt.Sprint(result) != fmt.Sprint(expected) {
+				t.Errorf("expected %v, got %v", expected, result)
+			}
+		})
+	}
+}
+
+func TestLoadProgramConfig(t *testing.T) {
+	env := map[string]interface{}{"a": 1, "b": 0}
+	program, err := Compile("a / b", Env(env), WithTimeout(time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected compile error: %v", err)
+	}
+	data, err := program.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected encoding error: %v", err)
+	}
+
+	loaded, err := LoadProgram(data)
+	if err != nil {
+		t.Fatalf("unexpected loading error: %v", err)
+	}
+	if loaded.config.maxExecutionTime != time.Minute {
+		t.Errorf("expected the timeout to be kept, got %v", loaded.config.maxExecutionTime)
+	}
+
+	// Runtime errors are still located in the source
+	_, err = Run(loaded, env)
+	if err == nil || !strings.Contains(err.Error(), "line 1, column 3") {
+		t.Errorf("expected a located runtime error, got %v", err)
+	}
+
+	loaded, err = LoadProgram(data, WithTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("unexpected loading error: %v", err)
+	}
+	if loaded.config.maxExecutionTime != time.Second {
+		t.Errorf("expected options to override the stored timeout, got %v", loaded.config.maxExecutionTime)
+	}
+}
+
+func TestLoadProgramOperators(t *testing.T) {
+	add := func(a, b testMoney) testMoney {
+		return testMoney{Amount: a.Amount + b.Amount, Currency: a.Currency}
+	}
+	env := map[string]interface{}{
+		"a": testMoney{Amount: 1, Currency: "EUR"},
+		"b": testMoney{Amount: 2, Currency: "EUR"},
+	}
+	program, err := Compile("a + b", Env(env), WithOperatorFunc("+", 0, add))
+	if err != nil {
+		t.Fatalf("unexpected compile error: %v", err)
+	}
+	data, err := program.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected encoding error: %v", err)
+	}
+
+	if _, err := LoadProgram(data); err == nil || !strings.Contains(err.Error(), "operator +") {
+		t.Errorf("expected missing operator implementations to be rejected, got %v", err)
+	}
+	wrong := func(a, b int) int { return a + b }
+	if _, err := LoadProgram(data, WithOperatorFunc("+", 0, wrong)); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Errorf("expected a signature mismatch, got %v", err)
+	}
+
+	loaded, err := LoadProgram(data, WithOperatorFunc("+", 0, add))
+	if err != nil {
+		t.Fatalf("unexpected loading error: %v", err)
+	}
+	result, err := Run(loaded, env)
+	if err != nil {
+		t.Fatalf("unexpected runtime error: %v", err)
+	}
+	if fmt.Sprint(result) != "map[Amount:3 Currency:EUR]" {
+		t.Errorf("unexpected result %v", result)
+	}
+}
+
+func TestLoadProgramModules(t *testing.T) {
+	env := map[string]interface{}{"a": 1.5, "b": 4}
+	program, err := Compile("geo.distance(a, b)", Env(env), WithModule("geo", "Distances", geoFunctions))
+	if err != nil {
+		t.Fatalf("unexpected compile error: %v", err)
+	}
+	data, err := program.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected encoding error: %v", err)
+	}
+
+	if _, err := LoadProgram(data); err == nil || !strings.Contains(err.Error(), "module geo is not provided") {
+		t.Errorf("expected missing module to be rejected, got %v", err)
+	}
+	partial := map[string]*modules.ModuleFunction{"distance": geoFunctions["distance"]}
+	if _, err := LoadProgram(data, WithModule("geo", "Distances", partial)); err == nil || !strings.Contains(err.Error(), "no function nearest") {
+		t.Errorf("expected missing module function to be rejected, got %v", err)
+	}
+
+	loaded, err := LoadProgram(data, WithModule("geo", "Distances", geoFunctions))
+	if err != nil {
+		t.Fatalf("unexpected loading error: %v", err)
+	}
+	result, err := Run(loaded, env)
+	if err != nil {
+		t.Fatalf("unexpected runtime error: %v", err)
+	}
+	if result != 2.5 {
+		t.Errorf("unexpected result %v", result)
+	}
+}
+
+func TestLoadProgramInvalid(t *testing.T) {
+	program, err := Compile("1 + 2")
+	if err != nil {
+		t.Fatalf("unexpected compile error: %v", err)
+	}
+	data, err := program.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected encoding error: %v", err)
+	}
+
+	tests := map[string][]byte{
+		"empty":     nil,
+		"magic":     append([]byte("JUNK"), data[4:]...),
+		"truncated": data[:len(data)-3],
+		"format":    append([]byte("EXPR\x09"), data[5:]...),
+	}
+	for name, input := range tests {
+		if _, err := LoadProgram(input); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	program.bytecode = &vm.Bytecode{Instructions: vm.Make(vm.OpAdd)}
+	data, err = program.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected encoding error: %v", err)
+	}
+	if _, err := LoadProgram(data); err == nil || !strings.Contains(err.Error(), "invalid bytecode") {
+		t.Errorf("expected corrupt bytecode to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package expr
+
+import (
+	"github.com/mredencom/expr/types"
+	"github.com/mredencom/expr/vm"
+)
+
+// Session runs several programs against one environment on a single VM.
+// Each variable of the environment is converted once, the first time a
+// program reads it, and shared by the programs run after. A session is not
+// safe for concurrent use and must be closed to release its VM.
+type Session struct {
+	environment interface{}
+	machine     *vm.VM
+
+	// Variables of the environment and their converted values, valid for
+	// the tag name of the programs they were read for
+	tagName   string
+	variables map[string]interface{}
+	values    map[string]types.Value
+}
+
+// NewSession creates a session for an environment, a map or a struct as
+// accepted by Run
+func NewSession(environment interface{}) *Session {
+	return &Session{environment: environment, machine: vm.GlobalVMPool.Get()}
+}
+
+// Run executes a program against the session environment
+func (s *Session) Run(program *Program) (interface{}, error) {
+	if s.variables == nil || s.tagName != program.config.tagName {
+		s.tagName = program.config.tagName
+		s.variables, _ = environmentVariables(s.environment, s.tagName)
+		s.values = make(map[string]types.Value)
+	}
+
+	program.prepare(s.machine)
+	if err := s.machine.SetEnvironmentCached(s.variables, s.values, program.variableOrder); err != nil {
+		return nil, &RuntimeError{Message: "environment setup error", Cause: err}
+	}
+	return program.execute(s.machine)
+}
+
+// Close releases the VM of the session. The session cannot be used after.
+func (s *Session) Close() {
+	if s.machine != nil {
+		vm.GlobalVMPool.Put(s.machine)
+		s.machine = nil
+	}
+}
@@ -0,0 +1,41 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+)
+
+type sessionUser struct {
+	Name string
+	Age  int
+}
+
+func TestSession(t *testing.T) {
+	env := map[string]interface{}{"user": sessionUser{Name: "ann", Age: 30}, "limit": 18}
+	adult, err := Compile(`user.Age >= limit`, Env(env))
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+	greeting, err := Compile(`let n = upper(user.Name); "hi " + n`, Env(env))
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+	ratio, err := Compile(`limit / (user.Age - 30)`, Env(env))
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+
+	session := NewSession(env)
+	defer session.Close()
+	for i := 0; i < 2; i++ {
+		if result, err := session.Run(adult); err != nil || result != true {
+			t.Errorf("Expected true, got %v (%v)", result, err)
+		}
+		if result, err := session.Run(greeting); err != nil || result != "hi ANN" {
+			t.Errorf("Expected hi ANN, got %v (%v)", result, err)
+		}
+		if _, err := session.Run(ratio); err == nil || !strings.Contains(err.Error(), "division by zero") {
+			t.Errorf("Expected a division error, got %v", err)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/mredencom/expr/debug"
+	"github.com/mredencom/expr/lexer"
 	"github.com/mredencom/expr/types"
 	"github.com/mredencom/expr/vm"
 )
@@ -243,6 +244,47 @@ func TestDebugger(t *testing.T) {
 	})
 }
 
+func TestDebuggerSourceMap(t *testing.T) {
+	source := "a +\n  b"
+	positions := vm.SourceMap{
+		{Offset: 0, Pos: lexer.Position{Line: 1, Column: 1, Offset: 0}},
+		{Offset: 3, Pos: lexer.Position{Line: 2, Column: 3, Offset: 6}},
+		{Offset: 6, Pos: lexer.Position{Line: 1, Column: 3, Offset: 2}},
+	}
+
+	debugger := debug.New()
+	debugger.Enable()
+	debugger.SetSourceMap(source, positions)
+
+	bp, err := debugger.SetBreakpointAt(2, 0)
+	if err != nil {
+		t.Fatalf("设置源码断点失败: %v", err)
+	}
+	if bp.PC != 3 || bp.Position.Line != 2 || bp.Source != "  b" {
+		t.Errorf("断点位置错误: PC=%d, 位置=%v, 源码=%q", bp.PC, bp.Position, bp.Source)
+	}
+	if _, err := debugger.SetBreakpointAt(3, 0); err == nil {
+		t.Error("没有指令的行不应该设置断点")
+	}
+
+	var hit *debug.DebugContext
+	debugger.OnBreakpoint(func(ctx *debug.DebugContext) {
+		hit = ctx
+	})
+	debugger.OnInstruction(0, []byte{byte(vm.OpGetVar), 0, 0}, nil)
+	debugger.OnInstruction(3, []byte{byte(vm.OpGetVar), 0, 1}, nil)
+	debugger.OnInstruction(6, []byte{byte(vm.OpAdd)}, nil)
+
+	if hit == nil || hit.Position.Line != 2 || hit.Position.Column != 3 {
+		t.Fatalf("断点上下文应该包含源码位置, 得到 %+v", hit)
+	}
+
+	stats := debugger.GetStats()
+	if len(stats.HotSpots) != 3 || !stats.HotSpots[0].Position.Valid() {
+		t.Errorf("热点应该按指令位置统计, 得到 %+v", stats.HotSpots)
+	}
+}
+
 func TestBreakpoint(t *testing.T) {
 	t.Run("CreateBreakpoint", func(t *testing.T) {
 		bp := debug.NewBreakpoint(15)
@@ -0,0 +1,86 @@
+package expr
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/mredencom/expr/env"
+	"github.com/mredencom/expr/types"
+)
+
+// CompileAs compiles an expression whose result is used as a T. Compilation
+// fails when the type checker can tell that the expression never produces a
+// value convertible to T.
+func CompileAs[T any](expression string, options ...Option) (*Program, error) {
+	resultType := reflect.TypeOf((*T)(nil)).Elem()
+	return Compile(expression, append(options, func(c *Config) {
+		c.resultType = resultType
+	})...)
+}
+
+// RunAs executes a program and converts its result to T. Any T accepted by
+// custom function parameters works, including slices, maps, structs and
+// named types such as time.Duration.
+func RunAs[T any](program *Program, environment interface{}) (T, error) {
+	var zero T
+	result, _, err := runProgram(context.Background(), program, environment)
+	if err != nil {
+		return zero, err
+	}
+
+	target := reflect.TypeOf(&zero).Elem()
+	converted, ok := env.ConvertTo(result, target, program.config.tagName, true)
+	if !ok {
+		return zero, &RuntimeError{Message: fmt.Sprintf("cannot use %s result as %s", result.Type(), target)}
+	}
+	if !converted.IsValid() {
+		return zero, nil
+	}
+	value, _ := converted.Interface().(T) // Zero for a nil interface
+	return value, nil
+}
+
+// validateResultType checks that a result of the statically known type can
+// be converted to target. Results of unknown type are accepted.
+func validateResultType(result types.TypeInfo, target reflect.Type, tagName string) error {
+	expected := resultClass(env.TypeInfoOfType(target, tagName))
+	actual := resultClass(result)
+	if expected == "" || actual == "" || expected == actual {
+		return nil
+	}
+
+	if actual == "nil" {
+		switch target.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map:
+			return nil
+		}
+	}
+	return fmt.Errorf("expression of type %s cannot be used as %s", result, target)
+}
+
+// resultClass groups the types that convert into each other at runtime:
+// numbers, strings, bools, lists and objects. Interfaces and types the
+// checker cannot determine have no class.
+func resultClass(t types.TypeInfo) string {
+	switch {
+	case t.Name == "":
+		return ""
+	case t.IsNumeric():
+		return "number"
+	}
+
+	switch t.Kind {
+	case types.KindString:
+		return "string"
+	case types.KindBool:
+		return "bool"
+	case types.KindSlice, types.KindArray:
+		return "list"
+	case types.KindMap, types.KindStruct:
+		return "object"
+	case types.KindNil:
+		return "nil"
+	}
+	return ""
+}
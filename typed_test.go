@@ -0,0 +1,85 @@
+package expr
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type typedPoint struct {
+	X, Y int
+}
+
+func TestRunAs(t *testing.T) {
+	env := map[string]interface{}{
+		"names":   []string{"ann", "bob"},
+		"seconds": 3,
+		"prices":  map[string]float64{"tea": 2.5},
+	}
+
+	names, err := runAs[[]string](`names | map(# + "!")`, env)
+	if err != nil || !reflect.DeepEqual(names, []string{"ann!", "bob!"}) {
+		t.Errorf("Expected [ann! bob!], got %#v (%v)", names, err)
+	}
+
+	prices, err := runAs[map[string]float64](`{"tea": prices.tea * 2, "coffee": 3}`, env)
+	if err != nil || !reflect.DeepEqual(prices, map[string]float64{"tea": 5, "coffee": 3}) {
+		t.Errorf("Expected prices map, got %#v (%v)", prices, err)
+	}
+
+	timeout, err := runAs[time.Duration](`seconds * 1000000000`, env)
+	if err != nil || timeout != 3*time.Second {
+		t.Errorf("Expected 3s, got %v (%v)", timeout, err)
+	}
+
+	point, err := runAs[typedPoint](`{"X": 1, "Y": seconds}`, env)
+	if err != nil || point != (typedPoint{X: 1, Y: 3}) {
+		t.Errorf("Expected {1 3}, got %+v (%v)", point, err)
+	}
+
+	missing, err := runAs[interface{}](`prices.milk`, env)
+	if err != nil || missing != nil {
+		t.Errorf("Expected nil, got %v (%v)", missing, err)
+	}
+
+	program, err := Compile(`"ten"`)
+	if err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+	if _, err := RunAs[int](program, nil); err == nil || !strings.Contains(err.Error(), "cannot use string result as int") {
+		t.Errorf("Expected a conversion error, got %v", err)
+	}
+}
+
+func TestCompileAs(t *testing.T) {
+	env := map[string]interface{}{"n": 3, "name": "ann", "any": nil}
+
+	if _, err := CompileAs[int](`"abc"`); err == nil || !strings.Contains(err.Error(), "expression of type string cannot be used as int") {
+		t.Errorf("Expected a string result to be rejected for int, got %v", err)
+	}
+	if _, err := CompileAs[[]string](`n + 1`, Env(env)); err == nil || !strings.Contains(err.Error(), "expression of type int cannot be used as []string") {
+		t.Errorf("Expected an int result to be rejected for []string, got %v", err)
+	}
+	if _, err := CompileAs[typedPoint](`name == "ann"`, Env(env)); err == nil || !strings.Contains(err.Error(), "cannot be used as expr.typedPoint") {
+		t.Errorf("Expected a bool result to be rejected for a struct, got %v", err)
+	}
+
+	// Results the checker cannot determine are accepted
+	if _, err := CompileAs[[]string](`any`, Env(env)); err != nil {
+		t.Errorf("Unexpected error for a result of unknown type: %v", err)
+	}
+	if _, err := CompileAs[time.Duration](`n * 2`, Env(env)); err != nil {
+		t.Errorf("Unexpected error for a numeric result: %v", err)
+	}
+}
+
+// runAs compiles an expression for T and runs it
+func runAs[T any](expression string, env map[string]interface{}) (T, error) {
+	program, err := CompileAs[T](expression, Env(env))
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return RunAs[T](program, env)
+}
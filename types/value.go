@@ -155,6 +155,7 @@ func (s *StringValue) Value() string {
 type SliceValue struct {
 	values   []Value
 	elemType TypeInfo
+	source   interface{} // Go value the slice was converted from, if it has methods
 }
 
 func NewSlice(values []Value, elemType TypeInfo) *SliceValue {
@@ -207,6 +208,17 @@ func (s *SliceValue) Get(index int) Value {
 	return s.values[index]
 }
 
+// Source returns the Go value the slice was converted from, or nil
+func (s *SliceValue) Source() interface{} {
+	return s.source
+}
+
+// SetSource records the Go value the slice was converted from, so that its
+// methods can be called from expressions
+func (s *SliceValue) SetSource(source interface{}) {
+	s.source = source
+}
+
 // ElementType returns the element type of the slice
 func (s *SliceValue) ElementType() TypeInfo {
 	return s.elemType
@@ -216,6 +228,7 @@ func (s *SliceValue) ElementType() TypeInfo {
 type MapValue struct {
 	values           map[string]Value
 	keyType, valType TypeInfo
+	source           interface{} // Go value the map was converted from, if it has methods
 }
 
 func NewMap(values map[string]Value, keyType, valType TypeInfo) *MapValue {
@@ -281,11 +294,27 @@ func (m *MapValue) Keys() []string {
 	return keys
 }
 
+// KeyType returns the key type of the map
+func (m *MapValue) KeyType() TypeInfo {
+	return m.keyType
+}
+
 // ValueType returns the value type of the map
 func (m *MapValue) ValueType() TypeInfo {
 	return m.valType
 }
 
+// Source returns the Go value the map was converted from, or nil
+func (m *MapValue) Source() interface{} {
+	return m.source
+}
+
+// SetSource records the Go value the map was converted from, so that its
+// methods can be called from expressions
+func (m *MapValue) SetSource(source interface{}) {
+	m.source = source
+}
+
 func (m *MapValue) Has(key string) bool {
 	_, exists := m.values[key]
 	return exists
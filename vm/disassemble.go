@@ -0,0 +1,236 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mredencom/expr/builtins"
+	"github.com/mredencom/expr/lexer"
+	"github.com/mredencom/expr/types"
+)
+
+// Instruction is a decoded instruction
+type Instruction struct {
+	Offset   int
+	Op       Opcode
+	Operands []int
+	Comment  string         // What the operands refer to: constant values, variable and function names
+	Pos      lexer.Position // Source position, Line is 0 when unknown
+}
+
+// Code is the disassembly of an instruction sequence: the program itself or
+// a lambda or placeholder body held in its constants
+type Code struct {
+	Name         string // "main", "function constant 3", "placeholder constant 5"
+	Constant     int    // Index of the constant holding the body, -1 for main
+	Instructions []Instruction
+}
+
+// Disassemble decodes the instructions of a program and of the lambda and
+// placeholder bodies in its constant pool. Globals are the names of the
+// global variables by index and are used to annotate variable accesses.
+func Disassemble(bytecode *Bytecode, globals []string) ([]Code, error) {
+	d := &disassembler{globals: globals}
+	main, err := d.decode(bytecode.Instructions, bytecode.Constants, bytecode.Positions, nil)
+	if err != nil {
+		return nil, err
+	}
+	codes := []Code{{Name: "main", Constant: -1, Instructions: main}}
+	return d.bodies(codes, "", bytecode.Constants)
+}
+
+// Listing formats disassembled code as text, one instruction per line. The
+// source position is shown where it changes.
+func Listing(codes []Code) string {
+	var b strings.Builder
+	for i, code := range codes {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(code.Name + ":\n")
+		var last lexer.Position
+		for _, ins := range code.Instructions {
+			pos := ""
+			if ins.Pos.Line > 0 && ins.Pos != last {
+				pos = fmt.Sprintf("%d:%d", ins.Pos.Line, ins.Pos.Column)
+				last = ins.Pos
+			}
+			line := fmt.Sprintf("%04d %-7s %-28s", ins.Offset, pos, ins.String())
+			if ins.Comment != "" {
+				line += " ; " + ins.Comment
+			}
+			b.WriteString(strings.TrimRight(line, " ") + "\n")
+		}
+	}
+	return b.String()
+}
+
+// String formats the opcode and operands of the instruction
+func (ins Instruction) String() string {
+	def, err := Lookup(ins.Op)
+	if err != nil {
+		return err.Error()
+	}
+	return FormatInstruction(def, ins.Operands)
+}
+
+// disassembler decodes instructions using the names of the program globals
+type disassembler struct {
+	globals []string
+}
+
+// bodies appends the disassembly of the bodies held by constants
+func (d *disassembler) bodies(codes []Code, where string, constants []types.Value) ([]Code, error) {
+	for i, constant := range constants {
+		switch c := constant.(type) {
+		case *types.FuncValue:
+			fn, ok := c.Body().(*CompiledFunction)
+			if !ok {
+				continue
+			}
+			instructions, err := d.decode(fn.Instructions, constants, fn.Positions, c)
+			if err != nil {
+				return nil, err
+			}
+			codes = append(codes, Code{Name: fmt.Sprintf("%sfunction constant %d", where, i), Constant: i, Instructions: instructions})
+		case *types.PlaceholderExprValue:
+			if len(c.Instructions()) == 0 {
+				continue
+			}
+			name := fmt.Sprintf("%splaceholder constant %d", where, i)
+			instructions, err := d.decode(c.Instructions(), c.Constants(), nil, nil)
+			if err != nil {
+				return nil, err
+			}
+			codes = append(codes, Code{Name: name, Constant: i, Instructions: instructions})
+
+			// Placeholder bodies have a constant pool of their own
+			if codes, err = d.bodies(codes, name+", ", c.Constants()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return codes, nil
+}
+
+// decode splits instructions and annotates their operands. fn is the
+// function the instructions are the body of, nil at top level.
+func (d *disassembler) decode(instructions []byte, constants []types.Value, positions SourceMap, fn *types.FuncValue) ([]Instruction, error) {
+	var decoded []Instruction
+	for ip := 0; ip < len(instructions); {
+		op := Opcode(instructions[ip])
+		def, err := Lookup(op)
+		if err != nil {
+			return nil, fmt.Errorf("cannot disassemble offset %d: %v", ip, err)
+		}
+		operands, width := ReadOperands(def, instructions[ip+1:])
+		if len(operands) != len(def.OperandWidth) {
+			return nil, fmt.Errorf("cannot disassemble offset %d: %s is missing operands", ip, def.Name)
+		}
+
+		ins := Instruction{Offset: ip, Op: op, Operands: operands}
+		ins.Comment = d.comment(op, operands, constants, fn)
+		ins.Pos, _ = positions.Lookup(ip)
+		decoded = append(decoded, ins)
+		ip += 1 + width
+	}
+	return decoded, nil
+}
+
+// comment describes what the operands of an instruction refer to
+func (d *disassembler) comment(op Opcode, operands []int, constants []types.Value, fn *types.FuncValue) string {
+	switch op {
+	case OpConstant:
+		return constantString(constants, operands[0])
+	case OpClosure:
+		comment := fmt.Sprintf("function constant %d", operands[0])
+		if funcVal, ok := constantAt(constants, operands[0]).(*types.FuncValue); ok {
+			if body, ok := funcVal.Body().(*CompiledFunction); ok && len(body.FreeNames) > 0 {
+				comment += ", captures " + strings.Join(body.FreeNames, ", ")
+			}
+		}
+		return comment
+	case OpGetVar, OpSetVar, OpRestElement:
+		return d.global(operands[0])
+	case OpArrayDestructure, OpObjectDestructure:
+		names := make([]string, operands[0])
+		for i := range names {
+			names[i] = d.global(operands[1] + i)
+		}
+		return strings.Join(names, ", ")
+	case OpGetLocal:
+		if fn != nil && operands[0] < len(fn.Parameters()) {
+			return fn.Parameters()[operands[0]]
+		}
+		return fmt.Sprintf("local %d", operands[0])
+	case OpGetFree:
+		if fn != nil {
+			if body, ok := fn.Body().(*CompiledFunction); ok && operands[0] < len(body.FreeNames) {
+				return body.FreeNames[operands[0]]
+			}
+		}
+	case OpOperator:
+		return "operator " + constantName(constants, operands[0])
+	case OpCallFunction:
+		return constantName(constants, operands[0]) + ", " + arguments(operands[1])
+	case OpModuleCall:
+		return constantName(constants, operands[0]) + "." + constantName(constants, operands[1]) + ", " + arguments(operands[2])
+	case OpBuiltin:
+		name := fmt.Sprintf("builtin %d", operands[0])
+		if operands[0] < len(builtins.StandardBuiltinNames) {
+			name = builtins.StandardBuiltinNames[operands[0]]
+		}
+		return name + ", " + arguments(operands[1])
+	case OpCall:
+		return arguments(operands[0])
+	case OpSlice:
+		return fmt.Sprintf("%d elements", operands[0])
+	case OpMap:
+		return fmt.Sprintf("%d pairs", operands[0])
+	}
+	return ""
+}
+
+// global returns the name of a global variable
+func (d *disassembler) global(index int) string {
+	if index < len(d.globals) && d.globals[index] != "" {
+		return d.globals[index]
+	}
+	return fmt.Sprintf("global %d", index)
+}
+
+// constantAt returns a constant, or nil when the index is out of range
+func constantAt(constants []types.Value, index int) types.Value {
+	if index < len(constants) {
+		return constants[index]
+	}
+	return nil
+}
+
+// constantString formats a constant, quoting strings
+func constantString(constants []types.Value, index int) string {
+	switch c := constantAt(constants, index).(type) {
+	case nil:
+		return fmt.Sprintf("constant %d out of range", index)
+	case *types.StringValue:
+		return fmt.Sprintf("%q", c.Value())
+	default:
+		return c.String()
+	}
+}
+
+// constantName returns a string constant holding a name
+func constantName(constants []types.Value, index int) string {
+	if str, ok := constantAt(constants, index).(*types.StringValue); ok {
+		return str.Value()
+	}
+	return fmt.Sprintf("constant %d", index)
+}
+
+// arguments describes an argument count
+func arguments(n int) string {
+	if n == 1 {
+		return "1 argument"
+	}
+	return fmt.Sprintf("%d arguments", n)
+}
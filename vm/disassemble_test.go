@@ -0,0 +1,90 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mredencom/expr/lexer"
+	"github.com/mredencom/expr/types"
+)
+
+func TestDisassemble(t *testing.T) {
+	lambda := &CompiledFunction{
+		Instructions: concatInstructions(Make(OpGetLocal, 0), Make(OpGetFree, 0), Make(OpAdd)),
+		NumLocals:    1,
+		FreeNames:    []string{"offset"},
+	}
+	bytecode := &Bytecode{
+		Instructions: concatInstructions(
+			Make(OpGetVar, 1),
+			Make(OpConstant, 0),
+			Make(OpMember),
+			Make(OpClosure, 2, 1),
+			Make(OpModuleCall, 3, 4, 1),
+			Make(OpBuiltin, 0, 1),
+		),
+		Constants: []types.Value{
+			types.NewString("age"),
+			types.NewInt(1),
+			types.NewFunc([]string{"x"}, lambda, nil, ""),
+			types.NewString("math"),
+			types.NewString("abs"),
+		},
+		Positions: SourceMap{{Offset: 0, Pos: lexer.Position{Line: 1, Column: 1}}, {Offset: 3, Pos: lexer.Position{Line: 1, Column: 6}}},
+	}
+
+	codes, err := Disassemble(bytecode, []string{"offset", "user"})
+	if err != nil {
+		t.Fatalf("Disassemble error: %v", err)
+	}
+	if len(codes) != 2 || codes[0].Name != "main" || codes[1].Name != "function constant 2" || codes[1].Constant != 2 {
+		t.Fatalf("Expected main and function constant 2, got %+v", codes)
+	}
+
+	main := codes[0].Instructions
+	expected := []struct {
+		offset  int
+		text    string
+		comment string
+		line    int
+	}{
+		{0, "OpGetVar 1", "user", 1},
+		{3, "OpConstant 0", `"age"`, 1},
+		{6, "OpMember", "", 1},
+		{7, "OpClosure 2 1", "function constant 2, captures offset", 1},
+		{11, "OpModuleCall 3 4 1", "math.abs, 1 argument", 1},
+		{17, "OpBuiltin 0 1", "len, 1 argument", 1},
+	}
+	if len(main) != len(expected) {
+		t.Fatalf("Expected %d instructions, got %d", len(expected), len(main))
+	}
+	for i, want := range expected {
+		ins := main[i]
+		if ins.Offset != want.offset || ins.String() != want.text || ins.Comment != want.comment || ins.Pos.Line != want.line {
+			t.Errorf("Instruction %d: expected %d %s ; %s, got %d %s ; %s at %v", i, want.offset, want.text, want.comment, ins.Offset, ins, ins.Comment, ins.Pos)
+		}
+	}
+	if main[1].Pos.Column != 6 {
+		t.Errorf("Expected OpConstant at column 6, got %v", main[1].Pos)
+	}
+
+	body := codes[1].Instructions
+	if len(body) != 3 || body[0].Comment != "x" || body[1].Comment != "offset" {
+		t.Errorf("Expected the lambda parameter and captured variable to be named, got %+v", body)
+	}
+
+	listing := Listing(codes)
+	for _, want := range []string{"main:\n", "0000 1:1     OpGetVar 1", "; user\n", "; math.abs, 1 argument\n", "\nfunction constant 2:\n"} {
+		if !strings.Contains(listing, want) {
+			t.Errorf("Expected listing to contain %q, got:\n%s", want, listing)
+		}
+	}
+}
+
+func TestDisassembleRejectsInvalidBytecode(t *testing.T) {
+	for _, instructions := range [][]byte{{255}, Make(OpConstant, 1)[:2]} {
+		if _, err := Disassemble(&Bytecode{Instructions: instructions}, nil); err == nil || !strings.Contains(err.Error(), "cannot disassemble offset 0") {
+			t.Errorf("Expected an error for %v, got %v", instructions, err)
+		}
+	}
+}
@@ -0,0 +1,456 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/mredencom/expr/lexer"
+	"github.com/mredencom/expr/types"
+)
+
+// bytecodeMagic starts every encoded bytecode
+const bytecodeMagic = "EXBC"
+
+// bytecodeFormat is the version of the bytecode encoding
+const bytecodeFormat = 1
+
+// Tags of encoded constants
+const (
+	tagNil byte = iota
+	tagBool
+	tagInt
+	tagFloat
+	tagString
+	tagSlice
+	tagMap
+	tagFunc
+	tagPlaceholderExpr
+)
+
+// MarshalBinary encodes the bytecode with its constants and source map. The
+// encoding records the instruction set it was compiled for.
+func (b *Bytecode) MarshalBinary() ([]byte, error) {
+	e := &Encoder{}
+	e.buf = append(e.buf, bytecodeMagic...)
+	e.WriteUint(bytecodeFormat)
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, InstructionSet())
+
+	e.WriteBytes(b.Instructions)
+	e.sourceMap(b.Positions)
+	if err := e.values(b.Constants); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+// UnmarshalBinary decodes bytecode encoded by MarshalBinary. Bytecode
+// compiled for another instruction set or failing Verify is rejected.
+func (b *Bytecode) UnmarshalBinary(data []byte) error {
+	if len(data) < len(bytecodeMagic) || string(data[:len(bytecodeMagic)]) != bytecodeMagic {
+		return fmt.Errorf("invalid bytecode: bad magic")
+	}
+	d := NewDecoder(data[len(bytecodeMagic):])
+
+	if format := d.ReadUint(); d.err == nil && format != bytecodeFormat {
+		return fmt.Errorf("unsupported bytecode format %d, expected %d", format, bytecodeFormat)
+	}
+	if len(d.data) < 8 {
+		return fmt.Errorf("invalid bytecode: truncated header")
+	}
+	set := binary.LittleEndian.Uint64(d.data)
+	d.data = d.data[8:]
+	if set != InstructionSet() {
+		return fmt.Errorf("bytecode was compiled for instruction set %016x, this build runs %016x", set, InstructionSet())
+	}
+
+	instructions := d.ReadBytes()
+	positions := d.sourceMap()
+	constants := d.values(0)
+	if err := d.Finish(); err != nil {
+		return fmt.Errorf("invalid bytecode: %w", err)
+	}
+
+	decoded := &Bytecode{Instructions: instructions, Constants: constants, Positions: positions}
+	if err := Verify(decoded); err != nil {
+		return err
+	}
+	*b = *decoded
+	return nil
+}
+
+// Encoder writes the primitives of binary formats
+type Encoder struct {
+	buf []byte
+}
+
+// Data returns the encoded bytes
+func (e *Encoder) Data() []byte {
+	return e.buf
+}
+
+// WriteUint writes an unsigned integer
+func (e *Encoder) WriteUint(v uint64) {
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+// WriteInt writes a signed integer
+func (e *Encoder) WriteInt(v int64) {
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+// WriteBool writes a boolean
+func (e *Encoder) WriteBool(v bool) {
+	if v {
+		e.buf = append(e.buf, 1)
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+// WriteBytes writes a length-prefixed byte slice
+func (e *Encoder) WriteBytes(v []byte) {
+	e.WriteUint(uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+// WriteString writes a length-prefixed string
+func (e *Encoder) WriteString(v string) {
+	e.WriteUint(uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+// WriteStrings writes a list of strings
+func (e *Encoder) WriteStrings(v []string) {
+	e.WriteUint(uint64(len(v)))
+	for _, s := range v {
+		e.WriteString(s)
+	}
+}
+
+func (e *Encoder) sourceMap(positions SourceMap) {
+	e.WriteUint(uint64(len(positions)))
+	for _, entry := range positions {
+		e.WriteUint(uint64(entry.Offset))
+		e.WriteUint(uint64(entry.Pos.Line))
+		e.WriteUint(uint64(entry.Pos.Column))
+		e.WriteUint(uint64(entry.Pos.Offset))
+	}
+}
+
+func (e *Encoder) values(values []types.Value) error {
+	e.WriteUint(uint64(len(values)))
+	for _, value := range values {
+		if err := e.value(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Encoder) value(value types.Value) error {
+	switch v := value.(type) {
+	case nil, *types.NilValue:
+		e.buf = append(e.buf, tagNil)
+	case *types.BoolValue:
+		e.buf = append(e.buf, tagBool)
+		e.WriteBool(v.Value())
+	case *types.IntValue:
+		e.buf = append(e.buf, tagInt)
+		e.WriteInt(v.Value())
+	case *types.FloatValue:
+		e.buf = append(e.buf, tagFloat)
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(v.Value()))
+	case *types.StringValue:
+		e.buf = append(e.buf, tagString)
+		e.WriteString(v.Value())
+	case *types.SliceValue:
+		e.buf = append(e.buf, tagSlice)
+		e.typeInfo(v.ElementType())
+		return e.values(v.Values())
+	case *types.MapValue:
+		e.buf = append(e.buf, tagMap)
+		e.typeInfo(v.KeyType())
+		e.typeInfo(v.ValueType())
+		return e.valueMap(v.Values())
+	case *types.FuncValue:
+		e.buf = append(e.buf, tagFunc)
+		e.WriteStrings(v.Parameters())
+		e.WriteString(v.Name())
+		if err := e.valueMap(v.Closure()); err != nil {
+			return err
+		}
+		switch body := v.Body().(type) {
+		case nil:
+			e.WriteBool(false)
+		case *CompiledFunction:
+			e.WriteBool(true)
+			e.WriteBytes(body.Instructions)
+			e.WriteUint(uint64(body.NumLocals))
+			e.WriteStrings(body.FreeNames)
+			e.sourceMap(body.Positions)
+		default:
+			return fmt.Errorf("cannot encode function body of type %T", body)
+		}
+	case *types.PlaceholderExprValue:
+		e.buf = append(e.buf, tagPlaceholderExpr)
+		e.WriteBytes(v.Instructions())
+		if err := e.values(v.Constants()); err != nil {
+			return err
+		}
+		e.WriteString(v.Operator())
+		return e.value(v.Operand())
+	default:
+		return fmt.Errorf("cannot encode constant of type %T", value)
+	}
+	return nil
+}
+
+// valueMap writes map entries ordered by key so that encoding is deterministic
+func (e *Encoder) valueMap(values map[string]types.Value) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	e.WriteUint(uint64(len(keys)))
+	for _, key := range keys {
+		e.WriteString(key)
+		if err := e.value(values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Encoder) typeInfo(info types.TypeInfo) {
+	e.buf = append(e.buf, byte(info.Kind))
+	e.WriteString(info.Name)
+	e.WriteInt(int64(info.Size))
+}
+
+// Decoder reads the primitives written by Encoder. The first error stops
+// decoding and is reported by Finish.
+type Decoder struct {
+	data []byte
+	err  error
+}
+
+// NewDecoder creates a decoder reading data
+func NewDecoder(data []byte) *Decoder {
+	return &Decoder{data: data}
+}
+
+// Finish returns the first decoding error, or an error if data is left over
+func (d *Decoder) Finish() error {
+	if d.err == nil && len(d.data) > 0 {
+		d.err = fmt.Errorf("%d trailing bytes", len(d.data))
+	}
+	return d.err
+}
+
+// ReadUint reads an unsigned integer
+func (d *Decoder) ReadUint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = fmt.Errorf("truncated integer")
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+// ReadInt reads a signed integer
+func (d *Decoder) ReadInt() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.err = fmt.Errorf("truncated integer")
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+// ReadLen reads a length or count that cannot exceed the remaining data
+func (d *Decoder) ReadLen() int {
+	n := d.ReadUint()
+	if d.err == nil && n > uint64(len(d.data)) {
+		d.err = fmt.Errorf("length %d exceeds remaining %d bytes", n, len(d.data))
+		return 0
+	}
+	return int(n)
+}
+
+// ReadBool reads a boolean
+func (d *Decoder) ReadBool() bool {
+	return d.byte() != 0
+}
+
+// ReadBytes reads a length-prefixed byte slice
+func (d *Decoder) ReadBytes() []byte {
+	n := d.ReadLen()
+	if d.err != nil {
+		return nil
+	}
+	v := make([]byte, n)
+	copy(v, d.data)
+	d.data = d.data[n:]
+	return v
+}
+
+// ReadString reads a length-prefixed string
+func (d *Decoder) ReadString() string {
+	n := d.ReadLen()
+	if d.err != nil {
+		return ""
+	}
+	v := string(d.data[:n])
+	d.data = d.data[n:]
+	return v
+}
+
+// ReadStrings reads a list of strings
+func (d *Decoder) ReadStrings() []string {
+	n := d.ReadLen()
+	if d.err != nil || n == 0 {
+		return nil
+	}
+	v := make([]string, n)
+	for i := range v {
+		v[i] = d.ReadString()
+	}
+	return v
+}
+
+func (d *Decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data) == 0 {
+		d.err = fmt.Errorf("unexpected end of data")
+		return 0
+	}
+	v := d.data[0]
+	d.data = d.data[1:]
+	return v
+}
+
+func (d *Decoder) sourceMap() SourceMap {
+	n := d.ReadLen()
+	if d.err != nil || n == 0 {
+		return nil
+	}
+	positions := make(SourceMap, n)
+	for i := range positions {
+		positions[i].Offset = int(d.ReadUint())
+		positions[i].Pos = lexer.Position{
+			Line:   int(d.ReadUint()),
+			Column: int(d.ReadUint()),
+			Offset: int(d.ReadUint()),
+		}
+	}
+	return positions
+}
+
+// maxValueDepth bounds the nesting of decoded constants
+const maxValueDepth = 256
+
+func (d *Decoder) values(depth int) []types.Value {
+	n := d.ReadLen()
+	if d.err != nil {
+		return nil
+	}
+	values := make([]types.Value, n)
+	for i := range values {
+		values[i] = d.value(depth)
+	}
+	return values
+}
+
+func (d *Decoder) value(depth int) types.Value {
+	if depth > maxValueDepth {
+		d.err = fmt.Errorf("constants nested deeper than %d levels", maxValueDepth)
+		return nil
+	}
+
+	switch tag := d.byte(); tag {
+	case tagNil:
+		return types.NewNil()
+	case tagBool:
+		return types.NewBool(d.ReadBool())
+	case tagInt:
+		return types.NewInt(d.ReadInt())
+	case tagFloat:
+		if d.err == nil && len(d.data) < 8 {
+			d.err = fmt.Errorf("truncated float")
+		}
+		if d.err != nil {
+			return nil
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(d.data))
+		d.data = d.data[8:]
+		return types.NewFloat(v)
+	case tagString:
+		return types.NewString(d.ReadString())
+	case tagSlice:
+		elemType := d.typeInfo()
+		return types.NewSlice(d.values(depth+1), elemType)
+	case tagMap:
+		keyType := d.typeInfo()
+		valType := d.typeInfo()
+		return types.NewMap(d.valueMap(depth+1), keyType, valType)
+	case tagFunc:
+		parameters := d.ReadStrings()
+		name := d.ReadString()
+		closure := d.valueMap(depth + 1)
+		var body interface{}
+		if d.ReadBool() {
+			body = &CompiledFunction{
+				Instructions: d.ReadBytes(),
+				NumLocals:    int(d.ReadUint()),
+				FreeNames:    d.ReadStrings(),
+				Positions:    d.sourceMap(),
+			}
+		}
+		return types.NewFunc(parameters, body, closure, name)
+	case tagPlaceholderExpr:
+		instructions := d.ReadBytes()
+		constants := d.values(depth + 1)
+		operator := d.ReadString()
+		operand := d.value(depth + 1)
+		return types.NewPlaceholderExpr(instructions, constants, operator, operand)
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("unknown constant tag %d", tag)
+		}
+		return nil
+	}
+}
+
+func (d *Decoder) valueMap(depth int) map[string]types.Value {
+	n := d.ReadLen()
+	if d.err != nil {
+		return nil
+	}
+	values := make(map[string]types.Value, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		key := d.ReadString()
+		values[key] = d.value(depth)
+	}
+	return values
+}
+
+func (d *Decoder) typeInfo() types.TypeInfo {
+	kind := types.TypeKind(d.byte())
+	name := d.ReadString()
+	size := int(d.ReadInt())
+	return types.TypeInfo{Kind: kind, Name: name, Size: size}
+}
@@ -0,0 +1,108 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mredencom/expr/lexer"
+	"github.com/mredencom/expr/types"
+)
+
+func TestBytecodeMarshalBinary(t *testing.T) {
+	anyType := types.TypeInfo{Kind: types.KindInterface, Name: "interface{}"}
+	fn := &CompiledFunction{
+		Instructions: Make(OpGetLocal, 0),
+		NumLocals:    1,
+		FreeNames:    []string{"x"},
+		Positions:    SourceMap{{Offset: 0, Pos: lexer.Position{Line: 1, Column: 8, Offset: 7}}},
+	}
+	bytecode := &Bytecode{
+		Instructions: append(Make(OpConstant, 0), Make(OpConstant, 1)...),
+		Constants: []types.Value{
+			types.NewNil(),
+			types.NewBool(true),
+			types.NewInt(-42),
+			types.NewFloat(2.5),
+			types.NewString("héllo"),
+			types.NewSlice([]types.Value{types.NewInt(1), types.NewSlice(nil, anyType)}, anyType),
+			types.NewMap(map[string]types.Value{"a": types.NewString("b")}, types.StringType, anyType),
+			types.NewFunc([]string{"v"}, fn, map[string]types.Value{"x": types.NewInt(3)}, ""),
+			types.NewPlaceholderExpr(nil, []types.Value{types.NewInt(1)}, ">", types.NewInt(3)),
+		},
+		Positions: SourceMap{{Offset: 0, Pos: lexer.Position{Line: 1, Column: 1}}},
+	}
+
+	data, err := bytecode.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected encoding error: %v", err)
+	}
+	decoded := &Bytecode{}
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unexpected decoding error: %v", err)
+	}
+
+	if string(decoded.Instructions) != string(bytecode.Instructions) {
+		t.Errorf("expected instructions %v, got %v", bytecode.Instructions, decoded.Instructions)
+	}
+	if len(decoded.Positions) != 1 || decoded.Positions[0] != bytecode.Positions[0] {
+		t.Errorf("expected positions %v, got %v", bytecode.Positions, decoded.Positions)
+	}
+	if len(decoded.Constants) != len(bytecode.Constants) {
+		t.Fatalf("expected %d constants, got %d", len(bytecode.Constants), len(decoded.Constants))
+	}
+	for i, constant := range bytecode.Constants {
+		if _, isFunc := constant.(*types.FuncValue); isFunc {
+			continue
+		}
+		if !constant.Equal(decoded.Constants[i]) || constant.Type().Name != decoded.Constants[i].Type().Name {
+			t.Errorf("constant %d: expected %v, got %v", i, constant, decoded.Constants[i])
+		}
+	}
+
+	funcValue, ok := decoded.Constants[7].(*types.FuncValue)
+	if !ok {
+		t.Fatalf("expected a function, got %T", decoded.Constants[7])
+	}
+	body, ok := funcValue.Body().(*CompiledFunction)
+	if !ok || string(body.Instructions) != string(fn.Instructions) || body.NumLocals != 1 ||
+		len(body.FreeNames) != 1 || len(body.Positions) != 1 || body.Positions[0] != fn.Positions[0] {
+		t.Errorf("expected body %+v, got %+v", fn, funcValue.Body())
+	}
+	if !funcValue.Closure()["x"].Equal(types.NewInt(3)) {
+		t.Errorf("expected the closure to be kept, got %v", funcValue.Closure())
+	}
+}
+
+func TestBytecodeUnmarshalBinaryRejectsInvalidData(t *testing.T) {
+	bytecode := &Bytecode{
+		Instructions: Make(OpConstant, 0),
+		Constants:    []types.Value{types.NewSlice([]types.Value{types.NewString("a")}, types.StringType)},
+	}
+	data, err := bytecode.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected encoding error: %v", err)
+	}
+
+	// The instruction set fingerprint follows the magic and the format
+	other := append([]byte{}, data...)
+	other[len(bytecodeMagic)+1] ^= 0xff
+	err = (&Bytecode{}).UnmarshalBinary(other)
+	if err == nil || !strings.Contains(err.Error(), "instruction set") {
+		t.Errorf("expected another instruction set to be rejected, got %v", err)
+	}
+
+	for i := 0; i < len(data); i++ {
+		if err := (&Bytecode{}).UnmarshalBinary(data[:i]); err == nil {
+			t.Errorf("expected truncated data of %d bytes to be rejected", i)
+		}
+	}
+}
+
+func TestBytecodeMarshalBinaryRejectsGoValues(t *testing.T) {
+	bytecode := &Bytecode{
+		Constants: []types.Value{types.NewFunc(nil, func() {}, nil, "native")},
+	}
+	if _, err := bytecode.MarshalBinary(); err == nil {
+		t.Error("expected functions without bytecode to be rejected")
+	}
+}
@@ -0,0 +1,228 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/mredencom/expr/types"
+)
+
+// CompiledFunction is the bytecode of a lambda body. Parameters occupy the
+// first locals; captured variables are read with OpGetFree in FreeNames order.
+type CompiledFunction struct {
+	Instructions []byte
+	NumLocals    int
+	FreeNames    []string
+	Positions    SourceMap // Source positions of the instructions, if known
+}
+
+// frame holds the locals and captured variables of a running function
+type frame struct {
+	locals []types.Value
+	free   []types.Value
+}
+
+// runFunction executes a compiled function body in a new frame. The body
+// shares the VM stack and leaves its result on top of it.
+func (vm *VM) runFunction(funcVal *types.FuncValue, fn *CompiledFunction, args []types.Value) (types.Value, error) {
+	locals := make([]types.Value, fn.NumLocals)
+	for i := range locals {
+		if i < len(args) {
+			locals[i] = args[i]
+		} else {
+			locals[i] = Nil
+		}
+	}
+
+	free := make([]types.Value, len(fn.FreeNames))
+	closure := funcVal.Closure()
+	for i, name := range fn.FreeNames {
+		if value, ok := closure[name]; ok {
+			free[i] = value
+		} else {
+			free[i] = Nil
+		}
+	}
+
+	caller, base, positions := vm.frame, vm.sp, vm.positions
+	vm.frame = &frame{locals: locals, free: free}
+	vm.positions = fn.Positions
+	defer func() {
+		vm.frame = caller
+		vm.sp = base
+		vm.positions = positions
+	}()
+
+	if _, err := vm.runHighPerformanceLoop(fn.Instructions); err != nil {
+		return nil, err
+	}
+	if vm.sp > base {
+		return vm.stack[vm.sp-1], nil
+	}
+	return Nil, nil
+}
+
+// executeGetLocal pushes a local variable of the current function
+func (vm *VM) executeGetLocal(index int) error {
+	if vm.frame == nil || index >= len(vm.frame.locals) {
+		return fmt.Errorf("local index out of bounds: %d", index)
+	}
+	return vm.push(vm.frame.locals[index])
+}
+
+// executeGetFree pushes a variable captured by the current closure
+func (vm *VM) executeGetFree(index int) error {
+	if vm.frame == nil || index >= len(vm.frame.free) {
+		return fmt.Errorf("free variable index out of bounds: %d", index)
+	}
+	return vm.push(vm.frame.free[index])
+}
+
+// executeClosure binds the top numFree stack values to a copy of the
+// function constant, keyed by the names of the variables they capture
+func (vm *VM) executeClosure(constIndex, numFree int) error {
+	if constIndex >= len(vm.constants) {
+		return fmt.Errorf("constant index out of bounds")
+	}
+
+	funcVal, ok := vm.constants[constIndex].(*types.FuncValue)
+	if !ok {
+		return fmt.Errorf("closure constant is not a function: %T", vm.constants[constIndex])
+	}
+	fn, ok := funcVal.Body().(*CompiledFunction)
+	if !ok || len(fn.FreeNames) != numFree {
+		return fmt.Errorf("closure does not match its compiled function")
+	}
+	if vm.sp < numFree {
+		return fmt.Errorf("stack underflow for closure")
+	}
+
+	closure := make(map[string]types.Value, numFree)
+	for i, name := range fn.FreeNames {
+		closure[name] = vm.stack[vm.sp-numFree+i]
+	}
+	vm.sp -= numFree
+
+	return vm.push(types.NewFunc(funcVal.Parameters(), fn, closure, funcVal.Name()))
+}
+
+// push pushes a value onto the stack
+func (vm *VM) push(value types.Value) error {
+	if vm.sp >= len(vm.stack) {
+		return fmt.Errorf("stack overflow")
+	}
+	vm.stack[vm.sp] = value
+	vm.sp++
+	return nil
+}
+
+// callLambdaBuiltin runs filter, map and reduce inside the VM when their
+// function argument is a compiled lambda. It reports false for any other call.
+func (vm *VM) callLambdaBuiltin(funcName string, args []types.Value) (types.Value, bool, error) {
+	if len(args) < 2 {
+		return nil, false, nil
+	}
+	funcVal, ok := args[1].(*types.FuncValue)
+	if !ok {
+		return nil, false, nil
+	}
+	if _, ok := funcVal.Body().(*CompiledFunction); !ok {
+		return nil, false, nil
+	}
+
+	switch funcName {
+	case "filter":
+		result, err := vm.executeLambdaFilter(args[0], funcVal)
+		return result, true, err
+	case "map":
+		result, err := vm.executeLambdaMap(args[0], funcVal)
+		return result, true, err
+	case "reduce":
+		result, err := vm.executeLambdaReduce(args[0], funcVal, args[2:])
+		return result, true, err
+	}
+	return nil, false, nil
+}
+
+// executeLambdaFilter keeps the elements for which the lambda returns a truthy value
+func (vm *VM) executeLambdaFilter(data types.Value, funcVal *types.FuncValue) (types.Value, error) {
+	slice, ok := data.(*types.SliceValue)
+	if !ok {
+		return Nil, fmt.Errorf("filter can only be applied to arrays")
+	}
+
+	elements := slice.Values()
+	result := make([]types.Value, 0, len(elements))
+	for i, element := range elements {
+		if err := vm.checkContext(i); err != nil {
+			return Nil, err
+		}
+
+		keep, err := vm.callLambdaFunction(funcVal, []types.Value{element})
+		if err != nil {
+			return Nil, err
+		}
+		if vm.isTruthy(keep) {
+			result = append(result, element)
+		}
+	}
+
+	return types.NewSlice(result, vm.getSliceElementType(slice)), nil
+}
+
+// executeLambdaMap replaces each element with the result of the lambda
+func (vm *VM) executeLambdaMap(data types.Value, funcVal *types.FuncValue) (types.Value, error) {
+	slice, ok := data.(*types.SliceValue)
+	if !ok {
+		return Nil, fmt.Errorf("map can only be applied to arrays")
+	}
+
+	elements := slice.Values()
+	result := make([]types.Value, len(elements))
+	for i, element := range elements {
+		if err := vm.checkContext(i); err != nil {
+			return Nil, err
+		}
+
+		transformed, err := vm.callLambdaFunction(funcVal, []types.Value{element})
+		if err != nil {
+			return Nil, err
+		}
+		result[i] = transformed
+	}
+
+	return types.NewSlice(result, types.TypeInfo{Kind: types.KindInterface, Name: "interface{}"}), nil
+}
+
+// executeLambdaReduce folds the elements with a two-parameter lambda,
+// starting from the optional initial value or the first element
+func (vm *VM) executeLambdaReduce(data types.Value, funcVal *types.FuncValue, initial []types.Value) (types.Value, error) {
+	if len(initial) > 1 {
+		return Nil, fmt.Errorf("reduce requires 2 or 3 arguments")
+	}
+	slice, ok := data.(*types.SliceValue)
+	if !ok {
+		return Nil, fmt.Errorf("reduce can only be applied to arrays")
+	}
+
+	elements := slice.Values()
+	var acc types.Value = Nil
+	if len(initial) == 1 {
+		acc = initial[0]
+	} else if len(elements) > 0 {
+		acc, elements = elements[0], elements[1:]
+	}
+
+	for i, element := range elements {
+		if err := vm.checkContext(i); err != nil {
+			return Nil, err
+		}
+
+		next, err := vm.callLambdaFunction(funcVal, []types.Value{acc, element})
+		if err != nil {
+			return Nil, err
+		}
+		acc = next
+	}
+
+	return acc, nil
+}
@@ -0,0 +1,169 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/mredencom/expr/env"
+	"github.com/mredencom/expr/types"
+)
+
+// contextInterface is the type of a leading context parameter
+var contextInterface = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// GoFunction wraps a Go function so that it can be called with expression
+// values. Arguments are converted to the parameter types, a leading
+// context.Context parameter receives the context of the run and a trailing
+// error result is reported as the call error.
+type GoFunction struct {
+	Name       string
+	fn         reflect.Value
+	typ        reflect.Type
+	hasContext bool
+	hasError   bool
+}
+
+// NewGoFunction validates fn and wraps it under the given name
+func NewGoFunction(name string, fn interface{}) (*GoFunction, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return nil, fmt.Errorf("%s must be a function, got %T", name, fn)
+	}
+
+	t := v.Type()
+	hasError := false
+	switch t.NumOut() {
+	case 0, 1:
+		hasError = t.NumOut() == 1 && t.Out(0) == errorInterface
+	case 2:
+		if t.Out(1) != errorInterface {
+			return nil, fmt.Errorf("second result of %s must be error, got %s", name, t)
+		}
+		hasError = true
+	default:
+		return nil, fmt.Errorf("%s must return at most a value and an error, got %s", name, t)
+	}
+
+	hasContext := t.NumIn() > 0 && t.In(0) == contextInterface
+	return &GoFunction{Name: name, fn: v, typ: t, hasContext: hasContext, hasError: hasError}, nil
+}
+
+// Call converts the arguments, calls the function and converts its result.
+// ctx is passed to functions taking a leading context.Context.
+func (f *GoFunction) Call(ctx context.Context, args []types.Value, tagName string) (types.Value, error) {
+	first := 0
+	if f.hasContext {
+		first = 1
+	}
+	numIn := f.typ.NumIn() - first
+	if f.typ.IsVariadic() {
+		if len(args) < numIn-1 {
+			return nil, fmt.Errorf("%s expects at least %d arguments, got %d", f.Name, numIn-1, len(args))
+		}
+	} else if len(args) != numIn {
+		return nil, fmt.Errorf("%s expects %d arguments, got %d", f.Name, numIn, len(args))
+	}
+
+	in := make([]reflect.Value, first+len(args))
+	if f.hasContext {
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		in[0] = reflect.ValueOf(ctx)
+	}
+	for i, arg := range args {
+		var paramType reflect.Type
+		if f.typ.IsVariadic() && i >= numIn-1 {
+			paramType = f.typ.In(first + numIn - 1).Elem()
+		} else {
+			paramType = f.typ.In(first + i)
+		}
+
+		converted, ok := env.ConvertTo(arg, paramType, tagName, true)
+		if !ok {
+			return nil, fmt.Errorf("argument %d of %s: cannot use %s as %s", i+1, f.Name, operandTypeName(arg), paramType)
+		}
+		in[first+i] = converted
+	}
+
+	out := f.fn.Call(in)
+	if f.hasError {
+		if errValue := out[len(out)-1]; !errValue.IsNil() {
+			return nil, errValue.Interface().(error)
+		}
+		out = out[:len(out)-1]
+	}
+	if len(out) == 0 || !out[0].CanInterface() {
+		return Nil, nil
+	}
+	return env.ConvertReflect(out[0].Interface(), tagName)
+}
+
+// executeCallFunction calls a custom function with the arguments on the stack
+func (vm *VM) executeCallFunction(nameIndex, argCount int) error {
+	name, ok := vm.constants[nameIndex].(*types.StringValue)
+	if !ok {
+		return fmt.Errorf("function name must be string, got %T", vm.constants[nameIndex])
+	}
+	if vm.sp < argCount {
+		return fmt.Errorf("stack underflow for function %s", name.Value())
+	}
+
+	fn, err := vm.customFunction(name.Value())
+	if err != nil {
+		return err
+	}
+
+	args := make([]types.Value, argCount)
+	copy(args, vm.stack[vm.sp-argCount:vm.sp])
+	vm.sp -= argCount
+
+	result, err := fn.Call(vm.ctx, args, vm.tagName)
+	if err != nil {
+		return fmt.Errorf("function %s: %w", name.Value(), err)
+	}
+	if vm.sp >= StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+	vm.stack[vm.sp] = result
+	vm.sp++
+	return nil
+}
+
+// customFunction returns the custom function registered under name,
+// wrapping plain Go functions on first use
+func (vm *VM) customFunction(name string) (*GoFunction, error) {
+	switch fn := vm.customBuiltins[name].(type) {
+	case *GoFunction:
+		return fn, nil
+	case nil:
+		return nil, fmt.Errorf("undefined function %s", name)
+	default:
+		goFunc, err := NewGoFunction(name, fn)
+		if err != nil {
+			return nil, err
+		}
+		vm.customBuiltins[name] = goFunc
+		return goFunc, nil
+	}
+}
+
+// boundMethod returns the exported method called name of the Go value a map
+// or slice was converted from, bound to that value
+func (vm *VM) boundMethod(object types.Value, name string) (types.Value, bool) {
+	source := env.SourceOf(object)
+	if !source.IsValid() {
+		return nil, false
+	}
+	method := source.MethodByName(name)
+	if !method.IsValid() {
+		return nil, false
+	}
+
+	fn, err := NewGoFunction(source.Type().Elem().Name()+"."+name, method.Interface())
+	if err != nil {
+		return nil, false
+	}
+	return types.NewFunc(nil, fn, nil, fn.Name), true
+}
@@ -1,6 +1,10 @@
 package vm
 
-import "fmt"
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
 
 // Opcode represents a virtual machine instruction
 type Opcode byte
@@ -127,6 +131,16 @@ const (
 	OpArrayDestructure  // Array destructuring assignment
 	OpObjectDestructure // Object destructuring assignment
 	OpRestElement       // Rest element in destructuring
+
+	// Function scope operations
+	OpGetLocal // Get local variable (lambda parameter)
+	OpGetFree  // Get variable captured by a closure
+
+	// Custom operators
+	OpOperator // Apply a custom or overloaded binary operator
+
+	// Custom functions
+	OpCallFunction // Call a Go function registered by name
 )
 
 // String returns the string representation of an opcode
@@ -288,6 +302,14 @@ func (op Opcode) String() string {
 		return "OpObjectDestructure"
 	case OpRestElement:
 		return "OpRestElement"
+	case OpGetLocal:
+		return "OpGetLocal"
+	case OpGetFree:
+		return "OpGetFree"
+	case OpOperator:
+		return "OpOperator"
+	case OpCallFunction:
+		return "OpCallFunction"
 	default:
 		return fmt.Sprintf("Unknown(%d)", int(op))
 	}
@@ -366,7 +388,7 @@ var definitions = map[Opcode]*Definition{
 	OpArray:              {"OpArray", []int{}},
 	OpObject:             {"OpObject", []int{}},
 	OpLambda:             {"OpLambda", []int{}},
-	OpClosure:            {"OpClosure", []int{}},
+	OpClosure:            {"OpClosure", []int{2, 1}}, // 2-byte function constant index, 1-byte free variable count
 	OpApply:              {"OpApply", []int{}},
 	OpPipe:               {"OpPipe", []int{}},
 	OpFilter:             {"OpFilter", []int{}},
@@ -379,6 +401,10 @@ var definitions = map[Opcode]*Definition{
 	OpArrayDestructure:   {"OpArrayDestructure", []int{2, 2}},  // 2-byte element count, 2-byte start variable index
 	OpObjectDestructure:  {"OpObjectDestructure", []int{2, 2}}, // 2-byte property count, 2-byte start variable index
 	OpRestElement:        {"OpRestElement", []int{2}},          // 2-byte variable index for rest element
+	OpGetLocal:           {"OpGetLocal", []int{1}},             // 1-byte local index
+	OpGetFree:            {"OpGetFree", []int{1}},              // 1-byte free variable index
+	OpOperator:           {"OpOperator", []int{2}},             // 2-byte operator symbol constant index
+	OpCallFunction:       {"OpCallFunction", []int{2, 1}},      // 2-byte function name constant index, 1-byte arg count
 }
 
 // Lookup returns the definition for an opcode
@@ -390,6 +416,36 @@ func Lookup(op Opcode) (*Definition, error) {
 	return def, nil
 }
 
+// instructionSet is the fingerprint of the opcode definitions
+var instructionSet = fingerprintDefinitions()
+
+// InstructionSet identifies the opcode numbering and operand widths.
+// Bytecode only runs on builds with the same instruction set.
+func InstructionSet() uint64 {
+	return instructionSet
+}
+
+// fingerprintDefinitions hashes the opcodes with their names and operand widths
+func fingerprintDefinitions() uint64 {
+	ops := make([]int, 0, len(definitions))
+	for op := range definitions {
+		ops = append(ops, int(op))
+	}
+	sort.Ints(ops)
+
+	h := fnv.New64a()
+	for _, op := range ops {
+		def := definitions[Opcode(op)]
+		h.Write([]byte{byte(op)})
+		h.Write([]byte(def.Name))
+		for _, width := range def.OperandWidth {
+			h.Write([]byte{byte(width)})
+		}
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
 // Make creates an instruction from opcode and operands
 func Make(op Opcode, operands ...int) []byte {
 	def, ok := definitions[op]
@@ -453,14 +509,9 @@ func FormatInstruction(def *Definition, operands []int) string {
 			len(operands), operandCount)
 	}
 
-	switch operandCount {
-	case 0:
-		return def.Name
-	case 1:
-		return fmt.Sprintf("%s %d", def.Name, operands[0])
-	case 2:
-		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
-	default:
-		return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+	formatted := def.Name
+	for _, operand := range operands {
+		formatted += fmt.Sprintf(" %d", operand)
 	}
+	return formatted
 }
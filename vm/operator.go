@@ -0,0 +1,140 @@
+package vm
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/mredencom/expr/env"
+	"github.com/mredencom/expr/types"
+)
+
+var errorInterface = reflect.TypeOf((*error)(nil)).Elem()
+
+// OperatorFunc is a Go implementation of a binary operator. It wraps a
+// function of the form func(A, B) R or func(A, B) (R, error).
+type OperatorFunc struct {
+	fn       reflect.Value
+	left     reflect.Type
+	right    reflect.Type
+	hasError bool
+}
+
+// NewOperatorFunc validates fn and wraps it as an operator implementation
+func NewOperatorFunc(fn interface{}) (*OperatorFunc, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return nil, fmt.Errorf("operator implementation must be a function, got %T", fn)
+	}
+
+	t := v.Type()
+	if t.NumIn() != 2 || t.IsVariadic() {
+		return nil, fmt.Errorf("operator implementation must take 2 arguments, got %s", t)
+	}
+
+	hasError := false
+	switch t.NumOut() {
+	case 1:
+	case 2:
+		if t.Out(1) != errorInterface {
+			return nil, fmt.Errorf("second result of operator implementation must be error, got %s", t)
+		}
+		hasError = true
+	default:
+		return nil, fmt.Errorf("operator implementation must return 1 value or (value, error), got %s", t)
+	}
+
+	return &OperatorFunc{fn: v, left: t.In(0), right: t.In(1), hasError: hasError}, nil
+}
+
+// SetOperators sets the Go implementations of custom and overloaded operators
+func (vm *VM) SetOperators(operators map[string][]*OperatorFunc) {
+	vm.operators = operators
+}
+
+// executeOperator applies a custom or overloaded operator. The first
+// implementation accepting the operand types is called; operands are matched
+// exactly before ints are widened to floats. Built-in operators fall back to
+// their usual behaviour when no implementation matches.
+func (vm *VM) executeOperator(symbol string, left, right types.Value) (types.Value, error) {
+	if result, ok, err := vm.callOperator(symbol, left, right); ok {
+		return result, err
+	}
+
+	switch symbol {
+	case "+":
+		return vm.executeAddition(left, right)
+	case "-":
+		return vm.executeSubtraction(left, right)
+	case "*":
+		return vm.executeMultiplication(left, right)
+	case "/":
+		return vm.executeDivision(left, right)
+	case "%":
+		return vm.executeModulo(left, right)
+	case "==":
+		return vm.executeComparison(OpEqual, left, right)
+	case "!=":
+		return vm.executeComparison(OpNotEqual, left, right)
+	case ">":
+		return vm.executeComparison(OpGreaterThan, left, right)
+	case ">=":
+		return vm.executeComparison(OpGreaterEqual, left, right)
+	case "<":
+		return vm.executeComparison(OpLessThan, left, right)
+	case "<=":
+		return vm.executeComparison(OpLessEqual, left, right)
+	}
+
+	return Nil, fmt.Errorf("no implementation of operator %s for %s and %s",
+		symbol, operandTypeName(left), operandTypeName(right))
+}
+
+// callOperator calls the implementation of symbol matching the operands. It
+// reports false when the operator has no matching implementation.
+func (vm *VM) callOperator(symbol string, left, right types.Value) (types.Value, bool, error) {
+	funcs := vm.operators[symbol]
+	if len(funcs) == 0 {
+		return nil, false, nil
+	}
+
+	for _, widen := range []bool{false, true} {
+		for _, fn := range funcs {
+			leftArg, ok := env.ConvertTo(left, fn.left, vm.tagName, widen)
+			if !ok {
+				continue
+			}
+			rightArg, ok := env.ConvertTo(right, fn.right, vm.tagName, widen)
+			if !ok {
+				continue
+			}
+
+			result, err := fn.call(leftArg, rightArg, vm.tagName)
+			if err != nil {
+				return Nil, true, fmt.Errorf("operator %s: %w", symbol, err)
+			}
+			return result, true, nil
+		}
+	}
+
+	return nil, false, nil
+}
+
+// call invokes the function and converts its result back to a value
+func (f *OperatorFunc) call(left, right reflect.Value, tagName string) (types.Value, error) {
+	out := f.fn.Call([]reflect.Value{left, right})
+	if f.hasError && !out[1].IsNil() {
+		return nil, out[1].Interface().(error)
+	}
+	if !out[0].CanInterface() {
+		return Nil, nil
+	}
+	return env.ConvertReflect(out[0].Interface(), tagName)
+}
+
+// operandTypeName describes an operand in error messages
+func operandTypeName(value types.Value) string {
+	if value == nil {
+		return "nil"
+	}
+	return value.Type().Name
+}
@@ -70,10 +70,16 @@ func (jt *SafeJumpTable) initializeSafeHandlers() {
 	// 变量操作
 	jt.handlers[OpGetVar] = safeHandleGetVar
 	jt.handlers[OpSetVar] = safeHandleSetVar
+	jt.handlers[OpGetLocal] = safeHandleGetLocal
+	jt.handlers[OpGetFree] = safeHandleGetFree
 
 	// 函数和内置函数
 	jt.handlers[OpCall] = safeHandleCall
 	jt.handlers[OpBuiltin] = safeHandleBuiltin
+	jt.handlers[OpClosure] = safeHandleClosure
+	jt.handlers[OpOperator] = safeHandleOperator
+	jt.handlers[OpCallFunction] = safeHandleCallFunction
+	jt.handlers[OpModuleCall] = safeHandleModuleCall
 
 	// 集合操作
 	jt.handlers[OpIndex] = safeHandleIndex
@@ -505,6 +511,28 @@ func safeHandleSetVar(vm *VM, instructions []byte, ip *int) (bool, error) {
 	return true, nil
 }
 
+func safeHandleGetLocal(vm *VM, instructions []byte, ip *int) (bool, error) {
+	if *ip >= len(instructions) {
+		return false, fmt.Errorf("insufficient bytes for local index")
+	}
+
+	index := int(instructions[*ip])
+	*ip++
+
+	return true, vm.executeGetLocal(index)
+}
+
+func safeHandleGetFree(vm *VM, instructions []byte, ip *int) (bool, error) {
+	if *ip >= len(instructions) {
+		return false, fmt.Errorf("insufficient bytes for free variable index")
+	}
+
+	index := int(instructions[*ip])
+	*ip++
+
+	return true, vm.executeGetFree(index)
+}
+
 func safeHandleCall(vm *VM, instructions []byte, ip *int) (bool, error) {
 	if *ip >= len(instructions) {
 		return false, fmt.Errorf("insufficient bytes for call")
@@ -516,6 +544,61 @@ func safeHandleCall(vm *VM, instructions []byte, ip *int) (bool, error) {
 	return true, vm.executeCall(argCount)
 }
 
+func safeHandleClosure(vm *VM, instructions []byte, ip *int) (bool, error) {
+	if *ip+2 >= len(instructions) {
+		return false, fmt.Errorf("incomplete OpClosure instruction")
+	}
+
+	constIndex := int(instructions[*ip])<<8 | int(instructions[*ip+1])
+	numFree := int(instructions[*ip+2])
+	*ip += 3
+
+	return true, vm.executeClosure(constIndex, numFree)
+}
+
+func safeHandleOperator(vm *VM, instructions []byte, ip *int) (bool, error) {
+	if *ip+1 >= len(instructions) {
+		return false, fmt.Errorf("incomplete OpOperator instruction")
+	}
+
+	constIndex := int(instructions[*ip])<<8 | int(instructions[*ip+1])
+	*ip += 2
+
+	if constIndex >= len(vm.constants) {
+		return false, fmt.Errorf("constant index out of bounds")
+	}
+	symbol, ok := vm.constants[constIndex].(*types.StringValue)
+	if !ok {
+		return false, fmt.Errorf("operator symbol must be a string constant")
+	}
+	if vm.sp < 2 {
+		return false, fmt.Errorf("insufficient operands")
+	}
+
+	result, err := vm.executeOperator(symbol.Value(), vm.stack[vm.sp-2], vm.stack[vm.sp-1])
+	if err != nil {
+		return false, err
+	}
+	vm.stack[vm.sp-2] = result
+	vm.sp--
+	return true, nil
+}
+
+func safeHandleCallFunction(vm *VM, instructions []byte, ip *int) (bool, error) {
+	if *ip+2 >= len(instructions) {
+		return false, fmt.Errorf("incomplete OpCallFunction instruction")
+	}
+
+	nameIndex := int(instructions[*ip])<<8 | int(instructions[*ip+1])
+	argCount := int(instructions[*ip+2])
+	*ip += 3
+
+	if nameIndex >= len(vm.constants) {
+		return false, fmt.Errorf("constant index out of bounds")
+	}
+	return true, vm.executeCallFunction(nameIndex, argCount)
+}
+
 func safeHandleBuiltin(vm *VM, instructions []byte, ip *int) (bool, error) {
 	if *ip+1 >= len(instructions) {
 		return false, fmt.Errorf("incomplete OpBuiltin instruction")
@@ -533,6 +616,22 @@ func safeHandleBuiltin(vm *VM, instructions []byte, ip *int) (bool, error) {
 	return true, nil
 }
 
+func safeHandleModuleCall(vm *VM, instructions []byte, ip *int) (bool, error) {
+	if *ip+4 >= len(instructions) {
+		return false, fmt.Errorf("incomplete OpModuleCall instruction")
+	}
+
+	moduleNameIndex := int(instructions[*ip])<<8 | int(instructions[*ip+1])
+	functionNameIndex := int(instructions[*ip+2])<<8 | int(instructions[*ip+3])
+	argCount := int(instructions[*ip+4])
+	*ip += 5
+
+	if err := vm.executeModuleCall(moduleNameIndex, functionNameIndex, argCount); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func safeHandleIndex(vm *VM, instructions []byte, ip *int) (bool, error) {
 	if vm.sp < 2 {
 		return false, fmt.Errorf("insufficient operands")
@@ -0,0 +1,74 @@
+package vm
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/mredencom/expr/lexer"
+)
+
+// SourcePosition maps the instruction starting at Offset to the source
+// position of the expression it was compiled from
+type SourcePosition struct {
+	Offset int
+	Pos    lexer.Position
+}
+
+// SourceMap lists the source positions of instructions ordered by offset
+type SourceMap []SourcePosition
+
+// Lookup returns the source position of the instruction at ip. Instructions
+// without an entry of their own belong to the closest entry before them.
+func (m SourceMap) Lookup(ip int) (lexer.Position, bool) {
+	i := sort.Search(len(m), func(i int) bool { return m[i].Offset > ip })
+	if i == 0 {
+		return lexer.Position{}, false
+	}
+	return m[i-1].Pos, true
+}
+
+// Offsets returns the offsets of the instructions compiled from the given
+// source line, in order. A column of 0 matches any column of the line.
+func (m SourceMap) Offsets(line, column int) []int {
+	var offsets []int
+	for _, entry := range m {
+		if entry.Pos.Line == line && (column == 0 || entry.Pos.Column == column) {
+			offsets = append(offsets, entry.Offset)
+		}
+	}
+	return offsets
+}
+
+// InstructionError is an error raised by an instruction. IP is the offset of
+// the instruction in the code that was running, which may be a lambda body,
+// and Pos its source position when that code has a source map.
+type InstructionError struct {
+	IP  int
+	Pos lexer.Position
+	Err error
+}
+
+func (e *InstructionError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *InstructionError) Unwrap() error {
+	return e.Err
+}
+
+// SetSourceMap sets the source map of the instructions run next
+func (vm *VM) SetSourceMap(positions SourceMap) {
+	vm.positions = positions
+}
+
+// instructionError locates an error raised by the instruction at ip. Errors
+// already located by a nested function keep their innermost location.
+func (vm *VM) instructionError(ip int, err error) error {
+	var located *InstructionError
+	if errors.As(err, &located) {
+		return err
+	}
+	pos, _ := vm.positions.Lookup(ip)
+	return &InstructionError{IP: ip, Pos: pos, Err: err}
+}
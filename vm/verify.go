@@ -0,0 +1,277 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/mredencom/expr/builtins"
+	"github.com/mredencom/expr/types"
+)
+
+// Verify checks that bytecode is well formed before it is run: operands
+// match the opcode definitions, jumps land on instruction boundaries,
+// constant, global, local and builtin indices are in range and the stack
+// neither underflows nor needs more than StackSize values. Lambda bodies
+// in the constant pool are checked as well.
+func Verify(bytecode *Bytecode) error {
+	v := &verifier{constants: bytecode.Constants, depths: make(map[*CompiledFunction]int)}
+	depth, err := v.verifyCode("", bytecode.Instructions, nil)
+	if err != nil {
+		return err
+	}
+	if depth > StackSize {
+		return fmt.Errorf("invalid bytecode: needs a stack of %d values, the VM has %d", depth, StackSize)
+	}
+	return nil
+}
+
+// verifier checks the instructions of a program and its functions
+type verifier struct {
+	constants []types.Value
+	depths    map[*CompiledFunction]int // Stack depths of verified functions, -1 while verifying
+}
+
+// verifyCode checks instructions run at top level (fn is nil) or as the body
+// of fn, and returns the stack depth they need including nested functions
+func (v *verifier) verifyCode(where string, instructions []byte, fn *CompiledFunction) (int, error) {
+	fail := func(offset int, format string, args ...interface{}) (int, error) {
+		return 0, fmt.Errorf("invalid bytecode%s at offset %d: %s", where, offset, fmt.Sprintf(format, args...))
+	}
+
+	// Split the instructions using the opcode definitions
+	type decoded struct {
+		op       Opcode
+		operands []int
+		next     int
+	}
+	code := make(map[int]decoded)
+	var offsets []int
+	for ip := 0; ip < len(instructions); {
+		op := Opcode(instructions[ip])
+		def, err := Lookup(op)
+		if err != nil {
+			return fail(ip, "%v", err)
+		}
+		width := 1
+		for _, w := range def.OperandWidth {
+			width += w
+		}
+		if ip+width > len(instructions) {
+			return fail(ip, "%s needs %d operand bytes, %d left", def.Name, width-1, len(instructions)-ip-1)
+		}
+		operands, _ := ReadOperands(def, instructions[ip+1:ip+width])
+		code[ip] = decoded{op: op, operands: operands, next: ip + width}
+		offsets = append(offsets, ip)
+		ip += width
+	}
+
+	// Check the operands of each instruction
+	nested := 0
+	for _, ip := range offsets {
+		ins := code[ip]
+		switch ins.op {
+		case OpJump, OpJumpTrue, OpJumpFalse, OpJumpNil:
+			target := ins.operands[0]
+			if _, ok := code[target]; !ok && target != len(instructions) {
+				return fail(ip, "jump target %d is not an instruction boundary", target)
+			}
+		case OpConstant, OpClosure:
+			index := ins.operands[0]
+			if index >= len(v.constants) {
+				return fail(ip, "constant index %d out of range, %d constants", index, len(v.constants))
+			}
+			depth, err := v.verifyConstant(index)
+			if err != nil {
+				return 0, err
+			}
+			if depth > nested {
+				nested = depth
+			}
+			if ins.op == OpClosure {
+				funcVal, ok := v.constants[index].(*types.FuncValue)
+				if !ok {
+					return fail(ip, "closure constant %d is not a function", index)
+				}
+				body, compiled := funcVal.Body().(*CompiledFunction)
+				if !compiled || len(body.FreeNames) != ins.operands[1] {
+					return fail(ip, "closure does not match function constant %d", index)
+				}
+			}
+		case OpOperator:
+			if err := v.checkStringConstant(ins.operands[0]); err != nil {
+				return fail(ip, "operator symbol: %v", err)
+			}
+		case OpCallFunction:
+			if err := v.checkStringConstant(ins.operands[0]); err != nil {
+				return fail(ip, "function name: %v", err)
+			}
+		case OpModuleCall:
+			for _, index := range ins.operands[:2] {
+				if err := v.checkStringConstant(index); err != nil {
+					return fail(ip, "module call: %v", err)
+				}
+			}
+		case OpGetVar, OpSetVar, OpRestElement:
+			if ins.operands[0] >= GlobalsSize {
+				return fail(ip, "global index %d out of range", ins.operands[0])
+			}
+		case OpArrayDestructure, OpObjectDestructure:
+			if ins.operands[0]+ins.operands[1] > GlobalsSize {
+				return fail(ip, "global index %d out of range", ins.operands[0]+ins.operands[1]-1)
+			}
+		case OpGetLocal:
+			if fn == nil || ins.operands[0] >= fn.NumLocals {
+				return fail(ip, "local index %d out of range", ins.operands[0])
+			}
+		case OpGetFree:
+			if fn == nil || ins.operands[0] >= len(fn.FreeNames) {
+				return fail(ip, "free variable index %d out of range", ins.operands[0])
+			}
+		case OpBuiltin:
+			if ins.operands[0] >= len(builtins.StandardBuiltinNames) {
+				return fail(ip, "builtin index %d out of range", ins.operands[0])
+			}
+		}
+	}
+
+	// Follow every path from the entry, requiring each instruction to be
+	// reached with the same stack depth
+	depths := map[int]int{0: 0}
+	work := []int{0}
+	maxDepth := 0
+	for len(work) > 0 {
+		ip := work[len(work)-1]
+		work = work[:len(work)-1]
+		ins, ok := code[ip]
+		if !ok {
+			continue // End of the instructions
+		}
+
+		pops, pushes, ok := stackEffect(ins.op, ins.operands)
+		if !ok {
+			return fail(ip, "%s cannot be verified", ins.op)
+		}
+		depth := depths[ip]
+		if depth < pops {
+			return fail(ip, "%s pops %d values from a stack of %d", ins.op, pops, depth)
+		}
+		depth += pushes - pops
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+		if depth > StackSize {
+			return fail(ip, "stack grows beyond %d values", StackSize)
+		}
+
+		var successors []int
+		switch ins.op {
+		case OpJump:
+			successors = []int{ins.operands[0]}
+		case OpJumpTrue, OpJumpFalse, OpJumpNil:
+			successors = []int{ins.next, ins.operands[0]}
+		case OpHalt, OpReturn:
+		default:
+			successors = []int{ins.next}
+		}
+		for _, next := range successors {
+			if known, seen := depths[next]; seen {
+				if known != depth {
+					return fail(next, "reached with stack depths %d and %d", known, depth)
+				}
+				continue
+			}
+			depths[next] = depth
+			work = append(work, next)
+		}
+	}
+
+	return maxDepth + nested, nil
+}
+
+// verifyConstant checks the bytecode held by a constant and returns the
+// stack depth it needs when run
+func (v *verifier) verifyConstant(index int) (int, error) {
+	switch constant := v.constants[index].(type) {
+	case *types.FuncValue:
+		fn, ok := constant.Body().(*CompiledFunction)
+		if !ok {
+			return 0, nil
+		}
+		if depth, seen := v.depths[fn]; seen {
+			if depth < 0 {
+				return 0, fmt.Errorf("invalid bytecode: function constant %d contains itself", index)
+			}
+			return depth, nil
+		}
+		v.depths[fn] = -1
+		depth, err := v.verifyCode(fmt.Sprintf(" in function constant %d", index), fn.Instructions, fn)
+		if err != nil {
+			return 0, err
+		}
+		v.depths[fn] = depth
+		return depth, nil
+	case *types.PlaceholderExprValue:
+		if len(constant.Instructions()) == 0 {
+			return 0, nil
+		}
+		nested := &verifier{constants: constant.Constants(), depths: v.depths}
+		return nested.verifyCode(fmt.Sprintf(" in placeholder constant %d", index), constant.Instructions(), nil)
+	}
+	return 0, nil
+}
+
+// checkStringConstant checks that a constant index refers to a string
+func (v *verifier) checkStringConstant(index int) error {
+	if index >= len(v.constants) {
+		return fmt.Errorf("constant index %d out of range, %d constants", index, len(v.constants))
+	}
+	if _, ok := v.constants[index].(*types.StringValue); !ok {
+		return fmt.Errorf("constant %d is %T, not a string", index, v.constants[index])
+	}
+	return nil
+}
+
+// stackEffect returns how many values an instruction pops and pushes. It
+// returns false for opcodes the VM has no stack discipline for.
+func stackEffect(op Opcode, operands []int) (pops, pushes int, ok bool) {
+	switch op {
+	case OpConstant, OpGetVar, OpGetLocal, OpGetFree, OpGetPipelineElement:
+		return 0, 1, true
+	case OpPop, OpSetVar, OpRestElement, OpJumpTrue, OpJumpFalse, OpJumpNil:
+		return 1, 0, true
+	case OpDup:
+		return 1, 2, true
+	case OpSwap:
+		return 2, 2, true
+	case OpNeg, OpNot, OpBitNot, OpToString, OpToInt, OpToFloat, OpToBool:
+		return 1, 1, true
+	case OpAdd, OpSub, OpMul, OpDiv, OpMod, OpPow,
+		OpAddInt64, OpSubInt64, OpMulInt64, OpDivInt64, OpModInt64,
+		OpAddFloat64, OpSubFloat64, OpMulFloat64, OpDivFloat64, OpModFloat64, OpAddString,
+		OpEqual, OpNotEqual, OpGreaterThan, OpGreaterEqual, OpLessThan, OpLessEqual,
+		OpAnd, OpOr, OpBitAnd, OpBitOr, OpBitXor, OpShiftL, OpShiftR,
+		OpIndex, OpMember, OpIn, OpConcat, OpMatches, OpContains, OpStartsWith, OpEndsWith,
+		OpPipe, OpFilter, OpMapFunc, OpOptionalChaining, OpNullCoalescing, OpOperator:
+		return 2, 1, true
+	case OpJump, OpNoop, OpHalt, OpReturn:
+		return 0, 0, true
+	case OpCall:
+		return operands[0] + 1, 1, true
+	case OpBuiltin:
+		return operands[1], 1, true
+	case OpClosure:
+		return operands[1], 1, true
+	case OpSlice:
+		return operands[0], 1, true
+	case OpMap:
+		return 2 * operands[0], 1, true
+	case OpModuleCall:
+		return operands[2], 1, true
+	case OpCallFunction:
+		return operands[1], 1, true
+	case OpArrayDestructure:
+		return 1, 0, true
+	case OpObjectDestructure:
+		return operands[0] + 1, 0, true
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,121 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mredencom/expr/types"
+)
+
+func concatInstructions(parts ...[]byte) []byte {
+	var out []byte
+	for _, part := range parts {
+		out = append(out, part...)
+	}
+	return out
+}
+
+func TestVerify(t *testing.T) {
+	lambda := &CompiledFunction{
+		Instructions: concatInstructions(Make(OpGetLocal, 0), Make(OpGetFree, 0), Make(OpAdd)),
+		NumLocals:    1,
+		FreeNames:    []string{"x"},
+	}
+	bytecode := &Bytecode{
+		Instructions: concatInstructions(
+			Make(OpConstant, 0),
+			Make(OpJumpFalse, 12),
+			Make(OpConstant, 1),
+			Make(OpJump, 15),
+			Make(OpConstant, 2),
+			Make(OpGetVar, 0),
+			Make(OpClosure, 3, 1),
+			Make(OpPop),
+		),
+		Constants: []types.Value{
+			types.NewBool(true),
+			types.NewInt(1),
+			types.NewInt(2),
+			types.NewFunc([]string{"v"}, lambda, nil, ""),
+		},
+	}
+	if err := Verify(bytecode); err != nil {
+		t.Fatalf("unexpected verify error: %v", err)
+	}
+}
+
+func TestVerifyRejectsInvalidBytecode(t *testing.T) {
+	deep := make([]byte, 0, 3*(StackSize+1))
+	for i := 0; i <= StackSize; i++ {
+		deep = append(deep, Make(OpConstant, 0)...)
+	}
+
+	tests := []struct {
+		name         string
+		instructions []byte
+		constants    []types.Value
+		expected     string
+	}{
+		{"unknown opcode", []byte{255}, nil, "undefined"},
+		{"truncated operand", Make(OpConstant, 0)[:2], []types.Value{types.NewInt(1)}, "operand bytes"},
+		{"jump into operand", concatInstructions(Make(OpJump, 1), Make(OpConstant, 0)), []types.Value{types.NewInt(1)}, "not an instruction boundary"},
+		{"constant out of range", Make(OpConstant, 3), []types.Value{types.NewInt(1)}, "constant index 3 out of range"},
+		{"local at top level", Make(OpGetLocal, 0), nil, "local index 0 out of range"},
+		{"builtin out of range", Make(OpBuiltin, 255, 0), nil, "builtin index 255 out of range"},
+		{"operator symbol", concatInstructions(Make(OpConstant, 0), Make(OpConstant, 0), Make(OpOperator, 0)), []types.Value{types.NewInt(1)}, "not a string"},
+		{"closure of a non-function", Make(OpClosure, 0, 0), []types.Value{types.NewInt(1)}, "closure constant 0 is not a function"},
+		{"stack underflow", concatInstructions(Make(OpConstant, 0), Make(OpAdd)), []types.Value{types.NewInt(1)}, "pops 2 values from a stack of 1"},
+		{"unbalanced branches", concatInstructions(
+			Make(OpConstant, 0),
+			Make(OpJumpTrue, 9),
+			Make(OpConstant, 0),
+			Make(OpNoop),
+		), []types.Value{types.NewBool(true)}, "stack depths"},
+		{"stack overflow", deep, []types.Value{types.NewInt(1)}, "stack grows beyond"},
+		{"unchecked opcode", Make(OpArray), nil, "cannot be verified"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(&Bytecode{Instructions: tt.instructions, Constants: tt.constants})
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestVerifyChecksFunctions(t *testing.T) {
+	closure := func(fn *CompiledFunction) *Bytecode {
+		return &Bytecode{
+			Instructions: concatInstructions(Make(OpConstant, 0), Make(OpClosure, 1, 1)),
+			Constants:    []types.Value{types.NewInt(1), types.NewFunc([]string{"v"}, fn, nil, "")},
+		}
+	}
+
+	err := Verify(closure(&CompiledFunction{Instructions: Make(OpGetFree, 0), FreeNames: []string{"a", "b"}}))
+	if err == nil || !strings.Contains(err.Error(), "closure does not match") {
+		t.Errorf("expected closure mismatch error, got %v", err)
+	}
+
+	err = Verify(closure(&CompiledFunction{Instructions: Make(OpGetLocal, 2), NumLocals: 1, FreeNames: []string{"a"}}))
+	if err == nil || !strings.Contains(err.Error(), "in function constant 1") {
+		t.Errorf("expected error in function constant, got %v", err)
+	}
+}
+
+func TestRunVerifiesBytecode(t *testing.T) {
+	machine := New(&Bytecode{})
+	_, err := machine.Run(&Bytecode{Instructions: Make(OpConstant, 5)}, nil)
+	if err == nil || !strings.Contains(err.Error(), "invalid bytecode") {
+		t.Errorf("expected invalid bytecode error, got %v", err)
+	}
+
+	data, err := (&Bytecode{Instructions: concatInstructions(Make(OpConstant, 0), Make(OpAdd)), Constants: []types.Value{types.NewInt(1)}}).MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected encoding error: %v", err)
+	}
+	if err := (&Bytecode{}).UnmarshalBinary(data); err == nil || !strings.Contains(err.Error(), "pops 2 values") {
+		t.Errorf("expected decoding to reject unverifiable bytecode, got %v", err)
+	}
+}
@@ -1,10 +1,13 @@
 package vm
 
 import (
+	"context"
 	"fmt"
+	"reflect"
 	"strings"
 
 	"github.com/mredencom/expr/builtins"
+	"github.com/mredencom/expr/env"
 	"github.com/mredencom/expr/modules"
 	"github.com/mredencom/expr/types"
 )
@@ -12,6 +15,10 @@ import (
 const (
 	StackSize   = 2048
 	GlobalsSize = 65536
+
+	// cancelCheckInterval is the number of dispatched instructions or
+	// processed elements between two checks of the execution context
+	cancelCheckInterval = 1024
 )
 
 var (
@@ -53,10 +60,12 @@ func (vm *VM) getStringValueFromPool(value string) types.Value {
 	return types.NewString(value)
 }
 
-// Bytecode represents compiled bytecode
+// Bytecode represents compiled bytecode. It must not be changed once it
+// has been run: a VM verifies a Bytecode the first time it runs it only.
 type Bytecode struct {
 	Instructions []byte
 	Constants    []types.Value
+	Positions    SourceMap // Source positions of the instructions, if known
 }
 
 // VM represents the virtual machine
@@ -71,6 +80,13 @@ type VM struct {
 	customBuiltins map[string]interface{}
 	env            map[string]interface{}
 	safeJumpTable  *SafeJumpTable // Simplified and stable instruction dispatch table
+	ctx            context.Context
+	tagName        string // Struct tag used to rename or hide struct fields
+	frame          *frame // Locals of the lambda being executed, nil at top level
+	operators      map[string][]*OperatorFunc
+	positions      SourceMap         // Source map of the running instructions
+	verified       *Bytecode         // Bytecode last checked by Verify in Run
+	moduleRegistry *modules.Registry // Modules called by OpModuleCall, nil for the default registry
 
 	// Pipeline context for pipeline operations
 	pipelineElement types.Value
@@ -137,11 +153,22 @@ func NewWithEnvironment(bytecode *Bytecode, envVars map[string]interface{}, adap
 	return vm
 }
 
-// Run executes the virtual machine with the given bytecode
+// Run executes the virtual machine with the given bytecode. Bytecode is
+// checked with Verify the first time it is run on the VM, so changing its
+// instructions or constants afterwards is not detected; run a new Bytecode
+// instead.
 func (vm *VM) Run(bytecode *Bytecode, env map[string]interface{}) (types.Value, error) {
+	if bytecode != vm.verified {
+		if err := Verify(bytecode); err != nil {
+			return nil, err
+		}
+		vm.verified = bytecode
+	}
+
 	vm.sp = 0 // Reset stack pointer
 	vm.constants = bytecode.Constants
 	vm.env = env
+	vm.positions = bytecode.Positions
 
 	return vm.runHighPerformanceLoop(bytecode.Instructions)
 }
@@ -151,13 +178,21 @@ func (vm *VM) runHighPerformanceLoop(instructions []byte) (types.Value, error) {
 	// Use safe jump table for stable performance
 	ip := 0
 	instrLen := len(instructions)
+	steps := 0
 
 	// Safe high-performance execution loop using safe jump table dispatch
 	for ip < instrLen {
+		// Cooperatively stop when the execution context is done
+		if err := vm.checkContext(steps); err != nil {
+			return nil, err
+		}
+		steps++
+
 		// Use safe jump table for instruction dispatch
+		start := ip
 		cont, err := vm.safeJumpTable.Execute(vm, instructions, &ip)
 		if err != nil {
-			return nil, err
+			return nil, vm.instructionError(start, err)
 		}
 		if !cont {
 			break // Halt instruction or end of execution
@@ -172,6 +207,14 @@ func (vm *VM) runHighPerformanceLoop(instructions []byte) (types.Value, error) {
 	return Nil, nil
 }
 
+// checkContext reports the context error, if any, every cancelCheckInterval steps
+func (vm *VM) checkContext(step int) error {
+	if vm.ctx == nil || step%cancelCheckInterval != 0 {
+		return nil
+	}
+	return vm.ctx.Err()
+}
+
 // runLegacyLoop executes instructions with the original switch-based approach
 // Kept for compatibility and fallback purposes
 func (vm *VM) runLegacyLoop(instructions []byte) (types.Value, error) {
@@ -683,42 +726,10 @@ func (vm *VM) runLegacyLoop(instructions []byte) (types.Value, error) {
 			argCount := int(instructions[ip])
 			ip++
 
-			// Get module and function names from constants
-			moduleNameVal := vm.constants[moduleNameIndex]
-			functionNameVal := vm.constants[functionNameIndex]
-
-			moduleName, ok := moduleNameVal.(*types.StringValue)
-			if !ok {
-				return nil, fmt.Errorf("module name must be string, got %T", moduleNameVal)
-			}
-
-			functionName, ok := functionNameVal.(*types.StringValue)
-			if !ok {
-				return nil, fmt.Errorf("function name must be string, got %T", functionNameVal)
-			}
-
-			// Collect arguments from stack
-			args := make([]interface{}, argCount)
-			for i := argCount - 1; i >= 0; i-- {
-				args[i] = vm.convertTypesValueToInterface(vm.stack[vm.sp-argCount+i])
-			}
-			vm.sp -= argCount
-
-			// Call module function
-			result, err := modules.DefaultRegistry.CallFunction(moduleName.Value(), functionName.Value(), args...)
-			if err != nil {
-				return nil, fmt.Errorf("module call error: %v", err)
-			}
-
-			// Convert result back to types.Value
-			resultValue, err := vm.convertGoValueToTypesValue(result)
-			if err != nil {
-				return nil, fmt.Errorf("failed to convert module result: %v", err)
+			if err := vm.executeModuleCall(moduleNameIndex, functionNameIndex, argCount); err != nil {
+				return nil, err
 			}
 
-			vm.stack[vm.sp] = resultValue
-			vm.sp++
-
 		case OpArrayDestructure:
 			// Array destructuring: [a, b, c] = [1, 2, 3]
 			// Operands: elementCount (2 bytes), startVarIndex (2 bytes)
@@ -1007,7 +1018,7 @@ func (vm *VM) executeBuiltin(builtinIndex int, argCount int) error {
 	// Call the appropriate builtin function
 	result, err := vm.callBuiltinByName(funcName, args)
 	if err != nil {
-		return fmt.Errorf("builtin %s error: %v", funcName, err)
+		return fmt.Errorf("builtin %s error: %w", funcName, err)
 	}
 
 	// Push result back to stack
@@ -1021,6 +1032,18 @@ func (vm *VM) executeBuiltin(builtinIndex int, argCount int) error {
 
 // callBuiltinByName calls a builtin function by name with the given arguments
 func (vm *VM) callBuiltinByName(funcName string, args []types.Value) (types.Value, error) {
+	// Compiled lambdas can only be executed by the VM itself
+	if result, ok, err := vm.callLambdaBuiltin(funcName, args); ok {
+		return result, err
+	}
+
+	// Prefer context-aware implementations so long-running builtins can be cancelled
+	if vm.ctx != nil {
+		if builtinFunc, exists := builtins.ContextBuiltins[funcName]; exists {
+			return builtinFunc(vm.ctx, args)
+		}
+	}
+
 	// Use the builtin functions from the builtins package
 	if builtinFunc, exists := builtins.AllBuiltins[funcName]; exists {
 		return builtinFunc(args)
@@ -1035,6 +1058,93 @@ func (vm *VM) callBuiltinByName(funcName string, args []types.Value) (types.Valu
 	}
 }
 
+// executeModuleCall calls a module function with the arguments on the stack
+func (vm *VM) executeModuleCall(moduleNameIndex, functionNameIndex, argCount int) error {
+	moduleName, ok := vm.constants[moduleNameIndex].(*types.StringValue)
+	if !ok {
+		return fmt.Errorf("module name must be string, got %T", vm.constants[moduleNameIndex])
+	}
+	functionName, ok := vm.constants[functionNameIndex].(*types.StringValue)
+	if !ok {
+		return fmt.Errorf("function name must be string, got %T", vm.constants[functionNameIndex])
+	}
+	if vm.sp < argCount {
+		return fmt.Errorf("stack underflow for module call")
+	}
+
+	registry := vm.moduleRegistry
+	if registry == nil {
+		registry = modules.DefaultRegistry
+	}
+	function, err := registry.GetFunction(moduleName.Value(), functionName.Value())
+	if err != nil {
+		return fmt.Errorf("module call error: %w", err)
+	}
+
+	// Collect arguments from stack, converting numbers to the declared
+	// parameter types
+	args := make([]interface{}, argCount)
+	for i := 0; i < argCount; i++ {
+		args[i] = vm.convertTypesValueToInterface(vm.stack[vm.sp-argCount+i])
+		if n := len(function.ParamTypes); n > 0 {
+			param := function.ParamTypes[n-1]
+			if i < n {
+				param = function.ParamTypes[i]
+			}
+			args[i] = convertModuleArgument(args[i], param)
+		}
+	}
+	vm.sp -= argCount
+
+	result, err := function.Handler(args...)
+	if err != nil {
+		return fmt.Errorf("module call error: %s.%s: %w", moduleName.Value(), functionName.Value(), err)
+	}
+
+	resultValue, err := vm.convertGoValueToTypesValue(result)
+	if err != nil {
+		return fmt.Errorf("failed to convert module result: %v", err)
+	}
+
+	vm.stack[vm.sp] = resultValue
+	vm.sp++
+	return nil
+}
+
+// moduleArgumentTypes are the Go types of the numeric module parameter kinds
+var moduleArgumentTypes = map[types.TypeKind]reflect.Type{
+	types.KindInt:     reflect.TypeOf(int(0)),
+	types.KindInt8:    reflect.TypeOf(int8(0)),
+	types.KindInt16:   reflect.TypeOf(int16(0)),
+	types.KindInt32:   reflect.TypeOf(int32(0)),
+	types.KindInt64:   reflect.TypeOf(int64(0)),
+	types.KindUint:    reflect.TypeOf(uint(0)),
+	types.KindUint8:   reflect.TypeOf(uint8(0)),
+	types.KindUint16:  reflect.TypeOf(uint16(0)),
+	types.KindUint32:  reflect.TypeOf(uint32(0)),
+	types.KindUint64:  reflect.TypeOf(uint64(0)),
+	types.KindFloat32: reflect.TypeOf(float32(0)),
+	types.KindFloat64: reflect.TypeOf(float64(0)),
+}
+
+// convertModuleArgument converts a number to the type of a numeric module
+// parameter. Floats are only converted to integers when they are whole.
+func convertModuleArgument(arg interface{}, param types.TypeInfo) interface{} {
+	target, ok := moduleArgumentTypes[param.Kind]
+	if !ok {
+		return arg
+	}
+	switch v := arg.(type) {
+	case int64:
+		return reflect.ValueOf(v).Convert(target).Interface()
+	case float64:
+		if param.IsFloat() || v == float64(int64(v)) {
+			return reflect.ValueOf(v).Convert(target).Interface()
+		}
+	}
+	return arg
+}
+
 // executeIndex performs index access
 func (vm *VM) executeIndex(object, index types.Value) (types.Value, error) {
 	// Slice index access
@@ -1072,13 +1182,19 @@ func (vm *VM) executeMember(object types.Value, memberIndex int) (types.Value, e
 		return nil, fmt.Errorf("member name must be string")
 	}
 
-	// Map member access
+	// Map member access, falling back to the methods of the Go value
 	if mapVal, ok := object.(*types.MapValue); ok {
 		if val, exists := mapVal.Get(memberName.Value()); exists {
 			return val, nil
 		}
+		if method, ok := vm.boundMethod(object, memberName.Value()); ok {
+			return method, nil
+		}
 		return Nil, nil
 	}
+	if method, ok := vm.boundMethod(object, memberName.Value()); ok {
+		return method, nil
+	}
 
 	return nil, fmt.Errorf("unsupported member access: %T.%s", object, memberName.Value())
 }
@@ -1091,13 +1207,19 @@ func (vm *VM) executeMemberByName(object, memberName types.Value) (types.Value,
 		return nil, fmt.Errorf("member name must be string, got %T", memberName)
 	}
 
-	// Map member access
+	// Map member access, falling back to the methods of the Go value
 	if mapVal, ok := object.(*types.MapValue); ok {
 		if val, exists := mapVal.Get(memberStr.Value()); exists {
 			return val, nil
 		}
+		if method, ok := vm.boundMethod(object, memberStr.Value()); ok {
+			return method, nil
+		}
 		return Nil, nil
 	}
+	if method, ok := vm.boundMethod(object, memberStr.Value()); ok {
+		return method, nil
+	}
 
 	return nil, fmt.Errorf("unsupported member access: %T.%s", object, memberStr.Value())
 }
@@ -1332,6 +1454,18 @@ func (vm *VM) callCompiledFunction(funcSlice *types.SliceValue, args []types.Val
 
 // callBuiltinFunction calls a builtin function by name
 func (vm *VM) callBuiltinFunction(funcName string, args []types.Value) (types.Value, error) {
+	// Compiled lambdas can only be executed by the VM itself
+	if result, ok, err := vm.callLambdaBuiltin(funcName, args); ok {
+		return result, err
+	}
+
+	// Prefer context-aware implementations so long-running builtins can be cancelled
+	if vm.ctx != nil {
+		if builtinFunc, exists := builtins.ContextBuiltins[funcName]; exists {
+			return builtinFunc(vm.ctx, args)
+		}
+	}
+
 	// Use the builtin functions from the builtins package
 	if builtinFunc, exists := builtins.AllBuiltins[funcName]; exists {
 		return builtinFunc(args)
@@ -1342,8 +1476,18 @@ func (vm *VM) callBuiltinFunction(funcName string, args []types.Value) (types.Va
 
 // callLambdaFunction calls a lambda function
 func (vm *VM) callLambdaFunction(funcVal *types.FuncValue, args []types.Value) (types.Value, error) {
-	// TODO: Implement lambda function execution
-	// For now, return first argument as placeholder
+	switch fn := funcVal.Body().(type) {
+	case *CompiledFunction:
+		return vm.runFunction(funcVal, fn, args)
+	case *GoFunction:
+		result, err := fn.Call(vm.ctx, args, vm.tagName)
+		if err != nil {
+			return nil, fmt.Errorf("method %s: %w", fn.Name, err)
+		}
+		return result, nil
+	}
+
+	// Functions without a compiled body return their first argument
 	if len(args) > 0 {
 		return args[0], nil
 	}
@@ -1360,7 +1504,11 @@ func (vm *VM) executeFilter(data types.Value, condition types.Value) (types.Valu
 	var result []types.Value
 	elements := slice.Values()
 
-	for _, element := range elements {
+	for i, element := range elements {
+		if err := vm.checkContext(i); err != nil {
+			return Nil, err
+		}
+
 		// Set pipeline element for placeholder evaluation
 		oldPipelineElement := vm.pipelineElement
 		vm.pipelineElement = element
@@ -1391,7 +1539,11 @@ func (vm *VM) executeMap(data types.Value, transform types.Value) (types.Value,
 	var result []types.Value
 	elements := slice.Values()
 
-	for _, element := range elements {
+	for i, element := range elements {
+		if err := vm.checkContext(i); err != nil {
+			return Nil, err
+		}
+
 		// Set pipeline element for placeholder evaluation
 		oldPipelineElement := vm.pipelineElement
 		vm.pipelineElement = element
@@ -1461,25 +1613,45 @@ func (vm *VM) evaluateCompiledPlaceholderExpression(condSlice *types.SliceValue,
 				return types.NewBool(false)
 			}
 
+			// Pipeline member access: ["__PIPELINE_MEMBER_ACCESS__", object, "property"]
+			if operator == "__PIPELINE_MEMBER_ACCESS__" {
+				if propertyVal, ok := rightVal.(*types.StringValue); ok {
+					object := vm.evaluatePlaceholderOperand(leftVal, element)
+					return vm.evaluateMemberAccess(object, propertyVal.Value())
+				}
+				return Nil
+			}
+
 			// Replace placeholders with the current element
-			var left, right types.Value
+			left := vm.evaluatePlaceholderOperand(leftVal, element)
 
-			if placeholderStr, ok := leftVal.(*types.StringValue); ok && placeholderStr.Value() == "__PLACEHOLDER__" {
-				left = element
-			} else if memberSlice, ok := leftVal.(*types.SliceValue); ok {
-				// Handle nested member access like #.age
-				left = vm.evaluateCompiledPlaceholderExpression(memberSlice, element)
-			} else {
-				left = leftVal
+			// Logical operators only evaluate the right operand when needed
+			switch operator {
+			case "&&":
+				if !vm.isTruthy(left) {
+					return types.NewBool(false)
+				}
+				return types.NewBool(vm.isTruthy(vm.evaluatePlaceholderOperand(rightVal, element)))
+			case "||":
+				if vm.isTruthy(left) {
+					return types.NewBool(true)
+				}
+				return types.NewBool(vm.isTruthy(vm.evaluatePlaceholderOperand(rightVal, element)))
+			case "??":
+				if left != nil && left.Type().Kind != types.KindNil {
+					return left
+				}
+				return vm.evaluatePlaceholderOperand(rightVal, element)
 			}
 
-			if placeholderStr, ok := rightVal.(*types.StringValue); ok && placeholderStr.Value() == "__PLACEHOLDER__" {
-				right = element
-			} else if memberSlice, ok := rightVal.(*types.SliceValue); ok {
-				// Handle nested member access
-				right = vm.evaluateCompiledPlaceholderExpression(memberSlice, element)
-			} else {
-				right = rightVal
+			right := vm.evaluatePlaceholderOperand(rightVal, element)
+
+			// Custom and overloaded operators take precedence
+			if result, ok, err := vm.callOperator(operator, left, right); ok {
+				if err != nil {
+					return Nil
+				}
+				return result
 			}
 
 			// Perform the operation
@@ -1496,16 +1668,6 @@ func (vm *VM) evaluateCompiledPlaceholderExpression(condSlice *types.SliceValue,
 				return vm.evaluateComparison(OpEqual, left, right)
 			case "!=":
 				return vm.evaluateComparison(OpNotEqual, left, right)
-			case "&&":
-				if vm.isTruthy(left) {
-					return right
-				}
-				return left
-			case "||":
-				if vm.isTruthy(left) {
-					return left
-				}
-				return right
 			case "+":
 				result, _ := vm.executeAddition(left, right)
 				return result
@@ -1543,6 +1705,19 @@ func (vm *VM) evaluateCompiledPlaceholderExpression(condSlice *types.SliceValue,
 	return types.NewBool(false)
 }
 
+// evaluatePlaceholderOperand resolves an operand of a compiled placeholder
+// expression against the current element
+func (vm *VM) evaluatePlaceholderOperand(operand types.Value, element types.Value) types.Value {
+	if placeholderStr, ok := operand.(*types.StringValue); ok && placeholderStr.Value() == "__PLACEHOLDER__" {
+		return element
+	}
+	if nested, ok := operand.(*types.SliceValue); ok {
+		// Nested expressions and member access like #.age
+		return vm.evaluateCompiledPlaceholderExpression(nested, element)
+	}
+	return operand
+}
+
 // evaluateMemberAccess evaluates member access on an element
 func (vm *VM) evaluateMemberAccess(element types.Value, memberName string) types.Value {
 	// Handle map member access
@@ -1704,27 +1879,31 @@ func (vm *VM) executeTypeMethod(data types.Value, methodName string, arguments [
 	// Construct the full method name like "string.upper", "int.abs", etc.
 	fullMethodName := typePrefix + "." + methodName
 
-	// Check if the method exists in TypeMethodBuiltins
-	if typeMethod, exists := builtins.TypeMethodBuiltins[fullMethodName]; exists {
-		// Prepare arguments for the type method call
-		methodArgs := []types.Value{objectValue}
-
-		// Add any additional arguments (skip the first one which is the object)
-		if len(arguments) > 1 {
-			for _, arg := range arguments[1:] {
-				// Evaluate argument if it's a placeholder
-				if placeholderStr, ok := arg.(*types.StringValue); ok && placeholderStr.Value() == "__PLACEHOLDER__" {
-					methodArgs = append(methodArgs, data)
-				} else {
-					methodArgs = append(methodArgs, arg)
-				}
+	// Prepare arguments for the method call
+	methodArgs := []types.Value{objectValue}
+
+	// Add any additional arguments (skip the first one which is the object)
+	if len(arguments) > 1 {
+		for _, arg := range arguments[1:] {
+			// Evaluate argument if it's a placeholder
+			if placeholderStr, ok := arg.(*types.StringValue); ok && placeholderStr.Value() == "__PLACEHOLDER__" {
+				methodArgs = append(methodArgs, data)
+			} else {
+				methodArgs = append(methodArgs, arg)
 			}
 		}
+	}
 
-		// Call the type method
+	// Check if the method exists in TypeMethodBuiltins
+	if typeMethod, exists := builtins.TypeMethodBuiltins[fullMethodName]; exists {
 		return typeMethod(methodArgs)
 	}
 
+	// Fall back to the methods of the Go value
+	if method, ok := vm.boundMethod(objectValue, methodName); ok {
+		return vm.callFunction(method, methodArgs[1:])
+	}
+
 	return Nil, fmt.Errorf("unknown type method: %s", fullMethodName)
 }
 
@@ -1736,6 +1915,9 @@ func (vm *VM) executeMemberAccess(object types.Value, propertyName string) (type
 		if value, exists := obj.Get(propertyName); exists {
 			return value, nil
 		}
+		if method, ok := vm.boundMethod(obj, propertyName); ok {
+			return method, nil
+		}
 		return types.NewNil(), nil
 	case *types.SliceValue:
 		// Check if this is accessing a slice property like "length"
@@ -1743,6 +1925,9 @@ func (vm *VM) executeMemberAccess(object types.Value, propertyName string) (type
 		case "length":
 			return types.NewInt(int64(len(obj.Values()))), nil
 		default:
+			if method, ok := vm.boundMethod(obj, propertyName); ok {
+				return method, nil
+			}
 			return Nil, fmt.Errorf("property %s not found on slice", propertyName)
 		}
 	case *types.StringValue:
@@ -1768,7 +1953,11 @@ func (vm *VM) executePipelineFilterWithTypeMethod(data types.Value, methodName s
 	var result []types.Value
 	elements := slice.Values()
 
-	for _, element := range elements {
+	for i, element := range elements {
+		if err := vm.checkContext(i); err != nil {
+			return Nil, err
+		}
+
 		// Call the type method on each element
 		methodResult, err := vm.executeTypeMethod(element, methodName, arguments)
 		if err != nil {
@@ -1796,7 +1985,11 @@ func (vm *VM) executePipelineMapWithTypeMethod(data types.Value, methodName stri
 	var result []types.Value
 	elements := slice.Values()
 
-	for _, element := range elements {
+	for i, element := range elements {
+		if err := vm.checkContext(i); err != nil {
+			return Nil, err
+		}
+
 		// Call the type method on each element and collect the result
 		methodResult, err := vm.executeTypeMethod(element, methodName, arguments)
 		if err != nil {
@@ -1821,7 +2014,11 @@ func (vm *VM) executePipelineFilterWithComplexTypeMethod(data types.Value, metho
 	var result []types.Value
 	elements := slice.Values()
 
-	for _, element := range elements {
+	for i, element := range elements {
+		if err := vm.checkContext(i); err != nil {
+			return Nil, err
+		}
+
 		// Set pipeline element for placeholder evaluation
 		oldPipelineElement := vm.pipelineElement
 		vm.pipelineElement = element
@@ -1858,7 +2055,11 @@ func (vm *VM) executePipelineMapWithComplexTypeMethod(data types.Value, methodNa
 	var result []types.Value
 	elements := slice.Values()
 
-	for _, element := range elements {
+	for i, element := range elements {
+		if err := vm.checkContext(i); err != nil {
+			return Nil, err
+		}
+
 		// Set pipeline element for placeholder evaluation
 		oldPipelineElement := vm.pipelineElement
 		vm.pipelineElement = element
@@ -2232,6 +2433,11 @@ func (vm *VM) performComparison(left, right types.Value, operator string) (types
 		}
 	}
 
+	// Mixed int/float comparison, the integer converted as in arithmetic
+	if leftFloat, rightFloat, ok := mixedFloats(left, right); ok {
+		return vm.performComparison(types.NewFloat(leftFloat), types.NewFloat(rightFloat), operator)
+	}
+
 	// String comparison
 	if leftStr, ok := left.(*types.StringValue); ok {
 		if rightStr, ok := right.(*types.StringValue); ok {
@@ -2528,9 +2734,18 @@ func (vm *VM) Reset() {
 	// Clear pipeline context
 	vm.pipelineElement = nil
 
-	// Clear constants and env
+	// Clear constants, env and execution context
 	vm.constants = nil
 	vm.env = nil
+	vm.ctx = nil
+	vm.tagName = ""
+	vm.frame = nil
+	vm.positions = nil
+	vm.operators = nil
+	vm.moduleRegistry = nil
+	for name := range vm.customBuiltins {
+		delete(vm.customBuiltins, name)
+	}
 }
 
 // SetConstants sets the constants for the VM
@@ -2538,6 +2753,17 @@ func (vm *VM) SetConstants(constants []types.Value) {
 	vm.constants = constants
 }
 
+// SetTagName sets the struct tag used to rename or hide fields of struct values
+func (vm *VM) SetTagName(tagName string) {
+	vm.tagName = tagName
+}
+
+// SetModules sets the registry of the modules called by the program. A nil
+// registry selects modules.DefaultRegistry.
+func (vm *VM) SetModules(registry *modules.Registry) {
+	vm.moduleRegistry = registry
+}
+
 // SetEnvironment sets up the environment variables for the VM
 func (vm *VM) SetEnvironment(envVars map[string]interface{}, variableOrder []string) error {
 	vm.env = envVars
@@ -2562,6 +2788,100 @@ func (vm *VM) SetEnvironment(envVars map[string]interface{}, variableOrder []str
 	return nil
 }
 
+// UpdateEnvironment prepares a VM that ran a program with the environment
+// previous to run it again with envVars. The stack is emptied and only the
+// variables whose values changed are converted again. Values are unchanged
+// when they are equal or, for maps, slices and pointers, the same object.
+func (vm *VM) UpdateEnvironment(envVars, previous map[string]interface{}, variableOrder []string) error {
+	vm.sp = 0
+	vm.frame = nil
+	vm.pipelineElement = nil
+	vm.env = envVars
+
+	for i, varName := range variableOrder {
+		if i >= len(vm.globals) {
+			return fmt.Errorf("too many variables: %d", len(variableOrder))
+		}
+
+		value, exists := envVars[varName]
+		if !exists {
+			vm.globals[i] = Nil
+			continue
+		}
+		if old, existed := previous[varName]; existed && vm.globals[i] != nil && sameGoValue(old, value) {
+			continue
+		}
+		typesValue, err := vm.convertGoValueToTypesValue(value)
+		if err != nil {
+			return fmt.Errorf("failed to convert variable %s: %v", varName, err)
+		}
+		vm.globals[i] = typesValue
+	}
+
+	return nil
+}
+
+// SetEnvironmentCached is like SetEnvironment for programs run one after the
+// other on the same environment: converted values are taken from cache and
+// the values converted for this program are added to it. The stack is
+// emptied for the next run.
+func (vm *VM) SetEnvironmentCached(envVars map[string]interface{}, cache map[string]types.Value, variableOrder []string) error {
+	vm.sp = 0
+	vm.frame = nil
+	vm.pipelineElement = nil
+	vm.env = envVars
+
+	for i, varName := range variableOrder {
+		if i >= len(vm.globals) {
+			return fmt.Errorf("too many variables: %d", len(variableOrder))
+		}
+
+		if typesValue, cached := cache[varName]; cached {
+			vm.globals[i] = typesValue
+			continue
+		}
+		value, exists := envVars[varName]
+		if !exists {
+			vm.globals[i] = Nil
+			continue
+		}
+		typesValue, err := vm.convertGoValueToTypesValue(value)
+		if err != nil {
+			return fmt.Errorf("failed to convert variable %s: %v", varName, err)
+		}
+		cache[varName] = typesValue
+		vm.globals[i] = typesValue
+	}
+
+	return nil
+}
+
+// sameGoValue reports whether two environment values convert to the same
+// expression value without converting them
+func sameGoValue(a, b interface{}) bool {
+	ta, tb := reflect.TypeOf(a), reflect.TypeOf(b)
+	if ta != tb {
+		return false
+	}
+	if ta == nil {
+		return true
+	}
+
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	switch ta.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return a == b
+	case reflect.Map, reflect.Ptr:
+		return va.Pointer() == vb.Pointer()
+	case reflect.Slice:
+		return va.Pointer() == vb.Pointer() && va.Len() == vb.Len()
+	}
+	return false
+}
+
 // convertGoValueToTypesValue converts Go values to types.Value
 func (vm *VM) convertGoValueToTypesValue(val interface{}) (types.Value, error) {
 	switch v := val.(type) {
@@ -2638,6 +2958,9 @@ func (vm *VM) convertGoValueToTypesValue(val interface{}) (types.Value, error) {
 		keyType := types.TypeInfo{Kind: types.KindString, Name: "string", Size: -1}
 		valueType := types.TypeInfo{Kind: types.KindInterface, Name: "interface{}", Size: -1}
 		return types.NewMap(values, keyType, valueType), nil
+	case types.Value:
+		// Already converted
+		return v, nil
 
 	default:
 		// Handle known struct types without reflection
@@ -2650,11 +2973,13 @@ func (vm *VM) convertGoValueToTypesValue(val interface{}) (types.Value, error) {
 			return converted, nil
 		}
 
-		return nil, fmt.Errorf("unsupported type: %T", val)
+		// Fall back to reflection with cached struct layouts
+		return env.ConvertReflect(val, vm.tagName)
 	}
 }
 
-// SetCustomBuiltin sets a custom builtin function
+// SetCustomBuiltin sets a custom builtin function called by OpCallFunction.
+// fn is a Go function or a *GoFunction wrapping one.
 func (vm *VM) SetCustomBuiltin(name string, fn interface{}) {
 	if vm.customBuiltins == nil {
 		vm.customBuiltins = make(map[string]interface{})
@@ -2667,6 +2992,16 @@ func (vm *VM) RunInstructionsWithResult(instructions []byte) (types.Value, error
 	return vm.runHighPerformanceLoop(instructions)
 }
 
+// RunInstructionsWithContext runs instructions and returns the result, stopping
+// with the context error as soon as ctx is cancelled or its deadline passes
+func (vm *VM) RunInstructionsWithContext(ctx context.Context, instructions []byte) (types.Value, error) {
+	vm.ctx = ctx
+	defer func() {
+		vm.ctx = nil
+	}()
+	return vm.runHighPerformanceLoop(instructions)
+}
+
 // RunInstructions runs instructions without returning result
 func (vm *VM) RunInstructions(instructions []byte) error {
 	_, err := vm.runHighPerformanceLoop(instructions)
@@ -2767,6 +3102,11 @@ func (vm *VM) executeComparison(op Opcode, left, right types.Value) (types.Value
 		}
 	}
 
+	// Mixed int/float comparison, the integer converted as in arithmetic
+	if leftFloat, rightFloat, ok := mixedFloats(left, right); ok {
+		return vm.executeComparison(op, types.NewFloat(leftFloat), types.NewFloat(rightFloat))
+	}
+
 	// String comparison
 	if leftStr, ok := left.(*types.StringValue); ok {
 		if rightStr, ok := right.(*types.StringValue); ok {
@@ -2810,6 +3150,22 @@ func (vm *VM) executeComparison(op Opcode, left, right types.Value) (types.Value
 	return nil, fmt.Errorf("unsupported comparison: %T %s %T", left, op, right)
 }
 
+// mixedFloats returns the values of an int and a float, in either order,
+// as floats
+func mixedFloats(left, right types.Value) (float64, float64, bool) {
+	switch l := left.(type) {
+	case *types.IntValue:
+		if r, ok := right.(*types.FloatValue); ok {
+			return float64(l.Value()), r.Value(), true
+		}
+	case *types.FloatValue:
+		if r, ok := right.(*types.IntValue); ok {
+			return l.Value(), float64(r.Value()), true
+		}
+	}
+	return 0, 0, false
+}
+
 // executeLogical performs logical operations
 func (vm *VM) executeLogical(op Opcode, left, right types.Value) (types.Value, error) {
 	switch op {
@@ -3050,7 +3406,8 @@ type StructConverter interface {
 	ToMap() map[string]interface{}
 }
 
-// tryConvertKnownStruct tries to convert known struct types without reflection
+// tryConvertKnownStruct converts structs and non-nil pointers to structs, preferring
+// the StructConverter interface over the cached reflection layout
 func (vm *VM) tryConvertKnownStruct(val interface{}) (types.Value, bool) {
 	// First try the StructConverter interface
 	if converter, ok := val.(StructConverter); ok {
@@ -3066,7 +3423,22 @@ func (vm *VM) tryConvertKnownStruct(val interface{}) (types.Value, bool) {
 		return types.NewMap(fields, keyType, valueType), true
 	}
 
-	return nil, false
+	rv := reflect.ValueOf(val)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, false
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, false
+	}
+
+	converted, err := env.ConvertReflect(val, vm.tagName)
+	if err != nil {
+		return nil, false
+	}
+	return converted, true
 }
 
 // tryConvertSlice tries to convert slice types without reflection
@@ -3120,15 +3492,13 @@ func (vm *VM) tryConvertSlice(val interface{}) (types.Value, bool) {
 	return nil, false
 }
 
-// convertStructToMap converts a struct to a MapValue using zero-reflection approach
+// convertStructToMap converts a struct to a MapValue
 func (vm *VM) convertStructToMap(val interface{}) (types.Value, error) {
-	// Try the zero-reflection approach first
 	if converted, ok := vm.tryConvertKnownStruct(val); ok {
 		return converted, nil
 	}
 
-	// For unknown struct types, return an error instead of using reflection
-	return nil, fmt.Errorf("unknown struct type: %T (register this type for zero-reflection support)", val)
+	return nil, fmt.Errorf("unknown struct type: %T", val)
 }
 
 // executeOptionalChaining performs optional chaining operation (obj?.property)
@@ -1,6 +1,9 @@
 package vm
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/mredencom/expr/types"
@@ -267,6 +270,20 @@ func TestVM_ExecuteComparison(t *testing.T) {
 			operator: ">",
 			expected: true,
 		},
+		{
+			name:     "Float Greater Than Integer",
+			left:     types.NewFloat(150.5),
+			right:    types.NewInt(100),
+			operator: ">",
+			expected: true,
+		},
+		{
+			name:     "Integer Equal Float",
+			left:     types.NewInt(3),
+			right:    types.NewFloat(3),
+			operator: "==",
+			expected: true,
+		},
 		{
 			name:     "String Equal",
 			left:     types.NewString("hello"),
@@ -604,6 +621,42 @@ func TestVM_SetCustomBuiltin(t *testing.T) {
 	}
 }
 
+// TestVM_CallGoFunction 测试按名称调用Go函数
+func TestVM_CallGoFunction(t *testing.T) {
+	type key struct{}
+	bytecode := &Bytecode{
+		Instructions: concatInstructions(Make(OpConstant, 1), Make(OpConstant, 2), Make(OpCallFunction, 0, 2)),
+		Constants:    []types.Value{types.NewString("scale"), types.NewInt(2), types.NewFloat(1.5)},
+	}
+	scale := func(ctx context.Context, factor int, values ...float64) (float64, error) {
+		if ctx.Value(key{}) == nil {
+			return 0, errors.New("missing context")
+		}
+		return float64(factor) * values[0], nil
+	}
+
+	machine := New(bytecode)
+	machine.SetCustomBuiltin("scale", scale)
+	result, err := machine.RunInstructionsWithContext(context.WithValue(context.Background(), key{}, true), bytecode.Instructions)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f, ok := result.(*types.FloatValue); !ok || f.Value() != 3 {
+		t.Errorf("Expected 3, got %v", result)
+	}
+
+	machine = New(bytecode)
+	machine.SetCustomBuiltin("scale", scale)
+	if _, err := machine.RunInstructionsWithContext(context.Background(), bytecode.Instructions); err == nil || !strings.Contains(err.Error(), "function scale: missing context") {
+		t.Errorf("Expected function error, got %v", err)
+	}
+
+	machine = New(bytecode)
+	if _, err := machine.RunInstructionsWithContext(context.Background(), bytecode.Instructions); err == nil || !strings.Contains(err.Error(), "undefined function scale") {
+		t.Errorf("Expected undefined function error, got %v", err)
+	}
+}
+
 // TestVM_CompareValues 测试值比较
 func TestVM_CompareValues(t *testing.T) {
 	tests := []struct {
@@ -1490,6 +1543,68 @@ func TestVM_ExecuteConcat(t *testing.T) {
 	}
 }
 
+// TestVM_UpdateEnvironment 测试只转换变化的环境变量
+func TestVM_UpdateEnvironment(t *testing.T) {
+	vm := New(&Bytecode{})
+	shared := map[string]interface{}{"rate": 2}
+	variableOrder := []string{"x", "shared", "tmp"}
+
+	first := map[string]interface{}{"x": 1, "shared": shared}
+	if err := vm.UpdateEnvironment(first, nil, variableOrder); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	converted := vm.globals[1]
+	vm.globals[2] = types.NewInt(9) // Set by a let of the previous run
+	vm.sp = 3
+
+	second := map[string]interface{}{"x": 2, "shared": shared}
+	if err := vm.UpdateEnvironment(second, first, variableOrder); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if vm.sp != 0 {
+		t.Errorf("Expected an empty stack, got sp %d", vm.sp)
+	}
+	if x, ok := vm.globals[0].(*types.IntValue); !ok || x.Value() != 2 {
+		t.Errorf("Expected x to be converted again, got %v", vm.globals[0])
+	}
+	if vm.globals[1] != converted {
+		t.Errorf("Expected the unchanged map to keep its converted value")
+	}
+	if vm.globals[2] != Nil {
+		t.Errorf("Expected a variable missing from the environment to be nil, got %v", vm.globals[2])
+	}
+
+	third := map[string]interface{}{"x": 2, "shared": map[string]interface{}{"rate": 3}}
+	if err := vm.UpdateEnvironment(third, second, variableOrder); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if vm.globals[1] == converted {
+		t.Errorf("Expected a different map to be converted")
+	}
+}
+
+// TestVM_SetEnvironmentCached 测试多个程序共享转换后的环境变量
+func TestVM_SetEnvironmentCached(t *testing.T) {
+	vm := New(&Bytecode{})
+	env := map[string]interface{}{"x": 1, "user": map[string]interface{}{"name": "ann"}}
+	cache := make(map[string]types.Value)
+
+	if err := vm.SetEnvironmentCached(env, cache, []string{"user", "y"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(cache) != 1 || cache["user"] != vm.globals[0] || vm.globals[1] != Nil {
+		t.Fatalf("Expected only user to be converted and cached, got %v", cache)
+	}
+
+	// A second program with another variable order reuses the conversion
+	if err := vm.SetEnvironmentCached(env, cache, []string{"x", "user"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if vm.globals[1] != cache["user"] || len(cache) != 2 {
+		t.Errorf("Expected user to come from the cache and x to be added, got %v", cache)
+	}
+}
+
 // TestVM_SetEnvironment 测试环境变量设置
 func TestVM_SetEnvironment(t *testing.T) {
 	vm := New(&Bytecode{})
@@ -4702,3 +4817,53 @@ func TestVM_FinalCoverageBoost(t *testing.T) {
 		t.Log("Simple coverage boost completed")
 	})
 }
+
+// TestVM_RunInstructionsWithContext 测试可取消的指令执行
+func TestVM_RunInstructionsWithContext(t *testing.T) {
+	bytecode := &Bytecode{
+		Instructions: append(Make(OpConstant, 0), Make(OpConstant, 1)...),
+		Constants:    []types.Value{types.NewInt(1), types.NewInt(2)},
+	}
+	bytecode.Instructions = append(bytecode.Instructions, Make(OpAdd)...)
+
+	vm := New(bytecode)
+	result, err := vm.RunInstructionsWithContext(context.Background(), bytecode.Instructions)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if intVal, ok := result.(*types.IntValue); !ok || intVal.Value() != 3 {
+		t.Errorf("Expected 3, got %v", result)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	vm.ResetStack()
+	_, err = vm.RunInstructionsWithContext(ctx, bytecode.Instructions)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if vm.ctx != nil {
+		t.Error("Expected context to be cleared after execution")
+	}
+}
+
+// TestVM_ExecuteFilterCancelled 测试过滤循环中的取消检查
+func TestVM_ExecuteFilterCancelled(t *testing.T) {
+	vm := New(&Bytecode{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	vm.ctx = ctx
+
+	data := types.NewSlice([]types.Value{types.NewInt(1), types.NewInt(2)}, types.TypeInfo{Kind: types.KindInt64, Name: "int"})
+	if _, err := vm.executeFilter(data, types.NewBool(true)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from filter, got %v", err)
+	}
+	if _, err := vm.executeMap(data, types.NewBool(true)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from map, got %v", err)
+	}
+	if _, err := vm.callBuiltinFunction("reduce", []types.Value{data, types.NewString("sum")}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from reduce, got %v", err)
+	}
+}